@@ -0,0 +1,276 @@
+package botredirect
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Форматы журналов доступа
+const (
+	AccessLogFormatJSON     = "json"
+	AccessLogFormatCommon   = "common"
+	AccessLogFormatCombined = "combined"
+)
+
+// accessLogMaxLine максимальная длина строки журнала (JSON журналы Caddy содержат все заголовки)
+const accessLogMaxLine = 1024 * 1024
+
+// errNotAccessLog строка JSON журнала Caddy, не относящаяся к журналу доступа
+var errNotAccessLog = errors.New("not an access log entry")
+
+// commonLogPattern строка Common Log Format, за которой могут следовать
+// Referer и User-Agent (Combined Log Format)
+var commonLogPattern = regexp.MustCompile(
+	`^(\S+) \S+ \S+ \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}|-) \S+(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// commonLogUnescape восстанавливает кавычки и обратные слэши, экранированные в CLF
+var commonLogUnescape = strings.NewReplacer(`\"`, `"`, `\\`, `\`)
+
+// AccessLogEntry запись журнала доступа, достаточная для повторной классификации запроса
+type AccessLogEntry struct {
+	Line     int         `json:"line"`
+	Format   string      `json:"format"`
+	Time     time.Time   `json:"time"`
+	ClientIP string      `json:"client_ip"`
+	Method   string      `json:"method"`
+	Host     string      `json:"host,omitempty"`
+	URI      string      `json:"uri"`
+	Proto    string      `json:"proto,omitempty"`
+	Status   int         `json:"status,omitempty"`
+	Headers  http.Header `json:"headers,omitempty"`
+}
+
+// UserAgent возвращает User-Agent запроса
+func (entry *AccessLogEntry) UserAgent() string {
+	return entry.Headers.Get("User-Agent")
+}
+
+// Request восстанавливает HTTP запрос записи. Тело не сохраняется в журналах,
+// запрос восстанавливается без него.
+func (entry *AccessLogEntry) Request() *http.Request {
+	// URI из журнала может быть некорректным (сканеры, атаки); детекторы клиента его не используют
+	u, err := url.ParseRequestURI(entry.URI)
+	if err != nil {
+		u = &url.URL{Path: "/"}
+	}
+
+	host := entry.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	method := entry.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	proto := entry.Proto
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		proto, major, minor = "HTTP/1.1", 1, 1
+	}
+
+	headers := make(http.Header, len(entry.Headers))
+	for name, values := range entry.Headers {
+		headers[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+
+	return &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+		Header:     headers,
+		Host:       host,
+		RemoteAddr: net.JoinHostPort(entry.ClientIP, "0"),
+		RequestURI: u.RequestURI(),
+	}
+}
+
+// AccessLogReader читает журнал доступа построчно. Формат определяется для каждой
+// строки: JSON журнал Caddy или Common/Combined Log Format.
+type AccessLogReader struct {
+	scanner *bufio.Scanner
+	line    int
+
+	// Строки, которые не удалось разобрать
+	Skipped int
+}
+
+// NewAccessLogReader создает новый экземпляр AccessLogReader
+func NewAccessLogReader(r io.Reader) *AccessLogReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), accessLogMaxLine)
+	return &AccessLogReader{scanner: scanner}
+}
+
+// Next возвращает следующую запись журнала или io.EOF. Пустые строки и записи JSON журнала,
+// не относящиеся к журналу доступа, пропускаются молча; остальные неразобранные строки
+// учитываются в Skipped.
+func (reader *AccessLogReader) Next() (*AccessLogEntry, error) {
+	for reader.scanner.Scan() {
+		reader.line++
+
+		line := strings.TrimSpace(reader.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		entry, err := ParseAccessLogLine(line)
+		if errors.Is(err, errNotAccessLog) {
+			continue
+		}
+		if err != nil {
+			reader.Skipped++
+			continue
+		}
+
+		entry.Line = reader.line
+		return entry, nil
+	}
+
+	if err := reader.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", reader.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+// ParseAccessLogLine разбирает строку журнала доступа: JSON журнал Caddy
+// (логгер http.log.access) или Common/Combined Log Format
+func ParseAccessLogLine(line string) (*AccessLogEntry, error) {
+	if strings.HasPrefix(line, "{") {
+		return parseCaddyAccessLog(line)
+	}
+	return parseCommonAccessLog(line)
+}
+
+// caddyAccessLog поля JSON журнала доступа Caddy, используемые при разборе
+type caddyAccessLog struct {
+	Timestamp json.RawMessage `json:"ts"`
+	Status    int             `json:"status"`
+	Request   *struct {
+		RemoteIP string      `json:"remote_ip"`
+		ClientIP string      `json:"client_ip"`
+		Proto    string      `json:"proto"`
+		Method   string      `json:"method"`
+		Host     string      `json:"host"`
+		URI      string      `json:"uri"`
+		Headers  http.Header `json:"headers"`
+	} `json:"request"`
+}
+
+// parseCaddyAccessLog разбирает запись JSON журнала доступа Caddy
+func parseCaddyAccessLog(line string) (*AccessLogEntry, error) {
+	var record caddyAccessLog
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return nil, err
+	}
+	if record.Request == nil {
+		return nil, errNotAccessLog
+	}
+
+	// client_ip учитывает trusted_proxies; в журналах старых версий Caddy есть только remote_ip
+	clientIP := record.Request.ClientIP
+	if clientIP == "" {
+		clientIP = record.Request.RemoteIP
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return nil, fmt.Errorf("client address: %w", err)
+	}
+
+	return &AccessLogEntry{
+		Format:   AccessLogFormatJSON,
+		Time:     parseCaddyTimestamp(record.Timestamp),
+		ClientIP: addr.Unmap().String(),
+		Method:   record.Request.Method,
+		Host:     record.Request.Host,
+		URI:      record.Request.URI,
+		Proto:    record.Request.Proto,
+		Status:   record.Status,
+		Headers:  record.Request.Headers,
+	}, nil
+}
+
+// parseCaddyTimestamp разбирает время записи: секунды Unix (по умолчанию)
+// или строка RFC 3339 (time_format). Неизвестный формат - нулевое время.
+func parseCaddyTimestamp(raw json.RawMessage) time.Time {
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9))
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseCommonAccessLog разбирает запись в Common или Combined Log Format
+func parseCommonAccessLog(line string) (*AccessLogEntry, error) {
+	match := commonLogPattern.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("unrecognized log line")
+	}
+
+	addr, err := netip.ParseAddr(match[1])
+	if err != nil {
+		return nil, fmt.Errorf("client address: %w", err)
+	}
+
+	entry := &AccessLogEntry{
+		Format:   AccessLogFormatCommon,
+		ClientIP: addr.Unmap().String(),
+		Method:   http.MethodGet,
+		URI:      "/",
+		Headers:  make(http.Header),
+	}
+
+	if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", match[2]); err == nil {
+		entry.Time = t
+	}
+
+	// Строка запроса "GET /path HTTP/1.1"; у некорректных запросов частей может не быть
+	if fields := strings.Fields(commonLogUnescape.Replace(match[3])); len(fields) > 0 {
+		entry.Method = fields[0]
+		if len(fields) > 1 {
+			entry.URI = fields[1]
+		}
+		if len(fields) > 2 {
+			entry.Proto = fields[2]
+		}
+	}
+
+	if match[4] != "-" {
+		fmt.Sscan(match[4], &entry.Status)
+	}
+
+	// Combined Log Format: "-" означает отсутствующий заголовок
+	if match[6] != "" || strings.HasSuffix(match[0], `"`) {
+		entry.Format = AccessLogFormatCombined
+		if referer := commonLogUnescape.Replace(match[5]); referer != "" && referer != "-" {
+			entry.Headers.Set("Referer", referer)
+		}
+		if userAgent := commonLogUnescape.Replace(match[6]); userAgent != "" && userAgent != "-" {
+			entry.Headers.Set("User-Agent", userAgent)
+		}
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,210 @@
+package botredirect
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseAccessLogLine проверяет разбор строк JSON журнала Caddy, Common и Combined Log Format
+func TestParseAccessLogLine(t *testing.T) {
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+	tests := []struct {
+		name    string
+		line    string
+		want    AccessLogEntry
+		wantUA  string
+		wantRef string
+		wantErr string
+	}{
+		{
+			name: "caddy json",
+			line: `{"level":"info","ts":1767225600.5,"logger":"http.log.access","msg":"handled request","request":{"remote_ip":"10.0.0.1","client_ip":"66.249.66.1","proto":"HTTP/2.0","method":"GET","host":"example.com","uri":"/page?x=1","headers":{"User-Agent":["` + googlebot + `"]}},"status":200}`,
+			want: AccessLogEntry{
+				Format: AccessLogFormatJSON, Time: time.Unix(1767225600, 5e8), ClientIP: "66.249.66.1",
+				Method: "GET", Host: "example.com", URI: "/page?x=1", Proto: "HTTP/2.0", Status: 200,
+			},
+			wantUA: googlebot,
+		},
+		{
+			name: "caddy json without client_ip and with rfc3339 time",
+			line: `{"ts":"2026-01-01T00:00:00.25Z","request":{"remote_ip":"::ffff:192.0.2.1","method":"POST","uri":"/login"},"status":403}`,
+			want: AccessLogEntry{
+				Format: AccessLogFormatJSON, Time: time.Date(2026, 1, 1, 0, 0, 0, 25e7, time.UTC), ClientIP: "192.0.2.1",
+				Method: "POST", URI: "/login", Status: 403,
+			},
+		},
+		{
+			name: "caddy json with unknown time format",
+			line: `{"ts":"yesterday","request":{"client_ip":"2001:db8::1","method":"GET","uri":"/"}}`,
+			want: AccessLogEntry{Format: AccessLogFormatJSON, ClientIP: "2001:db8::1", Method: "GET", URI: "/"},
+		},
+		{
+			name:    "caddy json of another logger",
+			line:    `{"level":"info","ts":1767225600,"logger":"tls","msg":"certificate obtained"}`,
+			wantErr: errNotAccessLog.Error(),
+		},
+		{
+			name:    "caddy json with invalid address",
+			line:    `{"request":{"remote_ip":"unknown","method":"GET","uri":"/"}}`,
+			wantErr: "client address",
+		},
+		{
+			name:    "truncated json",
+			line:    `{"request":{"remote_ip":"192.0.2.1"`,
+			wantErr: "unexpected end of JSON input",
+		},
+		{
+			name: "common",
+			line: `192.0.2.1 - frank [10/Oct/2025:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			want: AccessLogEntry{
+				Format: AccessLogFormatCommon, Time: time.Date(2025, 10, 10, 20, 55, 36, 0, time.UTC), ClientIP: "192.0.2.1",
+				Method: "GET", URI: "/apache_pb.gif", Proto: "HTTP/1.0", Status: 200,
+			},
+		},
+		{
+			name: "combined",
+			line: `66.249.66.1 - - [10/Oct/2025:13:55:36 +0000] "GET /robots.txt HTTP/1.1" 404 0 "https://www.google.com/" "` + googlebot + `"`,
+			want: AccessLogEntry{
+				Format: AccessLogFormatCombined, Time: time.Date(2025, 10, 10, 13, 55, 36, 0, time.UTC), ClientIP: "66.249.66.1",
+				Method: "GET", URI: "/robots.txt", Proto: "HTTP/1.1", Status: 404,
+			},
+			wantUA:  googlebot,
+			wantRef: "https://www.google.com/",
+		},
+		{
+			name: "combined with escaped quotes and missing headers",
+			line: `2001:db8::1 - - [10/Oct/2025:13:55:36 +0000] "GET /q?\"x\" HTTP/1.1" 200 5 "-" "curl \"7\""`,
+			want: AccessLogEntry{
+				Format: AccessLogFormatCombined, Time: time.Date(2025, 10, 10, 13, 55, 36, 0, time.UTC), ClientIP: "2001:db8::1",
+				Method: "GET", URI: `/q?"x"`, Proto: "HTTP/1.1", Status: 200,
+			},
+			wantUA: `curl "7"`,
+		},
+		{
+			name: "malformed request line",
+			line: `192.0.2.1 - - [bad time] "-" - -`,
+			want: AccessLogEntry{Format: AccessLogFormatCommon, ClientIP: "192.0.2.1", Method: "-", URI: "/"},
+		},
+		{
+			name: "empty request line",
+			line: `192.0.2.1 - - [10/Oct/2025:13:55:36 +0000] "" 400 0 "" ""`,
+			want: AccessLogEntry{
+				Format: AccessLogFormatCombined, Time: time.Date(2025, 10, 10, 13, 55, 36, 0, time.UTC), ClientIP: "192.0.2.1",
+				Method: "GET", URI: "/", Status: 400,
+			},
+		},
+		{
+			name:    "common with invalid address",
+			line:    `example.com - - [10/Oct/2025:13:55:36 +0000] "GET / HTTP/1.1" 200 0`,
+			wantErr: "client address",
+		},
+		{
+			name:    "unrecognized line",
+			line:    `Oct 10 13:55:36 host sshd[1]: Accepted publickey`,
+			wantErr: "unrecognized log line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := ParseAccessLogLine(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if entry.Format != tt.want.Format || !entry.Time.Equal(tt.want.Time) || entry.ClientIP != tt.want.ClientIP ||
+				entry.Method != tt.want.Method || entry.Host != tt.want.Host || entry.URI != tt.want.URI ||
+				entry.Proto != tt.want.Proto || entry.Status != tt.want.Status {
+				t.Errorf("entry = %+v, want %+v", entry, tt.want)
+			}
+			if entry.UserAgent() != tt.wantUA {
+				t.Errorf("user agent = %q, want %q", entry.UserAgent(), tt.wantUA)
+			}
+			if referer := entry.Headers.Get("Referer"); referer != tt.wantRef {
+				t.Errorf("referer = %q, want %q", referer, tt.wantRef)
+			}
+		})
+	}
+}
+
+// TestAccessLogReader проверяет номера строк и учет пропущенных строк
+func TestAccessLogReader(t *testing.T) {
+	input := strings.Join([]string{
+		`192.0.2.1 - - [10/Oct/2025:13:55:36 +0000] "GET / HTTP/1.1" 200 0`,
+		``,
+		`{"logger":"tls","msg":"certificate obtained"}`,
+		`not a log line`,
+		`{"request":{"client_ip":"192.0.2.2","method":"GET","uri":"/a"}}`,
+		`{"request":`,
+		`   192.0.2.3 - - [10/Oct/2025:13:55:36 +0000] "GET /b HTTP/1.1" 200 0   `,
+	}, "\n")
+
+	reader := NewAccessLogReader(strings.NewReader(input))
+
+	var lines []int
+	var clients []string
+	for {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, entry.Line)
+		clients = append(clients, entry.ClientIP)
+	}
+
+	if got := strings.Join(clients, " "); got != "192.0.2.1 192.0.2.2 192.0.2.3" {
+		t.Errorf("clients = %s", got)
+	}
+	if len(lines) != 3 || lines[0] != 1 || lines[1] != 5 || lines[2] != 7 {
+		t.Errorf("lines = %v, want [1 5 7]", lines)
+	}
+	if reader.Skipped != 2 {
+		t.Errorf("skipped = %d, want 2", reader.Skipped)
+	}
+
+	// Строка длиннее допустимой - ошибка чтения с номером строки
+	long := NewAccessLogReader(strings.NewReader("192.0.2.1 - - [x] \"GET /" + strings.Repeat("a", accessLogMaxLine) + "\" 200 0\n"))
+	if _, err := long.Next(); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("long line: error = %v, want line 1 error", err)
+	}
+}
+
+// TestAccessLogEntryRequest проверяет восстановление запроса из записи журнала
+func TestAccessLogEntryRequest(t *testing.T) {
+	entry := &AccessLogEntry{
+		ClientIP: "2001:db8::1",
+		URI:      "http://[::1",
+		Proto:    "HTTP/9",
+		Headers:  map[string][]string{"user-agent": {"ExampleBot/1.0"}},
+	}
+
+	r := entry.Request()
+	if r.Method != "GET" || r.URL.Path != "/" || r.Host != "localhost" || r.Proto != "HTTP/1.1" {
+		t.Errorf("request = %s %s %s %s", r.Method, r.URL, r.Host, r.Proto)
+	}
+	if r.RemoteAddr != "[2001:db8::1]:0" || canonicalHost(r.RemoteAddr) != "2001:db8::1" {
+		t.Errorf("remote addr = %q", r.RemoteAddr)
+	}
+	if r.UserAgent() != "ExampleBot/1.0" {
+		t.Errorf("user agent = %q", r.UserAgent())
+	}
+
+	// Заголовки запроса не разделяют срезы с записью
+	r.Header.Add("User-Agent", "changed")
+	if len(entry.Headers["user-agent"]) != 1 {
+		t.Error("request headers share values with the entry")
+	}
+}
@@ -0,0 +1,473 @@
+package botredirect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// defaultHandlerID идентификатор экземпляра без явно заданного id
+const defaultHandlerID = "default"
+
+// adminAPIPrefix префикс маршрутов admin API
+const adminAPIPrefix = "/bot_redirect/"
+
+// adminMaxBodySize ограничение размера тела запроса к admin API
+const adminMaxBodySize = 1 << 20
+
+// handlerIDPattern допустимый идентификатор экземпляра (используется в пути admin API)
+var handlerIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// handlerRegistration экземпляр обработчика в admin API
+type handlerRegistration struct {
+	handler *BotRedirect
+
+	// Контекст загрузки конфигурации: общий для всех экземпляров одной конфигурации
+	generation context.Context
+}
+
+// Экземпляры обработчика, доступные в admin API
+var (
+	handlersMutex sync.RWMutex
+	handlers      = make(map[string]*handlerRegistration)
+)
+
+// checkHandlerID проверяет, что id не занят другим экземпляром той же конфигурации:
+// у таких экземпляров были бы общие маршруты admin API и файл изменений списков
+func checkHandlerID(br *BotRedirect, generation context.Context) error {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+
+	if registration, ok := handlers[br.ID]; ok && registration.generation == generation && registration.handler != br {
+		return fmt.Errorf("duplicate id %q: every bot_redirect handler in a config needs a distinct id", br.ID)
+	}
+	return nil
+}
+
+// registerHandler делает экземпляр доступным в admin API. При перезагрузке конфигурации
+// новый экземпляр с тем же id заменяет старый до его Cleanup.
+func registerHandler(br *BotRedirect, generation context.Context) {
+	handlersMutex.Lock()
+	handlers[br.ID] = &handlerRegistration{handler: br, generation: generation}
+	handlersMutex.Unlock()
+}
+
+// unregisterHandler удаляет экземпляр, если id не занят уже более новым экземпляром
+func unregisterHandler(br *BotRedirect) {
+	handlersMutex.Lock()
+	if registration, ok := handlers[br.ID]; ok && registration.handler == br {
+		delete(handlers, br.ID)
+	}
+	handlersMutex.Unlock()
+}
+
+// lookupHandler возвращает экземпляр по id
+func lookupHandler(id string) (*BotRedirect, bool) {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+
+	registration, ok := handlers[id]
+	if !ok {
+		return nil, false
+	}
+	return registration.handler, registration.handler.botDetector != nil
+}
+
+// previousHandler возвращает экземпляр с тем же id из предыдущей загрузки конфигурации
+func previousHandler(id string, generation context.Context) (*BotRedirect, bool) {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+
+	registration, ok := handlers[id]
+	if !ok || registration.generation == generation {
+		return nil, false
+	}
+	return registration.handler, registration.handler.botDetector != nil
+}
+
+// AdminAPI модуль admin API Caddy для просмотра статистики и управления экземплярами bot_redirect.
+//
+//	GET    /bot_redirect/                      список экземпляров
+//	GET    /bot_redirect/<id>/stats            статистика детектора и компонентов
+//	GET    /bot_redirect/<id>/metrics          метрики плагина
+//	GET    /bot_redirect/<id>/config           действующая конфигурация (секреты скрыты)
+//	GET    /bot_redirect/<id>/lists            паттерны User-Agent, IP диапазоны, домены
+//	POST   /bot_redirect/<id>/lists            добавление и удаление элементов списков
+//	POST   /bot_redirect/<id>/cache/purge      очистка кешей по IP и/или User-Agent
+//	POST   /bot_redirect/<id>/limiter/reset    сброс лимитов клиента или всех лимитов
+//	GET    /bot_redirect/<id>/bans             действующие баны
+//	POST   /bot_redirect/<id>/bans             ручной бан
+//	DELETE /bot_redirect/<id>/bans?key=<key>   снятие бана
+//	GET    /bot_redirect/<id>/overrides        ручные переопределения
+//	POST   /bot_redirect/<id>/overrides        добавление переопределения
+//	DELETE /bot_redirect/<id>/overrides?id=<id> удаление переопределения
+type AdminAPI struct{}
+
+// CaddyModule возвращает информацию о модуле
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.bot_redirect",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes возвращает маршруты admin API
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: adminAPIPrefix,
+			Handler: caddy.AdminHandlerFunc(a.handleAPI),
+		},
+	}
+}
+
+// adminCachePurgeRequest тело запроса очистки кешей
+type adminCachePurgeRequest struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+}
+
+// adminListsRequest тело запроса изменения списков
+type adminListsRequest struct {
+	Changes []ListChange `json:"changes"`
+}
+
+// adminOverrideRequest тело запроса добавления переопределения
+type adminOverrideRequest struct {
+	OverrideRule
+
+	// Длительность действия (альтернатива expires_at)
+	Duration caddy.Duration `json:"duration"`
+}
+
+// adminLimiterResetRequest тело запроса сброса лимитов
+type adminLimiterResetRequest struct {
+	IP string `json:"ip"`
+}
+
+// adminBanRequest тело запроса ручного бана
+type adminBanRequest struct {
+	IP       string         `json:"ip"`
+	Key      string         `json:"key"`
+	Duration caddy.Duration `json:"duration"`
+	Reason   string         `json:"reason"`
+}
+
+// handleAPI разбирает путь и вызывает обработчик ресурса
+func (a *AdminAPI) handleAPI(w http.ResponseWriter, r *http.Request) error {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, adminAPIPrefix), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			return adminMethodNotAllowed()
+		}
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"handlers": handlerIDs()})
+	}
+
+	id, resource, _ := strings.Cut(path, "/")
+	br, ok := lookupHandler(id)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("bot_redirect handler %q not found", id),
+		}
+	}
+	bd := br.botDetector
+
+	switch resource {
+	case "stats":
+		if r.Method != http.MethodGet {
+			return adminMethodNotAllowed()
+		}
+		return adminRespond(w, http.StatusOK, bd.GetStats())
+
+	case "metrics":
+		if r.Method != http.MethodGet {
+			return adminMethodNotAllowed()
+		}
+		bd.GetMetrics().ServeHTTP(w, r)
+		return nil
+
+	case "config":
+		if r.Method != http.MethodGet {
+			return adminMethodNotAllowed()
+		}
+		config, err := br.effectiveConfig()
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+		}
+		return adminRespond(w, http.StatusOK, config)
+
+	case "lists":
+		switch r.Method {
+		case http.MethodGet:
+			return adminRespond(w, http.StatusOK, bd.GetLists())
+		case http.MethodPost:
+			var request adminListsRequest
+			if err := adminDecode(r, &request); err != nil {
+				return err
+			}
+			applied, err := bd.GetListEditor().Apply(request.Changes)
+			if errors.Is(err, errListsNotSaved) {
+				return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+			}
+			if err != nil {
+				return adminBadRequest(err)
+			}
+			return adminRespond(w, http.StatusOK, map[string]interface{}{"applied": applied, "lists": bd.GetLists()})
+		default:
+			return adminMethodNotAllowed()
+		}
+
+	case "cache/purge":
+		if r.Method != http.MethodPost {
+			return adminMethodNotAllowed()
+		}
+		var request adminCachePurgeRequest
+		if err := adminDecode(r, &request); err != nil {
+			return err
+		}
+		if request.IP != "" && !isIPAddress(request.IP) {
+			return adminBadRequest(fmt.Errorf("invalid IP address: %s", request.IP))
+		}
+
+		purged := bd.PurgeCache(request.IP, request.UserAgent)
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"purged": purged})
+
+	case "limiter/reset":
+		if r.Method != http.MethodPost {
+			return adminMethodNotAllowed()
+		}
+		var request adminLimiterResetRequest
+		if err := adminDecode(r, &request); err != nil {
+			return err
+		}
+
+		rateLimiter := bd.GetRateLimiter()
+		if rateLimiter == nil || !rateLimiter.IsEnabled() {
+			return caddy.APIError{HTTPStatus: http.StatusConflict, Err: fmt.Errorf("rate limiting is disabled")}
+		}
+
+		if request.IP == "" {
+			rateLimiter.Reset()
+			return adminRespond(w, http.StatusOK, map[string]interface{}{"reset": "all"})
+		}
+
+		if !isIPAddress(request.IP) {
+			return adminBadRequest(fmt.Errorf("invalid IP address: %s", request.IP))
+		}
+		deleted, err := rateLimiter.ResetClient(request.IP)
+		if err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadGateway, Err: err}
+		}
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"reset": request.IP, "deleted": deleted})
+
+	case "bans":
+		return a.handleBans(w, r, br)
+
+	case "overrides":
+		return a.handleOverrides(w, r, br)
+
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown resource: %s", resource),
+		}
+	}
+}
+
+// handleBans просмотр, выдача и снятие банов
+func (a *AdminAPI) handleBans(w http.ResponseWriter, r *http.Request, br *BotRedirect) error {
+	bd := br.botDetector
+	banManager := bd.GetBanManager()
+	if banManager == nil || !banManager.IsEnabled() {
+		return caddy.APIError{HTTPStatus: http.StatusConflict, Err: fmt.Errorf("ban manager is disabled (ban_threshold is not set)")}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"bans": banManager.List()})
+
+	case http.MethodPost:
+		var request adminBanRequest
+		if err := adminDecode(r, &request); err != nil {
+			return err
+		}
+
+		target := request.Key
+		if target == "" {
+			target = request.IP
+		}
+		if target == "" {
+			return adminBadRequest(fmt.Errorf("ip or key is required"))
+		}
+
+		ban, err := bd.Ban(target, time.Duration(request.Duration), request.Reason)
+		if err != nil {
+			return adminBadRequest(err)
+		}
+		return adminRespond(w, http.StatusCreated, ban)
+
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			return adminBadRequest(fmt.Errorf("key query parameter is required"))
+		}
+		if !bd.LiftBan(key) {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no ban for %s", key)}
+		}
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"lifted": key})
+
+	default:
+		return adminMethodNotAllowed()
+	}
+}
+
+// handleOverrides просмотр, добавление и удаление ручных переопределений
+func (a *AdminAPI) handleOverrides(w http.ResponseWriter, r *http.Request, br *BotRedirect) error {
+	overrides := br.botDetector.GetOverrideTable()
+
+	switch r.Method {
+	case http.MethodGet:
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"overrides": overrides.List()})
+
+	case http.MethodPost:
+		var request adminOverrideRequest
+		if err := adminDecode(r, &request); err != nil {
+			return err
+		}
+
+		rule := request.OverrideRule
+		if request.Duration != 0 {
+			if !rule.ExpiresAt.IsZero() {
+				return adminBadRequest(fmt.Errorf("duration and expires_at are mutually exclusive"))
+			}
+			if request.Duration < 0 {
+				return adminBadRequest(fmt.Errorf("duration must be positive"))
+			}
+			rule.ExpiresAt = time.Now().Add(time.Duration(request.Duration))
+		}
+
+		override, err := overrides.Add(rule)
+		if err != nil {
+			return adminBadRequest(err)
+		}
+		return adminRespond(w, http.StatusCreated, override)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			return adminBadRequest(fmt.Errorf("id query parameter is required"))
+		}
+		if !overrides.Remove(id) {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no override %s", id)}
+		}
+		return adminRespond(w, http.StatusOK, map[string]interface{}{"removed": id})
+
+	default:
+		return adminMethodNotAllowed()
+	}
+}
+
+// handlerIDs возвращает отсортированный список идентификаторов экземпляров
+func handlerIDs() []string {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+
+	ids := make([]string, 0, len(handlers))
+	for id := range handlers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// effectiveConfig возвращает конфигурацию экземпляра после применения значений по умолчанию.
+// Секреты проверки браузера и пароль Redis скрываются.
+func (br *BotRedirect) effectiveConfig() (map[string]interface{}, error) {
+	data, err := json.Marshal(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	if secrets, ok := config["challenge_secrets"].([]interface{}); ok {
+		for i := range secrets {
+			secrets[i] = "REDACTED"
+		}
+	}
+	if redisURL, ok := config["rate_limit_redis_url"].(string); ok {
+		config["rate_limit_redis_url"] = redactURL(redisURL)
+	}
+
+	return config, nil
+}
+
+// redactURL скрывает пароль в URL
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "REDACTED"
+	}
+	return parsed.Redacted()
+}
+
+// isIPAddress проверяет, что строка - IP адрес (порт допускается)
+func isIPAddress(value string) bool {
+	_, err := netip.ParseAddr(canonicalHost(value))
+	return err == nil
+}
+
+// adminDecode разбирает JSON тело запроса; пустое тело допускается
+func adminDecode(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, adminMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return adminBadRequest(fmt.Errorf("decoding request body: %v", err))
+	}
+	return nil
+}
+
+// adminRespond отправляет JSON ответ
+func adminRespond(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	return nil
+}
+
+// adminBadRequest ошибка некорректного запроса
+func adminBadRequest(err error) error {
+	return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+}
+
+// adminMethodNotAllowed ошибка неподдерживаемого метода
+func adminMethodNotAllowed() error {
+	return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
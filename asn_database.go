@@ -0,0 +1,153 @@
+package botredirect
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ASNDatabase сопоставляет IP адреса с номерами автономных систем.
+// Поддерживаются текстовые файлы "CIDR ASN" и формат pfx2as ("адрес<TAB>длина<TAB>ASN").
+type ASNDatabase struct {
+	// Префиксы по длине маски; поиск идет от самой длинной маски
+	ipv4 map[int]map[netip.Addr]uint32
+	ipv6 map[int]map[netip.Addr]uint32
+
+	ipv4Lengths []int
+	ipv6Lengths []int
+
+	source  string
+	entries int
+}
+
+// LoadASNDatabase загружает базу ASN из файла
+func LoadASNDatabase(path string) (*ASNDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db := &ASNDatabase{
+		ipv4:   make(map[int]map[netip.Addr]uint32),
+		ipv6:   make(map[int]map[netip.Addr]uint32),
+		source: path,
+	}
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prefix, asn, err := parseASNLine(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		db.add(prefix, asn)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	db.ipv4Lengths = sortedLengths(db.ipv4)
+	db.ipv6Lengths = sortedLengths(db.ipv6)
+
+	return db, nil
+}
+
+// parseASNLine разбирает строку "CIDR ASN" или "адрес длина ASN"
+func parseASNLine(fields []string) (netip.Prefix, uint32, error) {
+	var prefixStr, asnStr string
+	switch {
+	case len(fields) >= 2 && strings.Contains(fields[0], "/"):
+		prefixStr, asnStr = fields[0], fields[1]
+	case len(fields) >= 3:
+		prefixStr, asnStr = fields[0]+"/"+fields[1], fields[2]
+	default:
+		return netip.Prefix{}, 0, fmt.Errorf("expected CIDR and ASN")
+	}
+
+	prefix, err := netip.ParsePrefix(prefixStr)
+	if err != nil {
+		return netip.Prefix{}, 0, err
+	}
+
+	// pfx2as помечает MOAS префиксы как "A_B"; используем первую AS
+	asnStr = strings.TrimPrefix(strings.ToUpper(asnStr), "AS")
+	if idx := strings.IndexAny(asnStr, "_,"); idx != -1 {
+		asnStr = asnStr[:idx]
+	}
+	asn, err := strconv.ParseUint(asnStr, 10, 32)
+	if err != nil {
+		return netip.Prefix{}, 0, fmt.Errorf("invalid ASN %s", asnStr)
+	}
+
+	return prefix.Masked(), uint32(asn), nil
+}
+
+// add добавляет префикс в базу
+func (db *ASNDatabase) add(prefix netip.Prefix, asn uint32) {
+	table := db.ipv6
+	if prefix.Addr().Is4() {
+		table = db.ipv4
+	}
+
+	bits := prefix.Bits()
+	if table[bits] == nil {
+		table[bits] = make(map[netip.Addr]uint32)
+	}
+	table[bits][prefix.Addr()] = asn
+	db.entries++
+}
+
+// sortedLengths возвращает длины масок по убыванию
+func sortedLengths(table map[int]map[netip.Addr]uint32) []int {
+	lengths := make([]int, 0, len(table))
+	for bits := range table {
+		lengths = append(lengths, bits)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
+	return lengths
+}
+
+// Lookup возвращает ASN для IP адреса по самому длинному совпадающему префиксу
+func (db *ASNDatabase) Lookup(addr netip.Addr) (uint32, bool) {
+	if db == nil || !addr.IsValid() {
+		return 0, false
+	}
+
+	addr = addr.Unmap()
+	table, lengths := db.ipv6, db.ipv6Lengths
+	if addr.Is4() {
+		table, lengths = db.ipv4, db.ipv4Lengths
+	}
+
+	for _, bits := range lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if asn, ok := table[bits][prefix.Addr()]; ok {
+			return asn, true
+		}
+	}
+
+	return 0, false
+}
+
+// GetStats возвращает статистику базы
+func (db *ASNDatabase) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"source":  db.source,
+		"entries": db.entries,
+	}
+}
@@ -0,0 +1,623 @@
+package botredirect
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Причины нарушений, учитываемых менеджером банов
+const (
+	OffenseRateLimit = "rate_limit"
+	OffenseHoneypot  = "honeypot"
+	OffenseSpoofing  = "spoofing"
+	OffenseManual    = "manual"
+)
+
+// maxBanEntries ограничивает количество отслеживаемых клиентов
+const maxBanEntries = 100000
+
+// BanManager выдает клиентам баны с нарастающей длительностью (в духе fail2ban).
+// Нарушения накапливаются до порога, каждый следующий бан длиннее предыдущего в factor раз
+// (но не дольше maxDuration), а без новых нарушений счетчики и уровень бана снижаются
+// раз в decay. Клиенты группируются по префиксу сети.
+type BanManager struct {
+	// Конфигурация
+	enabled     bool
+	threshold   int
+	duration    time.Duration
+	factor      float64
+	maxDuration time.Duration
+	decay       time.Duration
+	ipv4Prefix  int
+	ipv6Prefix  int
+
+	// Состояние по префиксу
+	entries map[string]*BanEntry
+	mutex   sync.Mutex
+	clock   Clock
+
+	// Очистка
+	stopCleanup chan bool
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	offenses       int64
+	bansIssued     int64
+	blockedChecks  int64
+	liftedBans     int64
+	importedBans   int64
+	droppedEntries int64
+}
+
+// BanEntry состояние клиента в менеджере банов
+type BanEntry struct {
+	Key         string    `json:"key"`
+	LastIP      string    `json:"last_ip"`
+	Reason      string    `json:"reason"`
+	Offenses    int       `json:"offenses"`
+	Level       int       `json:"level"`
+	BannedAt    time.Time `json:"banned_at"`
+	BannedUntil time.Time `json:"banned_until"`
+	LastOffense time.Time `json:"last_offense"`
+
+	// Момент, от которого отсчитывается снижение счетчиков
+	decayFrom time.Time
+}
+
+// offenseWeights вес нарушений: попадание в ловушку сразу достигает порога
+var offenseWeights = map[string]int{
+	OffenseRateLimit: 1,
+	OffenseSpoofing:  1,
+}
+
+// NewBanManager создает новый экземпляр BanManager
+func NewBanManager(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *BanManager {
+	if config.BanThreshold <= 0 {
+		return &BanManager{enabled: false}
+	}
+
+	bm := &BanManager{
+		enabled:     true,
+		threshold:   config.BanThreshold,
+		duration:    config.BanDuration,
+		factor:      config.BanFactor,
+		maxDuration: config.BanMaxDuration,
+		decay:       config.BanDecay,
+		ipv4Prefix:  config.BanIPv4Prefix,
+		ipv6Prefix:  config.BanIPv6Prefix,
+		entries:     make(map[string]*BanEntry),
+		clock:       systemClock{},
+		stopCleanup: make(chan bool, 1),
+		metrics:     metrics,
+		debug:       debug,
+		logger:      logger,
+	}
+
+	if bm.factor < 1 {
+		bm.factor = 1
+	}
+	if bm.maxDuration < bm.duration {
+		bm.maxDuration = bm.duration
+	}
+	if bm.ipv4Prefix <= 0 || bm.ipv4Prefix > 32 {
+		bm.ipv4Prefix = 32
+	}
+	if bm.ipv6Prefix <= 0 || bm.ipv6Prefix > 128 {
+		bm.ipv6Prefix = 64
+	}
+
+	bm.startCleanup()
+
+	logger.Info("ban manager initialized",
+		zap.Int("threshold", bm.threshold),
+		zap.Duration("duration", bm.duration),
+		zap.Float64("factor", bm.factor),
+		zap.Duration("max_duration", bm.maxDuration),
+		zap.Duration("decay", bm.decay),
+	)
+
+	return bm
+}
+
+// prefixKey возвращает ключ сети для IP адреса
+func (bm *BanManager) prefixKey(ipStr string) (string, error) {
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+	addr = addr.Unmap()
+
+	bits := bm.ipv6Prefix
+	if addr.Is4() {
+		bits = bm.ipv4Prefix
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
+
+// extractIP извлекает IP адрес из строки (убирает порт)
+func (bm *BanManager) extractIP(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// Offense учитывает нарушение клиента и выдает бан при достижении порога.
+// Возвращает запись клиента, если в результате был выдан бан.
+func (bm *BanManager) Offense(remoteAddr, reason string) *BanEntry {
+	if !bm.enabled {
+		return nil
+	}
+
+	ipStr := bm.extractIP(remoteAddr)
+	key, err := bm.prefixKey(ipStr)
+	if err != nil {
+		return nil
+	}
+
+	atomic.AddInt64(&bm.offenses, 1)
+
+	weight, known := offenseWeights[reason]
+	if !known {
+		weight = bm.threshold
+	}
+
+	bm.mutex.Lock()
+	now := bm.clock.Now()
+
+	entry, exists := bm.entries[key]
+	if !exists {
+		if len(bm.entries) >= maxBanEntries {
+			bm.mutex.Unlock()
+			atomic.AddInt64(&bm.droppedEntries, 1)
+			return nil
+		}
+		entry = &BanEntry{Key: key, decayFrom: now}
+		bm.entries[key] = entry
+	}
+
+	// Нарушения во время бана не продлевают его
+	if now.Before(entry.BannedUntil) {
+		bm.mutex.Unlock()
+		return nil
+	}
+
+	bm.applyDecay(entry, now)
+
+	entry.LastIP = ipStr
+	entry.Reason = reason
+	entry.Offenses += weight
+	entry.LastOffense = now
+	entry.decayFrom = now
+
+	if entry.Offenses < bm.threshold {
+		bm.mutex.Unlock()
+		return nil
+	}
+
+	banDuration := bm.banDuration(entry.Level)
+	entry.Level++
+	entry.Offenses = 0
+	entry.BannedAt = now
+	entry.BannedUntil = now.Add(banDuration)
+	entry.decayFrom = entry.BannedUntil
+	snapshot := *entry
+	bm.mutex.Unlock()
+
+	atomic.AddInt64(&bm.bansIssued, 1)
+	if bm.metrics != nil {
+		bm.metrics.IncrementBansIssued(reason)
+	}
+
+	bm.logger.Warn("client banned",
+		zap.String("key", key),
+		zap.String("ip", ipStr),
+		zap.String("reason", reason),
+		zap.Int("level", snapshot.Level),
+		zap.Duration("duration", banDuration),
+		zap.Time("banned_until", snapshot.BannedUntil),
+	)
+
+	return &snapshot
+}
+
+// banDuration возвращает длительность бана для уровня эскалации
+func (bm *BanManager) banDuration(level int) time.Duration {
+	duration := float64(bm.duration) * math.Pow(bm.factor, float64(level))
+	if duration > float64(bm.maxDuration) {
+		return bm.maxDuration
+	}
+	return time.Duration(duration)
+}
+
+// applyDecay снижает счетчик нарушений и уровень бана за периоды без нарушений
+func (bm *BanManager) applyDecay(entry *BanEntry, now time.Time) {
+	if bm.decay <= 0 {
+		return
+	}
+
+	elapsed := now.Sub(entry.decayFrom)
+	if elapsed < bm.decay {
+		return
+	}
+
+	periods := int(elapsed / bm.decay)
+	entry.Offenses = 0
+	entry.Level -= periods
+	if entry.Level < 0 {
+		entry.Level = 0
+	}
+	entry.decayFrom = entry.decayFrom.Add(time.Duration(periods) * bm.decay)
+}
+
+// Check возвращает действующий бан для клиента или nil
+func (bm *BanManager) Check(remoteAddr string) *BanEntry {
+	if !bm.enabled {
+		return nil
+	}
+
+	key, err := bm.prefixKey(bm.extractIP(remoteAddr))
+	if err != nil {
+		return nil
+	}
+
+	bm.mutex.Lock()
+	entry, exists := bm.entries[key]
+	if !exists || !bm.clock.Now().Before(entry.BannedUntil) {
+		bm.mutex.Unlock()
+		return nil
+	}
+	snapshot := *entry
+	bm.mutex.Unlock()
+
+	atomic.AddInt64(&bm.blockedChecks, 1)
+	if bm.metrics != nil {
+		bm.metrics.IncrementBannedRequests(snapshot.Reason)
+	}
+
+	return &snapshot
+}
+
+// List возвращает действующие баны, отсортированные по времени окончания
+func (bm *BanManager) List() []BanEntry {
+	if !bm.enabled {
+		return nil
+	}
+
+	bm.mutex.Lock()
+	now := bm.clock.Now()
+	bans := make([]BanEntry, 0)
+	for _, entry := range bm.entries {
+		if now.Before(entry.BannedUntil) {
+			bans = append(bans, *entry)
+		}
+	}
+	bm.mutex.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].BannedUntil.Before(bans[j].BannedUntil)
+	})
+
+	return bans
+}
+
+// ResolveKey возвращает ключ сети для ключа сети или IP адреса
+func (bm *BanManager) ResolveKey(keyOrIP string) (string, bool) {
+	if _, err := netip.ParsePrefix(keyOrIP); err == nil {
+		return keyOrIP, true
+	}
+	key, err := bm.prefixKey(keyOrIP)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// Get возвращает действующий бан по ключу сети или nil
+func (bm *BanManager) Get(key string) *BanEntry {
+	if !bm.enabled {
+		return nil
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	entry, exists := bm.entries[key]
+	if !exists || !bm.clock.Now().Before(entry.BannedUntil) {
+		return nil
+	}
+	snapshot := *entry
+	return &snapshot
+}
+
+// Ban вручную банит IP адрес или сеть на заданное время (0 - базовая длительность бана).
+// Сеть должна совпадать с префиксом, по которому группируются клиенты.
+func (bm *BanManager) Ban(keyOrIP string, duration time.Duration, reason string) (*BanEntry, error) {
+	if !bm.enabled {
+		return nil, fmt.Errorf("ban manager is disabled")
+	}
+
+	key, ok := bm.ResolveKey(keyOrIP)
+	if !ok {
+		return nil, fmt.Errorf("invalid IP address or network: %s", keyOrIP)
+	}
+	if prefix, err := netip.ParsePrefix(key); err == nil {
+		if expected, err := bm.prefixKey(prefix.Addr().String()); err != nil || expected != key {
+			return nil, fmt.Errorf("network %s does not match ban prefix length (v4/%d, v6/%d)", key, bm.ipv4Prefix, bm.ipv6Prefix)
+		}
+	}
+
+	if duration <= 0 {
+		duration = bm.duration
+	}
+	if reason == "" {
+		reason = OffenseManual
+	}
+
+	bm.mutex.Lock()
+	now := bm.clock.Now()
+
+	entry, exists := bm.entries[key]
+	if !exists {
+		if len(bm.entries) >= maxBanEntries {
+			bm.mutex.Unlock()
+			atomic.AddInt64(&bm.droppedEntries, 1)
+			return nil, fmt.Errorf("too many tracked clients")
+		}
+		entry = &BanEntry{Key: key}
+		bm.entries[key] = entry
+	}
+
+	entry.Reason = reason
+	entry.Offenses = 0
+	entry.BannedAt = now
+	entry.BannedUntil = now.Add(duration)
+	entry.decayFrom = entry.BannedUntil
+	snapshot := *entry
+	bm.mutex.Unlock()
+
+	atomic.AddInt64(&bm.bansIssued, 1)
+	if bm.metrics != nil {
+		bm.metrics.IncrementBansIssued(reason)
+	}
+
+	bm.logger.Warn("client banned manually",
+		zap.String("key", key),
+		zap.String("reason", reason),
+		zap.Duration("duration", duration),
+		zap.Time("banned_until", snapshot.BannedUntil),
+	)
+
+	return &snapshot, nil
+}
+
+// Import принимает бан, выданный другим узлом кластера.
+// Возвращает true, если бан продлил или добавил локальный.
+func (bm *BanManager) Import(entry BanEntry) bool {
+	if !bm.enabled || entry.Key == "" {
+		return false
+	}
+
+	bm.mutex.Lock()
+	now := bm.clock.Now()
+	if !now.Before(entry.BannedUntil) {
+		bm.mutex.Unlock()
+		return false
+	}
+
+	local, exists := bm.entries[entry.Key]
+	if exists && !local.BannedUntil.Before(entry.BannedUntil) {
+		bm.mutex.Unlock()
+		return false
+	}
+	if !exists && len(bm.entries) >= maxBanEntries {
+		bm.mutex.Unlock()
+		atomic.AddInt64(&bm.droppedEntries, 1)
+		return false
+	}
+
+	imported := entry
+	imported.decayFrom = entry.BannedUntil
+	bm.entries[entry.Key] = &imported
+	bm.mutex.Unlock()
+
+	atomic.AddInt64(&bm.importedBans, 1)
+	if bm.metrics != nil {
+		bm.metrics.RecordBanImported(entry.Reason)
+	}
+	bm.logger.Info("ban imported",
+		zap.String("key", entry.Key),
+		zap.String("reason", entry.Reason),
+		zap.Time("banned_until", entry.BannedUntil),
+	)
+
+	return true
+}
+
+// ImportFrom переносит баны и историю нарушений из предыдущего экземпляра (при перезагрузке
+// конфигурации), чтобы перезагрузка не снимала баны и не сбрасывала эскалацию.
+// Записи сетей другой длины префикса пропускаются.
+func (bm *BanManager) ImportFrom(previous *BanManager) int {
+	if !bm.enabled || previous == nil || !previous.enabled || previous == bm {
+		return 0
+	}
+
+	previous.mutex.Lock()
+	entries := make([]BanEntry, 0, len(previous.entries))
+	for _, entry := range previous.entries {
+		entries = append(entries, *entry)
+	}
+	previous.mutex.Unlock()
+
+	imported := 0
+	bm.mutex.Lock()
+	for i := range entries {
+		entry := &entries[i]
+		prefix, err := netip.ParsePrefix(entry.Key)
+		if err != nil {
+			continue
+		}
+		if expected, err := bm.prefixKey(prefix.Addr().String()); err != nil || expected != entry.Key {
+			continue
+		}
+		if _, exists := bm.entries[entry.Key]; exists || len(bm.entries) >= maxBanEntries {
+			continue
+		}
+		bm.entries[entry.Key] = entry
+		imported++
+	}
+	bm.mutex.Unlock()
+
+	if imported > 0 {
+		bm.logger.Info("bans carried over", zap.Int("entries", imported))
+	}
+
+	return imported
+}
+
+// Lift снимает бан и сбрасывает историю нарушений. Принимает ключ сети или IP адрес.
+func (bm *BanManager) Lift(keyOrIP string) bool {
+	if !bm.enabled {
+		return false
+	}
+
+	key, ok := bm.ResolveKey(keyOrIP)
+	if !ok {
+		return false
+	}
+
+	bm.mutex.Lock()
+	_, exists := bm.entries[key]
+	delete(bm.entries, key)
+	bm.mutex.Unlock()
+
+	if exists {
+		atomic.AddInt64(&bm.liftedBans, 1)
+		if bm.metrics != nil {
+			bm.metrics.RecordBanLifted()
+		}
+		bm.logger.Info("ban lifted", zap.String("key", key))
+	}
+
+	return exists
+}
+
+// SetClock подменяет источник времени
+func (bm *BanManager) SetClock(clock Clock) {
+	bm.mutex.Lock()
+	bm.clock = clock
+	bm.mutex.Unlock()
+}
+
+// cleanup удаляет записи без бана, нарушений и уровня эскалации
+func (bm *BanManager) cleanup() {
+	bm.mutex.Lock()
+	now := bm.clock.Now()
+	for key, entry := range bm.entries {
+		if now.Before(entry.BannedUntil) {
+			continue
+		}
+		bm.applyDecay(entry, now)
+		if entry.Level == 0 && entry.Offenses == 0 {
+			delete(bm.entries, key)
+		}
+	}
+	size := len(bm.entries)
+	bm.mutex.Unlock()
+
+	bm.logger.Debug("ban manager cleanup completed",
+		zap.Int("tracked_clients", size),
+	)
+}
+
+// startCleanup запускает фоновую очистку
+func (bm *BanManager) startCleanup() {
+	interval := bm.decay
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				bm.cleanup()
+			case <-bm.stopCleanup:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown останавливает фоновую очистку
+func (bm *BanManager) Shutdown() {
+	if !bm.enabled {
+		return
+	}
+
+	select {
+	case bm.stopCleanup <- true:
+	default:
+	}
+}
+
+// IsEnabled возвращает статус включенности менеджера банов
+func (bm *BanManager) IsEnabled() bool {
+	return bm.enabled
+}
+
+// GetStats возвращает статистику
+func (bm *BanManager) GetStats() map[string]interface{} {
+	if !bm.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	bm.mutex.Lock()
+	now := bm.clock.Now()
+	tracked := len(bm.entries)
+	active := 0
+	for _, entry := range bm.entries {
+		if now.Before(entry.BannedUntil) {
+			active++
+		}
+	}
+	bm.mutex.Unlock()
+
+	return map[string]interface{}{
+		"enabled":              true,
+		"threshold":            bm.threshold,
+		"duration_seconds":     bm.duration.Seconds(),
+		"factor":               bm.factor,
+		"max_duration_seconds": bm.maxDuration.Seconds(),
+		"decay_seconds":        bm.decay.Seconds(),
+		"tracked_clients":      tracked,
+		"active_bans":          active,
+		"offenses":             atomic.LoadInt64(&bm.offenses),
+		"bans_issued":          atomic.LoadInt64(&bm.bansIssued),
+		"blocked_requests":     atomic.LoadInt64(&bm.blockedChecks),
+		"lifted_bans":          atomic.LoadInt64(&bm.liftedBans),
+		"imported_bans":        atomic.LoadInt64(&bm.importedBans),
+		"dropped_clients":      atomic.LoadInt64(&bm.droppedEntries),
+	}
+}
@@ -0,0 +1,298 @@
+package botredirect
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// newTestBanManager создает менеджер банов с управляемым временем
+func newTestBanManager(t *testing.T, clock Clock, modify func(config *Config)) *BanManager {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.BanThreshold = 2
+	config.BanDuration = time.Minute
+	config.BanFactor = 2
+	config.BanMaxDuration = 5 * time.Minute
+	config.BanDecay = 0
+	if modify != nil {
+		modify(config)
+	}
+
+	bm := NewBanManager(config, nil, nil, zap.NewNop())
+	bm.SetClock(clock)
+	t.Cleanup(bm.Shutdown)
+	return bm
+}
+
+// offendUntilBanned учитывает нарушения rate_limit до бана и возвращает его длительность
+func offendUntilBanned(t *testing.T, bm *BanManager, remoteAddr string, maxOffenses int) time.Duration {
+	t.Helper()
+
+	for i := 0; i < maxOffenses; i++ {
+		if ban := bm.Offense(remoteAddr, OffenseRateLimit); ban != nil {
+			return ban.BannedUntil.Sub(ban.BannedAt)
+		}
+	}
+	t.Fatalf("%s not banned after %d offenses", remoteAddr, maxOffenses)
+	return 0
+}
+
+// TestBanEscalation проверяет рост длительности банов в factor раз до ban_max_duration
+func TestBanEscalation(t *testing.T) {
+	clock := newFakeClock()
+	bm := newTestBanManager(t, clock, nil)
+
+	start := clock.Now()
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if ban := bm.Offense("192.0.2.1:1000", OffenseRateLimit); ban != nil {
+			t.Fatalf("ban %d issued below threshold", i+1)
+		}
+		got := offendUntilBanned(t, bm, "192.0.2.1:1000", 1)
+		if got != want {
+			t.Errorf("ban %d duration = %v, want %v", i+1, got, want)
+		}
+
+		// Нарушения во время бана не продлевают его и не копятся
+		banned := bm.Check("192.0.2.1:2000")
+		if banned == nil {
+			t.Fatalf("ban %d not enforced", i+1)
+		}
+		if ban := bm.Offense("192.0.2.1:1000", OffenseRateLimit); ban != nil {
+			t.Errorf("ban %d extended by an offense during the ban", i+1)
+		}
+
+		clock.Set(banned.BannedUntil)
+		if bm.Check("192.0.2.1:1000") != nil {
+			t.Errorf("ban %d still enforced at its end", i+1)
+		}
+	}
+
+	if entry := bm.Get("192.0.2.1/32"); entry != nil {
+		t.Errorf("expired ban returned: %+v", entry)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 17*time.Minute {
+		t.Errorf("total ban time = %v, want 17m", elapsed)
+	}
+
+	// Ловушка сразу достигает порога
+	if ban := bm.Offense("198.51.100.1:1000", OffenseHoneypot); ban == nil || ban.Level != 1 {
+		t.Errorf("honeypot ban = %+v, want level 1", ban)
+	}
+}
+
+// TestBanDecay проверяет, что периоды без нарушений сбрасывают счетчик и снижают уровень бана
+func TestBanDecay(t *testing.T) {
+	const client = "192.0.2.1:1000"
+
+	tests := []struct {
+		name  string
+		quiet time.Duration
+		want  time.Duration
+	}{
+		{name: "less than a period", quiet: 59 * time.Minute, want: 8 * time.Minute},
+		{name: "one period", quiet: time.Hour, want: 4 * time.Minute},
+		{name: "two periods", quiet: 2*time.Hour + 30*time.Minute, want: 2 * time.Minute},
+		{name: "more periods than levels", quiet: 10 * time.Hour, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			bm := newTestBanManager(t, clock, func(config *Config) {
+				config.BanThreshold = 1
+				config.BanMaxDuration = time.Hour
+				config.BanDecay = time.Hour
+			})
+
+			// Три бана подряд (1m, 2m, 4m): уровень 3
+			for i := 0; i < 3; i++ {
+				offendUntilBanned(t, bm, client, 1)
+				clock.Set(bm.Check(client).BannedUntil)
+			}
+
+			// Период без нарушений отсчитывается от конца бана
+			clock.Set(clock.Now().Add(tt.quiet))
+			if got := offendUntilBanned(t, bm, client, 1); got != tt.want {
+				t.Errorf("ban after %v without offenses = %v, want %v", tt.quiet, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("offense counter", func(t *testing.T) {
+		clock := newFakeClock()
+		bm := newTestBanManager(t, clock, func(config *Config) {
+			config.BanThreshold = 3
+			config.BanDecay = time.Hour
+		})
+
+		bm.Offense(client, OffenseRateLimit)
+		bm.Offense(client, OffenseRateLimit)
+		clock.Set(clock.Now().Add(time.Hour))
+		if ban := bm.Offense(client, OffenseRateLimit); ban != nil {
+			t.Fatal("offenses before the decay period counted")
+		}
+
+		// Период отсчитывается от последнего нарушения
+		clock.Set(clock.Now().Add(59 * time.Minute))
+		bm.Offense(client, OffenseRateLimit)
+		clock.Set(clock.Now().Add(59 * time.Minute))
+		if ban := bm.Offense(client, OffenseRateLimit); ban == nil {
+			t.Error("offenses within the decay period not counted")
+		}
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		clock := newFakeClock()
+		bm := newTestBanManager(t, clock, func(config *Config) {
+			config.BanThreshold = 1
+			config.BanDecay = time.Hour
+		})
+
+		offendUntilBanned(t, bm, client, 1)
+		offendUntilBanned(t, bm, "192.0.2.2:1000", 1)
+		clock.Set(clock.Now().Add(30 * time.Minute))
+		bm.Offense("192.0.2.2:1000", OffenseRateLimit)
+
+		// Запись без бана, уровня и нарушений удаляется; с уровнем - остается до снижения
+		clock.Set(clock.Now().Add(time.Hour + 30*time.Minute))
+		bm.cleanup()
+		if len(bm.entries) != 1 || bm.entries["192.0.2.2/32"] == nil {
+			t.Errorf("entries after cleanup = %v, want only 192.0.2.2/32", bm.entries)
+		}
+	})
+}
+
+// TestBanLift проверяет снятие бана по IP и сети и сброс истории нарушений
+func TestBanLift(t *testing.T) {
+	clock := newFakeClock()
+	bm := newTestBanManager(t, clock, func(config *Config) {
+		config.BanIPv4Prefix = 24
+	})
+
+	// Клиенты одной сети делят бан
+	offendUntilBanned(t, bm, "192.0.2.1:1000", 2)
+	if ban := bm.Check("192.0.2.200:1000"); ban == nil || ban.Key != "192.0.2.0/24" || ban.LastIP != "192.0.2.1" {
+		t.Fatalf("ban of the network = %+v", ban)
+	}
+
+	if bm.Lift("198.51.100.1") {
+		t.Error("lift of an unknown client reported success")
+	}
+	if bm.Lift("not-an-ip") {
+		t.Error("lift of an invalid key reported success")
+	}
+	if !bm.Lift("192.0.2.77") {
+		t.Fatal("lift by an address of the network failed")
+	}
+	if bm.Check("192.0.2.1:1000") != nil {
+		t.Fatal("ban still enforced after lift")
+	}
+
+	// История сброшена: следующий бан снова базовой длительности
+	if got := offendUntilBanned(t, bm, "192.0.2.1:1000", 2); got != time.Minute {
+		t.Errorf("ban after lift = %v, want %v", got, time.Minute)
+	}
+	if !bm.Lift("192.0.2.0/24") || len(bm.List()) != 0 {
+		t.Error("lift by network failed")
+	}
+
+	// Ручной бан сети должен совпадать с длиной префикса
+	if _, err := bm.Ban("192.0.2.0/16", 0, ""); err == nil {
+		t.Error("ban of a network with another prefix length accepted")
+	}
+	ban, err := bm.Ban("198.51.100.9", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ban.Key != "198.51.100.0/24" || ban.Reason != OffenseManual || ban.BannedUntil.Sub(ban.BannedAt) != time.Hour {
+		t.Errorf("manual ban = %+v", ban)
+	}
+}
+
+// TestBanImport проверяет перенос банов и эскалации в экземпляр новой конфигурации
+// и прием банов других узлов
+func TestBanImport(t *testing.T) {
+	clock := newFakeClock()
+	previous := newTestBanManager(t, clock, nil)
+
+	offendUntilBanned(t, previous, "192.0.2.1:1000", 2)
+	clock.Set(previous.Check("192.0.2.1:1000").BannedUntil)
+	offendUntilBanned(t, previous, "192.0.2.1:1000", 2) // уровень 2, бан 2m
+	previous.Offense("198.51.100.1:1000", OffenseRateLimit)
+
+	reloaded := newTestBanManager(t, clock, nil)
+	if n := reloaded.ImportFrom(previous); n != 2 {
+		t.Errorf("carried over %d entries, want 2", n)
+	}
+	if reloaded.Check("192.0.2.1:1000") == nil {
+		t.Fatal("ban lost on reload")
+	}
+
+	// Эскалация и счетчик нарушений продолжаются
+	clock.Set(reloaded.Check("192.0.2.1:1000").BannedUntil)
+	if got := offendUntilBanned(t, reloaded, "192.0.2.1:1000", 2); got != 4*time.Minute {
+		t.Errorf("ban after reload = %v, want 4m", got)
+	}
+	if ban := reloaded.Offense("198.51.100.1:1000", OffenseRateLimit); ban == nil {
+		t.Error("offense counter lost on reload")
+	}
+
+	// Сети другой длины префикса не переносятся
+	regrouped := newTestBanManager(t, clock, func(config *Config) { config.BanIPv4Prefix = 24 })
+	if n := regrouped.ImportFrom(reloaded); n != 0 {
+		t.Errorf("carried over %d entries with another prefix length", n)
+	}
+	if n := reloaded.ImportFrom(reloaded); n != 0 {
+		t.Errorf("import from itself added %d entries", n)
+	}
+
+	// Бан другого узла применяется, только если он длиннее локального
+	now := clock.Now()
+	tests := []struct {
+		name  string
+		entry BanEntry
+		want  bool
+	}{
+		{name: "new client", entry: BanEntry{Key: "203.0.113.1/32", BannedUntil: now.Add(time.Hour)}, want: true},
+		{name: "shorter than local", entry: BanEntry{Key: "192.0.2.1/32", BannedUntil: now.Add(time.Minute)}},
+		{name: "longer than local", entry: BanEntry{Key: "192.0.2.1/32", BannedUntil: now.Add(time.Hour)}, want: true},
+		{name: "expired", entry: BanEntry{Key: "203.0.113.2/32", BannedUntil: now}},
+		{name: "without key", entry: BanEntry{BannedUntil: now.Add(time.Hour)}},
+	}
+	for _, tt := range tests {
+		if got := reloaded.Import(tt.entry); got != tt.want {
+			t.Errorf("%s: imported = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if ban := reloaded.Check("192.0.2.1:1000"); ban == nil || !ban.BannedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("ban after import = %+v", ban)
+	}
+}
+
+// TestProvisionCarriesBansFromPreviousConfig проверяет, что перезагрузка конфигурации
+// не снимает баны экземпляра с тем же id
+func TestProvisionCarriesBansFromPreviousConfig(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	previous := &BotRedirect{ID: "bans", ListsOverlayFile: "off", BanThreshold: 5}
+	if err := provisionTestHandlerContext(t, ctx, previous); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previous.botDetector.GetBanManager().Ban("192.0.2.1", time.Hour, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := &BotRedirect{ID: "bans", ListsOverlayFile: "off", BanThreshold: 5}
+	if err := provisionTestHandler(t, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.botDetector.GetBanManager().Check("192.0.2.1:1000") == nil {
+		t.Error("ban lost on reload")
+	}
+}
@@ -1,11 +1,20 @@
 package botredirect
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -16,9 +25,19 @@ type BotDetector struct {
 	ipRangeChecker    *IPRangeChecker
 	reverseDNSChecker *ReverseDNSChecker
 	referrerChecker   *ReferrerChecker
+	robotsChecker     *RobotsChecker
+	honeypot          *Honeypot
+	challenger        *Challenger
+	signatureVerifier *SignatureVerifier
+	banManager        *BanManager
+	firewallExporter  *FirewallExporter
+	sharedCache       *SharedCache
+	listEditor        *ListEditor
+	listWatcher       *ListWatcher
+	overrideTable     *OverrideTable
 
 	// Системные компоненты
-	cache       *Cache
+	cache       *Cache[string, *BotVerdict]
 	templates   *Templates
 	metrics     *Metrics
 	rateLimiter *RateLimiter
@@ -28,6 +47,10 @@ type BotDetector struct {
 	config *Config
 	logger *zap.Logger
 
+	// Отпечаток списков, от которых зависит вердикт; входит в ключ общего кеша,
+	// чтобы после изменения списков не читать вердикты, вынесенные по старым спискам
+	listsVersion atomic.Pointer[string]
+
 	// Статистика (используем atomic для thread-safety)
 	totalChecks    int64
 	botDetections  int64
@@ -44,13 +67,58 @@ type DetectionResult struct {
 	DetectionMethod string
 	Confidence      float64
 	MatchedPattern  string
+	BotName         string
+	Verified        bool
+	Spoofed         bool
 	ProcessingTime  time.Duration
 	Details         map[string]interface{}
 	Timestamp       time.Time
 }
 
-// NewBotDetector создает новый экземпляр детектора ботов
-func NewBotDetector(config *Config, logger *zap.Logger) *BotDetector {
+// BotVerdict вывод о клиенте, не зависящий от конкретного запроса: подтвержденный краулер,
+// бот с неподтвержденным User-Agent или не бот. Кешируется по IP и User-Agent отдельно
+// от классификации запроса, которая зависит от Referer и вычисляется каждый раз заново.
+type BotVerdict struct {
+	IsBot           bool                   `json:"is_bot"`
+	DetectionMethod string                 `json:"detection_method"`
+	Confidence      float64                `json:"confidence"`
+	MatchedPattern  string                 `json:"matched_pattern"`
+	BotName         string                 `json:"bot_name"`
+	Verified        bool                   `json:"verified"`
+	Details         map[string]interface{} `json:"details"`
+
+	// User-Agent называет известного краулера, а проверка по его сетям и доменам завершилась неудачей
+	Spoofed bool `json:"spoofed,omitempty"`
+
+	// Проверка не завершилась (ошибка или таймаут DNS) - вердикт кешируется ненадолго
+	transient bool
+}
+
+// result создает результат детекции для запроса по вердикту о боте.
+// Каждый запрос получает свою копию: кешированный вердикт не изменяется.
+func (v *BotVerdict) result() *DetectionResult {
+	details := make(map[string]interface{}, len(v.Details))
+	for key, value := range v.Details {
+		details[key] = value
+	}
+
+	return &DetectionResult{
+		IsBot:           true,
+		UserType:        UserTypeBot,
+		DetectionMethod: v.DetectionMethod,
+		Confidence:      v.Confidence,
+		MatchedPattern:  v.MatchedPattern,
+		BotName:         v.BotName,
+		Verified:        v.Verified,
+		Spoofed:         v.Spoofed,
+		Details:         details,
+	}
+}
+
+// NewBotDetector создает новый экземпляр детектора ботов.
+// Ошибка возвращается, если не загружается обязательный файл конфигурации (robots_file,
+// asn_database) или не компилируется ключ либо уровень rate limiting.
+func NewBotDetector(config *Config, logger *zap.Logger) (*BotDetector, error) {
 	bd := &BotDetector{
 		config:         config,
 		logger:         logger,
@@ -61,15 +129,29 @@ func NewBotDetector(config *Config, logger *zap.Logger) *BotDetector {
 
 	// 1. Метрики (должны быть первыми)
 	bd.metrics = NewMetrics(config.EnableMetrics, config.VerboseMetrics, logger)
+	if config.EnablePrometheus {
+		// Caddy отдает реестр по умолчанию на admin endpoint /metrics и в обработчике metrics
+		bd.metrics.EnablePrometheus(prometheus.DefaultRegisterer, logger)
+	}
 
 	// 2. Debug конфигурация
 	bd.debug = NewDebugConfig(config, logger)
 
 	// 3. Cache система
-	bd.cache = NewCache(config, bd.metrics, bd.debug, logger)
+	bd.cache = NewCache[string, *BotVerdict](CacheOptions{
+		Name:            "verdict",
+		MaxSize:         config.MaxCacheSize,
+		TTL:             config.CacheTTL,
+		CleanupInterval: config.CleanupInterval,
+	}, hashString, bd.metrics, bd.debug, logger)
 
 	// 4. Rate Limiter
-	bd.rateLimiter = NewRateLimiter(config, bd.metrics, logger)
+	rateLimiter, err := NewRateLimiter(config, bd.metrics, logger)
+	if err != nil {
+		bd.Shutdown()
+		return nil, err
+	}
+	bd.rateLimiter = rateLimiter
 
 	// 5. Templates система
 	bd.templates = NewTemplates(config, logger)
@@ -80,16 +162,65 @@ func NewBotDetector(config *Config, logger *zap.Logger) *BotDetector {
 	bd.reverseDNSChecker = NewReverseDNSChecker(config, bd.metrics, bd.debug, logger)
 	bd.referrerChecker = NewReferrerChecker(config, bd.metrics, bd.debug, logger)
 
+	// 7. Контроль соблюдения robots.txt
+	robotsChecker, err := NewRobotsChecker(config, bd.metrics, bd.debug, logger)
+	if err != nil {
+		bd.Shutdown()
+		return nil, err
+	}
+	bd.robotsChecker = robotsChecker
+
+	// 8. Ловушки для вредоносных ботов
+	bd.honeypot = NewHoneypot(config, bd.metrics, bd.debug, logger)
+
+	// 9. Proof-of-work проверка браузера
+	bd.challenger = NewChallenger(config, bd.metrics, bd.debug, logger)
+
+	// 10. Проверка подписей агентов (Web Bot Auth)
+	bd.signatureVerifier = NewSignatureVerifier(config, bd.metrics, bd.debug, logger)
+
+	// 11. Баны с нарастающей длительностью
+	bd.banManager = NewBanManager(config, bd.metrics, bd.debug, logger)
+
+	// 12. Выгрузка банов и сетей ботов в правила файрвола
+	bd.firewallExporter = NewFirewallExporter(config, bd.banManager, bd.honeypot, bd.metrics, bd.debug, logger)
+
+	// 13. Общий для кластера кеш вердиктов и банов
+	bd.sharedCache = NewSharedCache(config, bd.banManager, bd.metrics, bd.debug, logger)
+
+	// 14. Изменение списков во время работы (накладывает сохраненные изменения)
+	bd.listEditor = NewListEditor(config, bd.userAgentMatcher, bd.ipRangeChecker, bd.referrerChecker, bd.reverseDNSChecker, bd.listsChanged, bd.metrics, bd.debug, logger)
+
+	// 15. Отслеживание файлов списков
+	bd.listWatcher = NewListWatcher(config, bd.listEditor, bd.metrics, bd.debug, logger)
+
+	// 16. Ручные переопределения классификации и действий
+	overrideTable, err := NewOverrideTable(config, bd.metrics, bd.debug, logger)
+	if err != nil {
+		bd.Shutdown()
+		return nil, err
+	}
+	bd.overrideTable = overrideTable
+
+	bd.updateListsVersion()
+
 	logger.Info("bot detector initialized",
 		zap.Bool("user_agent_enabled", bd.userAgentMatcher != nil),
 		zap.Bool("ip_range_enabled", bd.ipRangeChecker != nil),
 		zap.Bool("reverse_dns_enabled", bd.reverseDNSChecker != nil && config.EnableReverseDNS),
 		zap.Bool("referrer_enabled", bd.referrerChecker != nil && config.EnableReferrerCheck),
+		zap.Bool("robots_enabled", bd.robotsChecker != nil && bd.robotsChecker.IsEnabled()),
+		zap.Bool("honeypot_enabled", bd.honeypot != nil && bd.honeypot.IsEnabled()),
+		zap.Bool("challenge_enabled", bd.challenger != nil && bd.challenger.IsEnabled()),
+		zap.Bool("signatures_enabled", bd.signatureVerifier != nil && bd.signatureVerifier.IsEnabled()),
+		zap.Bool("bans_enabled", bd.banManager != nil && bd.banManager.IsEnabled()),
+		zap.Bool("firewall_export_enabled", bd.firewallExporter != nil && bd.firewallExporter.IsEnabled()),
+		zap.Bool("shared_cache_enabled", bd.sharedCache != nil && bd.sharedCache.IsEnabled()),
 		zap.Bool("cache_enabled", bd.cache != nil),
 		zap.Bool("metrics_enabled", bd.metrics != nil),
 	)
 
-	return bd
+	return bd, nil
 }
 
 // DetectBot выполняет полную проверку на бота
@@ -109,36 +240,73 @@ func (bd *BotDetector) DetectBot(r *http.Request) *DetectionResult {
 		debugInfo = bd.debug.StartRequestDebug(r)
 	}
 
-	// Проверяем кеш для быстрого ответа
-	cacheKey := bd.generateCacheKey(clientIP, userAgent)
-	if cached := bd.cache.Get(cacheKey); cached != nil {
-		if result, ok := cached.(*DetectionResult); ok {
-			// Проверяем актуальность кешированного результата
-			if time.Since(result.Timestamp) < bd.config.CacheTTL {
-				result.ProcessingTime = time.Since(startTime)
+	// Подписанные агенты проверяются до кеша: результат зависит от подписи конкретного запроса
+	if result := bd.detectSignedAgent(r, debugInfo); result != nil {
+		result.ProcessingTime = time.Since(startTime)
+		result.Timestamp = time.Now()
 
-				if bd.debug != nil && debugInfo != nil {
-					bd.debug.AddProcessingStep(debugInfo, "cache_hit", result.UserType.String(),
-						result.ProcessingTime, map[string]interface{}{
-							"detection_method": result.DetectionMethod,
-							"confidence":       result.Confidence,
-						})
-					bd.debug.FinishRequestDebug(debugInfo, "cache_result")
-				}
+		bd.updateStatistics(result)
+
+		if bd.debug != nil && debugInfo != nil {
+			bd.debug.FinishRequestDebug(debugInfo, result.UserType.String())
+		}
+
+		return result
+	}
+
+	// Вердикт о клиенте кешируется по IP и User-Agent
+	verdictKey := bd.verdictKey(clientIP, userAgent)
+	verdict, cached := bd.cache.Get(verdictKey)
+	if cached {
+		if bd.debug != nil && debugInfo != nil {
+			bd.debug.AddProcessingStep(debugInfo, "verdict_cache_hit", verdictOutcome(verdict),
+				0, map[string]interface{}{
+					"detection_method": verdict.DetectionMethod,
+					"verified":         verdict.Verified,
+				})
+		}
+	} else if shared, ok := bd.sharedCache.GetVerdict(bd.sharedVerdictKey(verdictKey)); ok {
+		// Вердикт, полученный другим узлом кластера, хранится локально недолго
+		verdict = shared
+		bd.cache.SetWithTTL(verdictKey, verdict, bd.sharedCache.LocalTTL())
+
+		if bd.debug != nil && debugInfo != nil {
+			bd.debug.AddProcessingStep(debugInfo, "shared_verdict_hit", verdictOutcome(verdict),
+				0, map[string]interface{}{
+					"detection_method": verdict.DetectionMethod,
+					"verified":         verdict.Verified,
+				})
+		}
+	} else {
+		verdict = bd.detectIdentity(r, debugInfo)
 
-				return result
+		ttl := bd.config.CacheTTL
+		if verdict.transient && ttl > dnsErrorCacheTTL {
+			ttl = dnsErrorCacheTTL
+		}
+
+		// В общий кеш попадают только завершенные вердикты о подтвержденных краулерах:
+		// их проверка дорогая, а заявление по одному User-Agent другим узлам не нужно
+		localTTL := ttl
+		if bd.sharedCache.IsEnabled() && verdict.Verified && !verdict.transient {
+			bd.sharedCache.PutVerdict(bd.sharedVerdictKey(verdictKey), verdict, ttl)
+			if localTTL > bd.sharedCache.LocalTTL() {
+				localTTL = bd.sharedCache.LocalTTL()
 			}
 		}
+		bd.cache.SetWithTTL(verdictKey, verdict, localTTL)
 	}
 
-	// Выполняем проверки по приоритету
-	result := bd.performDetection(r, debugInfo)
+	// Классификация запроса: бот по вердикту, иначе тип пользователя по Referer этого запроса
+	var result *DetectionResult
+	if verdict.IsBot {
+		result = verdict.result()
+	} else {
+		result = bd.determineUserType(r, debugInfo)
+	}
 	result.ProcessingTime = time.Since(startTime)
 	result.Timestamp = time.Now()
 
-	// Сохраняем в кеш
-	bd.cache.SetWithTTL(cacheKey, result, bd.config.CacheTTL)
-
 	// Обновляем статистику
 	bd.updateStatistics(result)
 
@@ -150,10 +318,23 @@ func (bd *BotDetector) DetectBot(r *http.Request) *DetectionResult {
 	return result
 }
 
-// performDetection выполняет основную логику детекции
-func (bd *BotDetector) performDetection(r *http.Request, debugInfo *RequestDebugInfo) *DetectionResult {
+// verdictOutcome описывает вердикт для отладочной информации
+func verdictOutcome(verdict *BotVerdict) string {
+	switch {
+	case !verdict.IsBot:
+		return "not_bot"
+	case verdict.Verified:
+		return "verified_bot"
+	default:
+		return "unverified_bot"
+	}
+}
+
+// detectIdentity выполняет проверки клиента на бота (User-Agent, IP диапазоны, обратный DNS)
+func (bd *BotDetector) detectIdentity(r *http.Request, debugInfo *RequestDebugInfo) *BotVerdict {
 	clientIP := r.RemoteAddr
 	userAgent := r.UserAgent()
+	transient := false
 
 	// 1. Проверка User-Agent (быстрая, высокая точность)
 	if bd.userAgentMatcher != nil {
@@ -179,17 +360,45 @@ func (bd *BotDetector) performDetection(r *http.Request, debugInfo *RequestDebug
 					})
 			}
 
-			return &DetectionResult{
+			crawler := lookupCrawler(userAgent)
+			verifiedBy, unfinished := bd.verifyCrawler(crawler, clientIP)
+
+			if bd.debug != nil && debugInfo != nil {
+				outcome := "verified"
+				if verifiedBy == "" {
+					outcome = "unverified"
+				}
+				details := map[string]interface{}{
+					"verified_by": verifiedBy,
+					"unfinished":  unfinished,
+				}
+				if crawler != nil {
+					details["crawler"] = crawler.name
+				}
+				bd.debug.AddProcessingStep(debugInfo, "crawler_verification", outcome, 0, details)
+			}
+
+			verdict := &BotVerdict{
 				IsBot:           true,
-				UserType:        UserTypeBot,
 				DetectionMethod: "user_agent",
 				Confidence:      uaResult.Confidence,
 				MatchedPattern:  uaResult.MatchedPattern,
+				BotName:         uaResult.MatchedPattern,
+				Verified:        verifiedBy != "",
+				Spoofed:         crawler != nil && verifiedBy == "" && !unfinished,
 				Details: map[string]interface{}{
 					"bot_type":   uaResult.BotType,
 					"user_agent": userAgent,
 				},
+				transient: unfinished,
 			}
+			if crawler != nil {
+				verdict.Details["crawler"] = crawler.name
+			}
+			if verifiedBy != "" {
+				verdict.Details["verified_by"] = verifiedBy
+			}
+			return verdict
 		}
 
 		if bd.debug != nil && debugInfo != nil && err == nil {
@@ -222,12 +431,13 @@ func (bd *BotDetector) performDetection(r *http.Request, debugInfo *RequestDebug
 					})
 			}
 
-			return &DetectionResult{
+			return &BotVerdict{
 				IsBot:           true,
-				UserType:        UserTypeBot,
 				DetectionMethod: "ip_range",
 				Confidence:      ipResult.Confidence,
 				MatchedPattern:  ipResult.MatchedRange,
+				BotName:         ipResult.Organization,
+				Verified:        true,
 				Details: map[string]interface{}{
 					"organization": ipResult.Organization,
 					"bot_type":     ipResult.BotType,
@@ -255,6 +465,8 @@ func (bd *BotDetector) performDetection(r *http.Request, debugInfo *RequestDebug
 				zap.String("client_ip", clientIP),
 				zap.Error(err),
 			)
+		} else if dnsResult.Error != "" {
+			transient = true
 		} else if dnsResult.IsBot {
 			stepDuration := time.Since(stepStart)
 
@@ -267,12 +479,13 @@ func (bd *BotDetector) performDetection(r *http.Request, debugInfo *RequestDebug
 					})
 			}
 
-			return &DetectionResult{
+			return &BotVerdict{
 				IsBot:           true,
-				UserType:        UserTypeBot,
 				DetectionMethod: "reverse_dns",
 				Confidence:      dnsResult.Confidence,
 				MatchedPattern:  dnsResult.Hostname,
+				BotName:         hostnameOwner(dnsResult.Hostname),
+				Verified:        true,
 				Details: map[string]interface{}{
 					"hostname":     dnsResult.Hostname,
 					"verified_ip":  dnsResult.VerifiedIP,
@@ -290,8 +503,151 @@ func (bd *BotDetector) performDetection(r *http.Request, debugInfo *RequestDebug
 		}
 	}
 
-	// 4. Определение типа обычного пользователя через Referrer
-	return bd.determineUserType(r, debugInfo)
+	return &BotVerdict{IsBot: false, transient: transient}
+}
+
+// detectSignedAgent проверяет HTTP Message Signature запроса.
+// Возвращает nil, если подписи нет или она недействительна - тогда выполняются обычные проверки.
+func (bd *BotDetector) detectSignedAgent(r *http.Request, debugInfo *RequestDebugInfo) *DetectionResult {
+	if bd.signatureVerifier == nil || !bd.signatureVerifier.IsEnabled() || !HasMessageSignature(r) {
+		return nil
+	}
+
+	stepStart := time.Now()
+	sigResult := bd.signatureVerifier.Verify(r)
+
+	if bd.debug != nil && debugInfo != nil {
+		outcome := "invalid"
+		if sigResult.Valid {
+			outcome = "verified"
+		}
+		bd.debug.AddProcessingStep(debugInfo, "message_signature_check", outcome,
+			time.Since(stepStart), map[string]interface{}{
+				"agent": sigResult.Agent,
+				"keyid": sigResult.KeyID,
+				"error": sigResult.Error,
+			})
+	}
+
+	if !sigResult.Valid {
+		return nil
+	}
+
+	agentName := strings.TrimPrefix(strings.TrimPrefix(sigResult.Agent, "https://"), "http://")
+
+	return &DetectionResult{
+		IsBot:           true,
+		UserType:        UserTypeBot,
+		DetectionMethod: "http_signature",
+		Confidence:      1.0,
+		MatchedPattern:  sigResult.KeyID,
+		BotName:         agentName,
+		Verified:        true,
+		Details: map[string]interface{}{
+			"agent":      sigResult.Agent,
+			"keyid":      sigResult.KeyID,
+			"bot_type":   BotTypeCrawler,
+			"components": sigResult.Components,
+			"expires":    sigResult.Expires,
+		},
+	}
+}
+
+// verifyCrawler подтверждает краулера, заявленного в User-Agent, по его собственным
+// IP диапазонам или домену обратного DNS. Краулер, которого нет в knownCrawlers, не подтверждается.
+// Возвращает способ подтверждения и признак незавершенной проверки (ошибка или таймаут DNS).
+func (bd *BotDetector) verifyCrawler(crawler *knownCrawler, clientIP string) (string, bool) {
+	if crawler == nil {
+		return "", false
+	}
+
+	if bd.ipRangeChecker != nil && crawler.ownsAddress(clientIP) {
+		if ipResult, err := bd.ipRangeChecker.IsBot(clientIP); err == nil && ipResult.IsBot {
+			return "ip_range", false
+		}
+	}
+
+	if bd.reverseDNSChecker != nil && bd.config.EnableReverseDNS && len(crawler.hostSuffixes) > 0 {
+		dnsResult, err := bd.reverseDNSChecker.CheckDNS(clientIP)
+		if err == nil && dnsResult.IsBot && crawler.ownsHostname(dnsResult.Hostname) {
+			return "reverse_dns", false
+		}
+		if err == nil && dnsResult.Error != "" {
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+// TraceStep добавляет шаг в трассировку решения запроса, если она включена (WithRequestTrace)
+func (bd *BotDetector) TraceStep(r *http.Request, step, result string, details map[string]interface{}) {
+	if bd.debug == nil {
+		return
+	}
+	if info := requestTrace(r); info != nil {
+		bd.debug.AddProcessingStep(info, step, result, 0, details)
+	}
+}
+
+// CheckOverride возвращает ручное переопределение, действующее для запроса.
+// Использование переопределения учитывается в статистике и трассировке решения запроса.
+func (bd *BotDetector) CheckOverride(r *http.Request) *Override {
+	override := bd.overrideTable.Check(r)
+	if override == nil {
+		return nil
+	}
+
+	bd.TraceStep(r, "override", override.Effect(), map[string]interface{}{
+		"override_id": override.ID,
+		"match":       override.Match,
+		"value":       override.Value,
+		"reason":      override.Reason,
+		"author":      override.Author,
+		"source":      override.Source,
+	})
+
+	return override
+}
+
+// DetectOverridden возвращает результат детекции для переопределения классификации.
+// Детекторы не вызываются.
+func (bd *BotDetector) DetectOverridden(override *Override) *DetectionResult {
+	atomic.AddInt64(&bd.totalChecks, 1)
+
+	result := override.result()
+	result.Timestamp = time.Now()
+
+	bd.updateStatistics(result)
+
+	return result
+}
+
+// DetectCleared определяет тип пользователя, прошедшего проверку браузера.
+// Проверки на бота пропускаются, результат не кешируется.
+func (bd *BotDetector) DetectCleared(r *http.Request) *DetectionResult {
+	startTime := time.Now()
+
+	atomic.AddInt64(&bd.totalChecks, 1)
+
+	var debugInfo *RequestDebugInfo
+	if bd.debug != nil {
+		debugInfo = bd.debug.StartRequestDebug(r)
+		bd.debug.AddProcessingStep(debugInfo, "challenge_clearance", "valid", 0, nil)
+	}
+
+	result := bd.determineUserType(r, debugInfo)
+	result.Details["challenge_clearance"] = true
+	result.ProcessingTime = time.Since(startTime)
+	result.Timestamp = time.Now()
+
+	bd.updateStatistics(result)
+
+	if bd.debug != nil && debugInfo != nil {
+		bd.debug.FinishRequestDebug(debugInfo, result.UserType.String())
+	}
+
+	return result
 }
 
 // determineUserType определяет тип обычного пользователя
@@ -385,10 +741,343 @@ func (bd *BotDetector) determineUserType(r *http.Request, debugInfo *RequestDebu
 	}
 }
 
-// generateCacheKey генерирует ключ для кеширования
-func (bd *BotDetector) generateCacheKey(ip, userAgent string) string {
-	// Используем комбинацию IP и User-Agent для ключа
-	return ip + "|" + userAgent
+// CheckRobots проверяет запрос подтвержденного краулера по robots.txt
+// и регистрирует нарушение. Возвращает nil, если проверка не применима:
+// неподтвержденные боты обрабатываются политикой unverified_bot_action,
+// и их нарушения не приписываются краулеру, которым они представились.
+func (bd *BotDetector) CheckRobots(r *http.Request, result *DetectionResult) *RobotsResult {
+	if bd.robotsChecker == nil || !bd.robotsChecker.IsEnabled() || result == nil || !result.IsBot || !result.Verified {
+		return nil
+	}
+
+	robotsResult := bd.robotsChecker.Evaluate(r.UserAgent(), r.URL.RequestURI())
+
+	robotsResult.Crawler = result.BotName
+	if robotsResult.MatchedGroup != "" && robotsResult.MatchedGroup != "*" {
+		robotsResult.Crawler = robotsResult.MatchedGroup
+	}
+	if robotsResult.Crawler == "" {
+		robotsResult.Crawler = "unknown"
+	}
+
+	if !robotsResult.Allowed {
+		bd.robotsChecker.RecordViolation(robotsResult.Crawler, r.URL.RequestURI(), canonicalHost(r.RemoteAddr))
+	}
+
+	return robotsResult
+}
+
+// RateLimitSubject формирует описание клиента для rate limiter на основе результата детекции
+func (bd *BotDetector) RateLimitSubject(r *http.Request, result *DetectionResult) *RateLimitSubject {
+	subject := &RateLimitSubject{
+		IP:        r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Header:    r.Header,
+	}
+
+	if bd.honeypot != nil {
+		subject.BadBot = bd.honeypot.IsMarked(r.RemoteAddr)
+	}
+
+	if result == nil || !result.IsBot {
+		return subject
+	}
+
+	subject.BotName = result.BotName
+	subject.Verified = result.Verified
+	if botType, ok := result.Details["bot_type"].(BotType); ok {
+		subject.BotType = botType
+	}
+
+	if bd.robotsChecker != nil {
+		subject.CrawlDelay = bd.robotsChecker.CrawlDelay(r.UserAgent())
+	}
+
+	return subject
+}
+
+// CheckHoneypot отмечает клиента, запросившего ловушку, и возвращает его действующую отметку
+func (bd *BotDetector) CheckHoneypot(r *http.Request) *ReputationEntry {
+	if bd.honeypot == nil || !bd.honeypot.IsEnabled() {
+		return nil
+	}
+	entry := bd.honeypot.Check(r.RemoteAddr, r.URL.Path)
+	if entry != nil && bd.honeypot.IsTrap(r.URL.Path) {
+		bd.RecordOffense(r, OffenseHoneypot)
+	}
+	return entry
+}
+
+// CheckBan возвращает действующий бан клиента или nil
+func (bd *BotDetector) CheckBan(r *http.Request) *BanEntry {
+	if bd.banManager == nil || !bd.banManager.IsEnabled() {
+		return nil
+	}
+
+	ban := bd.banManager.Check(r.RemoteAddr)
+	if ban != nil && bd.debug != nil && bd.debug.IsEnabled() {
+		bd.logger.Debug("request from banned client",
+			zap.String("ip", r.RemoteAddr),
+			zap.String("key", ban.Key),
+			zap.Time("banned_until", ban.BannedUntil),
+		)
+	}
+	return ban
+}
+
+// RecordOffense учитывает нарушение клиента в менеджере банов
+func (bd *BotDetector) RecordOffense(r *http.Request, reason string) {
+	if bd.banManager == nil || !bd.banManager.IsEnabled() {
+		return
+	}
+	if ban := bd.banManager.Offense(r.RemoteAddr, reason); ban != nil {
+		bd.sharedCache.PutBan(ban)
+	}
+}
+
+// Ban вручную банит IP адрес или сеть, в том числе в общем кеше кластера
+func (bd *BotDetector) Ban(keyOrIP string, duration time.Duration, reason string) (*BanEntry, error) {
+	if bd.banManager == nil {
+		return nil, fmt.Errorf("ban manager is disabled")
+	}
+
+	ban, err := bd.banManager.Ban(keyOrIP, duration, reason)
+	if err != nil {
+		return nil, err
+	}
+	bd.sharedCache.PutBan(ban)
+	return ban, nil
+}
+
+// LiftBan снимает бан по IP адресу или ключу сети, в том числе в общем кеше кластера
+func (bd *BotDetector) LiftBan(keyOrIP string) bool {
+	if bd.banManager == nil || !bd.banManager.IsEnabled() {
+		return false
+	}
+
+	key, ok := bd.banManager.ResolveKey(keyOrIP)
+	if !ok {
+		return false
+	}
+
+	bd.sharedCache.DeleteBan(key)
+	return bd.banManager.Lift(key)
+}
+
+// hostnameOwner возвращает домен владельца hostname (последние две метки)
+func hostnameOwner(hostname string) string {
+	labels := strings.Split(strings.TrimSuffix(hostname, "."), ".")
+	if len(labels) <= 2 {
+		return hostname
+	}
+
+	// Учитываем домены второго уровня вида co.uk, com.au
+	keep := 2
+	switch labels[len(labels)-2] {
+	case "co", "com", "net", "org", "ac", "gov":
+		if len(labels) > 2 && len(labels[len(labels)-1]) == 2 {
+			keep = 3
+		}
+	}
+	return strings.Join(labels[len(labels)-keep:], ".")
+}
+
+// verdictKey генерирует ключ кеша вердиктов: IP без порта в каноническом виде и User-Agent
+func (bd *BotDetector) verdictKey(remoteAddr, userAgent string) string {
+	return canonicalHost(remoteAddr) + "|" + strings.TrimSpace(userAgent)
+}
+
+// sharedVerdictKey возвращает ключ вердикта в общем кеше: ключ вердикта с отпечатком списков
+func (bd *BotDetector) sharedVerdictKey(verdictKey string) string {
+	version := ""
+	if current := bd.listsVersion.Load(); current != nil {
+		version = *current
+	}
+	return version + "|" + verdictKey
+}
+
+// updateListsVersion пересчитывает отпечаток списков паттернов User-Agent, IP диапазонов
+// и паттернов обратного DNS. Отпечаток зависит только от содержимого списков, поэтому
+// совпадает на узлах с одинаковыми списками.
+func (bd *BotDetector) updateListsVersion() {
+	hash := sha256.New()
+	write := func(name string, values []string) {
+		values = slices.Clone(values)
+		sort.Strings(values)
+		fmt.Fprintf(hash, "%s:%d\n", name, len(values))
+		for _, value := range values {
+			fmt.Fprintf(hash, "%s\n", value)
+		}
+	}
+
+	write(ListUserAgents, bd.userAgentMatcher.GetPatterns())
+	write(ListIPRanges, bd.ipRangeChecker.GetRanges())
+	patterns := bd.reverseDNSChecker.GetBotDomainPatterns()
+	botTypes := make([]string, 0, len(patterns))
+	for botType := range patterns {
+		botTypes = append(botTypes, botType)
+	}
+	sort.Strings(botTypes)
+	for _, botType := range botTypes {
+		write(listKey(ListDNSPatterns, botType), patterns[botType])
+	}
+
+	version := hex.EncodeToString(hash.Sum(nil)[:8])
+	bd.listsVersion.Store(&version)
+}
+
+// canonicalHost возвращает IP адрес без порта и зоны, IPv4-mapped адреса приводятся к IPv4
+func canonicalHost(remoteAddr string) string {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		host = addr.Unmap().WithZone("").String()
+	}
+	return host
+}
+
+// PurgeCache удаляет закешированные вердикты и результаты проверок для IP адреса и/или User-Agent.
+// Без аргументов очищает все кеши. Возвращает количество удаленных записей.
+func (bd *BotDetector) PurgeCache(ip, userAgent string) int {
+	userAgent = strings.TrimSpace(userAgent)
+
+	all := ip == "" && userAgent == ""
+
+	purged := 0
+	if all {
+		purged += bd.cache.Len()
+		bd.cache.Clear()
+	} else {
+		host := canonicalHost(ip)
+		purged += bd.cache.DeleteFunc(func(key string, _ *BotVerdict) bool {
+			keyHost, keyUA, _ := strings.Cut(key, "|")
+			return (ip == "" || keyHost == host) && (userAgent == "" || keyUA == userAgent)
+		})
+
+		// Записи общего кеша адресуются хешем ключа и удаляются только по точному ключу
+		if ip != "" && userAgent != "" {
+			bd.sharedCache.DeleteVerdict(bd.sharedVerdictKey(host + "|" + userAgent))
+		}
+	}
+
+	// Результаты компонентов, от которых зависят вердикты
+	if bd.userAgentMatcher != nil && (all || userAgent != "") {
+		purged += bd.userAgentMatcher.PurgeCache(userAgent)
+	}
+	if bd.ipRangeChecker != nil && (all || ip != "") {
+		purged += bd.ipRangeChecker.PurgeCache(ip)
+	}
+	if bd.reverseDNSChecker != nil && (all || ip != "") {
+		purged += bd.reverseDNSChecker.PurgeCache(ip)
+	}
+	if bd.referrerChecker != nil && all {
+		purged += bd.referrerChecker.PurgeCache()
+	}
+
+	bd.logger.Info("detection caches purged",
+		zap.String("ip", ip),
+		zap.String("user_agent", userAgent),
+		zap.Int("purged", purged),
+	)
+
+	return purged
+}
+
+// GetLists возвращает действующие списки компонентов детекции
+func (bd *BotDetector) GetLists() map[string]interface{} {
+	lists := map[string]interface{}{
+		"user_agents":      []string{},
+		"ip_ranges":        []string{},
+		"referrer_domains": []string{},
+		"dns_patterns":     map[string][]string{},
+		"overlay":          ListsOverlay{},
+	}
+
+	if bd.userAgentMatcher != nil {
+		lists["user_agents"] = bd.userAgentMatcher.GetPatterns()
+	}
+	if bd.ipRangeChecker != nil {
+		lists["ip_ranges"] = bd.ipRangeChecker.GetRanges()
+	}
+	if bd.referrerChecker != nil {
+		lists["referrer_domains"] = bd.referrerChecker.GetDomains()
+	}
+	if bd.reverseDNSChecker != nil {
+		lists["dns_patterns"] = bd.reverseDNSChecker.GetBotDomainPatterns()
+	}
+	if bd.listEditor != nil {
+		lists["overlay"] = bd.listEditor.GetOverlay()
+	}
+
+	return lists
+}
+
+// listsChanged удаляет вердикты и результаты компонентов, на которые влияют изменения списков:
+// по адресам из измененных IP диапазонов, по User-Agent, совпадающим с измененными паттернами,
+// и по referrer с измененными доменами. Изменение паттернов обратного DNS очищает кеш вердиктов
+// целиком; домены referrer в вердикт не входят. Вердикты общего кеша становятся недоступны
+// вместе с прежним отпечатком списков.
+func (bd *BotDetector) listsChanged(changes []ListChange) {
+	bd.updateListsVersion()
+
+	var prefixes []netip.Prefix
+	var patterns, domains []string
+	var matchers []func(userAgent string) bool
+	all := false
+
+	for _, change := range changes {
+		switch change.List {
+		case ListIPRanges:
+			if prefix, err := parseFirewallPrefix(change.Value); err == nil {
+				prefixes = append(prefixes, prefix)
+			}
+		case ListUserAgents:
+			patterns = append(patterns, change.Value)
+			matchers = append(matchers, bd.userAgentMatcher.patternMatcher(change.Value))
+		case ListReferrerDomains:
+			domains = append(domains, change.Value)
+		case ListDNSPatterns:
+			all = true
+		}
+	}
+
+	purgedResults := bd.userAgentMatcher.purgePatterns(patterns) + bd.ipRangeChecker.purgePrefixes(prefixes)
+	if bd.referrerChecker.enabled {
+		purgedResults += bd.referrerChecker.purgeDomains(domains)
+	}
+
+	purged := 0
+	switch {
+	case all:
+		purged = bd.cache.Len()
+		bd.cache.Clear()
+	case len(prefixes) > 0 || len(matchers) > 0:
+		purged = bd.cache.DeleteFunc(func(key string, _ *BotVerdict) bool {
+			host, userAgent, _ := strings.Cut(key, "|")
+			if addr, err := netip.ParseAddr(host); err == nil {
+				for _, prefix := range prefixes {
+					if prefix.Contains(addr) {
+						return true
+					}
+				}
+			}
+			for _, match := range matchers {
+				if match(userAgent) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	bd.logger.Info("verdicts invalidated after list changes",
+		zap.Int("changes", len(changes)),
+		zap.String("lists_version", *bd.listsVersion.Load()),
+		zap.Int("purged", purged),
+		zap.Int("purged_results", purgedResults),
+	)
 }
 
 // updateStatistics обновляет внутреннюю статистику
@@ -425,6 +1114,7 @@ func (bd *BotDetector) updateStatistics(result *DetectionResult) {
 	// Обновляем метрики
 	if bd.metrics != nil {
 		bd.metrics.RecordProcessingTime(result.ProcessingTime)
+		bd.metrics.RecordDetection(result)
 	}
 }
 
@@ -443,6 +1133,56 @@ func (bd *BotDetector) GetRateLimiter() *RateLimiter {
 	return bd.rateLimiter
 }
 
+// GetRobotsChecker возвращает robots checker
+func (bd *BotDetector) GetRobotsChecker() *RobotsChecker {
+	return bd.robotsChecker
+}
+
+// GetHoneypot возвращает honeypot
+func (bd *BotDetector) GetHoneypot() *Honeypot {
+	return bd.honeypot
+}
+
+// GetChallenger возвращает компонент проверки браузера
+func (bd *BotDetector) GetChallenger() *Challenger {
+	return bd.challenger
+}
+
+// GetBanManager возвращает менеджер банов
+func (bd *BotDetector) GetBanManager() *BanManager {
+	return bd.banManager
+}
+
+// GetSharedCache возвращает общий кеш кластера
+func (bd *BotDetector) GetSharedCache() *SharedCache {
+	return bd.sharedCache
+}
+
+// GetFirewallExporter возвращает компонент выгрузки правил файрвола
+func (bd *BotDetector) GetFirewallExporter() *FirewallExporter {
+	return bd.firewallExporter
+}
+
+// GetListEditor возвращает редактор списков
+func (bd *BotDetector) GetListEditor() *ListEditor {
+	return bd.listEditor
+}
+
+// GetOverrideTable возвращает таблицу ручных переопределений
+func (bd *BotDetector) GetOverrideTable() *OverrideTable {
+	return bd.overrideTable
+}
+
+// GetReverseDNSChecker возвращает компонент проверки обратного DNS
+func (bd *BotDetector) GetReverseDNSChecker() *ReverseDNSChecker {
+	return bd.reverseDNSChecker
+}
+
+// GetSignatureVerifier возвращает компонент проверки подписей
+func (bd *BotDetector) GetSignatureVerifier() *SignatureVerifier {
+	return bd.signatureVerifier
+}
+
 // GetStats возвращает статистику детектора
 func (bd *BotDetector) GetStats() map[string]interface{} {
 	bd.mutex.RLock()
@@ -476,6 +1216,14 @@ func (bd *BotDetector) GetStats() map[string]interface{} {
 			"cache":               bd.cache != nil,
 			"metrics":             bd.metrics != nil,
 			"rate_limiter":        bd.rateLimiter != nil,
+			"robots_checker":      bd.robotsChecker != nil && bd.robotsChecker.IsEnabled(),
+			"honeypot":            bd.honeypot != nil && bd.honeypot.IsEnabled(),
+			"challenge":           bd.challenger != nil && bd.challenger.IsEnabled(),
+			"message_signatures":  bd.signatureVerifier != nil && bd.signatureVerifier.IsEnabled(),
+			"bans":                bd.banManager != nil && bd.banManager.IsEnabled(),
+			"firewall_export":     bd.firewallExporter != nil && bd.firewallExporter.IsEnabled(),
+			"shared_cache":        bd.sharedCache != nil && bd.sharedCache.IsEnabled(),
+			"list_files":          bd.listWatcher != nil && bd.listWatcher.IsEnabled(),
 		},
 	}
 
@@ -496,6 +1244,46 @@ func (bd *BotDetector) GetStats() map[string]interface{} {
 		stats["referrer_stats"] = bd.referrerChecker.GetStats()
 	}
 
+	if bd.robotsChecker != nil {
+		stats["robots_stats"] = bd.robotsChecker.GetStats()
+	}
+
+	if bd.honeypot != nil {
+		stats["honeypot_stats"] = bd.honeypot.GetStats()
+	}
+
+	if bd.challenger != nil {
+		stats["challenge_stats"] = bd.challenger.GetStats()
+	}
+
+	if bd.signatureVerifier != nil {
+		stats["signature_stats"] = bd.signatureVerifier.GetStats()
+	}
+
+	if bd.banManager != nil {
+		stats["ban_stats"] = bd.banManager.GetStats()
+	}
+
+	if bd.firewallExporter != nil {
+		stats["firewall_export_stats"] = bd.firewallExporter.GetStats()
+	}
+
+	if bd.sharedCache != nil {
+		stats["shared_cache_stats"] = bd.sharedCache.GetStats()
+	}
+
+	if bd.listEditor != nil {
+		stats["lists_stats"] = bd.listEditor.GetStats()
+	}
+
+	if bd.listWatcher != nil {
+		stats["list_files_stats"] = bd.listWatcher.GetStats()
+	}
+
+	if bd.overrideTable != nil {
+		stats["override_stats"] = bd.overrideTable.GetStats()
+	}
+
 	if bd.cache != nil {
 		stats["cache_stats"] = bd.cache.GetStats()
 	}
@@ -508,14 +1296,50 @@ func (bd *BotDetector) Shutdown() {
 	bd.logger.Info("shutting down bot detector")
 
 	// Останавливаем компоненты в обратном порядке
+	if bd.listWatcher != nil {
+		bd.listWatcher.Shutdown()
+	}
+
+	if bd.sharedCache != nil {
+		bd.sharedCache.Shutdown()
+	}
+
+	if bd.firewallExporter != nil {
+		bd.firewallExporter.Shutdown()
+	}
+
 	if bd.reverseDNSChecker != nil {
 		bd.reverseDNSChecker.Shutdown()
 	}
 
+	if bd.referrerChecker != nil {
+		bd.referrerChecker.Shutdown()
+	}
+
+	if bd.ipRangeChecker != nil {
+		bd.ipRangeChecker.Shutdown()
+	}
+
+	if bd.userAgentMatcher != nil {
+		bd.userAgentMatcher.Shutdown()
+	}
+
 	if bd.rateLimiter != nil {
 		bd.rateLimiter.Shutdown()
 	}
 
+	if bd.honeypot != nil {
+		bd.honeypot.Shutdown()
+	}
+
+	if bd.signatureVerifier != nil {
+		bd.signatureVerifier.Shutdown()
+	}
+
+	if bd.banManager != nil {
+		bd.banManager.Shutdown()
+	}
+
 	if bd.cache != nil {
 		bd.cache.StopCleanup()
 	}
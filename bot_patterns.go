@@ -707,7 +707,6 @@ func getBasicBotUserAgents() []string {
 		"follow",
 		"watch",
 		"observe",
-		"monitor",
 		"guard",
 		"sentinel",
 		"agent",
@@ -1,28 +1,46 @@
 package botredirect
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
-// Cache универсальная система кеширования для всех компонентов
-type Cache struct {
-	// Основное хранилище
-	store map[string]*CacheEntry
-	mutex sync.RWMutex
+// cacheMaxShards максимальное количество шардов кеша
+const cacheMaxShards = 16
+
+// cacheMinShardSize минимальная емкость шарда; небольшие кеши делятся на меньшее число шардов,
+// чтобы вытеснение оставалось близким к глобальному LRU
+const cacheMinShardSize = 64
+
+// Cache универсальный типизированный кеш для всех компонентов.
+// Ключи распределяются по шардам с независимыми блокировками, внутри шарда
+// записи хранятся в двусвязном списке в порядке использования, поэтому чтение,
+// запись и вытеснение наименее используемой записи выполняются за O(1).
+type Cache[K comparable, V any] struct {
+	// Шарды
+	shards []*cacheShard[K, V]
+	hash   func(K) uint64
 
 	// Конфигурация
+	name            string
 	ttl             time.Duration
 	maxSize         int
 	cleanupInterval time.Duration
 
 	// Статистика (используем atomic для thread-safety)
-	hits      int64
-	misses    int64
-	evictions int64
+	hits        int64
+	misses      int64
+	evictions   int64
+	expirations int64
+
+	// Счетчики Prometheus (nil, если выключены)
+	promHits   prometheus.Counter
+	promMisses prometheus.Counter
 
 	// Компоненты
 	metrics *Metrics
@@ -31,226 +49,329 @@ type Cache struct {
 
 	// Очистка
 	stopCleanup chan bool
+	cleanupDone chan struct{}
 	isRunning   bool
 	cleanupOnce sync.Once
 }
 
-// CacheEntry запись в кеше с метаданными
-type CacheEntry struct {
-	Key        string
-	Value      interface{}
-	CreatedAt  time.Time
-	LastAccess time.Time
-	TTL        time.Duration
-	HitCount   int64
+// CacheOptions параметры экземпляра кеша
+type CacheOptions struct {
+	// Имя кеша в статистике и логах
+	Name string
+
+	// Максимальное количество записей (0 - без ограничения)
+	MaxSize int
+
+	// TTL записей по умолчанию (0 - без ограничения)
+	TTL time.Duration
+
+	// Интервал фонового удаления устаревших записей (0 - без фоновой очистки)
+	CleanupInterval time.Duration
+}
+
+// cacheShard часть кеша со своей блокировкой и списком LRU
+type cacheShard[K comparable, V any] struct {
+	mutex    sync.Mutex
+	items    map[K]*cacheNode[K, V]
+	root     cacheNode[K, V] // кольцевой список: root.next - самая свежая запись, root.prev - самая старая
+	capacity int
+}
+
+// cacheNode запись в кеше
+type cacheNode[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+	prev      *cacheNode[K, V]
+	next      *cacheNode[K, V]
 }
 
 // CacheStats статистика кеша
 type CacheStats struct {
-	Size      int
-	Hits      int64
-	Misses    int64
-	Evictions int64
-	HitRate   float64
+	Name        string
+	Size        int
+	MaxSize     int
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+	HitRate     float64
 }
 
-// NewCache создает новый экземпляр кеша
-func NewCache(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *Cache {
-	cache := &Cache{
-		store:           make(map[string]*CacheEntry),
-		ttl:             config.CacheTTL,
-		maxSize:         config.MaxCacheSize,
-		cleanupInterval: config.CleanupInterval,
+// NewCache создает новый экземпляр кеша. hash распределяет ключи по шардам.
+func NewCache[K comparable, V any](options CacheOptions, hash func(K) uint64, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *Cache[K, V] {
+	shardCount := cacheMaxShards
+	for shardCount > 1 && options.MaxSize > 0 && options.MaxSize/shardCount < cacheMinShardSize {
+		shardCount /= 2
+	}
+
+	capacity := 0
+	if options.MaxSize > 0 {
+		capacity = (options.MaxSize + shardCount - 1) / shardCount
+	}
+
+	cache := &Cache[K, V]{
+		shards:          make([]*cacheShard[K, V], shardCount),
+		hash:            hash,
+		name:            options.Name,
+		ttl:             options.TTL,
+		maxSize:         options.MaxSize,
+		cleanupInterval: options.CleanupInterval,
 		metrics:         metrics,
 		debug:           debug,
 		logger:          logger,
 		stopCleanup:     make(chan bool, 1), // буферизованный канал
+		cleanupDone:     make(chan struct{}),
 		isRunning:       false,
 	}
 
+	if metrics != nil {
+		cache.promHits, cache.promMisses = metrics.CacheCounters(cache.name)
+	}
+
+	for i := range cache.shards {
+		shard := &cacheShard[K, V]{
+			items:    make(map[K]*cacheNode[K, V]),
+			capacity: capacity,
+		}
+		shard.root.next = &shard.root
+		shard.root.prev = &shard.root
+		cache.shards[i] = shard
+	}
+
 	// Запускаем фоновую очистку
-	cache.startCleanup()
+	if cache.cleanupInterval > 0 {
+		cache.startCleanup()
+	}
 
-	logger.Info("cache system initialized",
+	logger.Debug("cache initialized",
+		zap.String("name", cache.name),
 		zap.Duration("ttl", cache.ttl),
 		zap.Int("max_size", cache.maxSize),
-		zap.Duration("cleanup_interval", cache.cleanupInterval),
+		zap.Int("shards", shardCount),
 	)
 
 	return cache
 }
 
-// Get получает значение из кеша
-func (c *Cache) Get(key string) interface{} {
-	c.mutex.RLock()
-	entry, exists := c.store[key]
-	c.mutex.RUnlock()
+// hashString хеш строкового ключа (FNV-1a) для выбора шарда
+func hashString(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
 
+// shard возвращает шард для ключа
+func (c *Cache[K, V]) shard(key K) *cacheShard[K, V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[c.hash(key)%uint64(len(c.shards))]
+}
+
+// Get получает значение из кеша
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	shard := c.shard(key)
+
+	shard.mutex.Lock()
+	node, exists := shard.items[key]
+	if exists && !node.expiresAt.IsZero() && time.Now().After(node.expiresAt) {
+		// Устаревшая запись
+		shard.remove(node)
+		exists = false
+		atomic.AddInt64(&c.expirations, 1)
+	}
 	if !exists {
-		c.incrementMisses()
-		if c.debug != nil {
-			c.debug.LogCacheOperation(&CacheDebugInfo{
-				Key:       key,
-				Operation: "miss",
-				Hit:       false,
-				Timestamp: time.Now(),
-			})
+		shard.mutex.Unlock()
+		atomic.AddInt64(&c.misses, 1)
+		if c.promMisses != nil {
+			c.promMisses.Inc()
 		}
-		return nil
-	}
+		c.logOperation(key, "miss", false, 0)
 
-	// Проверяем TTL
-	if c.isExpired(entry) {
-		c.Delete(key)
-		c.incrementMisses()
-		return nil
+		var zero V
+		return zero, false
 	}
+	shard.moveToFront(node)
+	value := node.value
+	shard.mutex.Unlock()
 
-	// Обновляем статистику доступа
-	c.mutex.Lock()
-	entry.LastAccess = time.Now()
-	atomic.AddInt64(&entry.HitCount, 1)
-	c.mutex.Unlock()
-
-	c.incrementHits()
-	if c.debug != nil {
-		c.debug.LogCacheOperation(&CacheDebugInfo{
-			Key:       key,
-			Operation: "hit",
-			Hit:       true,
-			Value:     entry.Value,
-			TTL:       entry.TTL,
-			Timestamp: time.Now(),
-		})
+	atomic.AddInt64(&c.hits, 1)
+	if c.promHits != nil {
+		c.promHits.Inc()
 	}
+	c.logOperation(key, "hit", true, 0)
 
-	return entry.Value
+	return value, true
 }
 
-// Set сохраняет значение в кеш
-func (c *Cache) Set(key string, value interface{}) {
+// Set сохраняет значение в кеш с TTL по умолчанию
+func (c *Cache[K, V]) Set(key K, value V) {
 	c.SetWithTTL(key, value, c.ttl)
 }
 
-// SetWithTTL сохраняет значение с кастомным TTL
-func (c *Cache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	// Проверяем размер кеша
-	if len(c.store) >= c.maxSize {
-		c.evictLRUUnsafe() // unsafe версия для использования под мьютексом
+// SetWithTTL сохраняет значение с собственным TTL (0 - без ограничения)
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
 	}
 
-	entry := &CacheEntry{
-		Key:        key,
-		Value:      value,
-		CreatedAt:  time.Now(),
-		LastAccess: time.Now(),
-		TTL:        ttl,
-		HitCount:   0,
+	shard := c.shard(key)
+
+	shard.mutex.Lock()
+	if node, exists := shard.items[key]; exists {
+		node.value = value
+		node.expiresAt = expiresAt
+		shard.moveToFront(node)
+		shard.mutex.Unlock()
+		c.logOperation(key, "set", false, ttl)
+		return
 	}
 
-	c.store[key] = entry
-
-	if c.debug != nil {
-		c.debug.LogCacheOperation(&CacheDebugInfo{
-			Key:       key,
-			Operation: "set",
-			Hit:       false,
-			Value:     value,
-			TTL:       ttl,
-			Timestamp: time.Now(),
-		})
+	evicted := false
+	if shard.capacity > 0 && len(shard.items) >= shard.capacity {
+		shard.remove(shard.root.prev)
+		evicted = true
 	}
-}
 
-// Delete удаляет запись из кеша
-func (c *Cache) Delete(key string) {
-	c.mutex.Lock()
-	delete(c.store, key)
-	c.mutex.Unlock()
-
-	if c.debug != nil {
-		c.debug.LogCacheOperation(&CacheDebugInfo{
-			Key:       key,
-			Operation: "delete",
-			Hit:       false,
-			Timestamp: time.Now(),
-		})
+	node := &cacheNode[K, V]{key: key, value: value, expiresAt: expiresAt}
+	shard.items[key] = node
+	shard.pushFront(node)
+	shard.mutex.Unlock()
+
+	if evicted {
+		atomic.AddInt64(&c.evictions, 1)
 	}
+	c.logOperation(key, "set", false, ttl)
 }
 
-// Clear очищает весь кеш
-func (c *Cache) Clear() {
-	c.mutex.Lock()
-	c.store = make(map[string]*CacheEntry)
-	c.mutex.Unlock()
+// Delete удаляет запись из кеша
+func (c *Cache[K, V]) Delete(key K) bool {
+	shard := c.shard(key)
 
-	c.logger.Info("cache cleared")
-}
+	shard.mutex.Lock()
+	node, exists := shard.items[key]
+	if exists {
+		shard.remove(node)
+	}
+	shard.mutex.Unlock()
 
-// isExpired проверяет истек ли TTL записи
-func (c *Cache) isExpired(entry *CacheEntry) bool {
-	if entry.TTL <= 0 {
-		return false // Бесконечный TTL
+	if exists {
+		c.logOperation(key, "delete", false, 0)
 	}
-	return time.Since(entry.CreatedAt) > entry.TTL
+	return exists
 }
 
-// evictLRUUnsafe удаляет наименее используемую запись (вызывать под мьютексом)
-func (c *Cache) evictLRUUnsafe() {
-	var oldestKey string
-	oldestTime := time.Now()
-
-	for key, entry := range c.store {
-		if entry.LastAccess.Before(oldestTime) {
-			oldestTime = entry.LastAccess
-			oldestKey = key
+// DeleteFunc удаляет записи, для которых match возвращает true, и возвращает их количество
+func (c *Cache[K, V]) DeleteFunc(match func(key K, value V) bool) int {
+	deleted := 0
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		for key, node := range shard.items {
+			if match(key, node.value) {
+				shard.remove(node)
+				deleted++
+			}
 		}
+		shard.mutex.Unlock()
 	}
 
-	if oldestKey != "" {
-		delete(c.store, oldestKey)
-		c.incrementEvictions()
+	if deleted > 0 {
+		c.logger.Debug("cache entries deleted",
+			zap.String("name", c.name),
+			zap.Int("deleted", deleted),
+		)
 	}
+	return deleted
 }
 
-// cleanup удаляет устаревшие записи
-func (c *Cache) cleanup() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// Clear очищает весь кеш
+func (c *Cache[K, V]) Clear() {
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		shard.items = make(map[K]*cacheNode[K, V])
+		shard.root.next = &shard.root
+		shard.root.prev = &shard.root
+		shard.mutex.Unlock()
+	}
 
-	keysToDelete := make([]string, 0)
+	c.logger.Debug("cache cleared", zap.String("name", c.name))
+}
 
-	for key, entry := range c.store {
-		if c.isExpired(entry) {
-			keysToDelete = append(keysToDelete, key)
-		}
+// Len возвращает количество записей
+func (c *Cache[K, V]) Len() int {
+	size := 0
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		size += len(shard.items)
+		shard.mutex.Unlock()
 	}
+	return size
+}
 
-	for _, key := range keysToDelete {
-		delete(c.store, key)
+// pushFront вставляет запись в начало списка (вызывать под мьютексом шарда)
+func (s *cacheShard[K, V]) pushFront(node *cacheNode[K, V]) {
+	node.prev = &s.root
+	node.next = s.root.next
+	s.root.next.prev = node
+	s.root.next = node
+}
+
+// moveToFront помечает запись как самую свежую (вызывать под мьютексом шарда)
+func (s *cacheShard[K, V]) moveToFront(node *cacheNode[K, V]) {
+	if s.root.next == node {
+		return
 	}
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	s.pushFront(node)
+}
 
-	// ИСПРАВЛЕНИЕ: Принудительная очистка если кеш переполнен
-	if len(c.store) > c.maxSize {
-		c.evictLRUUnsafe()
+// remove удаляет запись из списка и индекса (вызывать под мьютексом шарда)
+func (s *cacheShard[K, V]) remove(node *cacheNode[K, V]) {
+	node.prev.next = node.next
+	node.next.prev = node.prev
+	node.prev, node.next = nil, nil
+	delete(s.items, node.key)
+}
+
+// cleanup удаляет устаревшие записи
+func (c *Cache[K, V]) cleanup() {
+	now := time.Now()
+	expired := 0
+
+	for _, shard := range c.shards {
+		shard.mutex.Lock()
+		for _, node := range shard.items {
+			if !node.expiresAt.IsZero() && now.After(node.expiresAt) {
+				shard.remove(node)
+				expired++
+			}
+		}
+		shard.mutex.Unlock()
 	}
 
-	if len(keysToDelete) > 0 {
+	if expired > 0 {
+		atomic.AddInt64(&c.expirations, int64(expired))
 		c.logger.Debug("cache cleanup completed",
-			zap.Int("expired_entries", len(keysToDelete)),
-			zap.Int("current_size", len(c.store)),
+			zap.String("name", c.name),
+			zap.Int("expired_entries", expired),
+			zap.Int("current_size", c.Len()),
 		)
 	}
 }
 
 // startCleanup запускает фоновую очистку кеша
-func (c *Cache) startCleanup() {
+func (c *Cache[K, V]) startCleanup() {
 	c.cleanupOnce.Do(func() {
 		c.isRunning = true
 		go func() {
+			defer close(c.cleanupDone)
+
 			ticker := time.NewTicker(c.cleanupInterval)
 			defer ticker.Stop()
 
@@ -259,7 +380,6 @@ func (c *Cache) startCleanup() {
 				case <-ticker.C:
 					c.cleanup()
 				case <-c.stopCleanup:
-					c.isRunning = false
 					return
 				}
 			}
@@ -267,25 +387,36 @@ func (c *Cache) startCleanup() {
 	})
 }
 
-// StopCleanup останавливает фоновую очистку
-func (c *Cache) StopCleanup() {
+// StopCleanup останавливает фоновую очистку и ждет завершения горутины
+func (c *Cache[K, V]) StopCleanup() {
 	if c.isRunning {
 		select {
 		case c.stopCleanup <- true:
 		default:
 		}
+		<-c.cleanupDone
 	}
 }
 
-// GetStats возвращает статистику кеша
-func (c *Cache) GetStats() *CacheStats {
-	c.mutex.RLock()
-	size := len(c.store)
-	c.mutex.RUnlock()
+// logOperation логирует операцию с кешем в debug режиме
+func (c *Cache[K, V]) logOperation(key K, operation string, hit bool, ttl time.Duration) {
+	if c.debug == nil || !c.debug.Enabled || !c.debug.LogCacheOps {
+		return
+	}
 
+	c.debug.LogCacheOperation(&CacheDebugInfo{
+		Key:       c.name + ":" + fmt.Sprint(key),
+		Operation: operation,
+		Hit:       hit,
+		TTL:       ttl,
+		Timestamp: time.Now(),
+	})
+}
+
+// GetStats возвращает статистику кеша
+func (c *Cache[K, V]) GetStats() *CacheStats {
 	hits := atomic.LoadInt64(&c.hits)
 	misses := atomic.LoadInt64(&c.misses)
-	evictions := atomic.LoadInt64(&c.evictions)
 
 	hitRate := 0.0
 	totalRequests := hits + misses
@@ -294,16 +425,33 @@ func (c *Cache) GetStats() *CacheStats {
 	}
 
 	return &CacheStats{
-		Size:      size,
-		Hits:      hits,
-		Misses:    misses,
-		Evictions: evictions,
-		HitRate:   hitRate,
+		Name:        c.name,
+		Size:        c.Len(),
+		MaxSize:     c.maxSize,
+		Hits:        hits,
+		Misses:      misses,
+		Evictions:   atomic.LoadInt64(&c.evictions),
+		Expirations: atomic.LoadInt64(&c.expirations),
+		HitRate:     hitRate,
+	}
+}
+
+// ToMap возвращает статистику в виде карты для GetStats компонентов
+func (s *CacheStats) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        s.Name,
+		"size":        s.Size,
+		"max_size":    s.MaxSize,
+		"hits":        s.Hits,
+		"misses":      s.Misses,
+		"evictions":   s.Evictions,
+		"expirations": s.Expirations,
+		"hit_rate":    s.HitRate,
 	}
 }
 
 // UpdateMetrics обновляет метрики в системе мониторинга
-func (c *Cache) UpdateMetrics() {
+func (c *Cache[K, V]) UpdateMetrics() {
 	if c.metrics != nil {
 		stats := c.GetStats()
 		c.metrics.SetCacheSize(int64(stats.Size))
@@ -313,16 +461,3 @@ func (c *Cache) UpdateMetrics() {
 		}
 	}
 }
-
-// Методы для статистики (используем atomic operations)
-func (c *Cache) incrementHits() {
-	atomic.AddInt64(&c.hits, 1)
-}
-
-func (c *Cache) incrementMisses() {
-	atomic.AddInt64(&c.misses, 1)
-}
-
-func (c *Cache) incrementEvictions() {
-	atomic.AddInt64(&c.evictions, 1)
-}
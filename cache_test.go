@@ -0,0 +1,161 @@
+package botredirect
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestCache создает строковый кеш без метрик
+func newTestCache(options CacheOptions) *Cache[string, int] {
+	return NewCache[string, int](options, hashString, nil, nil, zap.NewNop())
+}
+
+// TestCacheLRUOrder проверяет, что вытесняется наименее используемая запись
+func TestCacheLRUOrder(t *testing.T) {
+	// Маленький кеш помещается в один шард, порядок вытеснения глобальный
+	c := newTestCache(CacheOptions{Name: "test", MaxSize: 3})
+	if len(c.shards) != 1 {
+		t.Fatalf("shards = %d, want 1", len(c.shards))
+	}
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("a")    // a становится самой свежей
+	c.Set("b", 4) // перезапись тоже освежает запись
+	c.Set("d", 5) // вытесняет c
+
+	for key, want := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+	if value, _ := c.Get("b"); value != 4 {
+		t.Errorf("b = %d, want 4", value)
+	}
+	if stats := c.GetStats(); stats.Evictions != 1 || stats.Size != 3 {
+		t.Errorf("evictions = %d, size = %d; want 1, 3", stats.Evictions, stats.Size)
+	}
+}
+
+// TestCacheTTL проверяет истечение записей с TTL по умолчанию и собственным TTL
+func TestCacheTTL(t *testing.T) {
+	c := newTestCache(CacheOptions{Name: "test", TTL: 20 * time.Millisecond})
+
+	c.Set("default", 1)
+	c.SetWithTTL("long", 2, time.Hour)
+	c.SetWithTTL("forever", 3, 0)
+
+	time.Sleep(40 * time.Millisecond)
+
+	for key, want := range map[string]bool{"default": false, "long": true, "forever": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+	if stats := c.GetStats(); stats.Expirations != 1 || stats.Size != 2 {
+		t.Errorf("expirations = %d, size = %d; want 1, 2", stats.Expirations, stats.Size)
+	}
+
+	// Перезапись продлевает запись
+	c.SetWithTTL("short", 4, 20*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	c.SetWithTTL("short", 5, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	if value, ok := c.Get("short"); !ok || value != 5 {
+		t.Errorf("short = %d, %v; want 5, true", value, ok)
+	}
+}
+
+// TestCacheShardSizeLimit проверяет, что размер ограничивается в каждом шарде
+// и общий размер не превышает max_size с округлением до шардов
+func TestCacheShardSizeLimit(t *testing.T) {
+	tests := []struct {
+		maxSize    int
+		wantShards int
+	}{
+		{maxSize: 10, wantShards: 1},
+		{maxSize: 128, wantShards: 2},
+		{maxSize: 1000, wantShards: 8},
+		{maxSize: 10000, wantShards: cacheMaxShards},
+		{maxSize: 0, wantShards: cacheMaxShards},
+	}
+
+	for _, tt := range tests {
+		c := newTestCache(CacheOptions{Name: "test", MaxSize: tt.maxSize})
+		if len(c.shards) != tt.wantShards {
+			t.Errorf("max_size %d: shards = %d, want %d", tt.maxSize, len(c.shards), tt.wantShards)
+		}
+
+		for i := 0; i < 3*tt.maxSize+100; i++ {
+			c.Set(strconv.Itoa(i), i)
+		}
+
+		capacity := 0
+		for _, shard := range c.shards {
+			if tt.maxSize > 0 && len(shard.items) > shard.capacity {
+				t.Errorf("max_size %d: shard holds %d entries, capacity %d", tt.maxSize, len(shard.items), shard.capacity)
+			}
+			capacity += shard.capacity
+		}
+		if tt.maxSize > 0 && (capacity < tt.maxSize || capacity >= tt.maxSize+len(c.shards)) {
+			t.Errorf("max_size %d: total capacity %d", tt.maxSize, capacity)
+		}
+		if tt.maxSize == 0 && c.Len() != 100 {
+			t.Errorf("unbounded cache holds %d entries, want 100", c.Len())
+		}
+	}
+}
+
+// TestCacheCleanup проверяет фоновое удаление истекших записей и его остановку
+func TestCacheCleanup(t *testing.T) {
+	c := newTestCache(CacheOptions{Name: "test", TTL: time.Millisecond, CleanupInterval: 5 * time.Millisecond})
+
+	c.Set("a", 1)
+	deadline := time.Now().Add(time.Second)
+	for c.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if c.Len() != 0 {
+		t.Fatal("expired entry not removed by background cleanup")
+	}
+
+	c.StopCleanup()
+	c.StopCleanup() // повторная остановка не блокируется
+
+	c.Set("b", 2)
+	time.Sleep(30 * time.Millisecond)
+	if c.Len() != 1 {
+		t.Error("cleanup still running after StopCleanup")
+	}
+}
+
+// TestBotDetectorShutdownStopsCaches проверяет, что остановка детектора завершает
+// горутины очистки кешей всех компонентов
+func TestBotDetectorShutdownStopsCaches(t *testing.T) {
+	config := DefaultConfig()
+	config.EnableMetrics = false
+	config.EnableReferrerCheck = true
+	config.CleanupInterval = time.Minute
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		bd, err := NewBotDetector(config, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bd.Shutdown()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines after 5 detector shutdowns: %d, before: %d", after, before)
+	}
+}
@@ -0,0 +1,374 @@
+package botredirect
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// challengeTokenTTL время, за которое клиент должен решить выданную проверку
+	challengeTokenTTL = 5 * time.Minute
+
+	// maxChallengeNonceLength ограничивает размер присылаемого решения
+	maxChallengeNonceLength = 20
+
+	// Префиксы полезной нагрузки разделяют токены проверки и cookie допуска,
+	// чтобы подпись одного нельзя было предъявить вместо другого
+	challengePayloadPrefix = "c"
+	clearancePayloadPrefix = "k"
+)
+
+// Challenger выдает браузерам proof-of-work проверку и подписанную cookie допуска.
+// Проверка полностью stateless: все данные содержатся в HMAC-подписанных токенах,
+// поэтому решение, полученное одним экземпляром, принимается любым другим с теми же секретами.
+type Challenger struct {
+	// Конфигурация
+	enabled    bool
+	difficulty int
+	ttl        time.Duration
+	cookieName string
+	path       string
+
+	// Секреты подписи: первый используется для подписи, все - для проверки
+	secrets [][]byte
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	issued         int64
+	solved         int64
+	failed         int64
+	clearanceHits  int64
+	clearanceMiss  int64
+	invalidCookies int64
+}
+
+// ChallengeData данные для шаблона страницы проверки
+type ChallengeData struct {
+	Token      string
+	Difficulty int
+	Action     string
+	Return     string
+}
+
+// NewChallenger создает новый экземпляр Challenger
+func NewChallenger(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *Challenger {
+	if len(config.ChallengeSecrets) == 0 {
+		return &Challenger{enabled: false}
+	}
+
+	c := &Challenger{
+		enabled:    true,
+		difficulty: config.ChallengeDifficulty,
+		ttl:        config.ChallengeTTL,
+		cookieName: config.ChallengeCookieName,
+		path:       config.ChallengePath,
+		metrics:    metrics,
+		debug:      debug,
+		logger:     logger,
+	}
+
+	for _, secret := range config.ChallengeSecrets {
+		c.secrets = append(c.secrets, []byte(secret))
+	}
+
+	logger.Info("browser challenge initialized",
+		zap.Int("difficulty", c.difficulty),
+		zap.Duration("clearance_ttl", c.ttl),
+		zap.String("path", c.path),
+		zap.Int("secrets", len(c.secrets)),
+	)
+
+	return c
+}
+
+// Issue создает новую проверку для запроса
+func (c *Challenger) Issue(r *http.Request) (*ChallengeData, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating challenge nonce: %w", err)
+	}
+
+	payload := strings.Join([]string{
+		challengePayloadPrefix,
+		strconv.FormatInt(time.Now().Unix(), 10),
+		hex.EncodeToString(nonce),
+		strconv.Itoa(c.difficulty),
+		c.binding(r),
+	}, "|")
+
+	atomic.AddInt64(&c.issued, 1)
+	if c.metrics != nil {
+		c.metrics.IncrementChallengesIssued()
+	}
+
+	return &ChallengeData{
+		Token:      c.sign(payload),
+		Difficulty: c.difficulty,
+		Action:     c.path,
+		Return:     sanitizeReturnPath(r.URL.RequestURI()),
+	}, nil
+}
+
+// Verify проверяет решение: подпись и срок токена, привязку к клиенту и proof-of-work
+func (c *Challenger) Verify(r *http.Request, token, nonce string) error {
+	err := c.verify(r, token, nonce)
+	if err != nil {
+		atomic.AddInt64(&c.failed, 1)
+		if c.metrics != nil {
+			c.metrics.IncrementChallengesFailed()
+		}
+		c.logger.Debug("challenge verification failed",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	atomic.AddInt64(&c.solved, 1)
+	if c.metrics != nil {
+		c.metrics.IncrementChallengesSolved()
+	}
+	return nil
+}
+
+// verify выполняет проверку решения без учета статистики
+func (c *Challenger) verify(r *http.Request, token, nonce string) error {
+	if nonce == "" || len(nonce) > maxChallengeNonceLength {
+		return fmt.Errorf("invalid nonce length")
+	}
+	for _, ch := range nonce {
+		if ch < '0' || ch > '9' {
+			return fmt.Errorf("nonce must be numeric")
+		}
+	}
+
+	payload, ok := c.open(token)
+	if !ok {
+		return fmt.Errorf("invalid challenge signature")
+	}
+
+	fields := strings.Split(payload, "|")
+	if len(fields) != 5 || fields[0] != challengePayloadPrefix {
+		return fmt.Errorf("malformed challenge")
+	}
+
+	issued, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed challenge timestamp")
+	}
+	if time.Since(time.Unix(issued, 0)) > challengeTokenTTL {
+		return fmt.Errorf("challenge expired")
+	}
+
+	difficulty, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return fmt.Errorf("malformed challenge difficulty")
+	}
+
+	if !hmac.Equal([]byte(fields[4]), []byte(c.binding(r))) {
+		return fmt.Errorf("challenge issued for another client")
+	}
+
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	if leadingZeroBits(sum[:]) < difficulty {
+		return fmt.Errorf("insufficient proof of work")
+	}
+
+	return nil
+}
+
+// Clearance создает подписанную cookie допуска для клиента
+func (c *Challenger) Clearance(r *http.Request) *http.Cookie {
+	expires := time.Now().Add(c.ttl)
+
+	payload := strings.Join([]string{
+		clearancePayloadPrefix,
+		strconv.FormatInt(expires.Unix(), 10),
+		c.binding(r),
+	}, "|")
+
+	return &http.Cookie{
+		Name:     c.cookieName,
+		Value:    c.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(c.ttl.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// HasClearance проверяет наличие действующей cookie допуска
+func (c *Challenger) HasClearance(r *http.Request) bool {
+	if !c.enabled {
+		return false
+	}
+
+	cookie, err := r.Cookie(c.cookieName)
+	if err != nil {
+		atomic.AddInt64(&c.clearanceMiss, 1)
+		return false
+	}
+
+	if !c.validClearance(r, cookie.Value) {
+		atomic.AddInt64(&c.invalidCookies, 1)
+		return false
+	}
+
+	atomic.AddInt64(&c.clearanceHits, 1)
+	return true
+}
+
+// validClearance проверяет подпись, срок действия и привязку cookie допуска
+func (c *Challenger) validClearance(r *http.Request, value string) bool {
+	payload, ok := c.open(value)
+	if !ok {
+		return false
+	}
+
+	fields := strings.Split(payload, "|")
+	if len(fields) != 3 || fields[0] != clearancePayloadPrefix {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return false
+	}
+
+	return hmac.Equal([]byte(fields[2]), []byte(c.binding(r)))
+}
+
+// IsSolutionRequest проверяет, является ли запрос отправкой решения проверки
+func (c *Challenger) IsSolutionRequest(r *http.Request) bool {
+	return c.enabled && r.Method == http.MethodPost && r.URL.Path == c.path
+}
+
+// sign подписывает полезную нагрузку текущим секретом
+func (c *Challenger) sign(payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.mac(c.secrets[0], encoded))
+}
+
+// open проверяет подпись любым из секретов и возвращает полезную нагрузку
+func (c *Challenger) open(token string) (string, bool) {
+	sep := strings.LastIndex(token, ".")
+	if sep <= 0 {
+		return "", false
+	}
+	encoded, signature := token[:sep], token[sep+1:]
+
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", false
+	}
+
+	for _, secret := range c.secrets {
+		if hmac.Equal(expected, c.mac(secret, encoded)) {
+			payload, err := base64.RawURLEncoding.DecodeString(encoded)
+			if err != nil {
+				return "", false
+			}
+			return string(payload), true
+		}
+	}
+
+	return "", false
+}
+
+// mac вычисляет HMAC-SHA256
+func (c *Challenger) mac(secret []byte, data string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// binding привязывает токен к IP адресу и User-Agent клиента
+func (c *Challenger) binding(r *http.Request) string {
+	sum := sha256.Sum256([]byte(c.extractIP(r.RemoteAddr) + "\n" + r.UserAgent()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// extractIP извлекает IP адрес из строки (убирает порт)
+func (c *Challenger) extractIP(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// leadingZeroBits считает количество ведущих нулевых бит
+func leadingZeroBits(sum []byte) int {
+	count := 0
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// sanitizeReturnPath допускает только локальные пути, чтобы форма не стала открытым редиректом.
+// Управляющие символы отклоняются: браузер удаляет табуляции и переводы строк из URL,
+// и "/\t/evil.com" превратился бы в "//evil.com".
+func sanitizeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] < 0x20 || path[i] == 0x7f {
+			return "/"
+		}
+	}
+	return path
+}
+
+// GetPath возвращает путь для отправки решения
+func (c *Challenger) GetPath() string {
+	return c.path
+}
+
+// IsEnabled возвращает статус включенности проверки
+func (c *Challenger) IsEnabled() bool {
+	return c.enabled
+}
+
+// GetStats возвращает статистику
+func (c *Challenger) GetStats() map[string]interface{} {
+	if !c.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	return map[string]interface{}{
+		"enabled":         true,
+		"difficulty":      c.difficulty,
+		"ttl_seconds":     c.ttl.Seconds(),
+		"secrets":         len(c.secrets),
+		"issued":          atomic.LoadInt64(&c.issued),
+		"solved":          atomic.LoadInt64(&c.solved),
+		"failed":          atomic.LoadInt64(&c.failed),
+		"clearance_hits":  atomic.LoadInt64(&c.clearanceHits),
+		"clearance_miss":  atomic.LoadInt64(&c.clearanceMiss),
+		"invalid_cookies": atomic.LoadInt64(&c.invalidCookies),
+	}
+}
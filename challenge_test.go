@@ -0,0 +1,209 @@
+package botredirect
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const testChallengeDifficulty = 8
+
+// newTestChallenger создает проверку с секретами secrets (первый используется для подписи)
+func newTestChallenger(secrets ...string) *Challenger {
+	config := DefaultConfig()
+	config.ChallengeSecrets = secrets
+	config.ChallengeDifficulty = testChallengeDifficulty
+	return NewChallenger(config, nil, nil, zap.NewNop())
+}
+
+// newChallengeRequest создает запрос клиента с адресом ip и User-Agent
+func newChallengeRequest(ip, userAgent string) *http.Request {
+	r := httptest.NewRequest("GET", "/page?x=1", nil)
+	r.RemoteAddr = ip + ":4321"
+	r.Header.Set("User-Agent", userAgent)
+	return r
+}
+
+// solveChallenge подбирает nonce, дающий не меньше difficulty нулевых бит (или меньше, если below)
+func solveChallenge(t *testing.T, token string, difficulty int, below bool) string {
+	t.Helper()
+
+	for i := 0; i < 1<<20; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(token + ":" + nonce))
+		if (leadingZeroBits(sum[:]) >= difficulty) != below {
+			return nonce
+		}
+	}
+	t.Fatal("no nonce found")
+	return ""
+}
+
+// TestChallengeVerify проверяет решение proof-of-work и привязку токена к клиенту
+func TestChallengeVerify(t *testing.T) {
+	c := newTestChallenger("current-secret")
+
+	const browser = "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"
+	issued, err := c.Issue(newChallengeRequest("192.0.2.1", browser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Return != "/page?x=1" || issued.Difficulty != testChallengeDifficulty {
+		t.Fatalf("issued challenge = %+v", issued)
+	}
+
+	solved := solveChallenge(t, issued.Token, testChallengeDifficulty, false)
+	weak := solveChallenge(t, issued.Token, testChallengeDifficulty, true)
+
+	// Токен, подписанный чужим секретом, и токен с истекшим сроком
+	forged := newTestChallenger("other-secret").sign(mustOpen(t, c, issued.Token))
+	expiredPayload := strings.Join([]string{
+		challengePayloadPrefix,
+		strconv.FormatInt(time.Now().Add(-challengeTokenTTL-time.Minute).Unix(), 10),
+		"00",
+		"0",
+		c.binding(newChallengeRequest("192.0.2.1", browser)),
+	}, "|")
+	expired := c.sign(expiredPayload)
+
+	tests := []struct {
+		name    string
+		ip, ua  string
+		token   string
+		nonce   string
+		wantErr string
+	}{
+		{name: "solved", ip: "192.0.2.1", ua: browser, token: issued.Token, nonce: solved},
+		{name: "nonce below difficulty", ip: "192.0.2.1", ua: browser, token: issued.Token, nonce: weak, wantErr: "insufficient proof of work"},
+		{name: "non-numeric nonce", ip: "192.0.2.1", ua: browser, token: issued.Token, nonce: "1e3", wantErr: "numeric"},
+		{name: "empty nonce", ip: "192.0.2.1", ua: browser, token: issued.Token, wantErr: "nonce length"},
+		{name: "long nonce", ip: "192.0.2.1", ua: browser, token: issued.Token, nonce: strings.Repeat("1", maxChallengeNonceLength+1), wantErr: "nonce length"},
+		{name: "another ip", ip: "192.0.2.2", ua: browser, token: issued.Token, nonce: solved, wantErr: "another client"},
+		{name: "another user agent", ip: "192.0.2.1", ua: browser + " Extra", token: issued.Token, nonce: solved, wantErr: "another client"},
+		{name: "forged signature", ip: "192.0.2.1", ua: browser, token: forged, nonce: solved, wantErr: "signature"},
+		{name: "tampered payload", ip: "192.0.2.1", ua: browser, token: "x" + issued.Token, nonce: solved, wantErr: "signature"},
+		{name: "expired", ip: "192.0.2.1", ua: browser, token: expired, nonce: "1", wantErr: "expired"},
+		{name: "clearance cookie instead of token", ip: "192.0.2.1", ua: browser, token: c.Clearance(newChallengeRequest("192.0.2.1", browser)).Value, nonce: "1", wantErr: "malformed"},
+	}
+
+	for _, tt := range tests {
+		err := c.Verify(newChallengeRequest(tt.ip, tt.ua), tt.token, tt.nonce)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// mustOpen возвращает полезную нагрузку токена, подписанного c
+func mustOpen(t *testing.T, c *Challenger, token string) string {
+	t.Helper()
+
+	payload, ok := c.open(token)
+	if !ok {
+		t.Fatal("token signature rejected")
+	}
+	return payload
+}
+
+// TestChallengeClearance проверяет cookie допуска: подпись, срок, привязку и смену секретов
+func TestChallengeClearance(t *testing.T) {
+	const browser = "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"
+
+	current := newTestChallenger("new-secret")
+	rotated := newTestChallenger("new-secret", "old-secret")
+	previous := newTestChallenger("old-secret")
+
+	withCookie := func(ip, ua, value string) *http.Request {
+		r := newChallengeRequest(ip, ua)
+		r.AddCookie(&http.Cookie{Name: current.cookieName, Value: value})
+		return r
+	}
+
+	client := newChallengeRequest("192.0.2.1", browser)
+	cookie := current.Clearance(client)
+	if !cookie.HttpOnly || cookie.Path != "/" || cookie.MaxAge != int(current.ttl.Seconds()) {
+		t.Errorf("cookie attributes = %+v", cookie)
+	}
+
+	issued, err := current.Issue(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := current.sign(strings.Join([]string{
+		clearancePayloadPrefix,
+		strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10),
+		current.binding(client),
+	}, "|"))
+
+	tests := []struct {
+		name       string
+		challenger *Challenger
+		ip, ua     string
+		value      string
+		want       bool
+	}{
+		{name: "valid", challenger: current, ip: "192.0.2.1", ua: browser, value: cookie.Value, want: true},
+		{name: "replayed from another ip", challenger: current, ip: "198.51.100.1", ua: browser, value: cookie.Value},
+		{name: "replayed with another user agent", challenger: current, ip: "192.0.2.1", ua: "curl/8.0", value: cookie.Value},
+		{name: "signed by an unknown secret", challenger: current, ip: "192.0.2.1", ua: browser, value: previous.Clearance(client).Value},
+		{name: "tampered signature", challenger: current, ip: "192.0.2.1", ua: browser, value: cookie.Value + "A"},
+		{name: "unsigned", challenger: current, ip: "192.0.2.1", ua: browser, value: "ayIxNzAwMDAwMDAwIg"},
+		{name: "expired", challenger: current, ip: "192.0.2.1", ua: browser, value: expired},
+		{name: "challenge token instead of cookie", challenger: current, ip: "192.0.2.1", ua: browser, value: issued.Token},
+
+		// Смена секрета: прежний секрет остается вторым, пока не истекут выданные им cookie
+		{name: "old secret during rotation", challenger: rotated, ip: "192.0.2.1", ua: browser, value: previous.Clearance(client).Value, want: true},
+		{name: "new secret during rotation", challenger: rotated, ip: "192.0.2.1", ua: browser, value: cookie.Value, want: true},
+		{name: "new cookie on a node with only the old secret", challenger: previous, ip: "192.0.2.1", ua: browser, value: rotated.Clearance(client).Value},
+	}
+
+	for _, tt := range tests {
+		if got := tt.challenger.HasClearance(withCookie(tt.ip, tt.ua, tt.value)); got != tt.want {
+			t.Errorf("%s: clearance = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if current.HasClearance(client) {
+		t.Error("request without a cookie cleared")
+	}
+}
+
+// TestSanitizeReturnPath проверяет, что после проверки клиент возвращается только на локальный путь
+func TestSanitizeReturnPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"/", "/"},
+		{"/page?x=1#top", "/page?x=1#top"},
+		{"/a//b", "/a//b"},
+		{"", "/"},
+		{"page", "/"},
+		{"//evil.com", "/"},
+		{"//evil.com/page", "/"},
+		{"/\\evil.com", "/"},
+		{"/\\/evil.com", "/"},
+		{"https://evil.com", "/"},
+		{"javascript:alert(1)", "/"},
+		{"\\\\evil.com", "/"},
+		{"/\t/evil.com", "/"},
+		{"/\n/evil.com", "/"},
+		{"/\r\n/evil.com", "/"},
+		{" //evil.com", "/"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeReturnPath(tt.path); got != tt.want {
+			t.Errorf("sanitizeReturnPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1097 @@
+package botredirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "bot-redirect",
+		Usage: "<classify|lists|lint|replay|evaluate> [--config <path>] [--adapter <name>] [--id <id>]",
+		Short: "Inspects bot_redirect handlers of a config without live traffic",
+		Long: `
+Loads the bot_redirect handlers of a config (a Caddyfile or JSON, as with
+'caddy run') and answers what they would do, without starting any server:
+
+  classify  runs one request through a handler and prints the decision trace
+  lists     prints the effective User-Agent patterns, IP ranges, referrer
+            domains, reverse DNS patterns and overrides
+  lint      checks the config and the effective lists for mistakes that do
+            not prevent startup but lead to misclassification
+  replay    classifies the requests of access logs and reports the result,
+            optionally compared with another config
+  evaluate  measures precision and recall of the classification and of each
+            detection signal on a hand-labeled dataset
+
+Effective lists include the built-in defaults, list files and runtime changes
+saved in the lists overlay file. Overrides added through the admin API live
+only in the running server and are not shown.
+
+Metrics, the shared cache, Redis storage and firewall export are disabled
+while inspecting; reverse DNS lookups are real when enabled in the config.
+If the config has several bot_redirect handlers, select one with --id.
+`,
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.AddCommand(newClassifyCommand(), newListsCommand(), newLintCommand(), newReplayCommand(), newEvaluateCommand())
+		},
+	})
+}
+
+// newClassifyCommand создает команду bot-redirect classify
+func newClassifyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "classify --ip <address> [--ua <user-agent>] [--referer <url>] [--header <name: value>]",
+		Short: "Prints the decision trace for a single request",
+		Long: `
+Runs a single request through a bot_redirect handler the same way live
+traffic is handled and prints every processing step: overrides, bans,
+honeypots, signature, User-Agent, IP range and reverse DNS checks, crawler
+verification, referrer classification, rate limiting, robots.txt and
+policies, followed by the resulting classification and response.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdClassify),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("ip", "", "Client IP address (required)")
+	cmd.Flags().String("ua", "", "User-Agent header")
+	cmd.Flags().String("referer", "", "Referer header")
+	cmd.Flags().StringArray("header", nil, "Additional request header as \"Name: value\" (repeatable)")
+	cmd.Flags().String("method", http.MethodGet, "Request method")
+	cmd.Flags().String("uri", "/", "Request URI (path and query)")
+	cmd.Flags().String("host", "localhost", "Request Host header")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
+
+// newListsCommand создает команду bot-redirect lists
+func newListsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lists [--list <name>]",
+		Short: "Prints the effective lists of bot_redirect handlers",
+		Long: `
+Prints the lists a handler actually uses after the built-in defaults, list
+files and the lists overlay are applied, together with the overlay itself
+and the overrides from the config.
+
+With --list only the entries of one list are printed, one per line:
+user_agents, ip_ranges, referrer_domains, dns_patterns or overrides.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdLists),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("list", "", "Print only the entries of this list")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
+
+// newLintCommand создает команду bot-redirect lint
+func newLintCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint [--verbose]",
+		Short: "Checks bot_redirect config and lists for mistakes",
+		Long: `
+Validates the config of every bot_redirect handler and runs the list and
+config linters: User-Agent patterns matching regular browsers, invalid or
+duplicate entries, entries shadowed by broader ones, overly broad or private
+IP ranges, wildcard referrer domains and options that have no effect.
+
+Exits with a non-zero status if any error is found. Notes about redundant
+entries are printed only with --verbose.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdLint),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().BoolP("verbose", "v", false, "Also print notes about redundant entries")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
+
+// newReplayCommand создает команду bot-redirect replay
+func newReplayCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay [--compare <config>] [--dns-cache <file>] [--dns-live] [<log file>...]",
+		Short: "Classifies access log requests and reports the result",
+		Long: `
+Reads access logs (Caddy JSON logs or Common/Combined Log Format, detected per
+line; "-" or no file reads stdin) and classifies every request with a
+bot_redirect handler: overrides from the config, signatures, User-Agent,
+IP ranges, reverse DNS and referrer. Bans, honeypots, rate limits and
+robots.txt depend on request timing and are not replayed.
+
+The report has the number of requests per classification and detection
+method, the most frequent User-Agents of unverified bots and the addresses
+that claimed to be search or social crawlers but failed verification.
+With --compare the same requests are classified with another config as
+well and the changes are listed with example clients.
+
+Reverse DNS never touches the network unless --dns-live is given. Answers
+are read from the --dns-cache file, a JSON object of the form
+  {"66.249.66.1": {"hostname": "crawl-66-249-66-1.googlebot.com",
+                   "addresses": ["66.249.66.1"]}}
+Crawlers whose address has no recorded answer are reported as unverifiable.
+With --dns-live missing answers are looked up and saved to the cache file,
+so later runs give the same result offline.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdReplay),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("compare", "", "Another config to classify the same requests with")
+	cmd.Flags().String("dns-cache", "", "File with recorded reverse DNS answers")
+	cmd.Flags().Bool("dns-live", false, "Look up addresses missing from the DNS cache and save them")
+	cmd.Flags().Int("top", 20, "Number of User-Agents and addresses listed in the report (0 - all)")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
+
+// newEvaluateCommand создает команду bot-redirect evaluate
+func newEvaluateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "evaluate [--dns-cache <file>] [--dns-live] <dataset.csv|dataset.jsonl>",
+		Short: "Measures classification quality on a labeled dataset",
+		Long: `
+Classifies every sample of a hand-labeled dataset with a bot_redirect handler
+and compares the result with the label. Prints the confusion matrix,
+precision, recall and F1 per classification and per detection signal
+(user_agent, ip_range, reverse_dns and headers, i.e. HTTP message
+signatures), followed by every false positive, false negative and
+otherwise misclassified sample with the signals that fired.
+
+Labels are bot or human, or the classes verified_bot, unverified_bot,
+from_search and direct. If any sample is labeled bot or human, classes are
+reduced to bot and human before comparing.
+
+CSV datasets need a header with the columns ip and label, and may have id,
+ua (or user_agent), referer and headers ("Name: value" lines or a JSON
+object); other columns are ignored. JSONL datasets have one object per line
+with the same fields, headers being an object of strings or string arrays.
+The format follows the file extension unless --dataset-format is given.
+
+Reverse DNS works as in replay: answers come from --dns-cache, --dns-live
+looks up the missing ones.
+`,
+		RunE: caddycmd.WrapCommandFuncForCobra(cmdEvaluate),
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("dataset-format", "", "Dataset format: csv or jsonl (default: by file extension)")
+	cmd.Flags().String("dns-cache", "", "File with recorded reverse DNS answers")
+	cmd.Flags().Bool("dns-live", false, "Look up addresses missing from the DNS cache and save them")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	return cmd
+}
+
+// addConfigFlags добавляет флаги выбора конфигурации и обработчика
+func addConfigFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("config", "c", "", "Configuration file (default: Caddyfile in the current directory)")
+	cmd.Flags().StringP("adapter", "a", "", "Name of config adapter to apply")
+	cmd.Flags().String("id", "", "Handler id, if the config has several bot_redirect handlers")
+}
+
+// cmdClassify выполняет команду bot-redirect classify
+func cmdClassify(fl caddycmd.Flags) (int, error) {
+	addr, err := netip.ParseAddr(fl.String("ip"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("--ip: a client IP address is required: %v", err)
+	}
+
+	br, err := loadCommandHandler(fl.String("config"), fl.String("adapter"), fl.String("id"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	r, err := newCommandRequest(fl, addr)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	cleanup, err := provisionOffline(br)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cleanup()
+
+	explanation, err := br.Explain(r)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("handling request: %v", err)
+	}
+
+	if fl.String("format") == "json" {
+		return writeCommandJSON(os.Stdout, map[string]interface{}{
+			"id":          br.ID,
+			"request":     requestSummary(r),
+			"explanation": explanation,
+		})
+	}
+
+	printExplanation(os.Stdout, br.ID, r, explanation)
+	return caddy.ExitCodeSuccess, nil
+}
+
+// cmdLists выполняет команду bot-redirect lists
+func cmdLists(fl caddycmd.Flags) (int, error) {
+	only := fl.String("list")
+	switch only {
+	case "", ListUserAgents, ListIPRanges, ListReferrerDomains, ListDNSPatterns, "overrides":
+	default:
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("unknown list: %s", only)
+	}
+
+	handlers, err := loadCommandHandlers(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	output := make([]map[string]interface{}, 0, len(handlers))
+	for _, br := range handlers {
+		cleanup, err := provisionOffline(br)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("handler %s: %v", br.ID, err)
+		}
+
+		lists := br.botDetector.GetLists()
+		lists["overrides"] = br.botDetector.GetOverrideTable().List()
+		cleanup()
+
+		if fl.String("format") == "json" {
+			lists["id"] = br.ID
+			output = append(output, lists)
+			continue
+		}
+
+		if len(handlers) > 1 {
+			fmt.Printf("# handler %s\n", br.ID)
+		}
+		printLists(os.Stdout, lists, only)
+	}
+
+	if fl.String("format") == "json" {
+		return writeCommandJSON(os.Stdout, output)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// cmdLint выполняет команду bot-redirect lint
+func cmdLint(fl caddycmd.Flags) (int, error) {
+	handlers, err := loadCommandHandlers(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	verbose := fl.Bool("verbose")
+	results := make([]map[string]interface{}, 0, len(handlers))
+	counts := make(map[string]int)
+	for _, br := range handlers {
+		var issues []LintIssue
+		if cleanup, err := provisionOffline(br); err != nil {
+			issues = []LintIssue{{Severity: LintError, Source: "config", Message: err.Error()}}
+		} else {
+			issues = br.botDetector.Lint()
+			cleanup()
+		}
+
+		shown := make([]LintIssue, 0, len(issues))
+		for _, issue := range issues {
+			if issue.Severity == LintInfo && !verbose {
+				continue
+			}
+			counts[issue.Severity]++
+			shown = append(shown, issue)
+		}
+		results = append(results, map[string]interface{}{"id": br.ID, "issues": shown})
+	}
+
+	if fl.String("format") == "json" {
+		if _, err := writeCommandJSON(os.Stdout, results); err != nil {
+			return caddy.ExitCodeFailedStartup, err
+		}
+	} else {
+		for _, result := range results {
+			if len(handlers) > 1 {
+				fmt.Printf("# handler %s\n", result["id"])
+			}
+			for _, issue := range result["issues"].([]LintIssue) {
+				fmt.Println(issue)
+			}
+		}
+		fmt.Printf("%d error(s), %d warning(s)", counts[LintError], counts[LintWarning])
+		if verbose {
+			fmt.Printf(", %d note(s)", counts[LintInfo])
+		}
+		fmt.Println()
+	}
+
+	if counts[LintError] > 0 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("lint found %d error(s)", counts[LintError])
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// cmdReplay выполняет команду bot-redirect replay
+func cmdReplay(fl caddycmd.Flags) (int, error) {
+	br, err := loadCommandHandler(fl.String("config"), fl.String("adapter"), fl.String("id"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	var compare *BotRedirect
+	if path := fl.String("compare"); path != "" {
+		if compare, err = loadCommandHandler(path, "", fl.String("id")); err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("--compare: %v", err)
+		}
+	}
+
+	resolver, err := newCommandResolver(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	cleanup, err := provisionOffline(br)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cleanup()
+	replayer := NewReplayer(br.botDetector, resolver)
+	report := NewReplayReport(fl.Int("top"))
+
+	var compareReplayer *Replayer
+	var compareReport *ReplayReport
+	var diff *ReplayDiff
+	if compare != nil {
+		compareCleanup, err := provisionOffline(compare)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, fmt.Errorf("--compare: %v", err)
+		}
+		defer compareCleanup()
+		compareReplayer = NewReplayer(compare.botDetector, resolver)
+		compareReport = NewReplayReport(fl.Int("top"))
+		diff = NewReplayDiff()
+	}
+
+	files := fl.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	skipped := 0
+	for _, file := range files {
+		var in io.Reader = os.Stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return caddy.ExitCodeFailedStartup, err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		reader := NewAccessLogReader(in)
+		for {
+			entry, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return caddy.ExitCodeFailedStartup, fmt.Errorf("reading %s: %v", file, err)
+			}
+
+			verdict := replayer.Classify(entry)
+			report.Add(entry, verdict)
+			if compareReplayer != nil {
+				compareVerdict := compareReplayer.Classify(entry)
+				compareReport.Add(entry, compareVerdict)
+				diff.Add(entry, verdict, compareVerdict)
+			}
+		}
+		skipped += reader.Skipped
+	}
+
+	report.Finish()
+	if diff != nil {
+		compareReport.Finish()
+		diff.Finish()
+	}
+
+	if err := saveCommandResolver(fl, resolver); err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	if fl.String("format") == "json" {
+		output := map[string]interface{}{
+			"id":      br.ID,
+			"skipped": skipped,
+			"report":  report,
+		}
+		if diff != nil {
+			output["compare"] = map[string]interface{}{
+				"config": fl.String("compare"),
+				"report": compareReport,
+				"diff":   diff,
+			}
+		}
+		return writeCommandJSON(os.Stdout, output)
+	}
+
+	printReplayReport(os.Stdout, br.ID, skipped, report, compareReport)
+	if diff != nil {
+		printReplayDiff(os.Stdout, fl.String("compare"), diff)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// cmdEvaluate выполняет команду bot-redirect evaluate
+func cmdEvaluate(fl caddycmd.Flags) (int, error) {
+	if fl.NArg() != 1 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("exactly one dataset file is required")
+	}
+	path := fl.Arg(0)
+
+	format := fl.String("dataset-format")
+	if format == "" {
+		var err error
+		if format, err = DatasetFormat(path); err != nil {
+			return caddy.ExitCodeFailedStartup, err
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, err
+		}
+		defer f.Close()
+		in = f
+	}
+	samples, err := ReadLabeledSamples(in, format)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("reading %s: %v", path, err)
+	}
+
+	br, err := loadCommandHandler(fl.String("config"), fl.String("adapter"), fl.String("id"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	resolver, err := newCommandResolver(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	cleanup, err := provisionOffline(br)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	defer cleanup()
+
+	evaluation := NewEvaluator(br.botDetector, resolver).Evaluate(samples)
+
+	if err := saveCommandResolver(fl, resolver); err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+
+	if fl.String("format") == "json" {
+		return writeCommandJSON(os.Stdout, map[string]interface{}{
+			"id":         br.ID,
+			"evaluation": evaluation,
+		})
+	}
+
+	printEvaluation(os.Stdout, br.ID, evaluation)
+	return caddy.ExitCodeSuccess, nil
+}
+
+// newCommandResolver создает resolver с записанными ответами DNS из флагов
+// --dns-cache и --dns-live
+func newCommandResolver(fl caddycmd.Flags) (*RecordedResolver, error) {
+	var live DNSResolver
+	if fl.Bool("dns-live") {
+		live = &net.Resolver{}
+	}
+
+	if path := fl.String("dns-cache"); path != "" {
+		return LoadRecordedResolver(path, live)
+	}
+	return NewRecordedResolver(nil, live), nil
+}
+
+// saveCommandResolver сохраняет ответы, полученные с --dns-live, в файл --dns-cache
+func saveCommandResolver(fl caddycmd.Flags, resolver *RecordedResolver) error {
+	path := fl.String("dns-cache")
+	if !fl.Bool("dns-live") || path == "" || !resolver.Changed() {
+		return nil
+	}
+	if err := resolver.Save(path); err != nil {
+		return fmt.Errorf("saving DNS cache: %v", err)
+	}
+	return nil
+}
+
+// loadCommandHandler загружает конфигурацию и возвращает единственный обработчик
+// bot_redirect (или выбранный по id)
+func loadCommandHandler(path, adapter, id string) (*BotRedirect, error) {
+	handlers, err := loadHandlers(path, adapter, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(handlers) > 1 {
+		return nil, fmt.Errorf("config has %d bot_redirect handlers (%s), select one with --id",
+			len(handlers), strings.Join(commandHandlerIDs(handlers), ", "))
+	}
+	return handlers[0], nil
+}
+
+// loadCommandHandlers загружает конфигурацию из флагов команды и возвращает обработчики
+// bot_redirect (только выбранный --id, если он задан) в порядке следования в конфигурации
+func loadCommandHandlers(fl caddycmd.Flags) ([]*BotRedirect, error) {
+	return loadHandlers(fl.String("config"), fl.String("adapter"), fl.String("id"))
+}
+
+// loadHandlers загружает конфигурацию и возвращает обработчики bot_redirect
+func loadHandlers(path, adapter, id string) ([]*BotRedirect, error) {
+	config, _, err := caddycmd.LoadConfig(path, adapter)
+	if err != nil {
+		return nil, err
+	}
+	if len(config) == 0 {
+		return nil, fmt.Errorf("no config found, use --config")
+	}
+
+	var tree interface{}
+	if err := json.Unmarshal(config, &tree); err != nil {
+		return nil, fmt.Errorf("decoding config: %v", err)
+	}
+
+	var handlers []*BotRedirect
+	if err := collectHandlers(tree, &handlers); err != nil {
+		return nil, err
+	}
+	if len(handlers) == 0 {
+		return nil, fmt.Errorf("config has no bot_redirect handlers")
+	}
+
+	// Как и при загрузке в Caddy, у обработчиков одной конфигурации разные id
+	seen := make(map[string]bool, len(handlers))
+	for _, br := range handlers {
+		if seen[br.ID] {
+			return nil, fmt.Errorf("several bot_redirect handlers share id %s, give them distinct ids", br.ID)
+		}
+		seen[br.ID] = true
+	}
+
+	if id == "" {
+		return handlers, nil
+	}
+
+	selected := make([]*BotRedirect, 0, 1)
+	for _, br := range handlers {
+		if br.ID == id {
+			selected = append(selected, br)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no bot_redirect handler with id %s (found: %s)", id, strings.Join(commandHandlerIDs(handlers), ", "))
+	}
+	return selected, nil
+}
+
+// collectHandlers ищет в JSON конфигурации обработчики bot_redirect, включая вложенные маршруты.
+// Ключи объектов обходятся по порядку, чтобы порядок обработчиков не менялся между запусками.
+func collectHandlers(node interface{}, handlers *[]*BotRedirect) error {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if value["handler"] == "bot_redirect" {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			br := new(BotRedirect)
+			if err := json.Unmarshal(raw, br); err != nil {
+				return fmt.Errorf("decoding bot_redirect handler: %v", err)
+			}
+			if br.ID == "" {
+				br.ID = defaultHandlerID
+			}
+			*handlers = append(*handlers, br)
+			return nil
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := collectHandlers(value[key], handlers); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		for _, item := range value {
+			if err := collectHandlers(item, handlers); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commandHandlerIDs возвращает идентификаторы загруженных обработчиков
+func commandHandlerIDs(handlers []*BotRedirect) []string {
+	ids := make([]string, 0, len(handlers))
+	for _, br := range handlers {
+		ids = append(ids, br.ID)
+	}
+	return ids
+}
+
+// provisionOffline настраивает обработчик для команд CLI: компоненты, которые пишут во внешние
+// системы (метрики, общий кеш, Redis, выгрузка файрвола), отключаются. Возвращает функцию
+// освобождения ресурсов.
+func provisionOffline(br *BotRedirect) (func(), error) {
+	br.EnableMetrics = false
+	br.EnablePrometheus = false
+	br.SharedCache = false
+	br.RateLimitStorage = LimiterStorageMemory
+	br.FirewallExportDir = ""
+	br.logger = commandLogger()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	if err := br.provision(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return func() {
+		br.Cleanup()
+		cancel()
+	}, nil
+}
+
+// commandLogger логгер команд CLI: предупреждения и ошибки компонентов в stderr
+func commandLogger() *zap.Logger {
+	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	return zap.New(zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), zapcore.WarnLevel))
+}
+
+// newCommandRequest создает запрос из флагов команды classify
+func newCommandRequest(fl caddycmd.Flags, addr netip.Addr) (*http.Request, error) {
+	uri := fl.String("uri")
+	if !strings.HasPrefix(uri, "/") {
+		return nil, fmt.Errorf("--uri must start with /: %s", uri)
+	}
+
+	r, err := http.NewRequest(fl.String("method"), "http://"+fl.String("host")+uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RemoteAddr = net.JoinHostPort(addr.String(), "0")
+	r.RequestURI = uri
+
+	headers, err := fl.GetStringArray("header")
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("--header must be \"Name: value\": %s", header)
+		}
+		r.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if ua := fl.String("ua"); ua != "" {
+		r.Header.Set("User-Agent", ua)
+	}
+	if referer := fl.String("referer"); referer != "" {
+		r.Header.Set("Referer", referer)
+	}
+
+	return r, nil
+}
+
+// requestSummary описывает запрос для вывода
+func requestSummary(r *http.Request) map[string]interface{} {
+	return map[string]interface{}{
+		"method":      r.Method,
+		"uri":         r.RequestURI,
+		"host":        r.Host,
+		"remote_addr": r.RemoteAddr,
+		"headers":     r.Header,
+	}
+}
+
+// printExplanation выводит трассировку решения в текстовом виде
+func printExplanation(out io.Writer, id string, r *http.Request, explanation *Explanation) {
+	fmt.Fprintf(out, "handler:  %s\n", id)
+	fmt.Fprintf(out, "request:  %s %s (host %s) from %s\n", r.Method, r.RequestURI, r.Host, canonicalHost(r.RemoteAddr))
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			fmt.Fprintf(out, "          %s: %s\n", name, value)
+		}
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "decision trace:")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for i, step := range explanation.Steps {
+		fmt.Fprintf(tw, "  %d.\t%s\t%s\t%s\n", i+1, step.Step, step.Result, formatStepDetails(step.Details))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	if explanation.Classification != "" {
+		fmt.Fprintf(out, "classification:  %s\n", explanation.Classification)
+	} else {
+		fmt.Fprintln(out, "classification:  none (decided before detection)")
+	}
+	fmt.Fprintf(out, "action:          %s\n", explanation.Action)
+
+	response := fmt.Sprintf("%d %s", explanation.Status, http.StatusText(explanation.Status))
+	switch {
+	case explanation.Upstream:
+		response += ", original content"
+	case explanation.Location != "":
+		response += " -> " + explanation.Location
+	}
+	fmt.Fprintf(out, "response:        %s\n", response)
+}
+
+// formatStepDetails форматирует подробности шага как key=value с сортировкой по ключу
+func formatStepDetails(details map[string]interface{}) string {
+	keys := make([]string, 0, len(details))
+	for key, value := range details {
+		if value == nil || value == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := fmt.Sprint(details[key])
+		if strings.ContainsAny(value, " \t") {
+			value = fmt.Sprintf("%q", value)
+		}
+		parts = append(parts, key+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// printLists выводит списки в текстовом виде; only - вывести только записи одного списка
+func printLists(out io.Writer, lists map[string]interface{}, only string) {
+	section := func(name string, entries []string) {
+		if only != "" {
+			for _, entry := range entries {
+				fmt.Fprintln(out, entry)
+			}
+			return
+		}
+		fmt.Fprintf(out, "## %s (%d)\n", name, len(entries))
+		for _, entry := range entries {
+			fmt.Fprintf(out, "  %s\n", entry)
+		}
+		fmt.Fprintln(out)
+	}
+
+	for _, name := range []string{ListUserAgents, ListIPRanges, ListReferrerDomains} {
+		if only == "" || only == name {
+			entries, _ := lists[name].([]string)
+			section(name, entries)
+		}
+	}
+
+	if only == "" || only == ListDNSPatterns {
+		patterns, _ := lists[ListDNSPatterns].(map[string][]string)
+		botTypes := make([]string, 0, len(patterns))
+		for botType := range patterns {
+			botTypes = append(botTypes, botType)
+		}
+		sort.Strings(botTypes)
+		for _, botType := range botTypes {
+			section(ListDNSPatterns+"/"+botType, patterns[botType])
+		}
+	}
+
+	if only == "" || only == "overrides" {
+		overrides, _ := lists["overrides"].([]Override)
+		entries := make([]string, 0, len(overrides))
+		for _, override := range overrides {
+			entry := fmt.Sprintf("%s %s -> %s", override.Match, override.Value, override.Effect())
+			if !override.ExpiresAt.IsZero() {
+				entry += " until " + override.ExpiresAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			if override.Reason != "" {
+				entry += fmt.Sprintf(" (%s)", override.Reason)
+			}
+			entries = append(entries, entry)
+		}
+		section("overrides", entries)
+	}
+
+	if only == "" {
+		overlay, _ := lists["overlay"].(ListsOverlay)
+		keys := make([]string, 0, len(overlay))
+		for key := range overlay {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		entries := make([]string, 0)
+		for _, key := range keys {
+			for _, value := range overlay[key].Add {
+				entries = append(entries, fmt.Sprintf("+ %s %s", key, value))
+			}
+			for _, value := range overlay[key].Remove {
+				entries = append(entries, fmt.Sprintf("- %s %s", key, value))
+			}
+		}
+		section("overlay", entries)
+	}
+}
+
+// printReplayReport выводит отчет replay; compare - отчет второй конфигурации
+// для сравнения количества запросов по классам
+func printReplayReport(out io.Writer, id string, skipped int, report, compare *ReplayReport) {
+	fmt.Fprintf(out, "handler:  %s\n", id)
+	fmt.Fprintf(out, "entries:  %d", report.Entries)
+	if skipped > 0 {
+		fmt.Fprintf(out, " (%d unparsed lines skipped)", skipped)
+	}
+	fmt.Fprintln(out)
+	if report.From != nil {
+		fmt.Fprintf(out, "period:   %s - %s\n", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "classifications:")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	classes := sortedCountKeys(report.Classifications)
+	if compare != nil {
+		classes = sortedCountKeys(report.Classifications, compare.Classifications)
+		fmt.Fprintln(tw, "  \tBASE\t\tCOMPARE")
+	}
+	for _, class := range classes {
+		fmt.Fprintf(tw, "  %s\t%d\t%s", class, report.Classifications[class], replayShare(report.Classifications[class], report.Entries))
+		if compare != nil {
+			fmt.Fprintf(tw, "\t%d\t%s", compare.Classifications[class], replayShare(compare.Classifications[class], compare.Entries))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "detection methods:")
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, method := range sortedCountKeys(report.DetectionMethods) {
+		fmt.Fprintf(tw, "  %s\t%d\n", method, report.DetectionMethods[method])
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "top unverified bot user agents:")
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  REQUESTS\tCLIENTS\tUSER-AGENT")
+	for _, count := range report.UnverifiedUserAgents {
+		fmt.Fprintf(tw, "  %d\t%d\t%s\n", count.Requests, count.Clients, count.Value)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "spoofed crawler addresses:")
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  IP\tREQUESTS\tPATTERN\tPTR\tUSER-AGENT")
+	for _, crawler := range report.SpoofedCrawlers {
+		hostname := crawler.Hostname
+		if hostname == "" {
+			hostname = "-"
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t%s\n", crawler.IP, crawler.Requests, crawler.Pattern, hostname, crawler.UserAgent)
+	}
+	tw.Flush()
+
+	if report.UnverifiableCrawlers > 0 {
+		fmt.Fprintf(out, "\n%d crawler request(s) from %d address(es) could not be verified: no recorded DNS answer (use --dns-cache or --dns-live)\n",
+			report.UnverifiableCrawlers, report.UnverifiableAddresses)
+	}
+}
+
+// printReplayDiff выводит изменения классификации второй конфигурацией
+func printReplayDiff(out io.Writer, path string, diff *ReplayDiff) {
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "compared with %s: %d of %d requests classified differently\n", path, diff.Changed, diff.Entries)
+	for _, transition := range diff.Transitions {
+		fmt.Fprintf(out, "  %s -> %s: %d\n", transition.From, transition.To, transition.Requests)
+		for _, example := range transition.Examples {
+			fmt.Fprintf(out, "      %s\n", example)
+		}
+	}
+}
+
+// printEvaluation выводит результат оценки на размеченном наборе
+func printEvaluation(out io.Writer, id string, evaluation *Evaluation) {
+	fmt.Fprintf(out, "handler:   %s\n", id)
+	fmt.Fprintf(out, "samples:   %d (%s labels)\n", evaluation.Samples, evaluation.Granularity)
+	fmt.Fprintf(out, "accuracy:  %s\n", evaluationPercent(evaluation.Accuracy, evaluation.Samples))
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "confusion matrix (rows: label, columns: predicted):")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  \t%s\n", strings.Join(evaluation.Classes, "\t"))
+	for _, label := range evaluation.Classes {
+		row, ok := evaluation.Confusion[label]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(tw, "  %s", label)
+		for _, predicted := range evaluation.Classes {
+			fmt.Fprintf(tw, "\t%d", row[predicted])
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "per classification:")
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  CLASS\tSUPPORT\tPREDICTED\tPRECISION\tRECALL\tF1")
+	for _, m := range evaluation.ClassMetrics {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\t%s\t%s\n", m.Class, m.Support, m.Predicted,
+			evaluationPercent(m.Precision, m.Predicted), evaluationPercent(m.Recall, m.Support), evaluationPercent(m.F1, m.Support+m.Predicted))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "per signal (fired vs labeled bot):")
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  SIGNAL\tTP\tFP\tFN\tTN\tPRECISION\tRECALL\tF1")
+	for _, m := range evaluation.SignalMetrics {
+		if !m.Enabled {
+			fmt.Fprintf(tw, "  %s\tdisabled\n", m.Signal)
+			continue
+		}
+		fired := m.TruePositives + m.FalsePositives
+		bots := m.TruePositives + m.FalseNegatives
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%d\t%d\t%s\t%s\t%s", m.Signal,
+			m.TruePositives, m.FalsePositives, m.FalseNegatives, m.TrueNegatives,
+			evaluationPercent(m.Precision, fired), evaluationPercent(m.Recall, bots), evaluationPercent(m.F1, fired+bots))
+		if m.Skipped > 0 {
+			fmt.Fprintf(tw, "\t(%d without recorded DNS answer)", m.Skipped)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	sections := []struct {
+		kind  string
+		title string
+	}{
+		{EvaluationFalsePositive, "false positives (labeled human, classified as bot)"},
+		{EvaluationFalseNegative, "false negatives (labeled bot, classified as human)"},
+		{EvaluationMisclassified, "other misclassifications"},
+	}
+	for _, section := range sections {
+		var matching []*EvaluationError
+		for _, evaluationError := range evaluation.Errors {
+			if evaluationError.Kind == section.kind {
+				matching = append(matching, evaluationError)
+			}
+		}
+
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "%s: %d\n", section.title, len(matching))
+		for _, e := range matching {
+			sample := e.Sample
+			name := fmt.Sprintf("line %d", sample.Line)
+			if sample.ID != "" {
+				name += " (" + sample.ID + ")"
+			}
+			fmt.Fprintf(out, "  %s: %s %q\n", name, sample.IP, sample.UserAgent)
+
+			details := fmt.Sprintf("label=%s predicted=%s", sample.Label, e.Predicted)
+			if e.DetectionMethod != "" {
+				details += " method=" + e.DetectionMethod
+			}
+			if e.MatchedPattern != "" {
+				details += fmt.Sprintf(" pattern=%q", e.MatchedPattern)
+			}
+			if len(e.Signals) > 0 {
+				details += " signals=" + strings.Join(e.Signals, ",")
+			}
+			if e.Unverifiable {
+				details += " (no recorded DNS answer)"
+			}
+			fmt.Fprintf(out, "      %s\n", details)
+		}
+	}
+}
+
+// evaluationPercent форматирует долю в процентах; при пустом знаменателе - "-"
+func evaluationPercent(value float64, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", value*100)
+}
+
+// sortedCountKeys возвращает ключи счетчиков по убыванию значения в первом из них
+func sortedCountKeys(counts ...map[string]int) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, count := range counts {
+		for key := range count {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := counts[0][keys[i]], counts[0][keys[j]]
+		if a != b {
+			return a > b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// replayShare доля запросов в процентах
+func replayShare(count, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(count)*100/float64(total))
+}
+
+// writeCommandJSON выводит результат команды в JSON
+func writeCommandJSON(out io.Writer, value interface{}) (int, error) {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
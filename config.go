@@ -2,6 +2,9 @@ package botredirect
 
 import (
 	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
 )
 
 // Config содержит всю конфигурацию плагина
@@ -54,6 +57,12 @@ type Config struct {
 	// Максимальный размер кеша
 	MaxCacheSize int `json:"max_cache_size"`
 
+	// Размеры кешей компонентов
+	UserAgentCacheSize  int `json:"user_agent_cache_size"`
+	IPRangeCacheSize    int `json:"ip_range_cache_size"`
+	ReferrerCacheSize   int `json:"referrer_cache_size"`
+	ReverseDNSCacheSize int `json:"reverse_dns_cache_size"`
+
 	// Интервал очистки кеша
 	CleanupInterval time.Duration `json:"cleanup_interval"`
 
@@ -78,11 +87,253 @@ type Config struct {
 	// Детальные метрики (для дебага)
 	VerboseMetrics bool `json:"verbose_metrics"`
 
-	// Путь для экспорта метрик (опционально)
+	// Путь, по которому обработчик отдает метрики в JSON (пустой - выключено)
 	MetricsPath string `json:"metrics_path"`
 
 	// Включить Prometheus метрики
 	EnablePrometheus bool `json:"enable_prometheus"`
+
+	// Путь к robots.txt сайта для контроля краулеров
+	RobotsFile string `json:"robots_file"`
+
+	// Действие при нарушении robots.txt (log, block, rate_limit)
+	RobotsAction string `json:"robots_action"`
+
+	// Алгоритм rate limiting по умолчанию (token_bucket, sliding_window, gcra)
+	RateLimitAlgorithm string `json:"rate_limit_algorithm"`
+
+	// Компоненты ключа rate limiting (ip, asn, ua, header:<имя>)
+	RateLimitKey []string `json:"rate_limit_key"`
+
+	// Длина префикса IPv4 сети в ключе
+	RateLimitIPv4Prefix int `json:"rate_limit_ipv4_prefix"`
+
+	// Длина префикса IPv6 сети в ключе
+	RateLimitIPv6Prefix int `json:"rate_limit_ipv6_prefix"`
+
+	// Файл базы ASN ("CIDR ASN" или pfx2as)
+	ASNDatabase string `json:"asn_database"`
+
+	// Уровни rate limiting для отдельных краулеров и сетей
+	RateLimitTiers []RateLimitTier `json:"rate_limit_tiers"`
+
+	// HTML шаблон ответа 429 (пусто - текстовый ответ)
+	RateLimitTemplate string `json:"rate_limit_template"`
+
+	// Хранилище лимитов (memory, redis)
+	RateLimitStorage string `json:"rate_limit_storage"`
+
+	// URL Redis/Valkey (redis://[user:password@]host[:port][/db], rediss:// для TLS)
+	RateLimitRedisURL string `json:"rate_limit_redis_url"`
+
+	// Префикс ключей в Redis
+	RateLimitRedisPrefix string `json:"rate_limit_redis_prefix"`
+
+	// Таймаут операций с Redis
+	RateLimitRedisTimeout time.Duration `json:"rate_limit_redis_timeout"`
+
+	// Поведение при недоступности хранилища (open - пропускать, closed - отклонять)
+	RateLimitFailMode string `json:"rate_limit_fail_mode"`
+
+	// Максимальное количество состояний лимитов в памяти (0 - без ограничения)
+	RateLimitMaxBuckets int `json:"rate_limit_max_buckets"`
+
+	// Хранилище лимитов запросов (создается при Provision по rate_limit_storage, nil - в памяти)
+	RateLimitStore LimiterStore `json:"-"`
+
+	// Количество нарушений до бана (0 - баны выключены)
+	BanThreshold int `json:"ban_threshold"`
+
+	// Длительность первого бана
+	BanDuration time.Duration `json:"ban_duration"`
+
+	// Множитель длительности каждого следующего бана
+	BanFactor float64 `json:"ban_factor"`
+
+	// Максимальная длительность бана
+	BanMaxDuration time.Duration `json:"ban_max_duration"`
+
+	// Период без нарушений, за который снижается уровень бана
+	BanDecay time.Duration `json:"ban_decay"`
+
+	// Длина префикса IPv4 сети, которой выдается бан
+	BanIPv4Prefix int `json:"ban_ipv4_prefix"`
+
+	// Длина префикса IPv6 сети, которой выдается бан
+	BanIPv6Prefix int `json:"ban_ipv6_prefix"`
+
+	// Каталог для выгрузки правил файрвола (пусто - выгрузка выключена)
+	FirewallExportDir string `json:"firewall_export_dir"`
+
+	// Форматы выгрузки: nftables, ipset, nginx
+	FirewallFormats []string `json:"firewall_formats"`
+
+	// Интервал выгрузки
+	FirewallExportInterval time.Duration `json:"firewall_export_interval"`
+
+	// Базовое имя set'ов и файлов выгрузки
+	FirewallSetName string `json:"firewall_set_name"`
+
+	// Семейство и таблица nftables, в которых объявлены set'ы
+	FirewallNftTable string `json:"firewall_nft_table"`
+
+	// Сети вредоносных ботов, выгружаемые всегда
+	FirewallCIDRs []string `json:"firewall_cidrs"`
+
+	// Общий для узлов кластера кеш вердиктов и банов в хранилище Caddy
+	SharedCache bool `json:"shared_cache"`
+
+	// Префикс ключей общего кеша в хранилище
+	SharedCachePrefix string `json:"shared_cache_prefix"`
+
+	// Время жизни локальной копии записи общего кеша
+	SharedCacheLocalTTL time.Duration `json:"shared_cache_local_ttl"`
+
+	// Интервал пакетной записи в хранилище
+	SharedCacheFlushInterval time.Duration `json:"shared_cache_flush_interval"`
+
+	// Интервал загрузки банов других узлов
+	SharedCacheSyncInterval time.Duration `json:"shared_cache_sync_interval"`
+
+	// Таймаут операций с хранилищем
+	SharedCacheTimeout time.Duration `json:"shared_cache_timeout"`
+
+	// Хранилище Caddy (задается при Provision)
+	Storage certmagic.Storage `json:"-"`
+
+	// Файл изменений списков, сделанных через admin API (пусто - изменения не сохраняются)
+	ListsOverlayFile string `json:"lists_overlay_file"`
+
+	// Файлы списков (одна запись в строке), изменения применяются без перезагрузки
+	BotUserAgentsFile    string `json:"bot_user_agents_file"`
+	BotIPRangesFile      string `json:"bot_ip_ranges_file"`
+	AllowedReferrersFile string `json:"allowed_referrers_file"`
+
+	// Интервал проверки файлов списков
+	ListFilesInterval time.Duration `json:"list_files_interval"`
+
+	// Ручные переопределения классификации и действий
+	Overrides []OverrideRule `json:"overrides"`
+
+	// Пути-ловушки; запросивший их клиент отмечается как вредоносный бот
+	HoneypotPaths []string `json:"honeypot_paths"`
+
+	// Время жизни отметки
+	HoneypotTTL time.Duration `json:"honeypot_ttl"`
+
+	// Длина префикса сети для отметки IPv4 клиентов
+	HoneypotIPv4Prefix int `json:"honeypot_ipv4_prefix"`
+
+	// Длина префикса сети для отметки IPv6 клиентов
+	HoneypotIPv6Prefix int `json:"honeypot_ipv6_prefix"`
+
+	// Действие для отмеченных клиентов (log, block, rate_limit)
+	HoneypotAction string `json:"honeypot_action"`
+
+	// Действие для ботов, определенных только по User-Agent без подтверждения по IP/DNS
+	UnverifiedBotAction string `json:"unverified_bot_action"`
+
+	// Секреты для подписи проверок и cookie допуска; первым подписывается, остальные принимаются (ротация)
+	ChallengeSecrets []string `json:"challenge_secrets"`
+
+	// Сложность proof-of-work (количество ведущих нулевых бит SHA-256)
+	ChallengeDifficulty int `json:"challenge_difficulty"`
+
+	// Время жизни cookie допуска
+	ChallengeTTL time.Duration `json:"challenge_ttl"`
+
+	// Имя cookie допуска
+	ChallengeCookieName string `json:"challenge_cookie_name"`
+
+	// Путь, на который отправляется решение проверки
+	ChallengePath string `json:"challenge_path"`
+
+	// Доверенные каталоги ключей подписанных агентов (Web Bot Auth)
+	SignatureDirectories []SignatureDirectory `json:"signature_directories"`
+
+	// Максимальный срок действия подписи, если агент не указал меньший expires
+	SignatureMaxAge time.Duration `json:"signature_max_age"`
+
+	// Интервал обновления каталогов ключей
+	SignatureRefresh time.Duration `json:"signature_refresh"`
+
+	// Отклонять подписи без nonce
+	SignatureRequireNonce bool `json:"signature_require_nonce"`
+}
+
+// SignatureDirectory описывает доверенный каталог ключей агента
+type SignatureDirectory struct {
+	// Origin агента, как он указывается в заголовке Signature-Agent
+	Agent string `json:"agent"`
+
+	// Файл или URL с JWKS; по умолчанию <agent>/.well-known/http-message-signatures-directory
+	Source string `json:"source,omitempty"`
+}
+
+// OverrideRule ручное переопределение классификации или действия для IP, сети или User-Agent
+type OverrideRule struct {
+	// Что сравнивается: ip (адрес или CIDR) или user_agent (подстрока без учета регистра)
+	Match string `json:"match"`
+
+	// Адрес, CIDR или подстрока User-Agent
+	Value string `json:"value"`
+
+	// Классификация: bot, from_search, direct
+	Classification string `json:"classification,omitempty"`
+
+	// Действие: pass, block, challenge
+	Action string `json:"action,omitempty"`
+
+	// Причина и автор
+	Reason string `json:"reason,omitempty"`
+	Author string `json:"author,omitempty"`
+
+	// Время окончания действия (пусто - бессрочно)
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RateLimitTier описывает уровень лимитов для группы клиентов.
+// Уровень применяется, если совпали все заданные условия (bot_types, bot_names, cidrs, verified, bad_bot).
+type RateLimitTier struct {
+	// Имя уровня (используется в ключах bucket'ов и статистике)
+	Name string `json:"name"`
+
+	// Категории ботов (search, social, seo, ...)
+	BotTypes []string `json:"bot_types,omitempty"`
+
+	// Имена краулеров (подстроки, без учета регистра)
+	BotNames []string `json:"bot_names,omitempty"`
+
+	// Разрешенные сети
+	CIDRs []string `json:"cidrs,omitempty"`
+
+	// Только краулеры, подтвержденные по IP диапазону или обратному DNS
+	Verified bool `json:"verified,omitempty"`
+
+	// Пропускать без ограничений
+	Bypass bool `json:"bypass,omitempty"`
+
+	// Максимальное количество запросов за окно
+	MaxRequests int `json:"max_requests,omitempty"`
+
+	// Окно для подсчета лимита
+	Window caddy.Duration `json:"window,omitempty"`
+
+	// Алгоритм лимита (по умолчанию rate_limit_algorithm)
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Компоненты ключа (по умолчанию rate_limit_key)
+	Key []string `json:"key,omitempty"`
+
+	// Длины префиксов сети в ключе (по умолчанию из общих настроек)
+	IPv4Prefix int `json:"ipv4_prefix,omitempty"`
+	IPv6Prefix int `json:"ipv6_prefix,omitempty"`
+
+	// Использовать Crawl-delay из robots.txt как лимит
+	UseCrawlDelay bool `json:"crawl_delay,omitempty"`
+
+	// Только клиенты, отмеченные как вредоносные (honeypot)
+	BadBot bool `json:"bad_bot,omitempty"`
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию
@@ -105,6 +356,10 @@ func DefaultConfig() *Config {
 		RateLimitWindow:     1 * time.Minute,
 		MaxCacheSize:        10000,
 		CleanupInterval:     10 * time.Minute,
+		UserAgentCacheSize:  1000,
+		IPRangeCacheSize:    5000,
+		ReferrerCacheSize:   3000,
+		ReverseDNSCacheSize: 2000,
 		DNSWorkerPoolSize:   5,
 		DNSQueueSize:        1000,
 		LogLevel:            "info",
@@ -112,8 +367,56 @@ func DefaultConfig() *Config {
 		LogDNSQueries:       false,
 		LogCacheOps:         false,
 		VerboseMetrics:      false,
-		MetricsPath:         "/metrics",
+		MetricsPath:         "",
 		EnablePrometheus:    false,
+		RobotsFile:          "",
+		RobotsAction:        "log",
+		RateLimitAlgorithm:  "token_bucket",
+		RateLimitIPv4Prefix: 32,
+		RateLimitIPv6Prefix: 64,
+		HoneypotTTL:         24 * time.Hour,
+		HoneypotIPv4Prefix:  32,
+		HoneypotIPv6Prefix:  64,
+		HoneypotAction:      "block",
+		UnverifiedBotAction: "log",
+		ChallengeDifficulty: 16,
+		ChallengeTTL:        1 * time.Hour,
+		ChallengeCookieName: "bot_redirect_clearance",
+		ChallengePath:       "/.well-known/bot-redirect/challenge",
+		SignatureMaxAge:     5 * time.Minute,
+		SignatureRefresh:    1 * time.Hour,
+
+		// Хранилище лимитов
+		RateLimitStorage:      "memory",
+		RateLimitRedisPrefix:  "bot_redirect:rl:",
+		RateLimitRedisTimeout: 100 * time.Millisecond,
+		RateLimitFailMode:     "open",
+		RateLimitMaxBuckets:   100000,
+
+		// Баны
+		BanThreshold:   0,
+		BanDuration:    5 * time.Minute,
+		BanFactor:      2,
+		BanMaxDuration: 24 * time.Hour,
+		BanDecay:       1 * time.Hour,
+		BanIPv4Prefix:  32,
+		BanIPv6Prefix:  64,
+
+		// Выгрузка правил файрвола
+		FirewallFormats:        []string{"nftables", "ipset", "nginx"},
+		FirewallExportInterval: 1 * time.Minute,
+		FirewallSetName:        "bot_redirect",
+		FirewallNftTable:       "inet filter",
+
+		// Общий кеш кластера
+		SharedCachePrefix:        "bot_redirect",
+		SharedCacheLocalTTL:      1 * time.Minute,
+		SharedCacheFlushInterval: 1 * time.Second,
+		SharedCacheSyncInterval:  10 * time.Second,
+		SharedCacheTimeout:       200 * time.Millisecond,
+
+		// Файлы списков
+		ListFilesInterval: 30 * time.Second,
 	}
 }
 
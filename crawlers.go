@@ -0,0 +1,137 @@
+package botredirect
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// knownCrawler краулер, заявленный в User-Agent, которого можно подтвердить
+// по его собственным сетям и домену обратного DNS
+type knownCrawler struct {
+	// Имя краулера
+	name string
+
+	// Подстроки User-Agent (в нижнем регистре), по которым опознается краулер
+	tokens []string
+
+	// Опубликованные владельцем сети краулера
+	networks []netip.Prefix
+
+	// Суффиксы имени хоста, подтвержденного прямым и обратным DNS
+	hostSuffixes []string
+}
+
+// knownCrawlers краулеры, подтверждаемые по собственным сетям и доменам.
+// Заявление краулера из списка не подтверждается чужими диапазонами или доменами.
+var knownCrawlers = []*knownCrawler{
+	{
+		name:         "Googlebot",
+		tokens:       []string{"googlebot", "google-inspectiontool", "googleother", "adsbot-google", "mediapartners-google", "storebot-google"},
+		networks:     mustParsePrefixes("66.249.64.0/19", "2001:4860:4801::/48"),
+		hostSuffixes: []string{"googlebot.com", "google.com"},
+	},
+	{
+		name:         "Bingbot",
+		tokens:       []string{"bingbot", "msnbot", "bingpreview", "adidxbot"},
+		networks:     mustParsePrefixes("40.77.167.0/24", "157.55.39.0/24", "207.46.13.0/24"),
+		hostSuffixes: []string{"search.msn.com"},
+	},
+	{
+		name:   "YandexBot",
+		tokens: []string{"yandex"},
+		networks: mustParsePrefixes("5.45.192.0/18", "5.255.192.0/18", "37.9.64.0/18", "77.88.0.0/18",
+			"87.250.224.0/19", "95.108.128.0/17", "141.8.128.0/18", "178.154.128.0/18", "213.180.192.0/19"),
+		hostSuffixes: []string{"yandex.ru", "yandex.net", "yandex.com"},
+	},
+	{
+		name:         "Baiduspider",
+		tokens:       []string{"baiduspider"},
+		hostSuffixes: []string{"crawl.baidu.com", "crawl.baidu.jp"},
+	},
+	{
+		name:         "Applebot",
+		tokens:       []string{"applebot"},
+		networks:     mustParsePrefixes("17.0.0.0/8"),
+		hostSuffixes: []string{"applebot.apple.com"},
+	},
+	{
+		name:         "Yahoo! Slurp",
+		tokens:       []string{"slurp"},
+		hostSuffixes: []string{"crawl.yahoo.net"},
+	},
+	{
+		name:         "Sogou",
+		tokens:       []string{"sogou"},
+		hostSuffixes: []string{"spider.sogou.com"},
+	},
+	{
+		name:   "facebookexternalhit",
+		tokens: []string{"facebookexternalhit", "facebookcatalog", "meta-externalagent"},
+		networks: mustParsePrefixes("31.13.24.0/21", "31.13.64.0/18", "66.220.144.0/20",
+			"69.171.224.0/19", "157.240.0.0/16", "173.252.64.0/18"),
+		hostSuffixes: []string{"facebook.com", "fbsb.com", "tfbnw.net"},
+	},
+	{
+		name:         "AhrefsBot",
+		tokens:       []string{"ahrefsbot", "ahrefssiteaudit"},
+		hostSuffixes: []string{"ahrefs.com", "ahrefs.net"},
+	},
+	{
+		name:         "SemrushBot",
+		tokens:       []string{"semrushbot"},
+		hostSuffixes: []string{"semrush.com"},
+	},
+	{
+		name:         "MJ12bot",
+		tokens:       []string{"mj12bot"},
+		hostSuffixes: []string{"majestic12.co.uk"},
+	},
+}
+
+// lookupCrawler возвращает краулера, заявленного в User-Agent, или nil,
+// если User-Agent не называет ни одного подтверждаемого краулера
+func lookupCrawler(userAgent string) *knownCrawler {
+	userAgentLower := strings.ToLower(userAgent)
+	for _, crawler := range knownCrawlers {
+		for _, token := range crawler.tokens {
+			if strings.Contains(userAgentLower, token) {
+				return crawler
+			}
+		}
+	}
+	return nil
+}
+
+// ownsAddress проверяет, входит ли адрес в сети краулера
+func (c *knownCrawler) ownsAddress(ip string) bool {
+	addr, err := netip.ParseAddr(canonicalHost(ip))
+	if err != nil {
+		return false
+	}
+	for _, network := range c.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsHostname проверяет, принадлежит ли имя хоста доменам краулера
+func (c *knownCrawler) ownsHostname(hostname string) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	for _, suffix := range c.hostSuffixes {
+		if strings.HasSuffix(hostname, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mustParsePrefixes разбирает список сетей, заданных в коде
+func mustParsePrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}
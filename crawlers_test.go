@@ -0,0 +1,76 @@
+package botredirect
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestVerifyClaimedCrawler проверяет, что заявление краулера подтверждается только
+// его собственными сетями и доменами
+func TestVerifyClaimedCrawler(t *testing.T) {
+	const (
+		googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+		bingbot   = "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"
+		baidu     = "Mozilla/5.0 (compatible; Baiduspider/2.0; +http://www.baidu.com/search/spider.html)"
+	)
+
+	config := DefaultConfig()
+	config.EnableReverseDNS = true
+	config.EnableRateLimit = false
+	bd, err := NewBotDetector(config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bd.Shutdown()
+
+	bd.GetReverseDNSChecker().SetResolver(NewRecordedResolver(map[string]*DNSRecord{
+		"198.51.100.1": {Hostname: "crawl-198-51-100-1.googlebot.com", Addresses: []string{"198.51.100.1"}},
+		"198.51.100.2": {Hostname: "msnbot-198-51-100-2.search.msn.com", Addresses: []string{"198.51.100.2"}},
+		"198.51.100.3": {Hostname: "baiduspider-198-51-100-3.crawl.baidu.com", Addresses: []string{"198.51.100.3"}},
+		"198.51.100.4": {Hostname: "crawl-198-51-100-4.googlebot.com", Addresses: []string{"192.0.2.1"}},
+		"66.249.66.1":  {},
+		"40.77.167.1":  {},
+		"203.0.113.9":  {},
+	}, nil))
+
+	tests := []struct {
+		ip, userAgent string
+		wantVerified  bool
+		wantBy        string
+	}{
+		{ip: "66.249.66.1", userAgent: googlebot, wantVerified: true, wantBy: "ip_range"},
+		{ip: "40.77.167.1", userAgent: bingbot, wantVerified: true, wantBy: "ip_range"},
+		{ip: "198.51.100.1", userAgent: googlebot, wantVerified: true, wantBy: "reverse_dns"},
+		{ip: "198.51.100.3", userAgent: baidu, wantVerified: true, wantBy: "reverse_dns"},
+
+		// Диапазон и домен другого краулера
+		{ip: "40.77.167.1", userAgent: googlebot},
+		{ip: "198.51.100.2", userAgent: googlebot},
+		{ip: "66.249.66.1", userAgent: bingbot},
+
+		// Прямая проверка не подтвердила PTR
+		{ip: "198.51.100.4", userAgent: googlebot},
+
+		// Общий User-Agent не называет краулера
+		{ip: "198.51.100.1", userAgent: "Mozilla/5.0 (compatible; ExampleCrawler/1.0)"},
+		{ip: "203.0.113.9", userAgent: "Mozilla/5.0 (compatible; ExampleBot/1.0)"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.ip + ":4321"
+		r.Header.Set("User-Agent", tt.userAgent)
+
+		result := bd.DetectBot(r)
+		if !result.IsBot {
+			t.Errorf("%s from %s: not detected as a bot", tt.userAgent, tt.ip)
+			continue
+		}
+		if result.Verified != tt.wantVerified || (tt.wantBy != "" && result.Details["verified_by"] != tt.wantBy) {
+			t.Errorf("%s from %s: verified = %v by %v, want %v by %q",
+				tt.userAgent, tt.ip, result.Verified, result.Details["verified_by"], tt.wantVerified, tt.wantBy)
+		}
+	}
+}
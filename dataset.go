@@ -0,0 +1,264 @@
+package botredirect
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+// Форматы размеченных наборов
+const (
+	DatasetFormatCSV   = "csv"
+	DatasetFormatJSONL = "jsonl"
+)
+
+// LabeledSample размеченный вручную запрос: клиент, заголовки и ожидаемая метка
+type LabeledSample struct {
+	Line      int         `json:"line"`
+	ID        string      `json:"id,omitempty"`
+	IP        string      `json:"ip"`
+	UserAgent string      `json:"user_agent"`
+	Headers   http.Header `json:"headers,omitempty"`
+	Label     string      `json:"label"`
+}
+
+// Entry представляет образец как запись журнала доступа для классификации
+func (sample *LabeledSample) Entry() *AccessLogEntry {
+	headers := sample.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	if sample.UserAgent != "" {
+		headers.Set("User-Agent", sample.UserAgent)
+	}
+
+	return &AccessLogEntry{
+		Line:     sample.Line,
+		ClientIP: sample.IP,
+		Method:   http.MethodGet,
+		URI:      "/",
+		Headers:  headers,
+	}
+}
+
+// DatasetFormat определяет формат набора по расширению файла
+func DatasetFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return DatasetFormatCSV, nil
+	case ".jsonl", ".ndjson", ".json":
+		return DatasetFormatJSONL, nil
+	default:
+		return "", fmt.Errorf("cannot infer dataset format of %s, use csv or jsonl", path)
+	}
+}
+
+// ReadLabeledSamples читает размеченный набор в формате CSV или JSONL.
+// Метки проверяются: bot, human или классы verified_bot, unverified_bot, from_search, direct.
+func ReadLabeledSamples(r io.Reader, format string) ([]*LabeledSample, error) {
+	var samples []*LabeledSample
+	var err error
+
+	switch format {
+	case DatasetFormatCSV:
+		samples, err = readCSVSamples(r)
+	case DatasetFormatJSONL:
+		samples, err = readJSONLSamples(r)
+	default:
+		return nil, fmt.Errorf("unknown dataset format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sample := range samples {
+		if err := normalizeSample(sample); err != nil {
+			return nil, fmt.Errorf("line %d: %w", sample.Line, err)
+		}
+	}
+	return samples, nil
+}
+
+// readCSVSamples читает CSV с заголовком. Обязательные колонки: ip, label; необязательные:
+// id, ua (user_agent), referer, headers. Неизвестные колонки (комментарии) пропускаются.
+func readCSVSamples(r io.Reader) ([]*LabeledSample, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "user_agent" {
+			name = "ua"
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"ip", "label"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header has no %s column", required)
+		}
+	}
+
+	var samples []*LabeledSample
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		sample := &LabeledSample{
+			Line:      line,
+			ID:        field("id"),
+			IP:        field("ip"),
+			UserAgent: field("ua"),
+			Label:     field("label"),
+		}
+		if sample.Headers, err = parseSampleHeaders(field("headers")); err != nil {
+			return nil, fmt.Errorf("line %d: headers: %w", line, err)
+		}
+		if referer := field("referer"); referer != "" {
+			sample.Headers.Set("Referer", referer)
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples, nil
+}
+
+// jsonlSample строка набора JSONL; значения заголовков - строка или массив строк
+type jsonlSample struct {
+	ID        string                 `json:"id"`
+	IP        string                 `json:"ip"`
+	UA        string                 `json:"ua"`
+	UserAgent string                 `json:"user_agent"`
+	Referer   string                 `json:"referer"`
+	Headers   map[string]interface{} `json:"headers"`
+	Label     string                 `json:"label"`
+}
+
+// readJSONLSamples читает набор JSONL: один JSON объект в строке
+func readJSONLSamples(r io.Reader) ([]*LabeledSample, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), accessLogMaxLine)
+
+	var samples []*LabeledSample
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		var record jsonlSample
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		sample := &LabeledSample{
+			Line:      line,
+			ID:        record.ID,
+			IP:        record.IP,
+			UserAgent: record.UA,
+			Headers:   make(http.Header),
+			Label:     record.Label,
+		}
+		if sample.UserAgent == "" {
+			sample.UserAgent = record.UserAgent
+		}
+		for name, value := range record.Headers {
+			switch value := value.(type) {
+			case string:
+				sample.Headers.Add(name, value)
+			case []interface{}:
+				for _, item := range value {
+					text, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("line %d: header %s: values must be strings", line, name)
+					}
+					sample.Headers.Add(name, text)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: header %s: value must be a string or an array of strings", line, name)
+			}
+		}
+		if record.Referer != "" {
+			sample.Headers.Set("Referer", record.Referer)
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// parseSampleHeaders разбирает заголовки из ячейки CSV: JSON объект
+// или строки "Name: value", разделенные переводом строки
+func parseSampleHeaders(value string) (http.Header, error) {
+	headers := make(http.Header)
+	if value == "" {
+		return headers, nil
+	}
+
+	if strings.HasPrefix(value, "{") {
+		var values map[string]string
+		if err := json.Unmarshal([]byte(value), &values); err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			headers.Add(name, value)
+		}
+		return headers, nil
+	}
+
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("expected \"Name: value\": %s", line)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// normalizeSample проверяет адрес и метку образца
+func normalizeSample(sample *LabeledSample) error {
+	addr, err := netip.ParseAddr(sample.IP)
+	if err != nil {
+		return fmt.Errorf("ip: %w", err)
+	}
+	sample.IP = addr.Unmap().String()
+
+	sample.Label = strings.ToLower(strings.TrimSpace(sample.Label))
+	if _, ok := evaluationLabels[sample.Label]; !ok {
+		return fmt.Errorf("unknown label %q, expected bot, human, %s, %s, %s or %s", sample.Label,
+			ReplayVerifiedBot, ReplayUnverifiedBot, UserTypeFromSearch, UserTypeDirect)
+	}
+	return nil
+}
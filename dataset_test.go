@@ -0,0 +1,192 @@
+package botredirect
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReadLabeledSamples проверяет чтение размеченных наборов CSV и JSONL и отказ от некорректных строк
+func TestReadLabeledSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		want    []LabeledSample
+		wantErr string
+	}{
+		{
+			name:   "csv",
+			format: DatasetFormatCSV,
+			data: "id,IP,user_agent,referer,headers,label,comment\n" +
+				"1,66.249.66.1,Googlebot/2.1,,,verified_bot,crawler\n" +
+				"2,::ffff:192.0.2.1,Mozilla/5.0,https://www.google.com/,\"{\"\"Accept-Language\"\":\"\"en\"\"}\", Human ,\n" +
+				"3,2001:db8::1,curl/8.0,,\"X-A: 1\nX-B: 2\",BOT\n",
+			want: []LabeledSample{
+				{Line: 2, ID: "1", IP: "66.249.66.1", UserAgent: "Googlebot/2.1", Label: ReplayVerifiedBot},
+				{Line: 3, ID: "2", IP: "192.0.2.1", UserAgent: "Mozilla/5.0", Label: EvaluationHuman},
+				{Line: 4, ID: "3", IP: "2001:db8::1", UserAgent: "curl/8.0", Label: EvaluationBot},
+			},
+		},
+		{
+			name:    "csv without label column",
+			format:  DatasetFormatCSV,
+			data:    "ip,ua\n192.0.2.1,curl/8.0\n",
+			wantErr: "no label column",
+		},
+		{
+			name:    "csv without header",
+			format:  DatasetFormatCSV,
+			wantErr: "reading CSV header",
+		},
+		{
+			name:    "csv with unknown label",
+			format:  DatasetFormatCSV,
+			data:    "ip,label\n192.0.2.1,bot\n192.0.2.2,robot\n",
+			wantErr: `line 3: unknown label "robot"`,
+		},
+		{
+			name:    "csv with invalid address",
+			format:  DatasetFormatCSV,
+			data:    "ip,label\nexample.com,bot\n",
+			wantErr: "line 2: ip",
+		},
+		{
+			name:    "csv with malformed headers cell",
+			format:  DatasetFormatCSV,
+			data:    "ip,label,headers\n192.0.2.1,bot,no colon here\n",
+			wantErr: "line 2: headers",
+		},
+		{
+			name:    "csv with unterminated quote",
+			format:  DatasetFormatCSV,
+			data:    "ip,label\n\"192.0.2.1,bot\n",
+			wantErr: "quote",
+		},
+		{
+			name:   "jsonl",
+			format: DatasetFormatJSONL,
+			data: "# comment\n\n" +
+				`{"id":"a","ip":"192.0.2.1","ua":"Mozilla/5.0","referer":"https://example.com/","headers":{"Accept":"*/*","X-Forwarded-For":["10.0.0.1","10.0.0.2"]},"label":"from_search"}` + "\n" +
+				`{"ip":"192.0.2.2","user_agent":"ExampleBot/1.0","label":"unverified_bot"}` + "\n",
+			want: []LabeledSample{
+				{Line: 3, ID: "a", IP: "192.0.2.1", UserAgent: "Mozilla/5.0", Label: UserTypeFromSearch.String()},
+				{Line: 4, IP: "192.0.2.2", UserAgent: "ExampleBot/1.0", Label: ReplayUnverifiedBot},
+			},
+		},
+		{
+			name:    "jsonl with malformed line",
+			format:  DatasetFormatJSONL,
+			data:    `{"ip":"192.0.2.1","label":"bot"}` + "\n" + `{"ip":` + "\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "jsonl with non-string header",
+			format:  DatasetFormatJSONL,
+			data:    `{"ip":"192.0.2.1","label":"bot","headers":{"X-Count":1}}`,
+			wantErr: "header X-Count",
+		},
+		{
+			name:    "jsonl with non-string header item",
+			format:  DatasetFormatJSONL,
+			data:    `{"ip":"192.0.2.1","label":"bot","headers":{"X-Count":["1",2]}}`,
+			wantErr: "header X-Count: values must be strings",
+		},
+		{
+			name:    "jsonl without label",
+			format:  DatasetFormatJSONL,
+			data:    `{"ip":"192.0.2.1"}`,
+			wantErr: "line 1: unknown label",
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			wantErr: "unknown dataset format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := ReadLabeledSamples(strings.NewReader(tt.data), tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(samples) != len(tt.want) {
+				t.Fatalf("samples = %d, want %d", len(samples), len(tt.want))
+			}
+			for i, sample := range samples {
+				want := tt.want[i]
+				if sample.Line != want.Line || sample.ID != want.ID || sample.IP != want.IP ||
+					sample.UserAgent != want.UserAgent || sample.Label != want.Label {
+					t.Errorf("sample %d = %+v, want %+v", i, sample, want)
+				}
+			}
+		})
+	}
+}
+
+// TestLabeledSampleHeaders проверяет заголовки образцов и их перенос в запись для классификации
+func TestLabeledSampleHeaders(t *testing.T) {
+	csvSamples, err := ReadLabeledSamples(strings.NewReader(
+		"ip,ua,referer,headers,label\n"+
+			"192.0.2.1,Mozilla/5.0,https://www.google.com/,\"{\"\"Accept-Language\"\":\"\"en\"\"}\",human\n"+
+			"192.0.2.2,,,\"X-A: 1\nX-A: 2\",bot\n"), DatasetFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonlSamples, err := ReadLabeledSamples(strings.NewReader(
+		`{"ip":"192.0.2.3","ua":"Mozilla/5.0","headers":{"User-Agent":"ignored","X-A":["1","2"]},"label":"bot"}`), DatasetFormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := csvSamples[0].Entry()
+	if first.Headers.Get("Accept-Language") != "en" || first.Headers.Get("Referer") != "https://www.google.com/" || first.UserAgent() != "Mozilla/5.0" {
+		t.Errorf("csv entry headers = %v", first.Headers)
+	}
+	if first.ClientIP != "192.0.2.1" || first.Method != "GET" || first.URI != "/" || first.Line != 2 {
+		t.Errorf("csv entry = %+v", first)
+	}
+
+	second := csvSamples[1].Entry()
+	if values := second.Headers.Values("X-A"); len(values) != 2 || second.UserAgent() != "" {
+		t.Errorf("csv entry headers = %v", second.Headers)
+	}
+
+	// Колонка ua заменяет заголовок User-Agent, заголовки образца не меняются
+	third := jsonlSamples[0].Entry()
+	if third.UserAgent() != "Mozilla/5.0" || len(third.Headers.Values("X-A")) != 2 {
+		t.Errorf("jsonl entry headers = %v", third.Headers)
+	}
+	if jsonlSamples[0].Headers.Get("User-Agent") != "ignored" {
+		t.Error("entry changed the sample headers")
+	}
+}
+
+// TestDatasetFormat проверяет определение формата набора по расширению
+func TestDatasetFormat(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"labels.csv", DatasetFormatCSV},
+		{"labels.CSV", DatasetFormatCSV},
+		{"labels.jsonl", DatasetFormatJSONL},
+		{"labels.ndjson", DatasetFormatJSONL},
+		{"labels.json", DatasetFormatJSONL},
+		{"labels.txt", ""},
+		{"labels", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := DatasetFormat(tt.path)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("DatasetFormat(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
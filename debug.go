@@ -1,6 +1,7 @@
 package botredirect
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -27,15 +28,22 @@ type RequestDebugInfo struct {
 	Headers      map[string]string
 	StartTime    time.Time
 	ProcessingSteps []ProcessingStep
+	FinalResult  string
+
+	// Трассировка решения, запрошенная для конкретного запроса (WithRequestTrace)
+	traced bool
 }
 
+// requestTraceKey ключ контекста запроса с трассировкой решения
+type requestTraceKey struct{}
+
 // ProcessingStep представляет один шаг обработки запроса
 type ProcessingStep struct {
-	Step      string
-	Result    string
-	Duration  time.Duration
-	Details   map[string]interface{}
-	Timestamp time.Time
+	Step      string                 `json:"step"`
+	Result    string                 `json:"result"`
+	Duration  time.Duration          `json:"duration"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
 }
 
 // DNSDebugInfo содержит отладочную информацию о DNS запросах
@@ -71,12 +79,46 @@ func NewDebugConfig(config *Config, logger *zap.Logger) *DebugConfig {
 	}
 }
 
+// WithRequestTrace включает трассировку решения для запроса независимо от debug режима:
+// шаги всех компонентов, обработавших запрос, записываются в возвращаемый RequestDebugInfo.
+// Используется командами CLI для объяснения классификации.
+func WithRequestTrace(r *http.Request) (*http.Request, *RequestDebugInfo) {
+	info := newRequestDebugInfo(r)
+	info.traced = true
+	return r.WithContext(context.WithValue(r.Context(), requestTraceKey{}, info)), info
+}
+
+// requestTrace возвращает трассировку решения запроса, если она включена
+func requestTrace(r *http.Request) *RequestDebugInfo {
+	info, _ := r.Context().Value(requestTraceKey{}).(*RequestDebugInfo)
+	return info
+}
+
 // StartRequestDebug начинает отладку запроса
 func (dc *DebugConfig) StartRequestDebug(r *http.Request) *RequestDebugInfo {
+	// Все этапы обработки запроса с трассировкой пишут в нее
+	if info := requestTrace(r); info != nil {
+		return info
+	}
+
 	if !dc.Enabled || !dc.LogAllRequests {
 		return nil
 	}
 
+	info := newRequestDebugInfo(r)
+
+	dc.logger.Debug("started request debug",
+		zap.String("ip", info.IP),
+		zap.String("user_agent", info.UserAgent),
+		zap.String("referer", info.Referer),
+		zap.String("url", info.URL),
+	)
+
+	return info
+}
+
+// newRequestDebugInfo создает отладочную информацию о запросе
+func newRequestDebugInfo(r *http.Request) *RequestDebugInfo {
 	info := &RequestDebugInfo{
 		IP:        r.RemoteAddr,
 		UserAgent: r.UserAgent(),
@@ -109,19 +151,12 @@ func (dc *DebugConfig) StartRequestDebug(r *http.Request) *RequestDebugInfo {
 		}
 	}
 
-	dc.logger.Debug("started request debug",
-		zap.String("ip", info.IP),
-		zap.String("user_agent", info.UserAgent),
-		zap.String("referer", info.Referer),
-		zap.String("url", info.URL),
-	)
-
 	return info
 }
 
 // AddProcessingStep добавляет шаг обработки в отладочную информацию
 func (dc *DebugConfig) AddProcessingStep(info *RequestDebugInfo, step, result string, duration time.Duration, details map[string]interface{}) {
-	if !dc.Enabled || info == nil {
+	if info == nil || (!dc.Enabled && !info.traced) {
 		return
 	}
 
@@ -135,6 +170,10 @@ func (dc *DebugConfig) AddProcessingStep(info *RequestDebugInfo, step, result st
 
 	info.ProcessingSteps = append(info.ProcessingSteps, processingStep)
 
+	if !dc.Enabled {
+		return
+	}
+
 	dc.logger.Debug("processing step completed",
 		zap.String("ip", info.IP),
 		zap.String("step", step),
@@ -146,6 +185,10 @@ func (dc *DebugConfig) AddProcessingStep(info *RequestDebugInfo, step, result st
 
 // FinishRequestDebug завершает отладку запроса
 func (dc *DebugConfig) FinishRequestDebug(info *RequestDebugInfo, finalResult string) {
+	if info != nil {
+		info.FinalResult = finalResult
+	}
+
 	if !dc.Enabled || info == nil {
 		return
 	}
@@ -268,6 +311,21 @@ func (dc *DebugConfig) LogReverseDNSCheck(ip string, hostname string, isValid bo
 	)
 }
 
+// LogRobotsCheck логирует проверку robots.txt
+func (dc *DebugConfig) LogRobotsCheck(userAgent, path string, allowed bool, matchedGroup, matchedRule string) {
+	if !dc.Enabled {
+		return
+	}
+
+	dc.logger.Debug("robots.txt check",
+		zap.String("user_agent", userAgent),
+		zap.String("path", path),
+		zap.Bool("allowed", allowed),
+		zap.String("matched_group", matchedGroup),
+		zap.String("matched_rule", matchedRule),
+	)
+}
+
 // LogCacheStats логирует статистику кеша
 func (dc *DebugConfig) LogCacheStats(size int, hits int64, misses int64, hitRate float64) {
 	if !dc.Enabled || !dc.VerboseMetrics {
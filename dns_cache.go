@@ -0,0 +1,228 @@
+package botredirect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+)
+
+// errNoDNSRecord адрес отсутствует в файле DNS, а запросы в сеть запрещены
+var errNoDNSRecord = errors.New("no recorded DNS answer (offline)")
+
+// DNSRecord записанный ответ DNS для IP адреса: PTR и адреса, в которые разрешается
+// этот hostname (прямая проверка). Пустой Hostname - PTR записи нет.
+type DNSRecord struct {
+	Hostname  string   `json:"hostname"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// RecordedResolver отвечает на DNS запросы по записанным ответам (файл DNS кеша).
+// Адреса, которых нет в записи, запрашиваются через live resolver, если он задан,
+// и добавляются в запись; без live resolver запрос завершается ошибкой, а адрес
+// учитывается как непроверяемый офлайн.
+type RecordedResolver struct {
+	records map[string]*DNSRecord
+	forward map[string][]string
+	live    DNSResolver
+
+	// Адреса, для которых ответа не нашлось
+	missing map[string]bool
+	changed bool
+	mutex   sync.Mutex
+}
+
+// NewRecordedResolver создает новый экземпляр RecordedResolver.
+// live - resolver для адресов без записи; nil - только записанные ответы.
+func NewRecordedResolver(records map[string]*DNSRecord, live DNSResolver) *RecordedResolver {
+	rr := &RecordedResolver{
+		records: make(map[string]*DNSRecord, len(records)),
+		forward: make(map[string][]string),
+		live:    live,
+		missing: make(map[string]bool),
+	}
+
+	for ip, record := range records {
+		ip = canonicalHost(ip)
+		rr.records[ip] = record
+		if record.Hostname != "" && len(record.Addresses) > 0 {
+			rr.forward[record.Hostname] = record.Addresses
+		}
+	}
+
+	return rr
+}
+
+// LoadRecordedResolver загружает записанные ответы из файла. Отсутствующий файл
+// допустим при live resolver: он будет создан при сохранении.
+func LoadRecordedResolver(path string, live DNSResolver) (*RecordedResolver, error) {
+	records := make(map[string]*DNSRecord)
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("decoding DNS cache %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && live != nil:
+	default:
+		return nil, err
+	}
+
+	return NewRecordedResolver(records, live), nil
+}
+
+// LookupAddr возвращает записанный PTR для адреса
+func (rr *RecordedResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	ip := canonicalHost(addr)
+
+	rr.mutex.Lock()
+	record, ok := rr.records[ip]
+	rr.mutex.Unlock()
+
+	if !ok {
+		if rr.live == nil {
+			rr.mutex.Lock()
+			rr.missing[ip] = true
+			rr.mutex.Unlock()
+			return nil, errNoDNSRecord
+		}
+
+		hostnames, err := rr.live.LookupAddr(ctx, addr)
+		if err != nil && !isDNSNotFound(err) {
+			// Временные ошибки не записываются: при следующем запуске запрос повторится
+			return nil, err
+		}
+
+		record = &DNSRecord{}
+		if len(hostnames) > 0 {
+			record.Hostname = trimDNSName(hostnames[0])
+		}
+
+		rr.mutex.Lock()
+		rr.records[ip] = record
+		rr.changed = true
+		rr.mutex.Unlock()
+	}
+
+	if record.Hostname == "" {
+		return nil, &net.DNSError{Err: "no PTR record", Name: addr, IsNotFound: true}
+	}
+	return []string{record.Hostname}, nil
+}
+
+// LookupIPAddr возвращает адреса, записанные для hostname
+func (rr *RecordedResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	host = trimDNSName(host)
+
+	rr.mutex.Lock()
+	addresses, ok := rr.forward[host]
+	rr.mutex.Unlock()
+
+	if !ok && rr.live != nil {
+		ips, err := rr.live.LookupIPAddr(ctx, host)
+		if err != nil && !isDNSNotFound(err) {
+			return nil, err
+		}
+
+		addresses = make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addresses = append(addresses, ip.IP.String())
+		}
+
+		rr.mutex.Lock()
+		rr.forward[host] = addresses
+		rr.changed = true
+		rr.mutex.Unlock()
+	}
+
+	if len(addresses) == 0 {
+		return nil, &net.DNSError{Err: "no A/AAAA records", Name: host, IsNotFound: true}
+	}
+
+	ips := make([]net.IPAddr, 0, len(addresses))
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, net.IPAddr{IP: ip})
+		}
+	}
+	return ips, nil
+}
+
+// Hostname возвращает записанный PTR для адреса
+func (rr *RecordedResolver) Hostname(ip string) string {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if record, ok := rr.records[canonicalHost(ip)]; ok {
+		return record.Hostname
+	}
+	return ""
+}
+
+// Missing сообщает, что для адреса не нашлось ответа и проверить его офлайн нельзя
+func (rr *RecordedResolver) Missing(ip string) bool {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return rr.missing[canonicalHost(ip)]
+}
+
+// MissingCount возвращает количество адресов без ответа
+func (rr *RecordedResolver) MissingCount() int {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return len(rr.missing)
+}
+
+// Records возвращает записанные ответы вместе с полученными через live resolver
+func (rr *RecordedResolver) Records() map[string]*DNSRecord {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	records := make(map[string]*DNSRecord, len(rr.records))
+	for ip, record := range rr.records {
+		copied := &DNSRecord{Hostname: record.Hostname}
+		if record.Hostname != "" {
+			copied.Addresses = append([]string(nil), rr.forward[record.Hostname]...)
+			sort.Strings(copied.Addresses)
+		}
+		records[ip] = copied
+	}
+	return records
+}
+
+// Changed сообщает, что live resolver добавил ответы, которых не было в записи
+func (rr *RecordedResolver) Changed() bool {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return rr.changed
+}
+
+// Save атомарно записывает ответы в файл
+func (rr *RecordedResolver) Save(path string) error {
+	data, err := json.MarshalIndent(rr.Records(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileIfChanged(path, append(data, '\n'))
+	return err
+}
+
+// isDNSNotFound сообщает, что записи не существует (в отличие от временной ошибки)
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// trimDNSName убирает завершающую точку полного имени
+func trimDNSName(name string) string {
+	if len(name) > 1 && name[len(name)-1] == '.' {
+		return name[:len(name)-1]
+	}
+	return name
+}
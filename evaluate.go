@@ -0,0 +1,331 @@
+package botredirect
+
+import "sort"
+
+// Метки бинарной разметки
+const (
+	EvaluationBot   = "bot"
+	EvaluationHuman = "human"
+)
+
+// Сигналы детектора, оцениваемые по отдельности
+const (
+	SignalUserAgent  = "user_agent"
+	SignalIPRange    = "ip_range"
+	SignalReverseDNS = "reverse_dns"
+	SignalHeaders    = "headers"
+)
+
+// Виды ошибок классификации
+const (
+	EvaluationFalsePositive = "false_positive"
+	EvaluationFalseNegative = "false_negative"
+	EvaluationMisclassified = "misclassified"
+)
+
+// evaluationLabels допустимые метки и их бинарное значение
+var evaluationLabels = map[string]string{
+	EvaluationBot:               EvaluationBot,
+	EvaluationHuman:             EvaluationHuman,
+	ReplayVerifiedBot:           EvaluationBot,
+	ReplayUnverifiedBot:         EvaluationBot,
+	UserTypeFromSearch.String(): EvaluationHuman,
+	UserTypeDirect.String():     EvaluationHuman,
+}
+
+// evaluationClassOrder порядок классов в матрице ошибок
+var evaluationClassOrder = []string{
+	EvaluationBot,
+	EvaluationHuman,
+	ReplayVerifiedBot,
+	ReplayUnverifiedBot,
+	UserTypeFromSearch.String(),
+	UserTypeDirect.String(),
+}
+
+// evaluationSignals порядок сигналов в отчете
+var evaluationSignals = []string{SignalUserAgent, SignalIPRange, SignalReverseDNS, SignalHeaders}
+
+// ClassMetrics точность и полнота классификации для одного класса
+type ClassMetrics struct {
+	Class     string  `json:"class"`
+	Support   int     `json:"support"`
+	Predicted int     `json:"predicted"`
+	Correct   int     `json:"correct"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// SignalMetrics точность и полнота одного сигнала как самостоятельного признака бота
+type SignalMetrics struct {
+	Signal  string `json:"signal"`
+	Enabled bool   `json:"enabled"`
+
+	TruePositives  int `json:"true_positives"`
+	FalsePositives int `json:"false_positives"`
+	FalseNegatives int `json:"false_negatives"`
+	TrueNegatives  int `json:"true_negatives"`
+
+	// Образцы, для которых сигнал нельзя вычислить (нет записанного ответа DNS)
+	Skipped int `json:"skipped,omitempty"`
+
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// EvaluationError образец, классифицированный не так, как размечен
+type EvaluationError struct {
+	Kind      string         `json:"kind"`
+	Sample    *LabeledSample `json:"sample"`
+	Predicted string         `json:"predicted"`
+
+	DetectionMethod string   `json:"detection_method,omitempty"`
+	MatchedPattern  string   `json:"matched_pattern,omitempty"`
+	Signals         []string `json:"signals"`
+
+	// Краулер не подтвержден из-за отсутствия записанного ответа DNS
+	Unverifiable bool `json:"unverifiable,omitempty"`
+}
+
+// Evaluation результат оценки детектора на размеченном наборе
+type Evaluation struct {
+	Samples int `json:"samples"`
+
+	// binary - метки bot/human, classification - классы verified_bot, unverified_bot, from_search, direct
+	Granularity string  `json:"granularity"`
+	Accuracy    float64 `json:"accuracy"`
+
+	// Матрица ошибок: метка -> предсказанный класс -> количество образцов
+	Classes   []string                  `json:"classes"`
+	Confusion map[string]map[string]int `json:"confusion"`
+
+	ClassMetrics  []*ClassMetrics    `json:"class_metrics"`
+	SignalMetrics []*SignalMetrics   `json:"signal_metrics"`
+	Errors        []*EvaluationError `json:"errors"`
+}
+
+// Evaluator оценивает классификацию детектора на размеченных образцах
+type Evaluator struct {
+	detector *BotDetector
+	resolver *RecordedResolver
+	replayer *Replayer
+}
+
+// NewEvaluator создает новый экземпляр Evaluator. Обратный DNS детектора отвечает
+// по записанным ответам resolver, как при повторе журналов.
+func NewEvaluator(detector *BotDetector, resolver *RecordedResolver) *Evaluator {
+	return &Evaluator{
+		detector: detector,
+		resolver: resolver,
+		replayer: NewReplayer(detector, resolver),
+	}
+}
+
+// Evaluate классифицирует образцы и сравнивает результат с разметкой. Если все метки -
+// классы (verified_bot, unverified_bot, from_search, direct), сравниваются классы,
+// иначе классы сводятся к bot/human. Сигналы всегда оцениваются по бинарной разметке.
+func (ev *Evaluator) Evaluate(samples []*LabeledSample) *Evaluation {
+	evaluation := &Evaluation{
+		Samples:     len(samples),
+		Granularity: "classification",
+		Confusion:   make(map[string]map[string]int),
+		Errors:      make([]*EvaluationError, 0),
+	}
+	for _, sample := range samples {
+		if sample.Label == EvaluationBot || sample.Label == EvaluationHuman {
+			evaluation.Granularity = "binary"
+			break
+		}
+	}
+
+	signals := make(map[string]*SignalMetrics, len(evaluationSignals))
+	for _, signal := range evaluationSignals {
+		signals[signal] = &SignalMetrics{Signal: signal, Enabled: ev.signalEnabled(signal)}
+		evaluation.SignalMetrics = append(evaluation.SignalMetrics, signals[signal])
+	}
+
+	correct := 0
+	for _, sample := range samples {
+		entry := sample.Entry()
+		verdict := ev.replayer.Classify(entry)
+
+		label := sample.Label
+		predicted := verdict.Class
+		if evaluation.Granularity == "binary" {
+			label = evaluationLabels[label]
+			if binary, ok := evaluationLabels[predicted]; ok {
+				predicted = binary
+			}
+		}
+
+		if evaluation.Confusion[label] == nil {
+			evaluation.Confusion[label] = make(map[string]int)
+		}
+		evaluation.Confusion[label][predicted]++
+
+		isBot := evaluationLabels[sample.Label] == EvaluationBot
+		fired := ev.signals(entry, isBot, signals)
+
+		if label == predicted {
+			correct++
+			continue
+		}
+
+		evaluationError := &EvaluationError{
+			Kind:         EvaluationMisclassified,
+			Sample:       sample,
+			Predicted:    verdict.Class,
+			Signals:      fired,
+			Unverifiable: verdict.Unverifiable,
+		}
+		if verdict.Result != nil {
+			evaluationError.DetectionMethod = verdict.Result.DetectionMethod
+			evaluationError.MatchedPattern = verdict.Result.MatchedPattern
+		}
+		switch predictedBot := evaluationLabels[verdict.Class]; {
+		case !isBot && predictedBot == EvaluationBot:
+			evaluationError.Kind = EvaluationFalsePositive
+		case isBot && predictedBot == EvaluationHuman:
+			evaluationError.Kind = EvaluationFalseNegative
+		}
+		evaluation.Errors = append(evaluation.Errors, evaluationError)
+	}
+
+	if len(samples) > 0 {
+		evaluation.Accuracy = float64(correct) / float64(len(samples))
+	}
+	evaluation.Classes = evaluationClasses(evaluation.Confusion)
+	evaluation.ClassMetrics = classMetrics(evaluation.Classes, evaluation.Confusion)
+	for _, metrics := range evaluation.SignalMetrics {
+		metrics.Precision, metrics.Recall, metrics.F1 = precisionRecall(
+			metrics.TruePositives, metrics.FalsePositives, metrics.FalseNegatives)
+	}
+
+	return evaluation
+}
+
+// signalEnabled сообщает, включен ли сигнал в конфигурации
+func (ev *Evaluator) signalEnabled(signal string) bool {
+	bd := ev.detector
+	switch signal {
+	case SignalUserAgent:
+		return bd.userAgentMatcher != nil
+	case SignalIPRange:
+		return bd.ipRangeChecker != nil
+	case SignalReverseDNS:
+		return bd.reverseDNSChecker != nil && bd.reverseDNSChecker.IsEnabled()
+	case SignalHeaders:
+		return bd.signatureVerifier != nil && bd.signatureVerifier.IsEnabled()
+	}
+	return false
+}
+
+// signals вычисляет каждый включенный сигнал для образца независимо от остальных,
+// учитывает его в метриках и возвращает сработавшие сигналы
+func (ev *Evaluator) signals(entry *AccessLogEntry, isBot bool, metrics map[string]*SignalMetrics) []string {
+	bd := ev.detector
+	fired := make([]string, 0, len(evaluationSignals))
+
+	for _, signal := range evaluationSignals {
+		m := metrics[signal]
+		if !m.Enabled {
+			continue
+		}
+
+		var bot bool
+		switch signal {
+		case SignalUserAgent:
+			result, err := bd.userAgentMatcher.IsBot(entry.UserAgent())
+			bot = err == nil && result.IsBot
+		case SignalIPRange:
+			result, err := bd.ipRangeChecker.IsBot(entry.ClientIP)
+			bot = err == nil && result.IsBot
+		case SignalReverseDNS:
+			result, err := bd.reverseDNSChecker.CheckDNS(entry.ClientIP)
+			if ev.resolver.Missing(entry.ClientIP) {
+				m.Skipped++
+				continue
+			}
+			bot = err == nil && result.IsBot
+		case SignalHeaders:
+			// Единственный сигнал детектора по заголовкам - подпись HTTP Message Signatures
+			r := entry.Request()
+			bot = HasMessageSignature(r) && bd.signatureVerifier.Verify(r).Valid
+		}
+
+		switch {
+		case bot && isBot:
+			m.TruePositives++
+		case bot && !isBot:
+			m.FalsePositives++
+		case !bot && isBot:
+			m.FalseNegatives++
+		default:
+			m.TrueNegatives++
+		}
+		if bot {
+			fired = append(fired, signal)
+		}
+	}
+
+	return fired
+}
+
+// evaluationClasses возвращает классы матрицы ошибок (метки и предсказания) в порядке вывода
+func evaluationClasses(confusion map[string]map[string]int) []string {
+	seen := make(map[string]bool)
+	for label, row := range confusion {
+		seen[label] = true
+		for predicted := range row {
+			seen[predicted] = true
+		}
+	}
+
+	classes := make([]string, 0, len(seen))
+	for _, class := range evaluationClassOrder {
+		if seen[class] {
+			classes = append(classes, class)
+			delete(seen, class)
+		}
+	}
+
+	// Действия переопределений (override:block) - после классов
+	rest := make([]string, 0, len(seen))
+	for class := range seen {
+		rest = append(rest, class)
+	}
+	sort.Strings(rest)
+	return append(classes, rest...)
+}
+
+// classMetrics вычисляет точность и полноту каждого класса по матрице ошибок
+func classMetrics(classes []string, confusion map[string]map[string]int) []*ClassMetrics {
+	metrics := make([]*ClassMetrics, 0, len(classes))
+	for _, class := range classes {
+		m := &ClassMetrics{Class: class, Correct: confusion[class][class]}
+		for _, predicted := range classes {
+			m.Support += confusion[class][predicted]
+			m.Predicted += confusion[predicted][class]
+		}
+		m.Precision, m.Recall, m.F1 = precisionRecall(m.Correct, m.Predicted-m.Correct, m.Support-m.Correct)
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// precisionRecall вычисляет точность, полноту и F1; при пустом знаменателе значение 0
+func precisionRecall(truePositives, falsePositives, falseNegatives int) (float64, float64, float64) {
+	var precision, recall, f1 float64
+	if truePositives+falsePositives > 0 {
+		precision = float64(truePositives) / float64(truePositives+falsePositives)
+	}
+	if truePositives+falseNegatives > 0 {
+		recall = float64(truePositives) / float64(truePositives+falseNegatives)
+	}
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
@@ -0,0 +1,106 @@
+package botredirect
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// TestPrecisionRecall проверяет точность, полноту и F1, включая пустые знаменатели
+func TestPrecisionRecall(t *testing.T) {
+	tests := []struct {
+		name                  string
+		tp, fp, fn            int
+		precision, recall, f1 float64
+	}{
+		{name: "perfect", tp: 5, precision: 1, recall: 1, f1: 1},
+		{name: "mixed", tp: 6, fp: 2, fn: 4, precision: 0.75, recall: 0.6, f1: 2 * 0.75 * 0.6 / 1.35},
+		{name: "no predictions", fn: 3, precision: 0, recall: 0, f1: 0},
+		{name: "no support", fp: 3, precision: 0, recall: 0, f1: 0},
+		{name: "empty", precision: 0, recall: 0, f1: 0},
+		{name: "only false negatives and a hit", tp: 1, fn: 3, precision: 1, recall: 0.25, f1: 0.4},
+	}
+
+	for _, tt := range tests {
+		precision, recall, f1 := precisionRecall(tt.tp, tt.fp, tt.fn)
+		for _, v := range []float64{precision, recall, f1} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Errorf("%s: non-finite value in %v, %v, %v", tt.name, precision, recall, f1)
+			}
+		}
+		if !approxEqual(precision, tt.precision) || !approxEqual(recall, tt.recall) || !approxEqual(f1, tt.f1) {
+			t.Errorf("%s: precision, recall, f1 = %v, %v, %v; want %v, %v, %v",
+				tt.name, precision, recall, f1, tt.precision, tt.recall, tt.f1)
+		}
+	}
+}
+
+// approxEqual сравнивает числа с плавающей точкой с допуском
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// TestClassMetrics проверяет метрики классов по матрице ошибок: классы без образцов
+// и без предсказаний получают нули, а не деление на ноль
+func TestClassMetrics(t *testing.T) {
+	// метка -> предсказание -> количество
+	confusion := map[string]map[string]int{
+		ReplayVerifiedBot:   {ReplayVerifiedBot: 8, ReplayUnverifiedBot: 2},
+		ReplayUnverifiedBot: {ReplayUnverifiedBot: 3, UserTypeDirect.String(): 1},
+		UserTypeDirect.String(): {
+			UserTypeDirect.String():   5,
+			ReplayUnverifiedBot:       1,
+			ReplayOverride + ":block": 2,
+		},
+		UserTypeFromSearch.String(): {UserTypeDirect.String(): 4},
+	}
+
+	classes := evaluationClasses(confusion)
+	wantClasses := []string{ReplayVerifiedBot, ReplayUnverifiedBot, UserTypeFromSearch.String(), UserTypeDirect.String(), ReplayOverride + ":block"}
+	if strings.Join(classes, " ") != strings.Join(wantClasses, " ") {
+		t.Fatalf("classes = %v, want %v", classes, wantClasses)
+	}
+
+	tests := map[string]ClassMetrics{
+		ReplayVerifiedBot:           {Support: 10, Predicted: 8, Correct: 8, Precision: 1, Recall: 0.8, F1: 2 * 0.8 / 1.8},
+		ReplayUnverifiedBot:         {Support: 4, Predicted: 6, Correct: 3, Precision: 0.5, Recall: 0.75, F1: 0.6},
+		UserTypeFromSearch.String(): {Support: 4, Predicted: 0, Correct: 0},
+		UserTypeDirect.String():     {Support: 8, Predicted: 10, Correct: 5, Precision: 0.5, Recall: 0.625, F1: 2 * 0.5 * 0.625 / 1.125},
+		ReplayOverride + ":block":   {Support: 0, Predicted: 2, Correct: 0},
+	}
+
+	for _, m := range classMetrics(classes, confusion) {
+		want, ok := tests[m.Class]
+		if !ok {
+			t.Errorf("unexpected class %s", m.Class)
+			continue
+		}
+		if m.Support != want.Support || m.Predicted != want.Predicted || m.Correct != want.Correct ||
+			!approxEqual(m.Precision, want.Precision) || !approxEqual(m.Recall, want.Recall) || !approxEqual(m.F1, want.F1) {
+			t.Errorf("%s: metrics = %+v, want %+v", m.Class, *m, want)
+		}
+	}
+
+	if metrics := classMetrics(evaluationClasses(map[string]map[string]int{}), nil); len(metrics) != 0 {
+		t.Errorf("metrics of an empty matrix = %v", metrics)
+	}
+}
+
+// TestEvaluationLabels проверяет сведение классов к бинарной разметке
+func TestEvaluationLabels(t *testing.T) {
+	for label, want := range map[string]string{
+		EvaluationBot:               EvaluationBot,
+		EvaluationHuman:             EvaluationHuman,
+		ReplayVerifiedBot:           EvaluationBot,
+		ReplayUnverifiedBot:         EvaluationBot,
+		UserTypeFromSearch.String(): EvaluationHuman,
+		UserTypeDirect.String():     EvaluationHuman,
+	} {
+		if got := evaluationLabels[label]; got != want {
+			t.Errorf("%s: binary label = %q, want %q", label, got, want)
+		}
+	}
+	if _, ok := evaluationLabels[ReplayOverride+":block"]; ok {
+		t.Error("override action has a binary label")
+	}
+}
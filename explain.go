@@ -0,0 +1,61 @@
+package botredirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// Explanation результат прогона запроса через обработчик с трассировкой решения
+type Explanation struct {
+	// Классификация запроса (bot, from_search, direct) и ее подробности
+	Classification string                 `json:"classification,omitempty"`
+	Details        map[string]interface{} `json:"details,omitempty"`
+
+	// Выполненное действие (pass, redirect, empty_page, block, challenge, rate_limit, banned...)
+	Action string `json:"action"`
+
+	// Ответ обработчика; Upstream - запрос передан дальше (оригинальный контент)
+	Status   int    `json:"status"`
+	Location string `json:"location,omitempty"`
+	Upstream bool   `json:"upstream"`
+
+	// Шаги обработки в порядке выполнения
+	Steps []ProcessingStep `json:"steps"`
+}
+
+// Explain прогоняет запрос через обработчик так же, как ServeHTTP обрабатывает живой трафик,
+// и возвращает трассировку решения. Ответ записывается в память, следующий обработчик
+// не вызывается. Состояние обработчика (кеши, лимиты, баны) изменяется как при обычном запросе.
+func (br *BotRedirect) Explain(r *http.Request) (*Explanation, error) {
+	r, trace := WithRequestTrace(r)
+
+	explanation := &Explanation{}
+	recorder := httptest.NewRecorder()
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		explanation.Upstream = true
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	if err := br.ServeHTTP(recorder, r, next); err != nil {
+		return nil, err
+	}
+
+	explanation.Status = recorder.Code
+	explanation.Location = recorder.Header().Get("Location")
+	explanation.Steps = trace.ProcessingSteps
+
+	for _, step := range trace.ProcessingSteps {
+		switch step.Step {
+		case "classification":
+			explanation.Classification = step.Result
+			explanation.Details = step.Details
+		case "response":
+			explanation.Action = step.Result
+		}
+	}
+
+	return explanation, nil
+}
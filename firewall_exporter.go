@@ -0,0 +1,388 @@
+package botredirect
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Форматы экспорта правил файрвола
+const (
+	FirewallFormatNftables = "nftables"
+	FirewallFormatIpset    = "ipset"
+	FirewallFormatNginx    = "nginx"
+)
+
+// firewallSetNamePattern допустимое имя set'а (ipset ограничивает имя 31 символом,
+// а к имени добавляются суффиксы _v4/_v6 и _tmp)
+var firewallSetNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,23}$`)
+
+// FirewallExporter периодически выгружает действующие баны, отмеченные ловушками сети
+// и заданные CIDR в файлы для nftables, ipset и nginx, чтобы внешний cron или systemd path
+// unit мог загрузить их в файрвол ядра и отсекать клиентов до TLS.
+// Файлы заменяются атомарно и перезаписываются только при изменении содержимого.
+type FirewallExporter struct {
+	// Конфигурация
+	enabled  bool
+	dir      string
+	formats  []string
+	interval time.Duration
+	setName  string
+	nftTable string
+	static   []netip.Prefix
+
+	// Источники
+	banManager *BanManager
+	honeypot   *Honeypot
+
+	// Управление горутиной экспорта
+	stop     chan struct{}
+	stopOnce sync.Once
+	mutex    sync.Mutex
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	exports      int64
+	writes       int64
+	unchanged    int64
+	errors       int64
+	lastEntries  int64
+	lastExportAt int64
+}
+
+// NewFirewallExporter создает новый экземпляр FirewallExporter
+func NewFirewallExporter(config *Config, banManager *BanManager, honeypot *Honeypot, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *FirewallExporter {
+	if config.FirewallExportDir == "" {
+		return &FirewallExporter{enabled: false}
+	}
+
+	fe := &FirewallExporter{
+		enabled:    true,
+		dir:        config.FirewallExportDir,
+		formats:    config.FirewallFormats,
+		interval:   config.FirewallExportInterval,
+		setName:    config.FirewallSetName,
+		nftTable:   config.FirewallNftTable,
+		banManager: banManager,
+		honeypot:   honeypot,
+		stop:       make(chan struct{}),
+		metrics:    metrics,
+		debug:      debug,
+		logger:     logger,
+	}
+
+	if len(fe.formats) == 0 {
+		fe.formats = []string{FirewallFormatNftables, FirewallFormatIpset, FirewallFormatNginx}
+	}
+	if fe.interval <= 0 {
+		fe.interval = time.Minute
+	}
+
+	for _, cidr := range config.FirewallCIDRs {
+		prefix, err := parseFirewallPrefix(cidr)
+		if err != nil {
+			logger.Warn("invalid firewall CIDR", zap.String("cidr", cidr), zap.Error(err))
+			continue
+		}
+		fe.static = append(fe.static, prefix)
+	}
+
+	go fe.run()
+
+	logger.Info("firewall exporter initialized",
+		zap.String("dir", fe.dir),
+		zap.Strings("formats", fe.formats),
+		zap.Duration("interval", fe.interval),
+		zap.Int("static_cidrs", len(fe.static)),
+	)
+
+	return fe
+}
+
+// parseFirewallPrefix разбирает CIDR или одиночный адрес
+func parseFirewallPrefix(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// run выполняет экспорт сразу и затем с заданным интервалом
+func (fe *FirewallExporter) run() {
+	fe.Export()
+
+	ticker := time.NewTicker(fe.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fe.Export()
+		case <-fe.stop:
+			return
+		}
+	}
+}
+
+// Export собирает текущие сети и записывает изменившиеся файлы
+func (fe *FirewallExporter) Export() error {
+	if !fe.enabled {
+		return nil
+	}
+
+	fe.mutex.Lock()
+	defer fe.mutex.Unlock()
+
+	atomic.AddInt64(&fe.exports, 1)
+
+	ipv4, ipv6 := fe.collect()
+	atomic.StoreInt64(&fe.lastEntries, int64(len(ipv4)+len(ipv6)))
+	atomic.StoreInt64(&fe.lastExportAt, time.Now().Unix())
+
+	var firstErr error
+	for _, format := range fe.formats {
+		name, content := fe.render(format, ipv4, ipv6)
+		if name == "" {
+			continue
+		}
+
+		written, err := writeFileIfChanged(filepath.Join(fe.dir, name), content)
+		switch {
+		case err != nil:
+			atomic.AddInt64(&fe.errors, 1)
+			fe.logger.Error("failed to export firewall rules",
+				zap.String("format", format),
+				zap.String("file", name),
+				zap.Error(err),
+			)
+			if firstErr == nil {
+				firstErr = err
+			}
+		case written:
+			atomic.AddInt64(&fe.writes, 1)
+			fe.logger.Info("firewall rules exported",
+				zap.String("format", format),
+				zap.String("file", name),
+				zap.Int("ipv4", len(ipv4)),
+				zap.Int("ipv6", len(ipv6)),
+			)
+		default:
+			atomic.AddInt64(&fe.unchanged, 1)
+		}
+	}
+
+	return firstErr
+}
+
+// collect возвращает отсортированные сети без пересечений, разделенные по семействам
+func (fe *FirewallExporter) collect() ([]netip.Prefix, []netip.Prefix) {
+	prefixes := append([]netip.Prefix(nil), fe.static...)
+
+	if fe.banManager != nil && fe.banManager.IsEnabled() {
+		for _, ban := range fe.banManager.List() {
+			if prefix, err := parseFirewallPrefix(ban.Key); err == nil {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+
+	if fe.honeypot != nil && fe.honeypot.IsEnabled() {
+		for _, entry := range fe.honeypot.GetReputation().List() {
+			if prefix, err := parseFirewallPrefix(entry.Key); err == nil {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+
+	var ipv4, ipv6 []netip.Prefix
+	for _, prefix := range collapsePrefixes(prefixes) {
+		if prefix.Addr().Is4() {
+			ipv4 = append(ipv4, prefix)
+		} else {
+			ipv6 = append(ipv6, prefix)
+		}
+	}
+	return ipv4, ipv6
+}
+
+// collapsePrefixes сортирует сети и убирает дубликаты и вложенные сети:
+// nftables не принимает пересекающиеся интервалы в одном set'е
+func collapsePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if cmp := prefixes[i].Addr().Compare(prefixes[j].Addr()); cmp != 0 {
+			return cmp < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	result := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if n := len(result); n > 0 && result[n-1].Overlaps(prefix) {
+			continue
+		}
+		result = append(result, prefix)
+	}
+	return result
+}
+
+// render формирует имя файла и содержимое для формата
+func (fe *FirewallExporter) render(format string, ipv4, ipv6 []netip.Prefix) (string, []byte) {
+	var buf bytes.Buffer
+	buf.WriteString("# generated by caddy bot_redirect, do not edit\n")
+
+	switch format {
+	case FirewallFormatNftables:
+		// Set'ы должны быть объявлены в таблице с flags interval
+		for _, family := range []struct {
+			suffix   string
+			prefixes []netip.Prefix
+		}{{"_v4", ipv4}, {"_v6", ipv6}} {
+			set := fe.nftTable + " " + fe.setName + family.suffix
+			fmt.Fprintf(&buf, "flush set %s\n", set)
+			if len(family.prefixes) > 0 {
+				fmt.Fprintf(&buf, "add element %s { %s }\n", set, joinPrefixes(family.prefixes, ", "))
+			}
+		}
+		return fe.setName + ".nft", buf.Bytes()
+
+	case FirewallFormatIpset:
+		// Новое содержимое собирается во временном set'е и подменяется через swap
+		for _, family := range []struct {
+			suffix   string
+			family   string
+			prefixes []netip.Prefix
+		}{{"_v4", "inet", ipv4}, {"_v6", "inet6", ipv6}} {
+			set := fe.setName + family.suffix
+			tmp := set + "_tmp"
+			fmt.Fprintf(&buf, "create %s hash:net family %s -exist\n", set, family.family)
+			fmt.Fprintf(&buf, "create %s hash:net family %s -exist\n", tmp, family.family)
+			fmt.Fprintf(&buf, "flush %s\n", tmp)
+			for _, prefix := range family.prefixes {
+				fmt.Fprintf(&buf, "add %s %s\n", tmp, prefix)
+			}
+			fmt.Fprintf(&buf, "swap %s %s\n", tmp, set)
+			fmt.Fprintf(&buf, "destroy %s\n", tmp)
+		}
+		return fe.setName + ".ipset", buf.Bytes()
+
+	case FirewallFormatNginx:
+		for _, prefix := range append(ipv4, ipv6...) {
+			fmt.Fprintf(&buf, "deny %s;\n", prefix)
+		}
+		return fe.setName + "_nginx.conf", buf.Bytes()
+
+	default:
+		return "", nil
+	}
+}
+
+// joinPrefixes объединяет сети в строку
+func joinPrefixes(prefixes []netip.Prefix, sep string) string {
+	parts := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		parts[i] = prefix.String()
+	}
+	return strings.Join(parts, sep)
+}
+
+// writeFileIfChanged атомарно заменяет файл (временный файл в том же каталоге и rename),
+// если его содержимое отличается. Возвращает true, если файл был записан.
+func writeFileIfChanged(path string, content []byte) (bool, error) {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return false, err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return false, err
+	}
+	if err := os.Chmod(tmpName, 0o644); err != nil {
+		os.Remove(tmpName)
+		return false, err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Shutdown останавливает периодический экспорт
+func (fe *FirewallExporter) Shutdown() {
+	if !fe.enabled {
+		return
+	}
+	fe.stopOnce.Do(func() {
+		close(fe.stop)
+	})
+}
+
+// IsEnabled возвращает статус включенности экспорта
+func (fe *FirewallExporter) IsEnabled() bool {
+	return fe.enabled
+}
+
+// GetStats возвращает статистику
+func (fe *FirewallExporter) GetStats() map[string]interface{} {
+	if !fe.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	return map[string]interface{}{
+		"enabled":          true,
+		"dir":              fe.dir,
+		"formats":          fe.formats,
+		"interval_seconds": fe.interval.Seconds(),
+		"static_cidrs":     len(fe.static),
+		"exports":          atomic.LoadInt64(&fe.exports),
+		"writes":           atomic.LoadInt64(&fe.writes),
+		"unchanged":        atomic.LoadInt64(&fe.unchanged),
+		"errors":           atomic.LoadInt64(&fe.errors),
+		"last_entries":     atomic.LoadInt64(&fe.lastEntries),
+		"last_export_unix": atomic.LoadInt64(&fe.lastExportAt),
+	}
+}
@@ -0,0 +1,182 @@
+package botredirect
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestFirewallExporter создает экспорт в каталог dir и дожидается первой выгрузки
+func newTestFirewallExporter(t *testing.T, dir string, bm *BanManager, hp *Honeypot, cidrs ...string) *FirewallExporter {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.FirewallExportDir = dir
+	config.FirewallExportInterval = time.Hour
+	config.FirewallCIDRs = cidrs
+
+	fe := NewFirewallExporter(config, bm, hp, nil, nil, zap.NewNop())
+	t.Cleanup(fe.Shutdown)
+
+	deadline := time.Now().Add(time.Second)
+	for fe.GetStats()["exports"] == int64(0) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Счетчик увеличивается в начале выгрузки: блокировка дожидается ее окончания
+	fe.mutex.Lock()
+	fe.mutex.Unlock()
+	return fe
+}
+
+// readExport читает выгруженный файл
+func readExport(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestFirewallExport проверяет содержимое файлов nftables, ipset и nginx
+// для банов, сетей из ловушек и заданных CIDR
+func TestFirewallExport(t *testing.T) {
+	clock := newFakeClock()
+	bm := newTestBanManager(t, clock, func(config *Config) { config.BanIPv4Prefix = 24 })
+	if _, err := bm.Ban("198.51.100.7", time.Hour, ""); err != nil {
+		t.Fatal(err)
+	}
+	hp := newTestHoneypot(t, clock)
+	hp.Check("[2001:db8:1::5]:443", "/trap")
+	hp.Check("192.0.2.1:1", "/trap")
+
+	dir := t.TempDir()
+	// 192.0.2.0/24 из ловушки поглощает заданный адрес 192.0.2.9 и дубликат из CIDR
+	newTestFirewallExporter(t, dir, bm, hp, "10.0.0.0/8", "192.0.2.9", "192.0.2.0/24", "::ffff:203.0.113.0/120", "bad")
+
+	wantNft := "# generated by caddy bot_redirect, do not edit\n" +
+		"flush set inet filter bot_redirect_v4\n" +
+		"add element inet filter bot_redirect_v4 { 10.0.0.0/8, 192.0.2.0/24, 198.51.100.0/24, 203.0.113.0/24 }\n" +
+		"flush set inet filter bot_redirect_v6\n" +
+		"add element inet filter bot_redirect_v6 { 2001:db8:1::/48 }\n"
+	if got := readExport(t, dir, "bot_redirect.nft"); got != wantNft {
+		t.Errorf("nftables:\n%s\nwant:\n%s", got, wantNft)
+	}
+
+	ipset := readExport(t, dir, "bot_redirect.ipset")
+	for _, line := range []string{
+		"create bot_redirect_v4 hash:net family inet -exist\n",
+		"create bot_redirect_v4_tmp hash:net family inet -exist\nflush bot_redirect_v4_tmp\nadd bot_redirect_v4_tmp 10.0.0.0/8\n",
+		"add bot_redirect_v4_tmp 203.0.113.0/24\nswap bot_redirect_v4_tmp bot_redirect_v4\ndestroy bot_redirect_v4_tmp\n",
+		"create bot_redirect_v6 hash:net family inet6 -exist\n",
+		"add bot_redirect_v6_tmp 2001:db8:1::/48\nswap bot_redirect_v6_tmp bot_redirect_v6\n",
+	} {
+		if !strings.Contains(ipset, line) {
+			t.Errorf("ipset file has no %q:\n%s", line, ipset)
+		}
+	}
+
+	wantNginx := "# generated by caddy bot_redirect, do not edit\n" +
+		"deny 10.0.0.0/8;\ndeny 192.0.2.0/24;\ndeny 198.51.100.0/24;\ndeny 203.0.113.0/24;\ndeny 2001:db8:1::/48;\n"
+	if got := readExport(t, dir, "bot_redirect_nginx.conf"); got != wantNginx {
+		t.Errorf("nginx:\n%s\nwant:\n%s", got, wantNginx)
+	}
+
+	// Пустые множества: nftables очищает set'ы без add element
+	empty := t.TempDir()
+	newTestFirewallExporter(t, empty, nil, nil)
+	if got := readExport(t, empty, "bot_redirect.nft"); strings.Contains(got, "add element") || !strings.Contains(got, "flush set inet filter bot_redirect_v6\n") {
+		t.Errorf("empty nftables export:\n%s", got)
+	}
+}
+
+// TestFirewallExportWritesOnlyChanges проверяет, что файлы перезаписываются только
+// при изменении списка сетей
+func TestFirewallExportWritesOnlyChanges(t *testing.T) {
+	clock := newFakeClock()
+	bm := newTestBanManager(t, clock, nil)
+	dir := t.TempDir()
+	fe := newTestFirewallExporter(t, dir, bm, nil, "10.0.0.0/8")
+
+	stat := func() time.Time {
+		info, err := os.Stat(filepath.Join(dir, "bot_redirect.nft"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+
+	// Отметка времени файла ставится в прошлое, чтобы перезапись была видна
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"bot_redirect.nft", "bot_redirect.ipset", "bot_redirect_nginx.conf"} {
+		if err := os.Chtimes(filepath.Join(dir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writes := fe.GetStats()["writes"].(int64)
+
+	if err := fe.Export(); err != nil {
+		t.Fatal(err)
+	}
+	if !stat().Equal(past) || fe.GetStats()["writes"].(int64) != writes || fe.GetStats()["unchanged"].(int64) < 3 {
+		t.Errorf("unchanged export rewrote files: %v", fe.GetStats())
+	}
+
+	if _, err := bm.Ban("192.0.2.1", time.Hour, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := fe.Export(); err != nil {
+		t.Fatal(err)
+	}
+	if stat().Equal(past) || fe.GetStats()["writes"].(int64) != writes+3 {
+		t.Errorf("changed export not written: %v", fe.GetStats())
+	}
+	if !strings.Contains(readExport(t, dir, "bot_redirect_nginx.conf"), "deny 192.0.2.1/32;") {
+		t.Error("new ban not exported")
+	}
+
+	// Истекший бан убирается из файлов
+	clock.Set(clock.Now().Add(2 * time.Hour))
+	if err := fe.Export(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(readExport(t, dir, "bot_redirect_nginx.conf"), "192.0.2.1") {
+		t.Error("expired ban still exported")
+	}
+
+	// Временные файлы не остаются в каталоге
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("export directory has %d files, want 3", len(entries))
+	}
+
+	// Ошибка записи возвращается и учитывается
+	fe.mutex.Lock()
+	fe.dir = filepath.Join(dir, "missing")
+	fe.mutex.Unlock()
+	if err := fe.Export(); err == nil || fe.GetStats()["errors"].(int64) != 3 {
+		t.Errorf("export to a missing directory: error = %v, errors = %v", err, fe.GetStats()["errors"])
+	}
+}
+
+// TestCollapsePrefixes проверяет удаление дубликатов и вложенных сетей
+func TestCollapsePrefixes(t *testing.T) {
+	var prefixes []netip.Prefix
+	for _, value := range []string{"192.0.2.128/25", "192.0.2.0/24", "10.1.0.0/16", "10.0.0.0/8", "192.0.2.0/24", "2001:db8::/32", "2001:db8:1::/48", "198.51.100.1/32"} {
+		prefixes = append(prefixes, netip.MustParsePrefix(value))
+	}
+
+	got := joinPrefixes(collapsePrefixes(prefixes), " ")
+	if want := "10.0.0.0/8 192.0.2.0/24 198.51.100.1/32 2001:db8::/32"; got != want {
+		t.Errorf("collapsed = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package botredirect
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// Honeypot отслеживает обращения к ловушкам - скрытым путям, которые запрещены в robots.txt
+// и не видны людям. Клиент, запросивший ловушку, отмечается в хранилище репутации.
+type Honeypot struct {
+	// Конфигурация
+	enabled bool
+	action  PolicyAction
+	traps   map[string]bool
+
+	// Хранилище отметок
+	reputation *ReputationStore
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	trapHits     int64
+	markedBlocks int64
+}
+
+// NewHoneypot создает новый экземпляр Honeypot
+func NewHoneypot(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *Honeypot {
+	if len(config.HoneypotPaths) == 0 {
+		return &Honeypot{enabled: false}
+	}
+
+	action := PolicyAction(config.HoneypotAction)
+	if action == "" {
+		action = PolicyActionBlock
+	}
+
+	hp := &Honeypot{
+		enabled:    true,
+		action:     action,
+		traps:      make(map[string]bool),
+		reputation: NewReputationStore(config.HoneypotTTL, config.HoneypotIPv4Prefix, config.HoneypotIPv6Prefix, logger),
+		metrics:    metrics,
+		debug:      debug,
+		logger:     logger,
+	}
+
+	for _, path := range config.HoneypotPaths {
+		if path == "" {
+			continue
+		}
+		hp.traps[strings.TrimSuffix(path, "/")] = true
+	}
+
+	logger.Info("honeypot initialized",
+		zap.Int("traps", len(hp.traps)),
+		zap.String("action", action.String()),
+		zap.Duration("ttl", config.HoneypotTTL),
+	)
+
+	return hp
+}
+
+// IsTrap проверяет, является ли путь ловушкой (включая вложенные пути)
+func (hp *Honeypot) IsTrap(path string) bool {
+	if !hp.enabled {
+		return false
+	}
+
+	path = strings.TrimSuffix(path, "/")
+	for path != "" {
+		if hp.traps[path] {
+			return true
+		}
+		idx := strings.LastIndex(path, "/")
+		if idx <= 0 {
+			break
+		}
+		path = path[:idx]
+	}
+
+	return false
+}
+
+// Check отмечает клиента, если запрошена ловушка, и возвращает действующую отметку клиента
+func (hp *Honeypot) Check(remoteAddr, path string) *ReputationEntry {
+	if !hp.enabled {
+		return nil
+	}
+
+	ip := hp.extractIP(remoteAddr)
+
+	if hp.IsTrap(path) {
+		atomic.AddInt64(&hp.trapHits, 1)
+		if hp.metrics != nil {
+			hp.metrics.IncrementHoneypotHits()
+		}
+
+		entry, err := hp.reputation.Mark(ip, "honeypot", path)
+		if err != nil {
+			hp.logger.Warn("failed to mark honeypot client",
+				zap.String("ip", ip),
+				zap.Error(err),
+			)
+			return nil
+		}
+		return entry
+	}
+
+	entry := hp.reputation.Lookup(ip)
+	if entry != nil {
+		atomic.AddInt64(&hp.markedBlocks, 1)
+	}
+	return entry
+}
+
+// IsMarked проверяет наличие действующей отметки для клиента
+func (hp *Honeypot) IsMarked(remoteAddr string) bool {
+	if !hp.enabled {
+		return false
+	}
+	return hp.reputation.Lookup(hp.extractIP(remoteAddr)) != nil
+}
+
+// extractIP извлекает IP адрес из строки (убирает порт)
+func (hp *Honeypot) extractIP(address string) string {
+	// Обработка IPv6 адресов с портом [::1]:8080
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]")
+		if end != -1 {
+			return address[1:end]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// GetReputation возвращает хранилище отметок
+func (hp *Honeypot) GetReputation() *ReputationStore {
+	return hp.reputation
+}
+
+// GetAction возвращает действие для отмеченных клиентов
+func (hp *Honeypot) GetAction() PolicyAction {
+	return hp.action
+}
+
+// IsEnabled возвращает статус включенности honeypot
+func (hp *Honeypot) IsEnabled() bool {
+	return hp.enabled
+}
+
+// GetStats возвращает статистику
+func (hp *Honeypot) GetStats() map[string]interface{} {
+	if !hp.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	traps := make([]string, 0, len(hp.traps))
+	for trap := range hp.traps {
+		traps = append(traps, trap)
+	}
+
+	return map[string]interface{}{
+		"enabled":          true,
+		"action":           hp.action.String(),
+		"traps":            traps,
+		"trap_hits":        atomic.LoadInt64(&hp.trapHits),
+		"marked_requests":  atomic.LoadInt64(&hp.markedBlocks),
+		"reputation_stats": hp.reputation.GetStats(),
+	}
+}
+
+// Shutdown останавливает фоновые задачи
+func (hp *Honeypot) Shutdown() {
+	if !hp.enabled {
+		return
+	}
+	hp.reputation.Shutdown()
+}
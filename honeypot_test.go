@@ -0,0 +1,139 @@
+package botredirect
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestHoneypot создает ловушку /trap с отметкой сетей /24 и /48 на час
+func newTestHoneypot(t *testing.T, clock Clock) *Honeypot {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.HoneypotPaths = []string{"/trap/", "/.env"}
+	config.HoneypotTTL = time.Hour
+	config.HoneypotIPv4Prefix = 24
+	config.HoneypotIPv6Prefix = 48
+
+	hp := NewHoneypot(config, nil, nil, zap.NewNop())
+	hp.GetReputation().SetClock(clock)
+	t.Cleanup(hp.Shutdown)
+	return hp
+}
+
+// TestHoneypotIsTrap проверяет совпадение ловушек по сегментам пути
+func TestHoneypotIsTrap(t *testing.T) {
+	hp := newTestHoneypot(t, newFakeClock())
+
+	for path, want := range map[string]bool{
+		"/trap":         true,
+		"/trap/":        true,
+		"/trap/a/b":     true,
+		"/.env":         true,
+		"/trapdoor":     false,
+		"/not/trap":     false,
+		"/.env.example": false,
+		"/":             false,
+		"":              false,
+	} {
+		if got := hp.IsTrap(path); got != want {
+			t.Errorf("IsTrap(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	if disabled := NewHoneypot(DefaultConfig(), nil, nil, zap.NewNop()); disabled.IsTrap("/trap") || disabled.Check("192.0.2.1:1", "/trap") != nil {
+		t.Error("disabled honeypot marks clients")
+	}
+}
+
+// TestHoneypotMarking проверяет отметку сети клиента, попавшего в ловушку
+func TestHoneypotMarking(t *testing.T) {
+	hp := newTestHoneypot(t, newFakeClock())
+
+	if entry := hp.Check("192.0.2.1:4321", "/page"); entry != nil {
+		t.Fatalf("client marked without a trap: %+v", entry)
+	}
+
+	entry := hp.Check("192.0.2.1:4321", "/trap/admin")
+	if entry == nil || entry.Key != "192.0.2.0/24" || entry.LastIP != "192.0.2.1" || entry.Path != "/trap/admin" || entry.Hits != 1 {
+		t.Fatalf("trap entry = %+v", entry)
+	}
+	entry = hp.Check("[2001:db8:1:2::1]:443", "/.env")
+	if entry == nil || entry.Key != "2001:db8:1::/48" {
+		t.Fatalf("ipv6 trap entry = %+v", entry)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"192.0.2.200:1000", true},
+		{"[::ffff:192.0.2.7]:1000", true},
+		{"192.0.3.1:1000", false},
+		{"[2001:db8:1:ffff::9]:443", true},
+		{"[2001:db8:2::1]:443", false},
+		{"not-an-address", false},
+	}
+	for _, tt := range tests {
+		if got := hp.Check(tt.remoteAddr, "/page") != nil; got != tt.want {
+			t.Errorf("%s marked = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+		if got := hp.IsMarked(tt.remoteAddr); got != tt.want {
+			t.Errorf("IsMarked(%s) = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+
+	// Повторное попадание учитывается в той же отметке сети
+	if entry := hp.Check("192.0.2.99:1", "/trap"); entry.Hits != 2 || entry.LastIP != "192.0.2.99" {
+		t.Errorf("repeated trap entry = %+v", entry)
+	}
+
+	stats := hp.GetStats()
+	if stats["trap_hits"] != int64(3) || stats["marked_requests"] != int64(3) {
+		t.Errorf("trap_hits = %v, marked_requests = %v; want 3, 3", stats["trap_hits"], stats["marked_requests"])
+	}
+}
+
+// TestHoneypotMarkExpiry проверяет истечение отметки и продление при новом попадании
+func TestHoneypotMarkExpiry(t *testing.T) {
+	clock := newFakeClock()
+	hp := newTestHoneypot(t, clock)
+	rs := hp.GetReputation()
+	start := clock.Now()
+
+	hp.Check("192.0.2.1:1", "/trap")
+	hp.Check("198.51.100.1:1", "/trap")
+
+	// Новое попадание продлевает отметку от момента попадания
+	clock.Set(start.Add(30 * time.Minute))
+	hp.Check("192.0.2.1:1", "/trap")
+
+	clock.Set(start.Add(time.Hour + time.Second))
+	if hp.IsMarked("198.51.100.1:1") {
+		t.Error("mark not expired after ttl")
+	}
+	if !hp.IsMarked("192.0.2.1:1") {
+		t.Error("extended mark expired")
+	}
+	if list := rs.List(); len(list) != 1 || list[0].Key != "192.0.2.0/24" {
+		t.Errorf("active marks = %+v", list)
+	}
+
+	rs.cleanup()
+	if n := rs.GetStats()["entries"]; n != 1 {
+		t.Errorf("entries after cleanup = %v, want 1", n)
+	}
+
+	// После истечения отметка начинается заново
+	clock.Set(start.Add(2 * time.Hour))
+	entry := hp.Check("192.0.2.5:1", "/trap")
+	if entry.Hits != 1 || !entry.MarkedAt.Equal(start.Add(2*time.Hour)) || !entry.ExpiresAt.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("entry after expiry = %+v", entry)
+	}
+
+	if !rs.Remove("192.0.2.0/24") || hp.IsMarked("192.0.2.5:1") || rs.Remove("192.0.2.0/24") {
+		t.Error("mark not removed")
+	}
+}
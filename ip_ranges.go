@@ -2,50 +2,150 @@ package botredirect
 
 import (
 	"fmt"
+	"maps"
 	"net"
+	"net/netip"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-// IPRangeChecker отвечает за проверку IP-адресов на принадлежность к диапазонам ботов
+// IPRangeChecker отвечает за проверку IP-адресов на принадлежность к диапазонам ботов.
+// Диапазоны хранятся в неизменяемом наборе за atomic.Pointer: проверки читают его без
+// блокировок, а изменения собирают новый набор и подменяют указатель.
 type IPRangeChecker struct {
-	// IP сети и диапазоны
-	ipv4Networks []*net.IPNet
-	ipv6Networks []*net.IPNet
-	
-	// Отдельные IP адреса для быстрой проверки
-	singleIPv4 map[string]bool
-	singleIPv6 map[string]bool
-	
-	// Метаданные для диапазонов
-	rangeMetadata map[string]*IPRangeMetadata
+	// Действующий набор диапазонов
+	ranges atomic.Pointer[ipRangeSet]
 	
 	// Кеш результатов
-	cache     map[string]*IPCheckResult
-	cacheTTL  time.Duration
-	maxCache  int
+	cache     *Cache[string, *IPCheckResult]
 	
-	// Синхронизация
-	mutex sync.RWMutex
+	// Синхронизация изменений (проверки не блокируются)
+	mutex sync.Mutex
 	
 	// Компоненты
 	metrics *Metrics
 	debug   *DebugConfig
 	logger  *zap.Logger
 	
-	// Статистика
+	// Статистика (используем atomic для thread-safety)
 	totalChecks    int64
 	botDetections  int64
-	cacheHits      int64
 	ipv4Checks     int64
 	ipv6Checks     int64
 	invalidIPs     int64
 }
 
+// ipRangeSet скомпилированный набор диапазонов; после создания не изменяется
+type ipRangeSet struct {
+	// CIDR диапазоны по версиям IP
+	ipv4Networks prefixTrie
+	ipv6Networks prefixTrie
+	
+	// Отдельные IP адреса для быстрой проверки
+	singleIPs map[netip.Addr]bool
+	
+	// Метаданные для диапазонов
+	metadata map[string]*IPRangeMetadata
+}
+
+// singleCounts возвращает количество одиночных IPv4 и IPv6 адресов
+func (set *ipRangeSet) singleCounts() (int, int) {
+	ipv4 := 0
+	for addr := range set.singleIPs {
+		if addr.Is4() {
+			ipv4++
+		}
+	}
+	return ipv4, len(set.singleIPs) - ipv4
+}
+
+// entries возвращает диапазоны и одиночные адреса набора
+func (set *ipRangeSet) entries() []string {
+	ranges := make([]string, 0, len(set.ipv4Networks.prefixes)+len(set.ipv6Networks.prefixes)+len(set.singleIPs))
+	for _, network := range set.ipv4Networks.prefixes {
+		ranges = append(ranges, network.String())
+	}
+	for _, network := range set.ipv6Networks.prefixes {
+		ranges = append(ranges, network.String())
+	}
+	for addr := range set.singleIPs {
+		ranges = append(ranges, addr.String())
+	}
+	return ranges
+}
+
+// prefixTrie бинарное дерево префиксов: поиск самой узкой сети, содержащей адрес,
+// занимает не больше шагов, чем бит в адресе
+type prefixTrie struct {
+	root     *prefixNode
+	prefixes []netip.Prefix
+}
+
+// prefixNode узел дерева префиксов
+type prefixNode struct {
+	children [2]*prefixNode
+	prefix   netip.Prefix
+	terminal bool
+}
+
+// addrBit возвращает бит адреса с номером i (IPv4 адреса нумеруются с начала своих 32 бит)
+func addrBit(addr netip.Addr, bytes *[16]byte, i int) byte {
+	if addr.Is4() {
+		i += 96
+	}
+	return (bytes[i/8] >> (7 - i%8)) & 1
+}
+
+// insert добавляет сеть; prefix должен быть приведен к адресу сети (Masked)
+func (t *prefixTrie) insert(prefix netip.Prefix) {
+	if t.root == nil {
+		t.root = &prefixNode{}
+	}
+	
+	addr := prefix.Addr()
+	bytes := addr.As16()
+	node := t.root
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := addrBit(addr, &bytes, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixNode{}
+		}
+		node = node.children[bit]
+	}
+	
+	if !node.terminal {
+		node.terminal = true
+		node.prefix = prefix
+		t.prefixes = append(t.prefixes, prefix)
+	}
+}
+
+// lookup возвращает самую узкую сеть, содержащую адрес
+func (t *prefixTrie) lookup(addr netip.Addr) (netip.Prefix, bool) {
+	var match netip.Prefix
+	found := false
+	
+	bytes := addr.As16()
+	node := t.root
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			match, found = node.prefix, true
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		node = node.children[addrBit(addr, &bytes, i)]
+	}
+	
+	return match, found
+}
+
 // IPRangeMetadata содержит метаданные о диапазоне IP
 type IPRangeMetadata struct {
 	Organization string
@@ -70,55 +170,46 @@ type IPCheckResult struct {
 // NewIPRangeChecker создает новый экземпляр IPRangeChecker
 func NewIPRangeChecker(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *IPRangeChecker {
 	irc := &IPRangeChecker{
-		ipv4Networks:  make([]*net.IPNet, 0),
-		ipv6Networks:  make([]*net.IPNet, 0),
-		singleIPv4:    make(map[string]bool),
-		singleIPv6:    make(map[string]bool),
-		rangeMetadata: make(map[string]*IPRangeMetadata),
-		cache:         make(map[string]*IPCheckResult),
-		cacheTTL:      config.CacheTTL,
-		maxCache:      5000, // Кеш для 5000 IP адресов
-		metrics:       metrics,
-		debug:         debug,
-		logger:        logger,
+		metrics: metrics,
+		debug:   debug,
+		logger:  logger,
 	}
 
+	irc.cache = NewCache[string, *IPCheckResult](CacheOptions{
+		Name:            "ip_ranges",
+		MaxSize:         config.IPRangeCacheSize,
+		TTL:             config.CacheTTL,
+		CleanupInterval: config.CleanupInterval,
+	}, hashString, metrics, debug, logger)
+
 	// Используем кастомные диапазоны если заданы, иначе дефолтные
 	ranges := config.BotIPRanges
 	if len(ranges) == 0 {
 		ranges = getDefaultBotIPRanges()
 	}
 
-	// Инициализация диапазонов
-	if err := irc.initializeRanges(ranges); err != nil {
-		logger.Error("failed to initialize IP ranges", zap.Error(err))
-		return irc
-	}
-
-	// Загрузка метаданных по умолчанию
-	irc.loadDefaultMetadata()
+	// Компиляция диапазонов с метаданными по умолчанию
+	set := irc.compileRanges(ranges, defaultRangeMetadata())
+	irc.ranges.Store(set)
+	singleIPv4, singleIPv6 := set.singleCounts()
 
 	logger.Info("IP range checker initialized",
-		zap.Int("ipv4_networks", len(irc.ipv4Networks)),
-		zap.Int("ipv6_networks", len(irc.ipv6Networks)),
-		zap.Int("single_ipv4", len(irc.singleIPv4)),
-		zap.Int("single_ipv6", len(irc.singleIPv6)),
-		zap.Int("metadata_entries", len(irc.rangeMetadata)),
+		zap.Int("ipv4_networks", len(set.ipv4Networks.prefixes)),
+		zap.Int("ipv6_networks", len(set.ipv6Networks.prefixes)),
+		zap.Int("single_ipv4", singleIPv4),
+		zap.Int("single_ipv6", singleIPv6),
+		zap.Int("metadata_entries", len(set.metadata)),
 	)
 
 	return irc
 }
 
-// initializeRanges инициализирует IP диапазоны из списка CIDR
-func (irc *IPRangeChecker) initializeRanges(ranges []string) error {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-
-	// Очистка предыдущих данных
-	irc.ipv4Networks = make([]*net.IPNet, 0, len(ranges))
-	irc.ipv6Networks = make([]*net.IPNet, 0, len(ranges))
-	irc.singleIPv4 = make(map[string]bool)
-	irc.singleIPv6 = make(map[string]bool)
+// compileRanges собирает набор из списка CIDR диапазонов и одиночных адресов
+func (irc *IPRangeChecker) compileRanges(ranges []string, metadata map[string]*IPRangeMetadata) *ipRangeSet {
+	set := &ipRangeSet{
+		singleIPs: make(map[netip.Addr]bool),
+		metadata:  metadata,
+	}
 
 	for _, rangeStr := range ranges {
 		if rangeStr == "" {
@@ -127,22 +218,17 @@ func (irc *IPRangeChecker) initializeRanges(ranges []string) error {
 
 		// Обработка одиночных IP адресов
 		if !strings.Contains(rangeStr, "/") {
-			ip := net.ParseIP(rangeStr)
-			if ip == nil {
+			addr, err := netip.ParseAddr(rangeStr)
+			if err != nil || addr.Zone() != "" {
 				irc.logger.Warn("invalid IP address", zap.String("ip", rangeStr))
 				continue
 			}
-
-			if ip.To4() != nil {
-				irc.singleIPv4[rangeStr] = true
-			} else {
-				irc.singleIPv6[rangeStr] = true
-			}
+			set.singleIPs[addr.Unmap()] = true
 			continue
 		}
 
 		// Обработка CIDR диапазонов
-		_, ipNet, err := net.ParseCIDR(rangeStr)
+		prefix, err := parseFirewallPrefix(rangeStr)
 		if err != nil {
 			irc.logger.Warn("invalid CIDR range", 
 				zap.String("range", rangeStr),
@@ -152,34 +238,14 @@ func (irc *IPRangeChecker) initializeRanges(ranges []string) error {
 		}
 
 		// Определяем тип IP (IPv4 или IPv6)
-		if ipNet.IP.To4() != nil {
-			irc.ipv4Networks = append(irc.ipv4Networks, ipNet)
+		if prefix.Addr().Is4() {
+			set.ipv4Networks.insert(prefix)
 		} else {
-			irc.ipv6Networks = append(irc.ipv6Networks, ipNet)
+			set.ipv6Networks.insert(prefix)
 		}
 	}
 
-	// Сортировка сетей для оптимизации поиска
-	irc.sortNetworks()
-
-	return nil
-}
-
-// sortNetworks сортирует сети по размеру маски для оптимизации поиска
-func (irc *IPRangeChecker) sortNetworks() {
-	// Сортируем IPv4 сети (наименьшие маски первыми для более специфичных совпадений)
-	sort.Slice(irc.ipv4Networks, func(i, j int) bool {
-		ones1, _ := irc.ipv4Networks[i].Mask.Size()
-		ones2, _ := irc.ipv4Networks[j].Mask.Size()
-		return ones1 > ones2 // Более специфичные сети первыми
-	})
-
-	// Сортируем IPv6 сети
-	sort.Slice(irc.ipv6Networks, func(i, j int) bool {
-		ones1, _ := irc.ipv6Networks[i].Mask.Size()
-		ones2, _ := irc.ipv6Networks[j].Mask.Size()
-		return ones1 > ones2
-	})
+	return set
 }
 
 // IsBot проверяет, принадлежит ли IP адрес к диапазонам ботов
@@ -204,8 +270,7 @@ func (irc *IPRangeChecker) IsBot(ipStr string) (*IPCheckResult, error) {
 	}
 
 	// Проверка кеша
-	if result := irc.getCachedResult(cleanIP); result != nil {
-		irc.incrementCacheHits()
+	if result, ok := irc.cache.Get(cleanIP); ok {
 		if irc.metrics != nil {
 			irc.metrics.IncrementCacheHits()
 		}
@@ -222,10 +287,15 @@ func (irc *IPRangeChecker) IsBot(ipStr string) (*IPCheckResult, error) {
 	}
 
 	// Выполнение проверки
-	result := irc.performCheck(cleanIP)
+	set := irc.ranges.Load()
+	result := irc.performCheck(cleanIP, set)
 	
-	// Сохранение в кеш
-	irc.setCachedResult(cleanIP, result)
+	// Сохранение в кеш. Если набор заменили во время проверки, очистка кеша изменением
+	// могла пройти до записи: устаревший результат удаляется
+	irc.cache.Set(cleanIP, result)
+	if irc.ranges.Load() != set {
+		irc.cache.Delete(cleanIP)
+	}
 	
 	// Логирование для дебага
 	if irc.debug != nil {
@@ -240,14 +310,11 @@ func (irc *IPRangeChecker) IsBot(ipStr string) (*IPCheckResult, error) {
 	return result, nil
 }
 
-// performCheck выполняет основную проверку IP адреса
-func (irc *IPRangeChecker) performCheck(ipStr string) *IPCheckResult {
-	irc.mutex.RLock()
-	defer irc.mutex.RUnlock()
-
+// performCheck выполняет основную проверку IP адреса по набору диапазонов
+func (irc *IPRangeChecker) performCheck(ipStr string, set *ipRangeSet) *IPCheckResult {
 	// Парсинг IP адреса
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
 		irc.incrementInvalidIPs()
 		return &IPCheckResult{
 			IsBot:     false,
@@ -255,30 +322,29 @@ func (irc *IPRangeChecker) performCheck(ipStr string) *IPCheckResult {
 			Timestamp: time.Now(),
 		}
 	}
+	addr = addr.WithZone("").Unmap()
 
 	// Определение версии IP
 	var ipVersion int
-	var networks []*net.IPNet
-	var singleIPs map[string]bool
+	var networks *prefixTrie
 
-	if ip.To4() != nil {
+	if addr.Is4() {
 		ipVersion = 4
-		networks = irc.ipv4Networks
-		singleIPs = irc.singleIPv4
+		networks = &set.ipv4Networks
 		irc.incrementIPv4Checks()
 	} else {
 		ipVersion = 6
-		networks = irc.ipv6Networks
-		singleIPs = irc.singleIPv6
+		networks = &set.ipv6Networks
 		irc.incrementIPv6Checks()
 	}
 
 	// 1. Проверка отдельных IP адресов (самый быстрый)
-	if singleIPs[ipStr] {
-		metadata := irc.rangeMetadata[ipStr]
+	if set.singleIPs[addr] {
+		rangeStr := addr.String()
+		metadata := set.metadata[rangeStr]
 		return &IPCheckResult{
 			IsBot:        true,
-			MatchedRange: ipStr,
+			MatchedRange: rangeStr,
 			Organization: irc.getOrganization(metadata),
 			BotType:      irc.getBotType(metadata),
 			Confidence:   1.0,
@@ -287,21 +353,19 @@ func (irc *IPRangeChecker) performCheck(ipStr string) *IPCheckResult {
 		}
 	}
 
-	// 2. Проверка CIDR диапазонов
-	for _, network := range networks {
-		if network.Contains(ip) {
-			rangeStr := network.String()
-			metadata := irc.rangeMetadata[rangeStr]
-			
-			return &IPCheckResult{
-				IsBot:        true,
-				MatchedRange: rangeStr,
-				Organization: irc.getOrganization(metadata),
-				BotType:      irc.getBotType(metadata),
-				Confidence:   0.9,
-				IPVersion:    ipVersion,
-				Timestamp:    time.Now(),
-			}
+	// 2. Проверка CIDR диапазонов (самая узкая сеть)
+	if network, ok := networks.lookup(addr); ok {
+		rangeStr := network.String()
+		metadata := set.metadata[rangeStr]
+	
+		return &IPCheckResult{
+			IsBot:        true,
+			MatchedRange: rangeStr,
+			Organization: irc.getOrganization(metadata),
+			BotType:      irc.getBotType(metadata),
+			Confidence:   0.9,
+			IPVersion:    ipVersion,
+			Timestamp:    time.Now(),
 		}
 	}
 
@@ -332,118 +396,27 @@ func (irc *IPRangeChecker) extractIP(address string) string {
 	return host
 }
 
-// getCachedResult получает результат из кеша
-func (irc *IPRangeChecker) getCachedResult(ip string) *IPCheckResult {
-	irc.mutex.RLock()
-	defer irc.mutex.RUnlock()
-	
-	if result, exists := irc.cache[ip]; exists {
-		// Проверка TTL
-		if time.Since(result.Timestamp) < irc.cacheTTL {
-			return result
-		}
-		// Удаление устаревшей записи
-		delete(irc.cache, ip)
-	}
-	
-	return nil
-}
-
-// setCachedResult сохраняет результат в кеш
-func (irc *IPRangeChecker) setCachedResult(ip string, result *IPCheckResult) {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	
-	// Проверка размера кеша
-	if len(irc.cache) >= irc.maxCache {
-		irc.cleanupCache()
-	}
-	
-	irc.cache[ip] = result
-	
-	if irc.debug != nil {
-		irc.debug.LogCacheOperation(&CacheDebugInfo{
-			Key:       ip,
-			Operation: "set",
-			Hit:       false,
-			Value:     result,
-			TTL:       irc.cacheTTL,
-			Timestamp: time.Now(),
-		})
-	}
-}
-
-// cleanupCache очищает старые записи из кеша
-func (irc *IPRangeChecker) cleanupCache() {
-	now := time.Now()
-	
-	for key, result := range irc.cache {
-		if now.Sub(result.Timestamp) > irc.cacheTTL {
-			delete(irc.cache, key)
-		}
-	}
-	
-	// Если кеш все еще переполнен, удаляем самые старые записи
-	if len(irc.cache) >= irc.maxCache {
-		// Простая стратегия: удаляем половину записей
-		count := 0
-		target := len(irc.cache) / 2
-		
-		for key := range irc.cache {
-			if count >= target {
-				break
-			}
-			delete(irc.cache, key)
-			count++
-		}
-	}
-}
-
 // AddRange добавляет новый IP диапазон в runtime
 func (irc *IPRangeChecker) AddRange(rangeStr string, metadata *IPRangeMetadata) error {
-	if rangeStr == "" {
-		return fmt.Errorf("empty range string")
+	rangeStr, err := normalizeIPRange(rangeStr)
+	if err != nil {
+		return err
 	}
 
 	irc.mutex.Lock()
 	defer irc.mutex.Unlock()
 
-	// Обработка одиночного IP
-	if !strings.Contains(rangeStr, "/") {
-		ip := net.ParseIP(rangeStr)
-		if ip == nil {
-			return fmt.Errorf("invalid IP address: %s", rangeStr)
-		}
-
-		if ip.To4() != nil {
-			irc.singleIPv4[rangeStr] = true
-		} else {
-			irc.singleIPv6[rangeStr] = true
-		}
-	} else {
-		// Обработка CIDR диапазона
-		_, ipNet, err := net.ParseCIDR(rangeStr)
-		if err != nil {
-			return fmt.Errorf("invalid CIDR range %s: %w", rangeStr, err)
-		}
-
-		if ipNet.IP.To4() != nil {
-			irc.ipv4Networks = append(irc.ipv4Networks, ipNet)
-		} else {
-			irc.ipv6Networks = append(irc.ipv6Networks, ipNet)
-		}
-
-		// Пересортировка сетей
-		irc.sortNetworks()
-	}
-
-	// Добавление метаданных
+	// Собираем новый набор с добавленным диапазоном и метаданными
+	current := irc.ranges.Load()
+	ranges := append(current.entries(), rangeStr)
+	rangeMetadata := maps.Clone(current.metadata)
 	if metadata != nil {
-		irc.rangeMetadata[rangeStr] = metadata
+		rangeMetadata[rangeStr] = metadata
 	}
+	irc.ranges.Store(irc.compileRanges(ranges, rangeMetadata))
 
 	// Очистка кеша после добавления нового диапазона
-	irc.cache = make(map[string]*IPCheckResult)
+	irc.cache.Clear()
 
 	irc.logger.Info("added new IP range",
 		zap.String("range", rangeStr),
@@ -455,52 +428,26 @@ func (irc *IPRangeChecker) AddRange(rangeStr string, metadata *IPRangeMetadata)
 
 // RemoveRange удаляет IP диапазон
 func (irc *IPRangeChecker) RemoveRange(rangeStr string) error {
+	rangeStr, err := normalizeIPRange(rangeStr)
+	if err != nil {
+		return err
+	}
+
 	irc.mutex.Lock()
 	defer irc.mutex.Unlock()
 
-	// Удаление одиночного IP
-	if !strings.Contains(rangeStr, "/") {
-		ip := net.ParseIP(rangeStr)
-		if ip == nil {
-			return fmt.Errorf("invalid IP address: %s", rangeStr)
-		}
-
-		if ip.To4() != nil {
-			delete(irc.singleIPv4, rangeStr)
-		} else {
-			delete(irc.singleIPv6, rangeStr)
-		}
-	} else {
-		// Удаление CIDR диапазона
-		_, targetNet, err := net.ParseCIDR(rangeStr)
-		if err != nil {
-			return fmt.Errorf("invalid CIDR range %s: %w", rangeStr, err)
-		}
-
-		if targetNet.IP.To4() != nil {
-			// Удаление из IPv4 сетей
-			for i, network := range irc.ipv4Networks {
-				if network.String() == rangeStr {
-					irc.ipv4Networks = append(irc.ipv4Networks[:i], irc.ipv4Networks[i+1:]...)
-					break
-				}
-			}
-		} else {
-			// Удаление из IPv6 сетей
-			for i, network := range irc.ipv6Networks {
-				if network.String() == rangeStr {
-					irc.ipv6Networks = append(irc.ipv6Networks[:i], irc.ipv6Networks[i+1:]...)
-					break
-				}
-			}
-		}
+	// Собираем новый набор без диапазона
+	current := irc.ranges.Load()
+	ranges := current.entries()
+	if i := slices.Index(ranges, rangeStr); i >= 0 {
+		ranges = slices.Delete(ranges, i, i+1)
 	}
-
-	// Удаление метаданных
-	delete(irc.rangeMetadata, rangeStr)
+	rangeMetadata := maps.Clone(current.metadata)
+	delete(rangeMetadata, rangeStr)
+	irc.ranges.Store(irc.compileRanges(ranges, rangeMetadata))
 
 	// Очистка кеша
-	irc.cache = make(map[string]*IPCheckResult)
+	irc.cache.Clear()
 
 	irc.logger.Info("removed IP range",
 		zap.String("range", rangeStr),
@@ -509,9 +456,9 @@ func (irc *IPRangeChecker) RemoveRange(rangeStr string) error {
 	return nil
 }
 
-// loadDefaultMetadata загружает метаданные по умолчанию для известных диапазонов
-func (irc *IPRangeChecker) loadDefaultMetadata() {
-	defaultMetadata := map[string]*IPRangeMetadata{
+// defaultRangeMetadata возвращает метаданные по умолчанию для известных диапазонов
+func defaultRangeMetadata() map[string]*IPRangeMetadata {
+	return map[string]*IPRangeMetadata{
 		// Google
 		"66.249.64.0/19": {
 			Organization: "Google LLC",
@@ -592,12 +539,6 @@ func (irc *IPRangeChecker) loadDefaultMetadata() {
 			LastUpdated:  time.Now(),
 		},
 	}
-
-	irc.mutex.Lock()
-	for rangeStr, metadata := range defaultMetadata {
-		irc.rangeMetadata[rangeStr] = metadata
-	}
-	irc.mutex.Unlock()
 }
 
 // Вспомогательные методы
@@ -616,82 +557,152 @@ func (irc *IPRangeChecker) getBotType(metadata *IPRangeMetadata) BotType {
 	return BotTypeUnknown
 }
 
-// GetStats возвращает статистику
-func (irc *IPRangeChecker) GetStats() map[string]interface{} {
-	irc.mutex.RLock()
-	defer irc.mutex.RUnlock()
+// normalizeIPRange проверяет CIDR или одиночный адрес и приводит его к каноническому виду
+func normalizeIPRange(rangeStr string) (string, error) {
+	rangeStr = strings.TrimSpace(rangeStr)
+	if rangeStr == "" {
+		return "", fmt.Errorf("empty range string")
+	}
+	
+	if !strings.Contains(rangeStr, "/") {
+		ip := net.ParseIP(rangeStr)
+		if ip == nil {
+			return "", fmt.Errorf("invalid IP address: %s", rangeStr)
+		}
+		return ip.String(), nil
+	}
+	
+	_, ipNet, err := net.ParseCIDR(rangeStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR range %s: %w", rangeStr, err)
+	}
+	return ipNet.String(), nil
+}
+
+// SetRanges заменяет список диапазонов целиком. Кеш не очищается: вызывающий удаляет
+// затронутые записи через purgePrefixes. Диапазоны проверяются до замены: при ошибке
+// действующий список не меняется.
+func (irc *IPRangeChecker) SetRanges(ranges []string) error {
+	for _, rangeStr := range ranges {
+		if _, err := normalizeIPRange(rangeStr); err != nil {
+			return err
+		}
+	}
 	
-	hitRate := 0.0
-	if irc.totalChecks > 0 {
-		hitRate = float64(irc.cacheHits) / float64(irc.totalChecks)
+	irc.mutex.Lock()
+	current := irc.ranges.Load()
+	irc.ranges.Store(irc.compileRanges(ranges, current.metadata))
+	irc.mutex.Unlock()
+	
+	irc.logger.Info("IP ranges replaced",
+		zap.Int("total_ranges", len(ranges)),
+	)
+	
+	return nil
+}
+
+// purgePrefixes удаляет из кеша результаты для адресов, входящих в любую из сетей
+func (irc *IPRangeChecker) purgePrefixes(prefixes []netip.Prefix) int {
+	if len(prefixes) == 0 {
+		return 0
 	}
 	
+	return irc.cache.DeleteFunc(func(ip string, _ *IPCheckResult) bool {
+		addr, err := netip.ParseAddr(canonicalHost(ip))
+		if err != nil {
+			return false
+		}
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// GetRanges возвращает отсортированный список диапазонов и одиночных адресов
+func (irc *IPRangeChecker) GetRanges() []string {
+	ranges := irc.ranges.Load().entries()
+	sort.Strings(ranges)
+	return ranges
+}
+
+// PurgeCache удаляет из кеша результаты для IP адреса (пустая строка - весь кеш)
+func (irc *IPRangeChecker) PurgeCache(ip string) int {
+	if ip == "" {
+		size := irc.cache.Len()
+		irc.cache.Clear()
+		return size
+	}
+	
+	target := canonicalHost(ip)
+	return irc.cache.DeleteFunc(func(key string, _ *IPCheckResult) bool {
+		return canonicalHost(key) == target
+	})
+}
+
+// Shutdown останавливает фоновую очистку кеша
+func (irc *IPRangeChecker) Shutdown() {
+	irc.cache.StopCleanup()
+}
+
+// GetStats возвращает статистику
+func (irc *IPRangeChecker) GetStats() map[string]interface{} {
+	set := irc.ranges.Load()
+	singleIPv4, singleIPv6 := set.singleCounts()
+	cacheStats := irc.cache.GetStats()
+	totalChecks := atomic.LoadInt64(&irc.totalChecks)
+	botDetections := atomic.LoadInt64(&irc.botDetections)
+	
 	detectionRate := 0.0
-	if irc.totalChecks > 0 {
-		detectionRate = float64(irc.botDetections) / float64(irc.totalChecks)
+	if totalChecks > 0 {
+		detectionRate = float64(botDetections) / float64(totalChecks)
 	}
 	
 	return map[string]interface{}{
-		"ipv4_networks":    len(irc.ipv4Networks),
-		"ipv6_networks":    len(irc.ipv6Networks),
-		"single_ipv4":      len(irc.singleIPv4),
-		"single_ipv6":      len(irc.singleIPv6),
-		"cache_size":       len(irc.cache),
-		"cache_max_size":   irc.maxCache,
-		"total_checks":     irc.totalChecks,
-		"bot_detections":   irc.botDetections,
-		"cache_hits":       irc.cacheHits,
-		"cache_hit_rate":   hitRate,
+		"ipv4_networks":    len(set.ipv4Networks.prefixes),
+		"ipv6_networks":    len(set.ipv6Networks.prefixes),
+		"single_ipv4":      singleIPv4,
+		"single_ipv6":      singleIPv6,
+		"cache_size":       cacheStats.Size,
+		"cache_max_size":   cacheStats.MaxSize,
+		"total_checks":     totalChecks,
+		"bot_detections":   botDetections,
+		"cache_hits":       cacheStats.Hits,
+		"cache_evictions":  cacheStats.Evictions,
+		"cache_hit_rate":   cacheStats.HitRate,
 		"detection_rate":   detectionRate,
-		"ipv4_checks":      irc.ipv4Checks,
-		"ipv6_checks":      irc.ipv6Checks,
-		"invalid_ips":      irc.invalidIPs,
-		"metadata_entries": len(irc.rangeMetadata),
+		"ipv4_checks":      atomic.LoadInt64(&irc.ipv4Checks),
+		"ipv6_checks":      atomic.LoadInt64(&irc.ipv6Checks),
+		"invalid_ips":      atomic.LoadInt64(&irc.invalidIPs),
+		"metadata_entries": len(set.metadata),
 	}
 }
 
 // ClearCache очищает кеш
 func (irc *IPRangeChecker) ClearCache() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	
-	irc.cache = make(map[string]*IPCheckResult)
+	irc.cache.Clear()
 	irc.logger.Info("IP range checker cache cleared")
 }
 
 // Методы для статистики
 func (irc *IPRangeChecker) incrementTotalChecks() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	irc.totalChecks++
+	atomic.AddInt64(&irc.totalChecks, 1)
 }
 
 func (irc *IPRangeChecker) incrementBotDetections() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	irc.botDetections++
-}
-
-func (irc *IPRangeChecker) incrementCacheHits() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	irc.cacheHits++
+	atomic.AddInt64(&irc.botDetections, 1)
 }
 
 func (irc *IPRangeChecker) incrementIPv4Checks() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	irc.ipv4Checks++
+	atomic.AddInt64(&irc.ipv4Checks, 1)
 }
 
 func (irc *IPRangeChecker) incrementIPv6Checks() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	irc.ipv6Checks++
+	atomic.AddInt64(&irc.ipv6Checks, 1)
 }
 
 func (irc *IPRangeChecker) incrementInvalidIPs() {
-	irc.mutex.Lock()
-	defer irc.mutex.Unlock()
-	irc.invalidIPs++
-}
\ No newline at end of file
+	atomic.AddInt64(&irc.invalidIPs, 1)
+}
@@ -0,0 +1,45 @@
+package botredirect
+
+import (
+	"net/netip"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestIPRangeCheckerSetRangesConcurrent заменяет диапазоны во время проверок
+// (запускать с -race). После последней замены в кеше не должно остаться результата
+// по предыдущему набору.
+func TestIPRangeCheckerSetRangesConcurrent(t *testing.T) {
+	withRange := []string{"198.51.100.0/24", "203.0.113.0/24"}
+	withoutRange := []string{"203.0.113.0/24"}
+	changed := []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}
+
+	config := DefaultConfig()
+	config.BotIPRanges = withoutRange
+	irc := NewIPRangeChecker(config, nil, nil, zap.NewNop())
+
+	for round := 0; round < 20; round++ {
+		runWithReaders(8, 200, func() {
+			irc.IsBot("198.51.100.7")
+			irc.IsBot("[2001:db8::1]:443")
+		}, func(i int) {
+			ranges := withoutRange
+			if i%2 == 0 {
+				ranges = withRange
+			}
+			if err := irc.SetRanges(ranges); err != nil {
+				t.Fatal(err)
+			}
+			irc.purgePrefixes(changed)
+		})
+
+		result, err := irc.IsBot("198.51.100.7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsBot {
+			t.Fatalf("round %d: stale cached verdict %+v after the range was removed", round, result)
+		}
+	}
+}
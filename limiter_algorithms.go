@@ -0,0 +1,300 @@
+package botredirect
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Clock источник времени для rate limiter (подменяется для детерминированной проверки)
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock системное время
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// LimiterAlgorithm алгоритм ограничения частоты запросов
+type LimiterAlgorithm string
+
+const (
+	LimiterTokenBucket   LimiterAlgorithm = "token_bucket"
+	LimiterSlidingWindow LimiterAlgorithm = "sliding_window"
+	LimiterGCRA          LimiterAlgorithm = "gcra"
+)
+
+func (la LimiterAlgorithm) String() string {
+	return string(la)
+}
+
+// ParseLimiterAlgorithm преобразует строку в LimiterAlgorithm
+func ParseLimiterAlgorithm(value string) (LimiterAlgorithm, error) {
+	switch algorithm := LimiterAlgorithm(value); algorithm {
+	case LimiterTokenBucket, LimiterSlidingWindow, LimiterGCRA:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit algorithm: %s", value)
+	}
+}
+
+// LimitResult состояние лимита после проверки запроса
+type LimitResult struct {
+	Allowed bool
+
+	// Сколько запросов еще можно выполнить сейчас
+	Remaining int
+
+	// Через сколько будет разрешен следующий запрос (для отклоненных)
+	RetryAfter time.Duration
+
+	// Через сколько лимит восстановится полностью
+	Reset time.Duration
+}
+
+// Limiter состояние лимита для одного ключа.
+// Все алгоритмы пропускают в среднем limit запросов за window.
+type Limiter interface {
+	// Allow проверяет запрос в момент now и учитывает его, если он разрешен
+	Allow(now time.Time) LimitResult
+
+	// Idle сообщает, что состояние не отличается от нового и ключ можно удалить
+	Idle(now time.Time) bool
+}
+
+// NewLimiter создает состояние лимита выбранным алгоритмом
+func NewLimiter(algorithm LimiterAlgorithm, limit int, window time.Duration, now time.Time) Limiter {
+	if limit < 1 {
+		limit = 1
+	}
+	if window <= 0 {
+		window = time.Second
+	}
+
+	switch algorithm {
+	case LimiterSlidingWindow:
+		return &SlidingWindow{
+			limit:       limit,
+			window:      window,
+			windowStart: now,
+		}
+	case LimiterGCRA:
+		return &GCRA{
+			interval:  window / time.Duration(limit),
+			tolerance: window / time.Duration(limit) * time.Duration(limit-1),
+			tat:       now,
+		}
+	default:
+		return &TokenBucket{
+			capacity:   float64(limit),
+			tokens:     float64(limit),
+			refillRate: float64(limit) / window.Seconds(),
+			lastRefill: now,
+		}
+	}
+}
+
+// TokenBucket реализует алгоритм token bucket: емкость limit, пополнение limit токенов за window
+type TokenBucket struct {
+	capacity   float64
+	tokens     float64
+	refillRate float64 // токенов в секунду
+	lastRefill time.Time
+	mutex      sync.Mutex
+}
+
+// Allow проверяет, можно ли выполнить запрос (потребляет один токен)
+func (tb *TokenBucket) Allow(now time.Time) LimitResult {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	// Пополняем токены на основе прошедшего времени
+	tb.refill(now)
+
+	result := LimitResult{}
+	if tb.tokens >= 1 {
+		tb.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = tb.timeFor(1 - tb.tokens)
+	}
+
+	result.Remaining = int(math.Floor(tb.tokens))
+	result.Reset = tb.timeFor(tb.capacity - tb.tokens)
+	return result
+}
+
+// timeFor возвращает время накопления заданного количества токенов
+func (tb *TokenBucket) timeFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / tb.refillRate * float64(time.Second))
+}
+
+// Idle сообщает, что bucket полностью пополнен
+func (tb *TokenBucket) Idle(now time.Time) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.refill(now)
+	return tb.tokens >= tb.capacity
+}
+
+// refill пополняет токены пропорционально прошедшему времени
+func (tb *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.lastRefill)
+	if elapsed <= 0 {
+		return
+	}
+
+	tb.tokens += elapsed.Seconds() * tb.refillRate
+	if tb.tokens > tb.capacity {
+		tb.tokens = tb.capacity
+	}
+	tb.lastRefill = now
+}
+
+// SlidingWindow реализует скользящее окно на двух счетчиках: количество запросов
+// предыдущего окна учитывается пропорционально его перекрытию с текущим моментом
+type SlidingWindow struct {
+	limit         int
+	window        time.Duration
+	windowStart   time.Time
+	currentCount  int
+	previousCount int
+	mutex         sync.Mutex
+}
+
+// Allow проверяет, укладывается ли запрос в оценку скользящего окна
+func (sw *SlidingWindow) Allow(now time.Time) LimitResult {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	sw.advance(now)
+
+	elapsed := now.Sub(sw.windowStart)
+	weight := 1 - float64(elapsed)/float64(sw.window)
+	estimated := float64(sw.previousCount)*weight + float64(sw.currentCount)
+
+	result := LimitResult{}
+	if estimated+1 > float64(sw.limit) {
+		result.RetryAfter = sw.retryAfter(elapsed)
+	} else {
+		sw.currentCount++
+		estimated++
+		result.Allowed = true
+	}
+
+	result.Remaining = int(math.Floor(float64(sw.limit) - estimated))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	// Текущее окно полностью выходит из оценки в конце следующего
+	switch {
+	case sw.currentCount > 0:
+		result.Reset = 2*sw.window - elapsed
+	case sw.previousCount > 0:
+		result.Reset = sw.window - elapsed
+	}
+	return result
+}
+
+// retryAfter вычисляет, когда оценка окна опустится ниже лимита
+func (sw *SlidingWindow) retryAfter(elapsed time.Duration) time.Duration {
+	window := float64(sw.window)
+	free := float64(sw.limit - sw.currentCount - 1)
+
+	// Место освободится в текущем окне по мере ухода предыдущего
+	if free >= 0 && sw.previousCount > 0 {
+		wait := window*(1-free/float64(sw.previousCount)) - float64(elapsed)
+		if wait < 0 {
+			wait = 0
+		}
+		return time.Duration(wait)
+	}
+
+	// Текущее окно заполнено: ждем, пока оно станет предыдущим и частично уйдет
+	wait := window - float64(elapsed)
+	if sw.currentCount > 0 {
+		wait += window * (1 - float64(sw.limit-1)/float64(sw.currentCount))
+	}
+	return time.Duration(wait)
+}
+
+// Idle сообщает, что оба окна пусты
+func (sw *SlidingWindow) Idle(now time.Time) bool {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	sw.advance(now)
+	return sw.currentCount == 0 && sw.previousCount == 0
+}
+
+// advance сдвигает окна к моменту now
+func (sw *SlidingWindow) advance(now time.Time) {
+	elapsed := now.Sub(sw.windowStart)
+	if elapsed < sw.window {
+		return
+	}
+
+	windows := elapsed / sw.window
+	if windows == 1 {
+		sw.previousCount = sw.currentCount
+	} else {
+		sw.previousCount = 0
+	}
+	sw.currentCount = 0
+	sw.windowStart = sw.windowStart.Add(windows * sw.window)
+}
+
+// GCRA реализует Generic Cell Rate Algorithm: один запрос за interval
+// с допуском всплеска до limit запросов
+type GCRA struct {
+	interval  time.Duration
+	tolerance time.Duration
+	tat       time.Time // теоретическое время прибытия следующего запроса
+	mutex     sync.Mutex
+}
+
+// Allow проверяет, не опережает ли запрос расписание больше чем на tolerance
+func (g *GCRA) Allow(now time.Time) LimitResult {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	result := LimitResult{}
+	if ahead := tat.Sub(now); ahead > g.tolerance {
+		result.RetryAfter = ahead - g.tolerance
+	} else {
+		g.tat = tat.Add(g.interval)
+		result.Allowed = true
+	}
+
+	ahead := g.tat.Sub(now)
+	if ahead < 0 {
+		ahead = 0
+	}
+	if ahead <= g.tolerance+g.interval {
+		result.Remaining = int((g.tolerance + g.interval - ahead) / g.interval)
+	}
+	result.Reset = ahead
+	return result
+}
+
+// Idle сообщает, что расписание не опережает текущий момент
+func (g *GCRA) Idle(now time.Time) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return !g.tat.After(now)
+}
@@ -0,0 +1,264 @@
+package botredirect
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeClock управляемый источник времени для тестов
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	c.now = now
+	c.mutex.Unlock()
+}
+
+// newTestRateLimiter создает rate limiter по конфигурации, которая должна быть корректной
+func newTestRateLimiter(t *testing.T, config *Config) *RateLimiter {
+	t.Helper()
+
+	rl, err := NewRateLimiter(config, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rl
+}
+
+// limiterBurst серия запросов в момент at от начала и ожидаемый результат
+type limiterBurst struct {
+	at       time.Duration
+	requests int
+
+	allowed int
+
+	// Состояние после последнего запроса серии
+	remaining  int
+	retryAfter time.Duration
+}
+
+func TestLimiterAlgorithms(t *testing.T) {
+	tests := []struct {
+		algorithm LimiterAlgorithm
+		bursts    []limiterBurst
+	}{
+		{
+			// 100 в минуту: пополнение 1 токен за 600ms
+			algorithm: LimiterTokenBucket,
+			bursts: []limiterBurst{
+				{at: 0, requests: 150, allowed: 100, remaining: 0, retryAfter: 600 * time.Millisecond},
+				{at: 30 * time.Second, requests: 150, allowed: 50, remaining: 0, retryAfter: 600 * time.Millisecond},
+				{at: 60 * time.Second, requests: 10, allowed: 10, remaining: 40},
+			},
+		},
+		{
+			// Предыдущее окно учитывается пропорционально перекрытию
+			algorithm: LimiterSlidingWindow,
+			bursts: []limiterBurst{
+				{at: 0, requests: 150, allowed: 100, remaining: 0, retryAfter: 60600 * time.Millisecond},
+				{at: 30 * time.Second, requests: 10, allowed: 0, remaining: 0, retryAfter: 30600 * time.Millisecond},
+				{at: 60 * time.Second, requests: 10, allowed: 0, remaining: 0, retryAfter: 600 * time.Millisecond},
+				{at: 90 * time.Second, requests: 150, allowed: 50, remaining: 0, retryAfter: 600 * time.Millisecond},
+			},
+		},
+		{
+			// Один запрос за 600ms с допуском всплеска до 100
+			algorithm: LimiterGCRA,
+			bursts: []limiterBurst{
+				{at: 0, requests: 150, allowed: 100, remaining: 0, retryAfter: 600 * time.Millisecond},
+				{at: 30 * time.Second, requests: 150, allowed: 50, remaining: 0, retryAfter: 600 * time.Millisecond},
+				{at: 60 * time.Second, requests: 10, allowed: 10, remaining: 40},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm.String(), func(t *testing.T) {
+			clock := newFakeClock()
+			start := clock.Now()
+			limiter := NewLimiter(tt.algorithm, 100, time.Minute, start)
+
+			for _, burst := range tt.bursts {
+				clock.Set(start.Add(burst.at))
+
+				allowed := 0
+				var last LimitResult
+				for i := 0; i < burst.requests; i++ {
+					last = limiter.Allow(clock.Now())
+					if last.Allowed {
+						allowed++
+					}
+				}
+
+				if allowed != burst.allowed {
+					t.Errorf("t+%v: allowed %d of %d, want %d", burst.at, allowed, burst.requests, burst.allowed)
+				}
+				if last.Remaining != burst.remaining {
+					t.Errorf("t+%v: remaining %d, want %d", burst.at, last.Remaining, burst.remaining)
+				}
+				if !approxDuration(last.RetryAfter, burst.retryAfter) {
+					t.Errorf("t+%v: retry after %v, want %v", burst.at, last.RetryAfter, burst.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+// TestRateLimiterWindowRefill проверяет, что пополнение учитывает rate_limit_window:
+// раньше bucket пополнялся max_requests_per_ip раз в секунду при любом окне
+func TestRateLimiterWindowRefill(t *testing.T) {
+	// Запросов, восстановившихся через секунду после исчерпания лимита 100 в минуту
+	refilled := map[LimiterAlgorithm]int{
+		LimiterTokenBucket:   1,
+		LimiterSlidingWindow: 0,
+		LimiterGCRA:          1,
+	}
+
+	for algorithm, want := range refilled {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			config := DefaultConfig()
+			config.MaxRequestsPerIP = 100
+			config.RateLimitWindow = time.Minute
+			config.RateLimitAlgorithm = algorithm.String()
+
+			rl := newTestRateLimiter(t, config)
+			defer rl.Shutdown()
+
+			clock := newFakeClock()
+			start := clock.Now()
+			rl.SetClock(clock)
+
+			for i := 0; i < 100; i++ {
+				if !rl.CheckRequest("203.0.113.7:1234") {
+					t.Fatalf("request %d denied within limit", i+1)
+				}
+			}
+
+			clock.Set(start.Add(time.Second))
+			allowed := 0
+			for i := 0; i < 100; i++ {
+				if rl.CheckRequest("203.0.113.7:1234") {
+					allowed++
+				}
+			}
+			if allowed != want {
+				t.Errorf("allowed %d requests one second after exhausting a per-minute limit, want %d", allowed, want)
+			}
+
+			decision := rl.Allow(&RateLimitSubject{IP: "203.0.113.7:1234"})
+			if decision.Allowed || decision.Window != time.Minute || decision.RetryAfter <= 0 {
+				t.Errorf("decision = %+v, want denied with window 1m and retry after", decision)
+			}
+		})
+	}
+}
+
+// TestRateLimiterSetClockConcurrent подменяет источник времени во время проверок (запускать с -race)
+func TestRateLimiterSetClockConcurrent(t *testing.T) {
+	rl := newTestRateLimiter(t, DefaultConfig())
+	defer rl.Shutdown()
+
+	clock := newFakeClock()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					rl.CheckRequest("203.0.113.7")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 {
+			rl.SetClock(clock)
+		} else {
+			rl.SetClock(systemClock{})
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// TestRateLimiterUpdateLimitsConcurrent меняет лимиты во время проверок (запускать с -race)
+func TestRateLimiterUpdateLimitsConcurrent(t *testing.T) {
+	rl := newTestRateLimiter(t, DefaultConfig())
+	defer rl.Shutdown()
+
+	runWithReaders(8, 1000, func() {
+		rl.CheckRequest("203.0.113.7")
+		rl.AllowKey("policy|203.0.113.7")
+		rl.CheckDNSRequest("203.0.113.7")
+	}, func(i int) {
+		rl.UpdateLimits(100+i%2, 10, time.Minute)
+	})
+}
+
+// TestRateLimiterCrawlerIdentity проверяет, что подтвержденный краулер делит bucket
+// на весь пул адресов только на уровне с bot_names
+func TestRateLimiterCrawlerIdentity(t *testing.T) {
+	config := DefaultConfig()
+	config.RateLimitTiers = []RateLimitTier{
+		{Name: "googlebot", BotNames: []string{"googlebot"}, Verified: true},
+		{Name: "search", BotTypes: []string{"search"}, Verified: true},
+	}
+	rl := newTestRateLimiter(t, config)
+	defer rl.Shutdown()
+
+	tests := []struct {
+		subject *RateLimitSubject
+		wantKey string
+	}{
+		{
+			subject: &RateLimitSubject{IP: "66.249.66.1:1234", BotName: "Googlebot", BotType: BotTypeSearch, Verified: true},
+			wantKey: "googlebot|bot:googlebot",
+		},
+		{
+			subject: &RateLimitSubject{IP: "157.55.39.1:1234", BotName: "Bingbot", BotType: BotTypeSearch, Verified: true},
+			wantKey: "search|bot:bingbot|ip:157.55.39.1",
+		},
+		{
+			subject: &RateLimitSubject{IP: "203.0.113.7:1234", BotName: "AhrefsBot", BotType: BotTypeSEO, Verified: true},
+			wantKey: "bot:ahrefsbot|ip:203.0.113.7",
+		},
+		{
+			subject: &RateLimitSubject{IP: "203.0.113.8:1234", BotName: "Googlebot", BotType: BotTypeSearch},
+			wantKey: "bot:googlebot|ip:203.0.113.8",
+		},
+	}
+
+	for _, tt := range tests {
+		if decision := rl.Allow(tt.subject); decision.Key != tt.wantKey {
+			t.Errorf("%s from %s: key = %q, want %q", tt.subject.BotName, tt.subject.IP, decision.Key, tt.wantKey)
+		}
+	}
+}
+
+// approxDuration сравнивает длительности с точностью до микросекунды (ошибки округления float64)
+func approxDuration(got, want time.Duration) bool {
+	diff := got - want
+	return diff > -time.Microsecond && diff < time.Microsecond
+}
@@ -0,0 +1,461 @@
+package botredirect
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Хранилища состояния rate limiter
+const (
+	LimiterStorageMemory = "memory"
+	LimiterStorageRedis  = "redis"
+)
+
+// Поведение при недоступности хранилища
+const (
+	LimiterFailOpen   = "open"
+	LimiterFailClosed = "closed"
+)
+
+// LimiterStore хранилище состояния лимитов по ключам.
+// Реализация должна атомарно проверять и учитывать запрос.
+type LimiterStore interface {
+	// Take проверяет запрос для ключа и учитывает его, если он разрешен
+	Take(key string, algorithm LimiterAlgorithm, limit int, window time.Duration, now time.Time) (LimitResult, error)
+
+	// Cleanup удаляет неиспользуемые состояния и возвращает количество оставшихся
+	Cleanup(now time.Time) int
+
+	// Reset удаляет все состояния
+	Reset() error
+
+	// DeleteMatching удаляет состояния ключей, для которых match возвращает true,
+	// и возвращает количество удаленных ключей
+	DeleteMatching(match func(key string) bool) (int, error)
+
+	// Close освобождает ресурсы хранилища
+	Close() error
+
+	// GetStats возвращает статистику хранилища
+	GetStats() map[string]interface{}
+}
+
+// limiterStoreShards количество шардов хранилища в памяти (степень двойки)
+const limiterStoreShards = 64
+
+// memoryLimiterStore хранит состояния в памяти процесса.
+// Ключи распределяются по шардам по хешу, у каждого шарда своя блокировка и LRU список,
+// поэтому запросы разных клиентов не сериализуются одной блокировкой, а очистка
+// блокирует шарды по одному. При достижении maxEntries вытесняется давно не
+// использованное состояние шарда.
+type memoryLimiterStore struct {
+	shards    [limiterStoreShards]limiterShard
+	shardSize int // 0 - без ограничения
+
+	evictions int64
+}
+
+// limiterShard часть хранилища под отдельной блокировкой
+type limiterShard struct {
+	mutex   sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List // начало - недавно использованные
+}
+
+// limiterEntry элемент LRU списка
+type limiterEntry struct {
+	key     string
+	limiter Limiter
+}
+
+// NewLimiterStore создает хранилище лимитов запросов по rate_limit_storage
+func NewLimiterStore(config *Config) (LimiterStore, error) {
+	if config.RateLimitStorage == LimiterStorageRedis {
+		return newRedisLimiterStore(config.RateLimitRedisURL, config.RateLimitRedisPrefix, config.RateLimitRedisTimeout)
+	}
+	return newMemoryLimiterStore(config.RateLimitMaxBuckets), nil
+}
+
+// newMemoryLimiterStore создает хранилище в памяти; maxEntries <= 0 снимает ограничение размера
+func newMemoryLimiterStore(maxEntries int) *memoryLimiterStore {
+	s := &memoryLimiterStore{}
+	if maxEntries > 0 {
+		s.shardSize = (maxEntries + limiterStoreShards - 1) / limiterStoreShards
+	}
+	for i := range s.shards {
+		s.shards[i].buckets = make(map[string]*list.Element)
+		s.shards[i].lru = list.New()
+	}
+	return s
+}
+
+// shard выбирает шард по FNV-1a хешу ключа
+func (s *memoryLimiterStore) shard(key string) *limiterShard {
+	return &s.shards[hashString(key)&(limiterStoreShards-1)]
+}
+
+// Take проверяет запрос; состояние создается выбранным алгоритмом при первом запросе.
+// Блокировка шарда удерживается только на время поиска: у состояния своя блокировка.
+func (s *memoryLimiterStore) Take(key string, algorithm LimiterAlgorithm, limit int, window time.Duration, now time.Time) (LimitResult, error) {
+	shard := s.shard(key)
+
+	shard.mutex.Lock()
+	var limiter Limiter
+	if element, exists := shard.buckets[key]; exists {
+		shard.lru.MoveToFront(element)
+		limiter = element.Value.(*limiterEntry).limiter
+	} else {
+		limiter = NewLimiter(algorithm, limit, window, now)
+		shard.buckets[key] = shard.lru.PushFront(&limiterEntry{key: key, limiter: limiter})
+
+		// Под давлением вытесняем давно не использованные состояния
+		for s.shardSize > 0 && shard.lru.Len() > s.shardSize {
+			oldest := shard.lru.Back()
+			shard.lru.Remove(oldest)
+			delete(shard.buckets, oldest.Value.(*limiterEntry).key)
+			atomic.AddInt64(&s.evictions, 1)
+		}
+	}
+	shard.mutex.Unlock()
+
+	return limiter.Allow(now), nil
+}
+
+// Cleanup удаляет состояния, которые не отличаются от новых, блокируя шарды по одному
+func (s *memoryLimiterStore) Cleanup(now time.Time) int {
+	total := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+
+		shard.mutex.Lock()
+		for key, element := range shard.buckets {
+			if element.Value.(*limiterEntry).limiter.Idle(now) {
+				shard.lru.Remove(element)
+				delete(shard.buckets, key)
+			}
+		}
+		total += len(shard.buckets)
+		shard.mutex.Unlock()
+	}
+	return total
+}
+
+// Len возвращает количество состояний
+func (s *memoryLimiterStore) Len() int {
+	total := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		total += len(shard.buckets)
+		shard.mutex.Unlock()
+	}
+	return total
+}
+
+// Reset удаляет все состояния
+func (s *memoryLimiterStore) Reset() error {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		shard.buckets = make(map[string]*list.Element)
+		shard.lru.Init()
+		shard.mutex.Unlock()
+	}
+	return nil
+}
+
+// DeleteMatching удаляет состояния выбранных ключей, блокируя шарды по одному
+func (s *memoryLimiterStore) DeleteMatching(match func(key string) bool) (int, error) {
+	deleted := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+
+		shard.mutex.Lock()
+		for key, element := range shard.buckets {
+			if match(key) {
+				shard.lru.Remove(element)
+				delete(shard.buckets, key)
+				deleted++
+			}
+		}
+		shard.mutex.Unlock()
+	}
+	return deleted, nil
+}
+
+// Close ничего не делает для хранилища в памяти
+func (s *memoryLimiterStore) Close() error {
+	return nil
+}
+
+// GetStats возвращает статистику хранилища
+func (s *memoryLimiterStore) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        LimiterStorageMemory,
+		"buckets":     s.Len(),
+		"shards":      limiterStoreShards,
+		"max_buckets": s.shardSize * limiterStoreShards,
+		"evictions":   atomic.LoadInt64(&s.evictions),
+	}
+}
+
+// gcraScript атомарно проверяет GCRA расписание ключа.
+// ARGV: текущее время, интервал и допуск в микросекундах.
+// Возвращает {разрешен, опережение расписания, время до повтора} в микросекундах.
+var gcraScript = newRedisScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local tolerance = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+  tat = now
+end
+if tat - now > tolerance then
+  return {0, tat - now, tat - now - tolerance}
+end
+tat = tat + interval
+redis.call('SET', KEYS[1], string.format('%d', tat), 'PX', math.ceil((tat - now) / 1000))
+return {1, tat - now, 0}
+`)
+
+// redisLimiterStore хранит состояния в Redis/Valkey и делит лимит между всеми узлами.
+//
+// token_bucket и gcra выполняются Lua скриптом GCRA: bucket емкостью limit с пополнением
+// limit за window пропускает ту же последовательность запросов, что и GCRA с допуском
+// limit-1 интервалов, но хранит одно значение на ключ. sliding_window использует
+// счетчики окон INCR+PEXPIRE. Время берется из часов узла, поэтому узлы должны быть
+// синхронизированы (NTP).
+type redisLimiterStore struct {
+	client *redisClient
+	prefix string
+	url    string
+
+	requests int64
+	errors   int64
+}
+
+// newRedisLimiterStore создает хранилище Redis
+func newRedisLimiterStore(rawURL, prefix string, timeout time.Duration) (*redisLimiterStore, error) {
+	client, err := newRedisClient(rawURL, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisLimiterStore{
+		client: client,
+		prefix: prefix,
+		url:    client.address,
+	}, nil
+}
+
+// Take проверяет запрос в Redis
+func (s *redisLimiterStore) Take(key string, algorithm LimiterAlgorithm, limit int, window time.Duration, now time.Time) (LimitResult, error) {
+	if limit < 1 {
+		limit = 1
+	}
+	if window <= 0 {
+		window = time.Second
+	}
+
+	atomic.AddInt64(&s.requests, 1)
+
+	var result LimitResult
+	var err error
+	if algorithm == LimiterSlidingWindow {
+		result, err = s.takeSlidingWindow(key, limit, window, now)
+	} else {
+		result, err = s.takeGCRA(key, limit, window, now)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+	return result, err
+}
+
+// takeGCRA выполняет GCRA скрипт
+func (s *redisLimiterStore) takeGCRA(key string, limit int, window time.Duration, now time.Time) (LimitResult, error) {
+	interval := window / time.Duration(limit)
+	if interval < time.Microsecond {
+		interval = time.Microsecond
+	}
+	tolerance := interval * time.Duration(limit-1)
+
+	reply, err := s.client.EvalScript(gcraScript, []string{s.prefix + "gcra:" + key},
+		strconv.FormatInt(now.UnixMicro(), 10),
+		strconv.FormatInt(interval.Microseconds(), 10),
+		strconv.FormatInt(tolerance.Microseconds(), 10),
+	)
+	if err != nil {
+		return LimitResult{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return LimitResult{}, fmt.Errorf("redis: unexpected gcra reply %v", reply)
+	}
+
+	var numbers [3]int64
+	for i, value := range values {
+		if numbers[i], err = redisInt(value); err != nil {
+			return LimitResult{}, err
+		}
+	}
+
+	ahead := time.Duration(numbers[1]) * time.Microsecond
+	result := LimitResult{
+		Allowed:    numbers[0] == 1,
+		RetryAfter: time.Duration(numbers[2]) * time.Microsecond,
+		Reset:      ahead,
+	}
+	if ahead <= tolerance+interval {
+		result.Remaining = int((tolerance + interval - ahead) / interval)
+	}
+	return result, nil
+}
+
+// takeSlidingWindow учитывает запрос в счетчике текущего окна (INCR+PEXPIRE)
+// и оценивает скользящее окно по предыдущему счетчику
+func (s *redisLimiterStore) takeSlidingWindow(key string, limit int, window time.Duration, now time.Time) (LimitResult, error) {
+	index := now.UnixNano() / int64(window)
+	currentKey := s.prefix + "sw:" + key + ":" + strconv.FormatInt(index, 10)
+	previousKey := s.prefix + "sw:" + key + ":" + strconv.FormatInt(index-1, 10)
+
+	// Счетчик живет два окна: текущее и следующее, где он станет предыдущим
+	replies, err := s.client.Pipeline([][]string{
+		{"MULTI"},
+		{"INCR", currentKey},
+		{"PEXPIRE", currentKey, strconv.FormatInt((2*window).Milliseconds()+1, 10)},
+		{"GET", previousKey},
+		{"EXEC"},
+	})
+	if err != nil {
+		return LimitResult{}, err
+	}
+
+	execReply, ok := replies[4].([]interface{})
+	if !ok || len(execReply) != 3 {
+		if replyErr, isErr := replies[4].(redisError); isErr {
+			return LimitResult{}, replyErr
+		}
+		return LimitResult{}, fmt.Errorf("redis: transaction aborted")
+	}
+
+	current, err := redisInt(execReply[0])
+	if err != nil {
+		return LimitResult{}, err
+	}
+	previous, err := redisInt(execReply[2])
+	if err != nil {
+		return LimitResult{}, err
+	}
+
+	// Оценку и время повтора считает тот же алгоритм, что и в памяти
+	estimate := &SlidingWindow{
+		limit:         limit,
+		window:        window,
+		windowStart:   time.Unix(0, index*int64(window)),
+		currentCount:  int(current - 1),
+		previousCount: int(previous),
+	}
+	result := estimate.Allow(now)
+
+	// Отклоненный запрос не должен расходовать лимит
+	if !result.Allowed {
+		if _, err := s.client.Do("DECR", currentKey); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Cleanup не требуется: ключи удаляются Redis по TTL
+func (s *redisLimiterStore) Cleanup(now time.Time) int {
+	return -1
+}
+
+// Reset удаляет все ключи с префиксом хранилища
+func (s *redisLimiterStore) Reset() error {
+	_, err := s.deleteKeys(nil)
+	return err
+}
+
+// DeleteMatching удаляет ключи Redis, относящиеся к выбранным ключам лимитов
+func (s *redisLimiterStore) DeleteMatching(match func(key string) bool) (int, error) {
+	return s.deleteKeys(match)
+}
+
+// limiterKey восстанавливает ключ лимита из ключа Redis (префикс, алгоритм и номер окна)
+func (s *redisLimiterStore) limiterKey(redisKey string) string {
+	key := strings.TrimPrefix(redisKey, s.prefix)
+	if rest, ok := strings.CutPrefix(key, "gcra:"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(key, "sw:"); ok {
+		if i := strings.LastIndexByte(rest, ':'); i >= 0 {
+			return rest[:i]
+		}
+		return rest
+	}
+	return key
+}
+
+// deleteKeys удаляет ключи с префиксом хранилища; match == nil удаляет все
+func (s *redisLimiterStore) deleteKeys(match func(key string) bool) (int, error) {
+	deleted := 0
+	cursor := "0"
+	for {
+		reply, err := s.client.Do("SCAN", cursor, "MATCH", s.prefix+"*", "COUNT", "1000")
+		if err != nil {
+			return deleted, err
+		}
+
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 2 {
+			return deleted, fmt.Errorf("redis: unexpected scan reply")
+		}
+		next, _ := values[0].([]byte)
+		keys, _ := values[1].([]interface{})
+
+		command := []string{"DEL"}
+		for _, key := range keys {
+			if keyBytes, ok := key.([]byte); ok && (match == nil || match(s.limiterKey(string(keyBytes)))) {
+				command = append(command, string(keyBytes))
+			}
+		}
+		if len(command) > 1 {
+			if _, err := s.client.Do(command...); err != nil {
+				return deleted, err
+			}
+			deleted += len(command) - 1
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return deleted, nil
+		}
+	}
+}
+
+// Close закрывает соединения
+func (s *redisLimiterStore) Close() error {
+	return s.client.Close()
+}
+
+// GetStats возвращает статистику хранилища
+func (s *redisLimiterStore) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        LimiterStorageRedis,
+		"address":     s.url,
+		"prefix":      s.prefix,
+		"requests":    atomic.LoadInt64(&s.requests),
+		"errors":      atomic.LoadInt64(&s.errors),
+		"connections": len(s.client.active),
+	}
+}
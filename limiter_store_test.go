@@ -0,0 +1,598 @@
+package botredirect
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// fakeRedis сервер RESP2 в процессе с командами, которые использует redisLimiterStore.
+// Lua скрипты выполняются встроенным интерпретатором с redis.call, KEYS и ARGV, как в Redis.
+type fakeRedis struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	data     map[string]string
+	scripts  map[string]string
+	commands []string
+	conns    map[net.Conn]bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeRedis{
+		listener: listener,
+		data:     make(map[string]string),
+		scripts:  make(map[string]string),
+		conns:    make(map[net.Conn]bool),
+	}
+	go server.serve()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// URL возвращает адрес сервера для rate_limit_redis_url
+func (s *fakeRedis) URL() string {
+	return "redis://" + s.listener.Addr().String()
+}
+
+// Close останавливает сервер и разрывает открытые соединения
+func (s *fakeRedis) Close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Commands возвращает имена полученных команд по порядку
+func (s *fakeRedis) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Get возвращает значение ключа
+func (s *fakeRedis) Get(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data[key]
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	var queued [][]string
+	inMulti := false
+	for {
+		request, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		items, _ := request.([]interface{})
+		args := make([]string, 0, len(items))
+		for _, item := range items {
+			value, _ := item.([]byte)
+			args = append(args, string(value))
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		name := strings.ToUpper(args[0])
+		s.record(name)
+		switch {
+		case name == "MULTI":
+			inMulti = true
+			writeFakeReply(writer, "OK")
+		case name == "EXEC":
+			replies := make([]interface{}, 0, len(queued))
+			for _, command := range queued {
+				replies = append(replies, s.execute(command))
+			}
+			queued, inMulti = nil, false
+			writeFakeReply(writer, replies)
+		case inMulti:
+			queued = append(queued, args)
+			writeFakeReply(writer, "QUEUED")
+		default:
+			writeFakeReply(writer, s.execute(args))
+		}
+
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeRedis) record(name string) {
+	s.mutex.Lock()
+	s.commands = append(s.commands, name)
+	s.mutex.Unlock()
+}
+
+// execute выполняет одну команду и возвращает ответ
+func (s *fakeRedis) execute(args []string) interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.executeLocked(args)
+}
+
+// executeLocked выполняет команду под s.mutex (в том числе redis.call из скрипта)
+func (s *fakeRedis) executeLocked(args []string) interface{} {
+	name := strings.ToUpper(args[0])
+
+	switch name {
+	case "GET":
+		if value, ok := s.data[args[1]]; ok {
+			return []byte(value)
+		}
+		return nil
+	case "SET":
+		s.data[args[1]] = args[2]
+		return "OK"
+	case "INCR", "DECR":
+		value, _ := strconv.ParseInt(s.data[args[1]], 10, 64)
+		if name == "INCR" {
+			value++
+		} else {
+			value--
+		}
+		s.data[args[1]] = strconv.FormatInt(value, 10)
+		return value
+	case "PEXPIRE":
+		return int64(1)
+	case "DEL":
+		deleted := int64(0)
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				deleted++
+			}
+		}
+		return deleted
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		keys := make([]interface{}, 0)
+		for key := range s.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}
+	case "EVALSHA":
+		source, ok := s.scripts[args[1]]
+		if !ok {
+			return redisError("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return s.eval(source, args[2:])
+	case "EVAL":
+		s.scripts[newRedisScript(args[1]).sha] = args[1]
+		return s.eval(args[1], args[2:])
+	default:
+		return redisError("ERR unknown command '" + args[0] + "'")
+	}
+}
+
+// eval выполняет Lua скрипт; args - количество ключей, ключи и аргументы
+func (s *fakeRedis) eval(source string, args []string) interface{} {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || numKeys > len(args)-1 {
+		return redisError("ERR Number of keys can't be greater than number of args")
+	}
+
+	state := lua.NewState()
+	defer state.Close()
+
+	state.SetGlobal("KEYS", luaStrings(state, args[1:1+numKeys]))
+	state.SetGlobal("ARGV", luaStrings(state, args[1+numKeys:]))
+
+	redis := state.NewTable()
+	state.SetField(redis, "call", state.NewFunction(func(L *lua.LState) int {
+		callArgs := make([]string, L.GetTop())
+		for i := range callArgs {
+			callArgs[i] = L.ToString(i + 1)
+		}
+		reply := s.executeLocked(callArgs)
+		if replyErr, ok := reply.(redisError); ok {
+			L.RaiseError("%s", string(replyErr))
+			return 0
+		}
+		L.Push(toLuaValue(L, reply))
+		return 1
+	}))
+	state.SetGlobal("redis", redis)
+
+	if err := state.DoString(source); err != nil {
+		return redisError("ERR " + err.Error())
+	}
+	if state.GetTop() == 0 {
+		return nil
+	}
+	return fromLuaValue(state.Get(-1))
+}
+
+// luaStrings создает Lua массив строк
+func luaStrings(state *lua.LState, values []string) *lua.LTable {
+	table := state.NewTable()
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
+
+// toLuaValue преобразует ответ команды в значение Lua (как redis.call)
+func toLuaValue(state *lua.LState, reply interface{}) lua.LValue {
+	switch value := reply.(type) {
+	case nil:
+		return lua.LFalse
+	case string:
+		status := state.NewTable()
+		state.SetField(status, "ok", lua.LString(value))
+		return status
+	case int64:
+		return lua.LNumber(value)
+	case []byte:
+		return lua.LString(value)
+	case []interface{}:
+		table := state.NewTable()
+		for _, item := range value {
+			table.Append(toLuaValue(state, item))
+		}
+		return table
+	default:
+		return lua.LNil
+	}
+}
+
+// fromLuaValue преобразует результат скрипта в ответ RESP2 (числа усекаются до целых, как в Redis)
+func fromLuaValue(value lua.LValue) interface{} {
+	switch value := value.(type) {
+	case lua.LNumber:
+		return int64(value)
+	case lua.LString:
+		return []byte(value)
+	case lua.LBool:
+		if value {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if status, ok := value.RawGetString("ok").(lua.LString); ok {
+			return string(status)
+		}
+		if message, ok := value.RawGetString("err").(lua.LString); ok {
+			return redisError(message)
+		}
+		items := make([]interface{}, 0, value.Len())
+		for i := 1; i <= value.Len(); i++ {
+			items = append(items, fromLuaValue(value.RawGetInt(i)))
+		}
+		return items
+	default:
+		return nil
+	}
+}
+
+// writeFakeReply кодирует ответ RESP2
+func writeFakeReply(w *bufio.Writer, reply interface{}) {
+	switch value := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + value + "\r\n")
+	case redisError:
+		w.WriteString("-" + string(value) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(value, 10) + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(value)) + "\r\n")
+		w.Write(value)
+		w.WriteString("\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(value)) + "\r\n")
+		for _, item := range value {
+			writeFakeReply(w, item)
+		}
+	}
+}
+
+func TestRedisLimiterStoreGCRA(t *testing.T) {
+	server := newFakeRedis(t)
+	store, err := newRedisLimiterStore(server.URL(), "test:", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := make([]LimitResult, 0, 4)
+	for i := 0; i < 4; i++ {
+		result, err := store.Take("client", LimiterGCRA, 3, 3*time.Second, now)
+		if err != nil {
+			t.Fatalf("take %d: %v", i+1, err)
+		}
+		results = append(results, result)
+	}
+
+	// Первый вызов загружает скрипт через EVAL после NOSCRIPT, следующие используют EVALSHA
+	want := []string{"EVALSHA", "EVAL", "EVALSHA", "EVALSHA", "EVALSHA"}
+	if got := server.Commands(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+
+	for i, remaining := range []int{2, 1, 0} {
+		if !results[i].Allowed || results[i].Remaining != remaining {
+			t.Errorf("take %d = %+v, want allowed with remaining %d", i+1, results[i], remaining)
+		}
+	}
+	if denied := results[3]; denied.Allowed || denied.RetryAfter != time.Second || denied.Reset != 3*time.Second {
+		t.Errorf("take 4 = %+v, want denied with retry after 1s and reset 3s", denied)
+	}
+
+	// Через интервал восстанавливается один запрос
+	result, err := store.Take("client", LimiterTokenBucket, 3, 3*time.Second, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("take after interval = %+v, want allowed with remaining 0", result)
+	}
+}
+
+func TestRedisLimiterStoreSlidingWindow(t *testing.T) {
+	server := newFakeRedis(t)
+	store, err := newRedisLimiterStore(server.URL(), "test:", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Начало окна: оценка не учитывает предыдущее окно
+	index := int64(29000000)
+	now := time.Unix(0, index*int64(time.Minute))
+
+	allowed := 0
+	var last LimitResult
+	for i := 0; i < 3; i++ {
+		last, err = store.Take("client", LimiterSlidingWindow, 2, time.Minute, now)
+		if err != nil {
+			t.Fatalf("take %d: %v", i+1, err)
+		}
+		if last.Allowed {
+			allowed++
+		}
+	}
+
+	if allowed != 2 {
+		t.Errorf("allowed %d of 3, want 2", allowed)
+	}
+	if last.RetryAfter != 90*time.Second {
+		t.Errorf("retry after %v, want 1m30s", last.RetryAfter)
+	}
+
+	// Отклоненный запрос возвращает счетчик обратно
+	counter := "test:sw:client:" + strconv.FormatInt(index, 10)
+	if value := server.Get(counter); value != "2" {
+		t.Errorf("counter %s = %q, want 2", counter, value)
+	}
+
+	transaction := "MULTI INCR PEXPIRE GET EXEC"
+	want := strings.Repeat(transaction+" ", 2) + transaction + " DECR"
+	if got := strings.Join(server.Commands(), " "); got != want {
+		t.Errorf("commands = %s, want %s", got, want)
+	}
+
+	// Следующее окно: предыдущее учитывается полностью в начале окна
+	result, err := store.Take("client", LimiterSlidingWindow, 2, time.Minute, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Errorf("take at next window start = %+v, want denied", result)
+	}
+
+	deleted, err := store.DeleteMatching(func(key string) bool { return key == "client" })
+	if err != nil || deleted != 2 {
+		t.Errorf("DeleteMatching = %d, %v, want 2 keys", deleted, err)
+	}
+}
+
+func TestRedisLimiterStoreFailMode(t *testing.T) {
+	tests := []struct {
+		failMode string
+		allowed  bool
+	}{
+		{failMode: LimiterFailOpen, allowed: true},
+		{failMode: LimiterFailClosed, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.failMode, func(t *testing.T) {
+			server := newFakeRedis(t)
+
+			config := DefaultConfig()
+			config.MaxRequestsPerIP = 5
+			config.RateLimitStorage = LimiterStorageRedis
+			config.RateLimitRedisURL = server.URL()
+			config.RateLimitFailMode = tt.failMode
+
+			store, err := NewLimiterStore(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.RateLimitStore = store
+
+			rl := newTestRateLimiter(t, config)
+			defer rl.Shutdown()
+
+			if decision := rl.Allow(&RateLimitSubject{IP: "198.51.100.1"}); !decision.Allowed || decision.Remaining != 4 {
+				t.Fatalf("decision with redis available = %+v, want allowed with remaining 4", decision)
+			}
+
+			// Соединение из пула разорвано, новые соединения отклоняются
+			server.Close()
+			for i := 0; i < 2; i++ {
+				decision := rl.Allow(&RateLimitSubject{IP: "198.51.100.1"})
+				if decision.Allowed != tt.allowed {
+					t.Errorf("request %d with redis down: allowed = %v, want %v", i+1, decision.Allowed, tt.allowed)
+				}
+				if !tt.allowed && decision.RetryAfter != time.Second {
+					t.Errorf("request %d with redis down: retry after %v, want 1s", i+1, decision.RetryAfter)
+				}
+			}
+
+			stats := rl.GetStats()
+			if errors := stats["storage_errors"].(int64); errors != 2 {
+				t.Errorf("storage errors = %d, want 2", errors)
+			}
+		})
+	}
+}
+
+// mutexLimiterStore базовая реализация для сравнения: одна карта под общей блокировкой
+type mutexLimiterStore struct {
+	mutex    sync.Mutex
+	limiters map[string]Limiter
+}
+
+func (s *mutexLimiterStore) Take(key string, algorithm LimiterAlgorithm, limit int, window time.Duration, now time.Time) (LimitResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	limiter, ok := s.limiters[key]
+	if !ok {
+		limiter = NewLimiter(algorithm, limit, window, now)
+		s.limiters[key] = limiter
+	}
+	return limiter.Allow(now), nil
+}
+
+// BenchmarkMemoryLimiterStore сравнивает шардированное хранилище с одной блокировкой;
+// запускать с -cpu 1,8,32
+func BenchmarkMemoryLimiterStore(b *testing.B) {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = "ip:198.51." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)
+	}
+	now := time.Now()
+
+	stores := []struct {
+		name  string
+		store interface {
+			Take(key string, algorithm LimiterAlgorithm, limit int, window time.Duration, now time.Time) (LimitResult, error)
+		}
+	}{
+		{name: "sharded", store: newMemoryLimiterStore(0)},
+		{name: "single-mutex", store: &mutexLimiterStore{limiters: make(map[string]Limiter)}},
+	}
+
+	for _, tt := range stores {
+		b.Run(tt.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					tt.store.Take(keys[i%len(keys)], LimiterTokenBucket, 100, time.Minute, now)
+					i += 7
+				}
+			})
+		})
+	}
+}
+
+// TestRedisClientMaxActiveConns проверяет, что клиент не открывает соединений сверх лимита
+// и ждет освобождения соединения не дольше таймаута
+func TestRedisClientMaxActiveConns(t *testing.T) {
+	server := newFakeRedis(t)
+	client, err := newRedisClient(server.URL(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.active = make(chan struct{}, 2)
+
+	first, err := client.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := client.Do("GET", "key"); err != errRedisPoolExhausted {
+		t.Fatalf("command with all connections busy: error = %v, want %v", err, errRedisPoolExhausted)
+	}
+	if waited := time.Since(start); waited < 200*time.Millisecond {
+		t.Errorf("gave up after %v, want to wait for the 200ms timeout", waited)
+	}
+
+	// Освободившееся соединение достается ожидающему запросу
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.put(first)
+	}()
+	if _, err := client.Do("GET", "key"); err != nil {
+		t.Fatalf("command after a connection was released: %v", err)
+	}
+	client.put(second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Do("INCR", "counter"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if open := len(client.active); open > 2 {
+		t.Errorf("%d connections open, want at most 2", open)
+	}
+	if got := server.Get("counter"); got != "16" {
+		t.Errorf("counter = %s, want 16", got)
+	}
+}
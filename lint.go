@@ -0,0 +1,279 @@
+package botredirect
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Уровни замечаний линтера
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+	LintInfo    = "info"
+)
+
+// lintSeverityOrder порядок вывода замечаний: сначала ошибки
+var lintSeverityOrder = map[string]int{
+	LintError:   0,
+	LintWarning: 1,
+	LintInfo:    2,
+}
+
+// lintBrowserUserAgents User-Agent распространенных браузеров: паттерн ботов,
+// совпадающий с ними, отправит обычных посетителей по ветке ботов
+var lintBrowserUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+	"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) YaBrowser/24.4.0.0 Chrome/122.0.0.0 Safari/537.36",
+}
+
+// LintIssue замечание линтера конфигурации или списков
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Source   string `json:"source"`
+	Value    string `json:"value,omitempty"`
+	Message  string `json:"message"`
+}
+
+// String форматирует замечание для вывода в консоль
+func (issue LintIssue) String() string {
+	if issue.Value == "" {
+		return fmt.Sprintf("%-7s %s: %s", issue.Severity, issue.Source, issue.Message)
+	}
+	return fmt.Sprintf("%-7s %s %q: %s", issue.Severity, issue.Source, issue.Value, issue.Message)
+}
+
+// Lint проверяет действующую конфигурацию и списки (после списков по умолчанию, файлов
+// списков и изменений через admin API) на ошибки, которые не мешают запуску, но приводят
+// к неверной классификации: паттерны, совпадающие с браузерами, дубликаты, перекрытые
+// записи, слишком широкие диапазоны, опции без эффекта.
+func (bd *BotDetector) Lint() []LintIssue {
+	var issues []LintIssue
+
+	issues = append(issues, bd.lintConfig()...)
+	if bd.userAgentMatcher != nil {
+		issues = append(issues, bd.userAgentMatcher.lint()...)
+	}
+	if bd.ipRangeChecker != nil {
+		issues = append(issues, lintIPRanges(bd.ipRangeChecker.GetRanges())...)
+	}
+	if bd.referrerChecker != nil && bd.referrerChecker.enabled {
+		issues = append(issues, bd.referrerChecker.lint()...)
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return lintSeverityOrder[issues[i].Severity] < lintSeverityOrder[issues[j].Severity]
+	})
+
+	return issues
+}
+
+// lintConfig проверяет сочетания опций, которые допустимы, но не работают так, как ожидается
+func (bd *BotDetector) lintConfig() []LintIssue {
+	config := bd.config
+	var issues []LintIssue
+
+	add := func(severity, option, message string) {
+		issues = append(issues, LintIssue{Severity: severity, Source: "config", Value: option, Message: message})
+	}
+
+	if !config.EnableReverseDNS && PolicyAction(config.UnverifiedBotAction) != PolicyActionLog {
+		add(LintWarning, "unverified_bot_action",
+			"reverse DNS is disabled: crawlers outside bot_ip_ranges cannot be verified and get the "+config.UnverifiedBotAction+" action")
+	}
+
+	if !config.EnableRateLimit && len(config.RateLimitTiers) > 0 {
+		add(LintWarning, "rate_limit_tier", "rate limit tiers have no effect without enable_rate_limit")
+	}
+
+	if !config.EnableReferrerCheck && len(config.AllowedReferrers) > 0 {
+		add(LintWarning, "allowed_referrers", "allowed referrers have no effect without enable_referrer_check")
+	}
+
+	if config.EnableDebug && config.LogAllRequests {
+		add(LintWarning, "log_all_requests", "every request is logged with all processing steps; not intended for production")
+	}
+
+	if config.BanThreshold > 0 && PolicyAction(config.UnverifiedBotAction) == PolicyActionLog &&
+		len(config.HoneypotPaths) == 0 && !config.EnableRateLimit {
+		add(LintWarning, "ban_threshold", "no offense source is enabled (rate limit, honeypot or unverified_bot_action), bans are never issued")
+	}
+
+	return issues
+}
+
+// lint проверяет паттерны User-Agent
+func (uam *UserAgentMatcher) lint() []LintIssue {
+	patterns := uam.GetPatterns()
+	var issues []LintIssue
+
+	add := func(severity, pattern, message string) {
+		issues = append(issues, LintIssue{Severity: severity, Source: ListUserAgents, Value: pattern, Message: message})
+	}
+
+	// Ядро простых паттернов вхождения (*bot*) для поиска перекрытых паттернов
+	cores := make(map[string]string)
+	var containsPatterns []string
+	for _, pattern := range patterns {
+		if !uam.isExactMatch(pattern) && uam.isSimpleContains(pattern) {
+			cores[pattern] = strings.ToLower(strings.Trim(pattern, "*"))
+			containsPatterns = append(containsPatterns, pattern)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, pattern := range patterns {
+		key := strings.ToLower(pattern)
+		if first, ok := seen[key]; ok {
+			add(LintWarning, pattern, fmt.Sprintf("duplicate of %q", first))
+			continue
+		}
+		seen[key] = pattern
+
+		if err := uam.validatePattern(pattern); err != nil {
+			add(LintError, pattern, err.Error())
+			continue
+		}
+
+		if core, ok := cores[pattern]; ok && len(core) < 3 {
+			add(LintWarning, pattern, "substring pattern is shorter than 3 characters")
+		}
+
+		match := uam.patternMatcher(pattern)
+		for _, browser := range lintBrowserUserAgents {
+			if match(browser) {
+				add(LintError, pattern, "matches a regular browser User-Agent: "+browser)
+				break
+			}
+		}
+
+		// Паттерн, все совпадения которого уже покрывает более общий паттерн вхождения
+		var own string
+		switch {
+		case uam.isExactMatch(pattern):
+			own = key
+		case cores[pattern] != "":
+			own = cores[pattern]
+		default:
+			continue
+		}
+		for _, other := range containsPatterns {
+			core := cores[other]
+			if other != pattern && core != own && core != "" && strings.Contains(own, core) {
+				add(LintInfo, pattern, fmt.Sprintf("redundant, every match is also matched by %q", other))
+				break
+			}
+		}
+	}
+
+	return issues
+}
+
+// lintIPRanges проверяет IP диапазоны ботов
+func lintIPRanges(ranges []string) []LintIssue {
+	var issues []LintIssue
+
+	add := func(severity, value, message string) {
+		issues = append(issues, LintIssue{Severity: severity, Source: ListIPRanges, Value: value, Message: message})
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+	seen := make(map[netip.Prefix]string)
+	for _, value := range ranges {
+		prefix, err := parseFirewallPrefix(value)
+		if err != nil {
+			add(LintError, value, err.Error())
+			continue
+		}
+		if first, ok := seen[prefix]; ok {
+			add(LintWarning, value, fmt.Sprintf("duplicate of %q", first))
+			continue
+		}
+		seen[prefix] = value
+		prefixes = append(prefixes, prefix)
+
+		addr := prefix.Addr()
+		switch {
+		case prefix.Bits() == 0:
+			add(LintError, value, "matches every address")
+		case addr.Is4() && prefix.Bits() < 8, addr.Is6() && prefix.Bits() < 16:
+			add(LintWarning, value, "range is very broad")
+		}
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() || addr.IsMulticast() {
+			add(LintWarning, value, "private or special-purpose range: internal clients are classified as verified bots")
+		}
+	}
+
+	for _, prefix := range prefixes {
+		for _, other := range prefixes {
+			if other != prefix && other.Bits() < prefix.Bits() && other.Contains(prefix.Addr()) {
+				add(LintInfo, seen[prefix], fmt.Sprintf("redundant, contained in %q", seen[other]))
+				break
+			}
+		}
+	}
+
+	return issues
+}
+
+// lint проверяет домены referrer
+func (rc *ReferrerChecker) lint() []LintIssue {
+	domains := rc.GetDomains()
+	var issues []LintIssue
+
+	add := func(severity, value, message string) {
+		issues = append(issues, LintIssue{Severity: severity, Source: ListReferrerDomains, Value: value, Message: message})
+	}
+
+	var patterns []string
+	matchers := make(map[string]func(hostname string) bool)
+	for _, domain := range domains {
+		if !rc.isExactDomain(domain) {
+			patterns = append(patterns, domain)
+			matchers[domain] = rc.domainMatcher(domain)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, domain := range domains {
+		key := strings.ToLower(domain)
+		if first, ok := seen[key]; ok {
+			add(LintWarning, domain, fmt.Sprintf("duplicate of %q", first))
+			continue
+		}
+		seen[key] = domain
+
+		if !rc.isExactDomain(domain) && !rc.isWildcardDomain(domain) {
+			if _, err := regexp.Compile("(?i)" + rc.convertToRegex(domain)); err != nil {
+				add(LintError, domain, err.Error())
+				continue
+			}
+		}
+
+		// Шаблон без домена второго уровня (*, *.com) пропускает любой referrer зоны
+		if strings.HasPrefix(domain, "*") && !strings.Contains(strings.TrimLeft(domain, "*."), ".") {
+			add(LintWarning, domain, "wildcard matches a whole top-level domain or every referrer")
+			continue
+		}
+
+		if !rc.isExactDomain(domain) {
+			continue
+		}
+		for _, other := range patterns {
+			if matchers[other](key) {
+				add(LintInfo, domain, fmt.Sprintf("redundant, also matched by %q", other))
+				break
+			}
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,573 @@
+package botredirect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Списки, изменяемые через admin API
+const (
+	ListUserAgents      = "user_agents"
+	ListIPRanges        = "ip_ranges"
+	ListReferrerDomains = "referrer_domains"
+	ListDNSPatterns     = "dns_patterns"
+)
+
+// Операции над списками
+const (
+	ListOpAdd    = "add"
+	ListOpRemove = "remove"
+)
+
+// listBotTypes типы ботов, для которых задаются паттерны доменов обратного DNS
+var listBotTypes = map[BotType]bool{
+	BotTypeSearch:     true,
+	BotTypeSocial:     true,
+	BotTypeCrawler:    true,
+	BotTypeMonitoring: true,
+	BotTypeSEO:        true,
+}
+
+// errListsNotSaved overlay не удалось сохранить, изменения не применены
+var errListsNotSaved = errors.New("saving lists overlay failed")
+
+// ListChange одно изменение списка
+type ListChange struct {
+	Op    string `json:"op"`
+	List  string `json:"list"`
+	Value string `json:"value"`
+
+	// Тип бота для паттернов dns_patterns
+	BotType string `json:"bot_type,omitempty"`
+}
+
+// ListDelta изменения одного списка относительно конфигурации
+type ListDelta struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// ListsOverlay изменения списков, сделанные во время работы. Ключ - имя списка,
+// для паттернов обратного DNS - "dns_patterns/<тип бота>".
+type ListsOverlay map[string]*ListDelta
+
+// ListEditor применяет изменения списков паттернов User-Agent, IP диапазонов, доменов referrer
+// и паттернов обратного DNS во время работы и сохраняет их в overlay файл. При следующем
+// Provision overlay накладывается на списки из конфигурации, поэтому изменения переживают перезагрузку.
+type ListEditor struct {
+	// Конфигурация
+	path string
+
+	// Изменяемые компоненты
+	userAgentMatcher  *UserAgentMatcher
+	ipRangeChecker    *IPRangeChecker
+	referrerChecker   *ReferrerChecker
+	reverseDNSChecker *ReverseDNSChecker
+
+	// Списки из конфигурации (или по умолчанию), записи из файлов списков, их объединение
+	// и наложенные на него изменения
+	config  map[string][]string
+	files   map[string][]string
+	base    map[string][]string
+	overlay ListsOverlay
+	loadErr error
+
+	// Вызывается после применения изменений для очистки зависящих от списков кешей
+	onChange func(changes []ListChange)
+
+	// Синхронизация: изменения применяются по одному
+	mutex sync.Mutex
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	updates       int64
+	changes       int64
+	rejected      int64
+	persistErrors int64
+	lastUpdateAt  int64
+}
+
+// NewListEditor создает новый экземпляр ListEditor и накладывает сохраненный overlay на списки компонентов
+func NewListEditor(config *Config, userAgentMatcher *UserAgentMatcher, ipRangeChecker *IPRangeChecker, referrerChecker *ReferrerChecker, reverseDNSChecker *ReverseDNSChecker, onChange func(changes []ListChange), metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *ListEditor {
+	le := &ListEditor{
+		path:              config.ListsOverlayFile,
+		userAgentMatcher:  userAgentMatcher,
+		ipRangeChecker:    ipRangeChecker,
+		referrerChecker:   referrerChecker,
+		reverseDNSChecker: reverseDNSChecker,
+		config:            make(map[string][]string),
+		files:             make(map[string][]string),
+		base:              make(map[string][]string),
+		overlay:           make(ListsOverlay),
+		onChange:          onChange,
+		metrics:           metrics,
+		debug:             debug,
+		logger:            logger,
+	}
+
+	// Базовые списки - то, что компоненты загрузили из конфигурации или списков по умолчанию
+	le.base[ListUserAgents] = userAgentMatcher.GetPatterns()
+	le.base[ListIPRanges] = ipRangeChecker.GetRanges()
+	if referrerChecker.enabled {
+		le.base[ListReferrerDomains] = referrerChecker.GetDomains()
+	}
+	if reverseDNSChecker.IsEnabled() {
+		for botType, patterns := range reverseDNSChecker.GetBotDomainPatterns() {
+			le.base[listKey(ListDNSPatterns, botType)] = patterns
+		}
+	}
+
+	for key, list := range le.base {
+		le.config[key] = list
+	}
+
+	if le.path == "" {
+		return le
+	}
+
+	overlay, err := loadListsOverlay(le.path)
+	if err != nil {
+		// Поврежденный файл не перезаписываем: изменения списков отключаются до исправления
+		le.loadErr = err
+		logger.Error("failed to load lists overlay, runtime list changes are disabled",
+			zap.String("path", le.path),
+			zap.Error(err),
+		)
+		return le
+	}
+	if len(overlay) == 0 {
+		return le
+	}
+
+	le.overlay = overlay
+	for key := range overlay {
+		if err := le.apply(key); err != nil {
+			logger.Warn("failed to apply lists overlay",
+				zap.String("list", key),
+				zap.Error(err),
+			)
+		}
+	}
+
+	logger.Info("lists overlay applied",
+		zap.String("path", le.path),
+		zap.Int("lists", len(overlay)),
+	)
+
+	return le
+}
+
+// loadListsOverlay читает overlay файл; отсутствующий файл - пустой overlay
+func loadListsOverlay(path string) (ListsOverlay, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	overlay := make(ListsOverlay)
+	if err := json.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	for key, delta := range overlay {
+		if delta == nil {
+			delete(overlay, key)
+		}
+	}
+	return overlay, nil
+}
+
+// listKey возвращает ключ списка в overlay
+func listKey(list, botType string) string {
+	if list == ListDNSPatterns {
+		return list + "/" + botType
+	}
+	return list
+}
+
+// Apply проверяет и применяет пачку изменений. Изменения применяются все вместе или не применяются:
+// все значения проверяются, затем overlay сохраняется в файл, и только после этого заменяются списки.
+// Возвращает количество изменений, которые что-то изменили.
+func (le *ListEditor) Apply(changes []ListChange) (int, error) {
+	le.mutex.Lock()
+	defer le.mutex.Unlock()
+
+	applied, err := le.applyLocked(changes)
+	if err != nil {
+		atomic.AddInt64(&le.rejected, 1)
+		return 0, err
+	}
+	return applied, nil
+}
+
+// applyLocked применяет изменения под мьютексом
+func (le *ListEditor) applyLocked(changes []ListChange) (int, error) {
+	if le.loadErr != nil {
+		return 0, fmt.Errorf("lists overlay %s could not be loaded: %w", le.path, le.loadErr)
+	}
+	if len(changes) == 0 {
+		return 0, fmt.Errorf("no changes")
+	}
+
+	// Изменения накладываются на копию overlay
+	overlay := make(ListsOverlay, len(le.overlay))
+	for key, delta := range le.overlay {
+		overlay[key] = &ListDelta{Add: slices.Clone(delta.Add), Remove: slices.Clone(delta.Remove)}
+	}
+
+	touched := make(map[string]bool)
+	applied := 0
+	for i := range changes {
+		change := &changes[i]
+		key, err := le.normalize(change)
+		if err != nil {
+			return 0, fmt.Errorf("change %d: %w", i+1, err)
+		}
+
+		delta := overlay[key]
+		if delta == nil {
+			delta = &ListDelta{}
+			overlay[key] = delta
+		}
+
+		changed, err := le.applyChange(key, delta, change)
+		if err != nil {
+			return 0, fmt.Errorf("change %d: %w", i+1, err)
+		}
+		if changed {
+			touched[key] = true
+			applied++
+		}
+		if len(delta.Add) == 0 && len(delta.Remove) == 0 {
+			delete(overlay, key)
+		}
+	}
+
+	if applied == 0 {
+		return 0, nil
+	}
+
+	if err := le.persist(overlay); err != nil {
+		atomic.AddInt64(&le.persistErrors, 1)
+		return 0, fmt.Errorf("%w: %v", errListsNotSaved, err)
+	}
+
+	le.overlay = overlay
+	for key := range touched {
+		if err := le.apply(key); err != nil {
+			// Значения проверены заранее, ошибка здесь означает рассинхронизацию с компонентом
+			le.logger.Error("failed to apply list change", zap.String("list", key), zap.Error(err))
+		}
+	}
+
+	if le.onChange != nil {
+		le.onChange(changes)
+	}
+
+	atomic.AddInt64(&le.updates, 1)
+	atomic.AddInt64(&le.changes, int64(applied))
+	atomic.StoreInt64(&le.lastUpdateAt, time.Now().Unix())
+
+	le.logger.Info("lists updated",
+		zap.Int("changes", applied),
+		zap.Int("lists", len(touched)),
+		zap.Bool("persisted", le.path != ""),
+	)
+
+	return applied, nil
+}
+
+// normalize проверяет изменение, приводит значение к каноническому виду и возвращает ключ списка
+func (le *ListEditor) normalize(change *ListChange) (string, error) {
+	if change.Op != ListOpAdd && change.Op != ListOpRemove {
+		return "", fmt.Errorf("unknown op %q (expected add or remove)", change.Op)
+	}
+
+	change.Value = strings.TrimSpace(change.Value)
+	if change.Value == "" {
+		return "", fmt.Errorf("empty value")
+	}
+
+	switch change.List {
+	case ListUserAgents:
+		if change.Op == ListOpAdd {
+			if err := le.userAgentMatcher.validatePattern(change.Value); err != nil {
+				return "", err
+			}
+		}
+
+	case ListIPRanges:
+		value, err := normalizeIPRange(change.Value)
+		if err != nil {
+			return "", err
+		}
+		change.Value = value
+
+	case ListReferrerDomains:
+		if !le.referrerChecker.enabled {
+			return "", fmt.Errorf("referrer check is disabled")
+		}
+		change.Value = strings.ToLower(change.Value)
+		if change.Op == ListOpAdd {
+			if err := le.referrerChecker.validateDomain(change.Value); err != nil {
+				return "", err
+			}
+		}
+
+	case ListDNSPatterns:
+		if !le.reverseDNSChecker.IsEnabled() {
+			return "", fmt.Errorf("reverse DNS is disabled")
+		}
+		if !listBotTypes[BotType(change.BotType)] {
+			return "", fmt.Errorf("unknown bot_type %q for dns_patterns", change.BotType)
+		}
+		if change.Op == ListOpAdd {
+			if err := validateBotDomainPattern(change.Value); err != nil {
+				return "", err
+			}
+		}
+
+	default:
+		return "", fmt.Errorf("unknown list %q", change.List)
+	}
+
+	return listKey(change.List, change.BotType), nil
+}
+
+// applyChange изменяет delta списка. Возвращает false, если изменение ничего не меняет
+// (добавление уже присутствующего значения).
+func (le *ListEditor) applyChange(key string, delta *ListDelta, change *ListChange) (bool, error) {
+	inBase := slices.Contains(le.base[key], change.Value)
+	added := slices.Index(delta.Add, change.Value)
+	removed := slices.Index(delta.Remove, change.Value)
+
+	switch change.Op {
+	case ListOpAdd:
+		if removed >= 0 {
+			delta.Remove = slices.Delete(delta.Remove, removed, removed+1)
+			return true, nil
+		}
+		if inBase || added >= 0 {
+			return false, nil
+		}
+		delta.Add = append(delta.Add, change.Value)
+		return true, nil
+
+	default:
+		if added >= 0 {
+			delta.Add = slices.Delete(delta.Add, added, added+1)
+			return true, nil
+		}
+		if inBase && removed < 0 {
+			delta.Remove = append(delta.Remove, change.Value)
+			return true, nil
+		}
+		return false, fmt.Errorf("%s is not in %s", change.Value, key)
+	}
+}
+
+// SetFileEntries заменяет записи списка, загруженные из файла, и применяет список с наложенными
+// изменениями. Записи должны быть проверены и приведены к каноническому виду (см. normalize).
+// Возвращает разницу с прежним действующим списком; по ней вызывающий очищает кеши.
+func (le *ListEditor) SetFileEntries(list string, entries []string) ([]ListChange, error) {
+	le.mutex.Lock()
+	defer le.mutex.Unlock()
+
+	key := listKey(list, "")
+	previous := le.effective(key)
+
+	base := slices.Clone(le.config[key])
+	seen := make(map[string]bool, len(base)+len(entries))
+	for _, value := range base {
+		seen[value] = true
+	}
+	for _, value := range entries {
+		if !seen[value] {
+			seen[value] = true
+			base = append(base, value)
+		}
+	}
+
+	oldBase, oldFiles := le.base[key], le.files[key]
+	le.base[key] = base
+	le.files[key] = slices.Clone(entries)
+
+	current := le.effective(key)
+	changes := diffLists(list, previous, current)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	if err := le.apply(key); err != nil {
+		le.base[key], le.files[key] = oldBase, oldFiles
+		return nil, err
+	}
+
+	if le.onChange != nil {
+		le.onChange(changes)
+	}
+
+	return changes, nil
+}
+
+// diffLists возвращает изменения, переводящие список previous в current
+func diffLists(list string, previous, current []string) []ListChange {
+	before := make(map[string]bool, len(previous))
+	for _, value := range previous {
+		before[value] = true
+	}
+	after := make(map[string]bool, len(current))
+	for _, value := range current {
+		after[value] = true
+	}
+
+	changes := make([]ListChange, 0)
+	for _, value := range current {
+		if !before[value] {
+			changes = append(changes, ListChange{Op: ListOpAdd, List: list, Value: value})
+		}
+	}
+	for _, value := range previous {
+		if !after[value] {
+			changes = append(changes, ListChange{Op: ListOpRemove, List: list, Value: value})
+		}
+	}
+	return changes
+}
+
+// effective возвращает список с наложенными изменениями
+func (le *ListEditor) effective(key string) []string {
+	delta := le.overlay[key]
+	if delta == nil {
+		return slices.Clone(le.base[key])
+	}
+
+	list := make([]string, 0, len(le.base[key])+len(delta.Add))
+	for _, value := range le.base[key] {
+		if !slices.Contains(delta.Remove, value) {
+			list = append(list, value)
+		}
+	}
+	for _, value := range delta.Add {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// apply заменяет список в компоненте на список с наложенными изменениями
+func (le *ListEditor) apply(key string) error {
+	list, botType, _ := strings.Cut(key, "/")
+
+	switch list {
+	case ListUserAgents:
+		return le.userAgentMatcher.SetPatterns(le.effective(key))
+	case ListIPRanges:
+		return le.ipRangeChecker.SetRanges(le.effective(key))
+	case ListReferrerDomains:
+		return le.referrerChecker.SetDomains(le.effective(key))
+	case ListDNSPatterns:
+		if !listBotTypes[BotType(botType)] {
+			return fmt.Errorf("unknown bot type %q", botType)
+		}
+		// Паттерны заменяются для всех типов сразу, поэтому собираем полный набор
+		patterns := make(map[BotType][]string)
+		for _, bt := range le.dnsBotTypes() {
+			patterns[BotType(bt)] = le.effective(listKey(ListDNSPatterns, bt))
+		}
+		return le.reverseDNSChecker.SetBotDomainPatterns(patterns)
+	default:
+		return fmt.Errorf("unknown list %q", list)
+	}
+}
+
+// dnsBotTypes возвращает типы ботов, для которых есть паттерны в конфигурации или overlay
+func (le *ListEditor) dnsBotTypes() []string {
+	types := make(map[string]bool)
+	for key := range le.base {
+		if list, botType, ok := strings.Cut(key, "/"); ok && list == ListDNSPatterns {
+			types[botType] = true
+		}
+	}
+	for key := range le.overlay {
+		if list, botType, ok := strings.Cut(key, "/"); ok && list == ListDNSPatterns && listBotTypes[BotType(botType)] {
+			types[botType] = true
+		}
+	}
+
+	result := make([]string, 0, len(types))
+	for botType := range types {
+		result = append(result, botType)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// persist атомарно записывает overlay в файл
+func (le *ListEditor) persist(overlay ListsOverlay) error {
+	if le.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(overlay, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(le.path), 0o700); err != nil {
+		return err
+	}
+	_, err = writeFileIfChanged(le.path, append(data, '\n'))
+	return err
+}
+
+// GetOverlay возвращает копию действующих изменений списков
+func (le *ListEditor) GetOverlay() ListsOverlay {
+	le.mutex.Lock()
+	defer le.mutex.Unlock()
+
+	overlay := make(ListsOverlay, len(le.overlay))
+	for key, delta := range le.overlay {
+		overlay[key] = &ListDelta{Add: slices.Clone(delta.Add), Remove: slices.Clone(delta.Remove)}
+	}
+	return overlay
+}
+
+// GetStats возвращает статистику
+func (le *ListEditor) GetStats() map[string]interface{} {
+	le.mutex.Lock()
+	overlayLists := len(le.overlay)
+	loadErr := le.loadErr
+	le.mutex.Unlock()
+
+	stats := map[string]interface{}{
+		"overlay_file":     le.path,
+		"overlay_lists":    overlayLists,
+		"updates":          atomic.LoadInt64(&le.updates),
+		"changes":          atomic.LoadInt64(&le.changes),
+		"rejected":         atomic.LoadInt64(&le.rejected),
+		"persist_errors":   atomic.LoadInt64(&le.persistErrors),
+		"last_update_unix": atomic.LoadInt64(&le.lastUpdateAt),
+	}
+	if loadErr != nil {
+		stats["load_error"] = loadErr.Error()
+	}
+	return stats
+}
@@ -0,0 +1,285 @@
+package botredirect
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// listFile отслеживаемый файл списка
+type listFile struct {
+	list string
+	path string
+
+	// Состояние последней проверки: файл перечитывается, только если изменились
+	// время модификации или размер, и применяется, только если изменилось содержимое
+	modTime  time.Time
+	size     int64
+	hash     [sha256.Size]byte
+	read     bool
+	entries  int
+	loadedAt time.Time
+	lastErr  error
+}
+
+// ListWatcher следит за файлами списков паттернов User-Agent, IP диапазонов и доменов referrer
+// и применяет изменения без перезагрузки Caddy. Файлы опрашиваются с заданным интервалом
+// (время модификации, размер и хеш содержимого), новый список проверяется целиком и заменяет
+// прежний атомарно; при ошибке остается прежняя версия. Записи файла объединяются со списком
+// из конфигурации, изменения через admin API накладываются поверх.
+type ListWatcher struct {
+	// Конфигурация
+	enabled  bool
+	interval time.Duration
+	files    []*listFile
+
+	// Применяет записи файлов к спискам компонентов
+	listEditor *ListEditor
+
+	// Управление горутиной опроса
+	stop     chan struct{}
+	stopOnce sync.Once
+	mutex    sync.Mutex
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	checks       int64
+	reloads      int64
+	unchanged    int64
+	errors       int64
+	lastReloadAt int64
+}
+
+// NewListWatcher создает новый экземпляр ListWatcher и загружает файлы списков
+func NewListWatcher(config *Config, listEditor *ListEditor, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *ListWatcher {
+	lw := &ListWatcher{
+		interval:   config.ListFilesInterval,
+		listEditor: listEditor,
+		stop:       make(chan struct{}),
+		metrics:    metrics,
+		debug:      debug,
+		logger:     logger,
+	}
+
+	for _, file := range []*listFile{
+		{list: ListUserAgents, path: config.BotUserAgentsFile},
+		{list: ListIPRanges, path: config.BotIPRangesFile},
+		{list: ListReferrerDomains, path: config.AllowedReferrersFile},
+	} {
+		if file.path != "" {
+			lw.files = append(lw.files, file)
+		}
+	}
+
+	if len(lw.files) == 0 {
+		return &ListWatcher{enabled: false}
+	}
+
+	lw.enabled = true
+	if lw.interval <= 0 {
+		lw.interval = 30 * time.Second
+	}
+
+	// Первая загрузка синхронная: списки из файлов действуют с первого запроса
+	lw.Check()
+
+	go lw.run()
+
+	logger.Info("list watcher initialized",
+		zap.Int("files", len(lw.files)),
+		zap.Duration("interval", lw.interval),
+	)
+
+	return lw
+}
+
+// run периодически проверяет файлы
+func (lw *ListWatcher) run() {
+	ticker := time.NewTicker(lw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lw.Check()
+		case <-lw.stop:
+			return
+		}
+	}
+}
+
+// Check проверяет все файлы и применяет изменившиеся
+func (lw *ListWatcher) Check() {
+	if !lw.enabled {
+		return
+	}
+
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	for _, file := range lw.files {
+		atomic.AddInt64(&lw.checks, 1)
+		if err := lw.checkFile(file); err != nil {
+			lw.fail(file, err)
+		}
+	}
+}
+
+// checkFile перечитывает файл, если он изменился, и применяет новый список
+func (lw *ListWatcher) checkFile(file *listFile) error {
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return err
+	}
+	if file.read && info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+		atomic.AddInt64(&lw.unchanged, 1)
+		return nil
+	}
+
+	data, err := os.ReadFile(file.path)
+	if err != nil {
+		return err
+	}
+
+	// Файл перезаписан тем же содержимым (или той же ошибочной версией)
+	hash := sha256.Sum256(data)
+	unchanged := file.read && hash == file.hash
+	file.modTime, file.size, file.hash, file.read = info.ModTime(), info.Size(), hash, true
+	if unchanged {
+		atomic.AddInt64(&lw.unchanged, 1)
+		return nil
+	}
+
+	entries, err := lw.parse(file.list, data)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", file.path, err)
+	}
+
+	changes, err := lw.listEditor.SetFileEntries(file.list, entries)
+	if err != nil {
+		return fmt.Errorf("applying %s: %w", file.path, err)
+	}
+
+	file.entries = len(entries)
+	file.loadedAt = time.Now()
+	file.lastErr = nil
+	atomic.AddInt64(&lw.reloads, 1)
+	atomic.StoreInt64(&lw.lastReloadAt, file.loadedAt.Unix())
+
+	lw.logger.Info("list file loaded",
+		zap.String("list", file.list),
+		zap.String("path", file.path),
+		zap.Int("entries", len(entries)),
+		zap.Int("changes", len(changes)),
+	)
+
+	return nil
+}
+
+// parse разбирает файл списка: одна запись в строке, пустые строки и строки,
+// начинающиеся с #, пропускаются. Записи проверяются так же, как изменения через admin API.
+func (lw *ListWatcher) parse(list string, data []byte) ([]string, error) {
+	entries := make([]string, 0)
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		value := string(bytes.TrimSpace(scanner.Bytes()))
+		if value == "" || value[0] == '#' {
+			continue
+		}
+
+		change := ListChange{Op: ListOpAdd, List: list, Value: value}
+		if _, err := lw.listEditor.normalize(&change); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !seen[change.Value] {
+			seen[change.Value] = true
+			entries = append(entries, change.Value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// fail учитывает ошибку загрузки файла; прежняя версия списка продолжает действовать
+func (lw *ListWatcher) fail(file *listFile, err error) {
+	atomic.AddInt64(&lw.errors, 1)
+
+	// Повторяющуюся ошибку (например, отсутствующий файл) логируем один раз
+	if file.lastErr != nil && file.lastErr.Error() == err.Error() {
+		return
+	}
+	file.lastErr = err
+
+	lw.logger.Error("failed to load list file, keeping previous version",
+		zap.String("list", file.list),
+		zap.String("path", file.path),
+		zap.Error(err),
+	)
+}
+
+// Shutdown останавливает опрос файлов
+func (lw *ListWatcher) Shutdown() {
+	if !lw.enabled {
+		return
+	}
+	lw.stopOnce.Do(func() {
+		close(lw.stop)
+	})
+}
+
+// IsEnabled возвращает статус включенности отслеживания файлов
+func (lw *ListWatcher) IsEnabled() bool {
+	return lw.enabled
+}
+
+// GetStats возвращает статистику
+func (lw *ListWatcher) GetStats() map[string]interface{} {
+	if !lw.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	lw.mutex.Lock()
+	files := make([]map[string]interface{}, 0, len(lw.files))
+	for _, file := range lw.files {
+		entry := map[string]interface{}{
+			"list":    file.list,
+			"path":    file.path,
+			"entries": file.entries,
+		}
+		if !file.loadedAt.IsZero() {
+			entry["loaded_unix"] = file.loadedAt.Unix()
+		}
+		if file.lastErr != nil {
+			entry["error"] = file.lastErr.Error()
+		}
+		files = append(files, entry)
+	}
+	lw.mutex.Unlock()
+
+	return map[string]interface{}{
+		"enabled":          true,
+		"interval_seconds": lw.interval.Seconds(),
+		"files":            files,
+		"checks":           atomic.LoadInt64(&lw.checks),
+		"reloads":          atomic.LoadInt64(&lw.reloads),
+		"unchanged":        atomic.LoadInt64(&lw.unchanged),
+		"errors":           atomic.LoadInt64(&lw.errors),
+		"last_reload_unix": atomic.LoadInt64(&lw.lastReloadAt),
+	}
+}
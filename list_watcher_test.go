@@ -0,0 +1,112 @@
+package botredirect
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writeTestListFile записывает файл списка с заданным временем модификации,
+// чтобы изменение было заметно независимо от точности времени файловой системы
+func writeTestListFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestListWatcherKeepsPreviousList проверяет, что файл с ошибкой отклоняется целиком,
+// прежний список продолжает действовать, а исправленный файл применяется
+func TestListWatcherKeepsPreviousList(t *testing.T) {
+	steps := []struct {
+		name    string
+		content string
+		old     bool
+		updated bool
+		failed  bool
+	}{
+		{
+			name:    "initial file",
+			content: "# test ranges\n198.51.100.0/24\n",
+			old:     true,
+		},
+		{
+			name:    "invalid CIDR keeps previous list",
+			content: "203.0.113.0/24\n192.0.2.0/33\n",
+			old:     true,
+			failed:  true,
+		},
+		{
+			name:    "same invalid file is not reparsed",
+			content: "203.0.113.0/24\n192.0.2.0/33\n",
+			old:     true,
+			failed:  true,
+		},
+		{
+			name:    "fixed file replaces list",
+			content: "203.0.113.0/24\n",
+			updated: true,
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "ip_ranges.txt")
+	modTime := time.Now().Add(-time.Hour)
+	writeTestListFile(t, path, steps[0].content, modTime)
+
+	config := DefaultConfig()
+	config.EnableMetrics = false
+	config.BotIPRangesFile = path
+	config.ListFilesInterval = time.Hour
+
+	// Первая версия загружается при создании детектора, следующие — явной проверкой
+	bd, err := NewBotDetector(config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bd.Shutdown()
+	lw := bd.listWatcher
+
+	for i, step := range steps {
+		if i > 0 {
+			modTime = modTime.Add(time.Minute)
+			writeTestListFile(t, path, step.content, modTime)
+			lw.Check()
+		}
+
+		for ip, want := range map[string]bool{"198.51.100.10": step.old, "203.0.113.10": step.updated} {
+			result, err := bd.ipRangeChecker.IsBot(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.IsBot != want {
+				t.Errorf("%s: %s is bot = %v, want %v", step.name, ip, result.IsBot, want)
+			}
+		}
+
+		files := lw.GetStats()["files"].([]map[string]interface{})
+		errText, failed := files[0]["error"].(string)
+		if failed != step.failed {
+			t.Errorf("%s: stats error = %q, want failed %v", step.name, errText, step.failed)
+		}
+		if failed && !strings.Contains(errText, "line 2") {
+			t.Errorf("%s: error = %q, want line 2", step.name, errText)
+		}
+	}
+
+	// Перезапись той же ошибочной версии не считается новой ошибкой
+	stats := lw.GetStats()
+	if stats["reloads"] != int64(2) {
+		t.Errorf("reloads = %v, want 2", stats["reloads"])
+	}
+	if stats["errors"] != int64(1) {
+		t.Errorf("errors = %v, want 1", stats["errors"])
+	}
+}
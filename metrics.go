@@ -1,12 +1,14 @@
 package botredirect
 
 import (
+	"encoding/json"
 	"expvar"
 	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -31,6 +33,24 @@ type Metrics struct {
 	// Метрики rate limiting
 	RateLimited        *expvar.Int
 	RateLimitBlocked   *expvar.Int
+	RateLimitStoreErrors *expvar.Int
+	
+	// Метрики robots.txt
+	RobotsViolations   *expvar.Int
+	HoneypotHits       *expvar.Int
+	
+	// Метрики банов
+	BansIssued         *expvar.Int
+	BannedRequests     *expvar.Int
+	
+	// Метрики проверки браузера (proof-of-work)
+	ChallengesIssued   *expvar.Int
+	ChallengesSolved   *expvar.Int
+	ChallengesFailed   *expvar.Int
+	
+	// Метрики HTTP Message Signatures
+	SignaturesVerified *expvar.Int
+	SignaturesInvalid  *expvar.Int
 	
 	// Метрики производительности
 	TotalRequests      *expvar.Int
@@ -51,6 +71,9 @@ type Metrics struct {
 	maxSamples     int
 	logger         *zap.Logger
 	
+	// Prometheus коллекторы (nil, если enable_prometheus выключен)
+	prom           *PrometheusMetrics
+	
 	// Atomic counters для thread-safe операций
 	responseTimeSum   int64 // в наносекундах
 	responseTimeCount int64
@@ -86,6 +109,20 @@ func NewMetrics(enabled, verbose bool, logger *zap.Logger) *Metrics {
 	
 	m.RateLimited = expvar.NewInt("bot_redirect.rate_limited")
 	m.RateLimitBlocked = expvar.NewInt("bot_redirect.rate_limit_blocked")
+	m.RateLimitStoreErrors = expvar.NewInt("bot_redirect.rate_limit_store_errors")
+	
+	m.RobotsViolations = expvar.NewInt("bot_redirect.robots_violations")
+	m.HoneypotHits = expvar.NewInt("bot_redirect.honeypot_hits")
+	
+	m.BansIssued = expvar.NewInt("bot_redirect.bans_issued")
+	m.BannedRequests = expvar.NewInt("bot_redirect.banned_requests")
+	
+	m.ChallengesIssued = expvar.NewInt("bot_redirect.challenges_issued")
+	m.ChallengesSolved = expvar.NewInt("bot_redirect.challenges_solved")
+	m.ChallengesFailed = expvar.NewInt("bot_redirect.challenges_failed")
+	
+	m.SignaturesVerified = expvar.NewInt("bot_redirect.signatures_verified")
+	m.SignaturesInvalid = expvar.NewInt("bot_redirect.signatures_invalid")
 	
 	m.TotalRequests = expvar.NewInt("bot_redirect.total_requests")
 	m.ProcessingTime = expvar.NewFloat("bot_redirect.processing_time_ms")
@@ -118,6 +155,50 @@ func NewMetrics(enabled, verbose bool, logger *zap.Logger) *Metrics {
 	return m
 }
 
+// EnablePrometheus подключает коллекторы Prometheus в реестре Caddy.
+// Работает независимо от enable_metrics.
+func (m *Metrics) EnablePrometheus(registerer prometheus.Registerer, logger *zap.Logger) {
+	m.prom = registerPrometheusMetrics(registerer, logger)
+}
+
+// PrometheusEnabled возвращает true, если подключены коллекторы Prometheus
+func (m *Metrics) PrometheusEnabled() bool {
+	return m.prom != nil
+}
+
+// RecordRequest учитывает обработанный запрос: классификацию, бота и выполненное действие
+func (m *Metrics) RecordRequest(result *DetectionResult, action string) {
+	m.prom.observeRequest(result, action)
+}
+
+// RecordDetection учитывает время классификации запроса
+func (m *Metrics) RecordDetection(result *DetectionResult) {
+	m.prom.observeDetection(result)
+}
+
+// RecordDNSLookup учитывает исход и время DNS проверки
+func (m *Metrics) RecordDNSLookup(outcome string, duration time.Duration) {
+	m.prom.observeDNSLookup(outcome, duration)
+}
+
+// CacheCounters возвращает счетчики попаданий и промахов кеша (nil без Prometheus)
+func (m *Metrics) CacheCounters(cache string) (prometheus.Counter, prometheus.Counter) {
+	return m.prom.cacheCounters(cache)
+}
+
+// RecordCacheLookup учитывает обращение к кешу, не использующему Cache
+func (m *Metrics) RecordCacheLookup(cache string, hit bool) {
+	if m.prom == nil {
+		return
+	}
+	hits, misses := m.prom.cacheCounters(cache)
+	if hit {
+		hits.Inc()
+	} else {
+		misses.Inc()
+	}
+}
+
 // IncrementBotRequests увеличивает счетчик запросов от ботов
 func (m *Metrics) IncrementBotRequests() {
 	if !m.enabled {
@@ -203,6 +284,7 @@ func (m *Metrics) IncrementDNSSuccesses() {
 
 // IncrementRateLimited увеличивает счетчик rate limited запросов
 func (m *Metrics) IncrementRateLimited() {
+	m.prom.rateLimitEvent("dns_limited", "dns")
 	if !m.enabled {
 		return
 	}
@@ -210,13 +292,107 @@ func (m *Metrics) IncrementRateLimited() {
 }
 
 // IncrementRateLimitBlocked увеличивает счетчик заблокированных запросов
-func (m *Metrics) IncrementRateLimitBlocked() {
+func (m *Metrics) IncrementRateLimitBlocked(tier string) {
+	m.prom.rateLimitEvent("blocked", tier)
 	if !m.enabled {
 		return
 	}
 	m.RateLimitBlocked.Add(1)
 }
 
+// IncrementRateLimitStoreErrors увеличивает счетчик ошибок хранилища rate limiter
+func (m *Metrics) IncrementRateLimitStoreErrors() {
+	m.prom.rateLimitEvent("store_error", "")
+	if !m.enabled {
+		return
+	}
+	m.RateLimitStoreErrors.Add(1)
+}
+
+// IncrementBansIssued увеличивает счетчик выданных банов
+func (m *Metrics) IncrementBansIssued(reason string) {
+	m.prom.banEvent("issued", reason)
+	if !m.enabled {
+		return
+	}
+	m.BansIssued.Add(1)
+}
+
+// IncrementBannedRequests увеличивает счетчик запросов, отклоненных из-за бана
+func (m *Metrics) IncrementBannedRequests(reason string) {
+	m.prom.banEvent("rejected", reason)
+	if !m.enabled {
+		return
+	}
+	m.BannedRequests.Add(1)
+}
+
+// RecordBanImported учитывает бан, полученный от другого узла кластера (только Prometheus)
+func (m *Metrics) RecordBanImported(reason string) {
+	m.prom.banEvent("imported", reason)
+}
+
+// RecordBanLifted учитывает снятый бан (только Prometheus)
+func (m *Metrics) RecordBanLifted() {
+	m.prom.banEvent("lifted", "")
+}
+
+// IncrementRobotsViolations увеличивает счетчик нарушений robots.txt
+func (m *Metrics) IncrementRobotsViolations() {
+	if !m.enabled {
+		return
+	}
+	m.RobotsViolations.Add(1)
+}
+
+// IncrementHoneypotHits увеличивает счетчик обращений к ловушкам
+func (m *Metrics) IncrementHoneypotHits() {
+	if !m.enabled {
+		return
+	}
+	m.HoneypotHits.Add(1)
+}
+
+// IncrementChallengesIssued увеличивает счетчик выданных проверок
+func (m *Metrics) IncrementChallengesIssued() {
+	if !m.enabled {
+		return
+	}
+	m.ChallengesIssued.Add(1)
+}
+
+// IncrementChallengesSolved увеличивает счетчик пройденных проверок
+func (m *Metrics) IncrementChallengesSolved() {
+	if !m.enabled {
+		return
+	}
+	m.ChallengesSolved.Add(1)
+}
+
+// IncrementChallengesFailed увеличивает счетчик неверных решений
+func (m *Metrics) IncrementChallengesFailed() {
+	if !m.enabled {
+		return
+	}
+	m.ChallengesFailed.Add(1)
+}
+
+// IncrementSignaturesVerified увеличивает счетчик подтвержденных подписей
+func (m *Metrics) IncrementSignaturesVerified() {
+	if !m.enabled {
+		return
+	}
+	m.SignaturesVerified.Add(1)
+}
+
+// IncrementSignaturesInvalid увеличивает счетчик отклоненных подписей
+func (m *Metrics) IncrementSignaturesInvalid() {
+	if !m.enabled {
+		return
+	}
+	m.SignaturesInvalid.Add(1)
+}
+
 // RecordProcessingTime записывает время обработки запроса
 func (m *Metrics) RecordProcessingTime(duration time.Duration) {
 	if !m.enabled {
@@ -321,6 +497,16 @@ func (m *Metrics) GetStats() map[string]interface{} {
 		"dns_success_rate":     m.getDNSSuccessRate(),
 		"rate_limited":         m.RateLimited.Value(),
 		"rate_limit_blocked":   m.RateLimitBlocked.Value(),
+		"rate_limit_store_errors": m.RateLimitStoreErrors.Value(),
+		"robots_violations":    m.RobotsViolations.Value(),
+		"honeypot_hits":        m.HoneypotHits.Value(),
+		"bans_issued":          m.BansIssued.Value(),
+		"banned_requests":      m.BannedRequests.Value(),
+		"challenges_issued":    m.ChallengesIssued.Value(),
+		"challenges_solved":    m.ChallengesSolved.Value(),
+		"challenges_failed":    m.ChallengesFailed.Value(),
+		"signatures_verified":  m.SignaturesVerified.Value(),
+		"signatures_invalid":   m.SignaturesInvalid.Value(),
 		"avg_response_time_ms": m.AverageResponseTime.Value(),
 	}
 
@@ -364,7 +550,8 @@ func (m *Metrics) LogStats() {
 	}
 }
 
-// ServeHTTP предоставляет HTTP endpoint для метрик
+// ServeHTTP предоставляет HTTP endpoint для метрик плагина.
+// Отдается только статистика плагина: полный expvar содержит командную строку и состояние памяти процесса.
 func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !m.enabled {
 		http.Error(w, "Metrics disabled", http.StatusServiceUnavailable)
@@ -372,9 +559,11 @@ func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	
-	// Используем встроенный expvar handler
-	expvar.Handler().ServeHTTP(w, r)
+	if err := json.NewEncoder(w).Encode(m.GetStats()); err != nil {
+		m.logger.Error("failed to encode metrics", zap.Error(err))
+	}
 }
 
 // StartPeriodicLogging запускает периодическое логирование статистики
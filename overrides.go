@@ -0,0 +1,396 @@
+package botredirect
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Что сравнивает переопределение
+const (
+	OverrideMatchIP        = "ip"
+	OverrideMatchUserAgent = "user_agent"
+)
+
+// Действия переопределений
+const (
+	OverrideActionPass      = "pass"
+	OverrideActionBlock     = "block"
+	OverrideActionChallenge = "challenge"
+)
+
+// Источники переопределений
+const (
+	OverrideSourceConfig = "config"
+	OverrideSourceAPI    = "api"
+)
+
+// overrideClassifications допустимые классификации переопределений
+var overrideClassifications = map[string]UserType{
+	UserTypeBot.String():        UserTypeBot,
+	UserTypeFromSearch.String(): UserTypeFromSearch,
+	UserTypeDirect.String():     UserTypeDirect,
+}
+
+// Override действующее переопределение
+type Override struct {
+	OverrideRule
+
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+	Hits      int64     `json:"hits"`
+
+	// Разобранное значение для сравнения
+	prefix    netip.Prefix
+	userAgent string
+}
+
+// Effect возвращает действие или классификацию переопределения
+func (o *Override) Effect() string {
+	if o.Action != "" {
+		return o.Action
+	}
+	return o.Classification
+}
+
+// expired проверяет, истекло ли переопределение
+func (o *Override) expired(now time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !now.Before(o.ExpiresAt)
+}
+
+// matches проверяет, относится ли переопределение к клиенту
+func (o *Override) matches(addr netip.Addr, userAgent string) bool {
+	if o.Match == OverrideMatchIP {
+		return addr.IsValid() && o.prefix.Contains(addr)
+	}
+	return strings.Contains(userAgent, o.userAgent)
+}
+
+// result создает результат детекции для переопределения классификации
+func (o *Override) result() *DetectionResult {
+	userType := overrideClassifications[o.Classification]
+	result := &DetectionResult{
+		IsBot:           userType == UserTypeBot,
+		UserType:        userType,
+		DetectionMethod: "override",
+		Confidence:      1.0,
+		MatchedPattern:  o.Value,
+		Details: map[string]interface{}{
+			"override_id":     o.ID,
+			"override_reason": o.Reason,
+			"override_author": o.Author,
+		},
+	}
+	if result.IsBot {
+		// Оператор сам поручился за клиента: политика неподтвержденных ботов не применяется
+		result.Verified = true
+		result.BotName = "override"
+		result.Details["bot_type"] = BotTypeUnknown
+	}
+	return result
+}
+
+// validateOverrideRule проверяет правило переопределения
+func validateOverrideRule(rule OverrideRule) error {
+	switch rule.Match {
+	case OverrideMatchIP:
+		if _, err := parseFirewallPrefix(rule.Value); err != nil {
+			return fmt.Errorf("invalid IP or CIDR %q: %v", rule.Value, err)
+		}
+	case OverrideMatchUserAgent:
+		if strings.TrimSpace(rule.Value) == "" {
+			return fmt.Errorf("user_agent value is required")
+		}
+	default:
+		return fmt.Errorf("match must be ip or user_agent, got %q", rule.Match)
+	}
+
+	if (rule.Action == "") == (rule.Classification == "") {
+		return fmt.Errorf("exactly one of action or classification is required")
+	}
+	if rule.Classification != "" {
+		if _, ok := overrideClassifications[rule.Classification]; !ok {
+			return fmt.Errorf("classification must be bot, from_search or direct, got %q", rule.Classification)
+		}
+	}
+	switch rule.Action {
+	case "", OverrideActionPass, OverrideActionBlock, OverrideActionChallenge:
+	default:
+		return fmt.Errorf("action must be pass, block or challenge, got %q", rule.Action)
+	}
+
+	return nil
+}
+
+// OverrideTable таблица ручных переопределений классификации и действий. Проверяется до банов
+// и всех детекторов: переопределения по IP (более узкие сети первыми), затем по User-Agent.
+// Переопределения из конфигурации заменяются при перезагрузке, созданные через admin API
+// переносятся в новый экземпляр с тем же id.
+type OverrideTable struct {
+	// Переопределения в порядке проверки
+	overrides []*Override
+	mutex     sync.RWMutex
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	checks  int64
+	hits    int64
+	added   int64
+	removed int64
+	expired int64
+}
+
+// NewOverrideTable создает новый экземпляр OverrideTable с переопределениями из конфигурации.
+// Неверное переопределение - ошибка: пропущенное правило молча не блокировало бы клиента.
+func NewOverrideTable(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) (*OverrideTable, error) {
+	ot := &OverrideTable{
+		overrides: make([]*Override, 0, len(config.Overrides)),
+		metrics:   metrics,
+		debug:     debug,
+		logger:    logger,
+	}
+
+	now := time.Now()
+	for i, rule := range config.Overrides {
+		override, err := newOverride(rule, fmt.Sprintf("config-%d", i+1), OverrideSourceConfig, now)
+		if err != nil {
+			return nil, fmt.Errorf("override %d: %w", i+1, err)
+		}
+		ot.overrides = append(ot.overrides, override)
+	}
+	sortOverrides(ot.overrides)
+
+	if len(ot.overrides) > 0 {
+		logger.Info("override table initialized",
+			zap.Int("overrides", len(ot.overrides)),
+		)
+	}
+
+	return ot, nil
+}
+
+// newOverride проверяет правило и создает переопределение
+func newOverride(rule OverrideRule, id, source string, now time.Time) (*Override, error) {
+	rule.Value = strings.TrimSpace(rule.Value)
+	if err := validateOverrideRule(rule); err != nil {
+		return nil, err
+	}
+
+	override := &Override{
+		OverrideRule: rule,
+		ID:           id,
+		Source:       source,
+		CreatedAt:    now,
+	}
+	if rule.Match == OverrideMatchIP {
+		override.prefix, _ = parseFirewallPrefix(rule.Value)
+		override.Value = override.prefix.String()
+	} else {
+		override.userAgent = strings.ToLower(rule.Value)
+	}
+	return override, nil
+}
+
+// newOverrideID генерирует идентификатор переопределения
+func newOverrideID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("ov-%x", time.Now().UnixNano())
+	}
+	return "ov-" + hex.EncodeToString(b)
+}
+
+// sortOverrides упорядочивает переопределения: IP перед User-Agent, более узкие сети первыми,
+// при равенстве более новые первыми
+func sortOverrides(overrides []*Override) {
+	sort.SliceStable(overrides, func(i, j int) bool {
+		a, b := overrides[i], overrides[j]
+		if a.Match != b.Match {
+			return a.Match == OverrideMatchIP
+		}
+		if a.Match == OverrideMatchIP && a.prefix.Bits() != b.prefix.Bits() {
+			return a.prefix.Bits() > b.prefix.Bits()
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+}
+
+// Check возвращает переопределение, действующее для запроса, и учитывает его использование
+func (ot *OverrideTable) Check(r *http.Request) *Override {
+	ot.mutex.RLock()
+	defer ot.mutex.RUnlock()
+
+	if len(ot.overrides) == 0 {
+		return nil
+	}
+
+	atomic.AddInt64(&ot.checks, 1)
+
+	addr, _ := netip.ParseAddr(canonicalHost(r.RemoteAddr))
+	userAgent := strings.ToLower(r.UserAgent())
+	now := time.Now()
+
+	for _, override := range ot.overrides {
+		if override.expired(now) || !override.matches(addr, userAgent) {
+			continue
+		}
+		atomic.AddInt64(&override.Hits, 1)
+		atomic.AddInt64(&ot.hits, 1)
+		return override
+	}
+
+	return nil
+}
+
+// Add добавляет переопределение
+func (ot *OverrideTable) Add(rule OverrideRule) (*Override, error) {
+	now := time.Now()
+	if !rule.ExpiresAt.IsZero() && !rule.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("expires_at is in the past")
+	}
+
+	override, err := newOverride(rule, newOverrideID(), OverrideSourceAPI, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ot.mutex.Lock()
+	ot.pruneLocked(now)
+	ot.overrides = append(ot.overrides, override)
+	sortOverrides(ot.overrides)
+	ot.mutex.Unlock()
+
+	atomic.AddInt64(&ot.added, 1)
+
+	ot.logger.Info("override added",
+		zap.String("id", override.ID),
+		zap.String("match", override.Match),
+		zap.String("value", override.Value),
+		zap.String("effect", override.Effect()),
+		zap.String("reason", override.Reason),
+		zap.String("author", override.Author),
+		zap.Time("expires_at", override.ExpiresAt),
+	)
+
+	return override, nil
+}
+
+// Remove удаляет переопределение по идентификатору
+func (ot *OverrideTable) Remove(id string) bool {
+	ot.mutex.Lock()
+	defer ot.mutex.Unlock()
+
+	for i, override := range ot.overrides {
+		if override.ID != id {
+			continue
+		}
+		ot.overrides = append(ot.overrides[:i], ot.overrides[i+1:]...)
+		atomic.AddInt64(&ot.removed, 1)
+
+		ot.logger.Info("override removed",
+			zap.String("id", id),
+			zap.String("source", override.Source),
+			zap.Int64("hits", atomic.LoadInt64(&override.Hits)),
+		)
+		return true
+	}
+	return false
+}
+
+// List возвращает копии действующих переопределений в порядке проверки
+func (ot *OverrideTable) List() []Override {
+	ot.mutex.Lock()
+	ot.pruneLocked(time.Now())
+	overrides := make([]Override, 0, len(ot.overrides))
+	for _, override := range ot.overrides {
+		entry := *override
+		entry.Hits = atomic.LoadInt64(&override.Hits)
+		overrides = append(overrides, entry)
+	}
+	ot.mutex.Unlock()
+
+	return overrides
+}
+
+// Import добавляет переопределения, созданные через admin API в предыдущем экземпляре
+// (при перезагрузке конфигурации), вместе со счетчиками использования
+func (ot *OverrideTable) Import(previous *OverrideTable) int {
+	if previous == nil || previous == ot {
+		return 0
+	}
+
+	now := time.Now()
+	imported := 0
+
+	ot.mutex.Lock()
+	for _, entry := range previous.List() {
+		if entry.Source != OverrideSourceAPI || entry.expired(now) {
+			continue
+		}
+		override, err := newOverride(entry.OverrideRule, entry.ID, entry.Source, entry.CreatedAt)
+		if err != nil {
+			continue
+		}
+		override.Hits = entry.Hits
+		ot.overrides = append(ot.overrides, override)
+		imported++
+	}
+	sortOverrides(ot.overrides)
+	ot.mutex.Unlock()
+
+	if imported > 0 {
+		ot.logger.Info("runtime overrides carried over", zap.Int("overrides", imported))
+	}
+
+	return imported
+}
+
+// pruneLocked удаляет истекшие переопределения
+func (ot *OverrideTable) pruneLocked(now time.Time) {
+	kept := ot.overrides[:0]
+	for _, override := range ot.overrides {
+		if override.expired(now) {
+			atomic.AddInt64(&ot.expired, 1)
+			ot.logger.Info("override expired",
+				zap.String("id", override.ID),
+				zap.Int64("hits", atomic.LoadInt64(&override.Hits)),
+			)
+			continue
+		}
+		kept = append(kept, override)
+	}
+	for i := len(kept); i < len(ot.overrides); i++ {
+		ot.overrides[i] = nil
+	}
+	ot.overrides = kept
+}
+
+// GetStats возвращает статистику
+func (ot *OverrideTable) GetStats() map[string]interface{} {
+	ot.mutex.RLock()
+	active := len(ot.overrides)
+	ot.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"overrides": active,
+		"checks":    atomic.LoadInt64(&ot.checks),
+		"hits":      atomic.LoadInt64(&ot.hits),
+		"added":     atomic.LoadInt64(&ot.added),
+		"removed":   atomic.LoadInt64(&ot.removed),
+		"expired":   atomic.LoadInt64(&ot.expired),
+	}
+}
@@ -0,0 +1,74 @@
+package botredirect
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestInvalidOverrideRejected проверяет, что неверное переопределение в конфигурации
+// останавливает загрузку с номером правила, а не пропускается
+func TestInvalidOverrideRejected(t *testing.T) {
+	rules := []OverrideRule{
+		{Match: OverrideMatchIP, Value: "198.51.100.0/24", Action: OverrideActionBlock},
+		{Match: OverrideMatchIP, Value: "192.0.2.0/33", Action: OverrideActionBlock},
+	}
+
+	br := &BotRedirect{ListsOverlayFile: "off", Overrides: rules}
+	err := provisionTestHandler(t, br)
+	if err == nil || !strings.Contains(err.Error(), "override 2") {
+		t.Errorf("provision: error = %v, want override 2 error", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableMetrics = false
+	config.Overrides = rules
+	bd, err := NewBotDetector(config, zap.NewNop())
+	if err == nil {
+		bd.Shutdown()
+		t.Fatal("detector created with an invalid override")
+	}
+	if !strings.Contains(err.Error(), "override 2") || !strings.Contains(err.Error(), "192.0.2.0/33") {
+		t.Errorf("detector: error = %v, want override 2 and its value", err)
+	}
+}
+
+// TestOverrideInRequestTrace проверяет, что сработавшее переопределение попадает
+// в трассировку решения самого запроса
+func TestOverrideInRequestTrace(t *testing.T) {
+	br := &BotRedirect{
+		ListsOverlayFile: "off",
+		Overrides: []OverrideRule{
+			{Match: OverrideMatchIP, Value: "192.0.2.0/24", Action: OverrideActionBlock, Reason: "incident"},
+		},
+	}
+	if err := provisionTestHandler(t, br); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.7:4321"
+	explanation, err := br.Explain(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, step := range explanation.Steps {
+		if step.Step != "override" {
+			continue
+		}
+		found = true
+		if step.Result != OverrideActionBlock || step.Details["reason"] != "incident" || step.Details["value"] != "192.0.2.0/24" {
+			t.Errorf("override step = %+v", step)
+		}
+	}
+	if !found {
+		t.Errorf("no override step in trace %+v", explanation.Steps)
+	}
+	if explanation.Upstream {
+		t.Error("blocked request passed upstream")
+	}
+}
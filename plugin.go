@@ -2,8 +2,13 @@ package botredirect
 
 import (
 	"fmt"
+	"html/template"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
@@ -20,6 +25,9 @@ func init() {
 
 // BotRedirect реализует HTTP middleware для разделения ботов и пользователей
 type BotRedirect struct {
+	// Идентификатор экземпляра в admin API (по умолчанию "default")
+	ID string `json:"id,omitempty"`
+
 	// Конфигурационные поля
 	RedirectURL         string         `json:"redirect_url,omitempty"`
 	BotIPRanges         []string       `json:"bot_ip_ranges,omitempty"`
@@ -47,6 +55,89 @@ type BotRedirect struct {
 	VerboseMetrics      bool           `json:"verbose_metrics,omitempty"`
 	MetricsPath         string         `json:"metrics_path,omitempty"`
 	EnablePrometheus    bool           `json:"enable_prometheus,omitempty"`
+	RobotsFile          string         `json:"robots_file,omitempty"`
+	RobotsAction        string         `json:"robots_action,omitempty"`
+
+	// Размеры кешей компонентов
+	UserAgentCacheSize  int `json:"user_agent_cache_size,omitempty"`
+	IPRangeCacheSize    int `json:"ip_range_cache_size,omitempty"`
+	ReferrerCacheSize   int `json:"referrer_cache_size,omitempty"`
+	ReverseDNSCacheSize int `json:"reverse_dns_cache_size,omitempty"`
+
+	// Уровни rate limiting для краулеров и сетей
+	RateLimitAlgorithm  string          `json:"rate_limit_algorithm,omitempty"`
+	RateLimitKey        []string        `json:"rate_limit_key,omitempty"`
+	RateLimitIPv4Prefix int             `json:"rate_limit_ipv4_prefix,omitempty"`
+	RateLimitIPv6Prefix int             `json:"rate_limit_ipv6_prefix,omitempty"`
+	ASNDatabase         string          `json:"asn_database,omitempty"`
+	RateLimitTiers      []RateLimitTier `json:"rate_limit_tiers,omitempty"`
+	RateLimitTemplate   string          `json:"rate_limit_template,omitempty"`
+
+	// Общее хранилище лимитов для нескольких узлов
+	RateLimitStorage      string         `json:"rate_limit_storage,omitempty"`
+	RateLimitRedisURL     string         `json:"rate_limit_redis_url,omitempty"`
+	RateLimitRedisPrefix  string         `json:"rate_limit_redis_prefix,omitempty"`
+	RateLimitRedisTimeout caddy.Duration `json:"rate_limit_redis_timeout,omitempty"`
+	RateLimitFailMode     string         `json:"rate_limit_fail_mode,omitempty"`
+	RateLimitMaxBuckets   int            `json:"rate_limit_max_buckets,omitempty"`
+
+	// Баны с нарастающей длительностью
+	BanThreshold   int            `json:"ban_threshold,omitempty"`
+	BanDuration    caddy.Duration `json:"ban_duration,omitempty"`
+	BanFactor      float64        `json:"ban_factor,omitempty"`
+	BanMaxDuration caddy.Duration `json:"ban_max_duration,omitempty"`
+	BanDecay       caddy.Duration `json:"ban_decay,omitempty"`
+	BanIPv4Prefix  int            `json:"ban_ipv4_prefix,omitempty"`
+	BanIPv6Prefix  int            `json:"ban_ipv6_prefix,omitempty"`
+
+	// Выгрузка правил файрвола
+	FirewallExportDir      string         `json:"firewall_export_dir,omitempty"`
+	FirewallFormats        []string       `json:"firewall_formats,omitempty"`
+	FirewallExportInterval caddy.Duration `json:"firewall_export_interval,omitempty"`
+	FirewallSetName        string         `json:"firewall_set_name,omitempty"`
+	FirewallNftTable       string         `json:"firewall_nft_table,omitempty"`
+	FirewallCIDRs          []string       `json:"firewall_cidrs,omitempty"`
+
+	// Общий кеш кластера в хранилище Caddy
+	SharedCache              bool           `json:"shared_cache,omitempty"`
+	SharedCachePrefix        string         `json:"shared_cache_prefix,omitempty"`
+	SharedCacheLocalTTL      caddy.Duration `json:"shared_cache_local_ttl,omitempty"`
+	SharedCacheFlushInterval caddy.Duration `json:"shared_cache_flush_interval,omitempty"`
+	SharedCacheSyncInterval  caddy.Duration `json:"shared_cache_sync_interval,omitempty"`
+	SharedCacheTimeout       caddy.Duration `json:"shared_cache_timeout,omitempty"`
+
+	// Файл изменений списков через admin API ("off" - изменения не сохраняются)
+	ListsOverlayFile string `json:"lists_overlay_file,omitempty"`
+
+	// Файлы списков, изменения которых применяются без перезагрузки
+	BotUserAgentsFile    string         `json:"bot_user_agents_file,omitempty"`
+	BotIPRangesFile      string         `json:"bot_ip_ranges_file,omitempty"`
+	AllowedReferrersFile string         `json:"allowed_referrers_file,omitempty"`
+	ListFilesInterval    caddy.Duration `json:"list_files_interval,omitempty"`
+
+	// Ручные переопределения классификации и действий
+	Overrides []OverrideRule `json:"overrides,omitempty"`
+
+	// Ловушки для вредоносных ботов
+	HoneypotPaths      []string       `json:"honeypot_paths,omitempty"`
+	HoneypotTTL        caddy.Duration `json:"honeypot_ttl,omitempty"`
+	HoneypotIPv4Prefix int            `json:"honeypot_ipv4_prefix,omitempty"`
+	HoneypotIPv6Prefix int            `json:"honeypot_ipv6_prefix,omitempty"`
+	HoneypotAction     string         `json:"honeypot_action,omitempty"`
+
+	// Proof-of-work проверка браузера для подозрительных клиентов
+	UnverifiedBotAction string         `json:"unverified_bot_action,omitempty"`
+	ChallengeSecrets    []string       `json:"challenge_secrets,omitempty"`
+	ChallengeDifficulty int            `json:"challenge_difficulty,omitempty"`
+	ChallengeTTL        caddy.Duration `json:"challenge_ttl,omitempty"`
+	ChallengeCookieName string         `json:"challenge_cookie_name,omitempty"`
+	ChallengePath       string         `json:"challenge_path,omitempty"`
+
+	// Проверка HTTP Message Signatures подписанных агентов
+	SignatureDirectories  []SignatureDirectory `json:"signature_directories,omitempty"`
+	SignatureMaxAge       caddy.Duration       `json:"signature_max_age,omitempty"`
+	SignatureRefresh      caddy.Duration       `json:"signature_refresh,omitempty"`
+	SignatureRequireNonce bool                 `json:"signature_require_nonce,omitempty"`
 
 	// Главный компонент
 	botDetector *BotDetector `json:"-"`
@@ -66,7 +157,11 @@ func (BotRedirect) CaddyModule() caddy.ModuleInfo {
 // Provision настраивает модуль во время инициализации
 func (br *BotRedirect) Provision(ctx caddy.Context) error {
 	br.logger = ctx.Logger()
+	return br.provision(ctx)
+}
 
+// provision создает компоненты с уже заданным логгером (команды CLI задают свой)
+func (br *BotRedirect) provision(ctx caddy.Context) error {
 	// ИСПРАВЛЕНИЕ: Валидация перед инициализацией
 	if br.RedirectURL == "" {
 		return fmt.Errorf("bot_redirect: redirect_url is required")
@@ -113,8 +208,186 @@ func (br *BotRedirect) Provision(ctx caddy.Context) error {
 		br.CleanupInterval = caddy.Duration(10 * time.Minute)
 	}
 
-	if br.MetricsPath == "" {
-		br.MetricsPath = "/metrics"
+	if br.UserAgentCacheSize == 0 {
+		br.UserAgentCacheSize = 1000
+	}
+
+	if br.IPRangeCacheSize == 0 {
+		br.IPRangeCacheSize = 5000
+	}
+
+	if br.ReferrerCacheSize == 0 {
+		br.ReferrerCacheSize = 3000
+	}
+
+	if br.ReverseDNSCacheSize == 0 {
+		br.ReverseDNSCacheSize = 2000
+	}
+
+	if br.ID == "" {
+		br.ID = defaultHandlerID
+	}
+
+	if br.RobotsAction == "" {
+		br.RobotsAction = string(PolicyActionLog)
+	}
+
+	if br.RateLimitAlgorithm == "" {
+		br.RateLimitAlgorithm = string(LimiterTokenBucket)
+	}
+
+	if br.RateLimitStorage == "" {
+		br.RateLimitStorage = LimiterStorageMemory
+	}
+
+	if br.RateLimitRedisPrefix == "" {
+		br.RateLimitRedisPrefix = "bot_redirect:rl:"
+	}
+
+	if br.RateLimitRedisTimeout == 0 {
+		br.RateLimitRedisTimeout = caddy.Duration(100 * time.Millisecond)
+	}
+
+	if br.RateLimitFailMode == "" {
+		br.RateLimitFailMode = LimiterFailOpen
+	}
+
+	if br.RateLimitMaxBuckets == 0 {
+		br.RateLimitMaxBuckets = 100000
+	}
+
+	if br.BanDuration == 0 {
+		br.BanDuration = caddy.Duration(5 * time.Minute)
+	}
+
+	if br.BanFactor == 0 {
+		br.BanFactor = 2
+	}
+
+	if br.BanMaxDuration == 0 {
+		br.BanMaxDuration = caddy.Duration(24 * time.Hour)
+	}
+
+	if br.BanDecay == 0 {
+		br.BanDecay = caddy.Duration(1 * time.Hour)
+	}
+
+	if br.BanIPv4Prefix == 0 {
+		br.BanIPv4Prefix = 32
+	}
+
+	if br.BanIPv6Prefix == 0 {
+		br.BanIPv6Prefix = 64
+	}
+
+	if len(br.FirewallFormats) == 0 {
+		br.FirewallFormats = []string{FirewallFormatNftables, FirewallFormatIpset, FirewallFormatNginx}
+	}
+
+	if br.FirewallExportInterval == 0 {
+		br.FirewallExportInterval = caddy.Duration(1 * time.Minute)
+	}
+
+	if br.FirewallSetName == "" {
+		br.FirewallSetName = "bot_redirect"
+	}
+
+	if br.FirewallNftTable == "" {
+		br.FirewallNftTable = "inet filter"
+	}
+
+	if br.SharedCachePrefix == "" {
+		br.SharedCachePrefix = "bot_redirect"
+	}
+
+	if br.SharedCacheLocalTTL == 0 {
+		br.SharedCacheLocalTTL = caddy.Duration(1 * time.Minute)
+	}
+
+	if br.SharedCacheFlushInterval == 0 {
+		br.SharedCacheFlushInterval = caddy.Duration(1 * time.Second)
+	}
+
+	if br.SharedCacheSyncInterval == 0 {
+		br.SharedCacheSyncInterval = caddy.Duration(10 * time.Second)
+	}
+
+	if br.SharedCacheTimeout == 0 {
+		br.SharedCacheTimeout = caddy.Duration(200 * time.Millisecond)
+	}
+
+	if br.ListsOverlayFile == "" {
+		br.ListsOverlayFile = filepath.Join(caddy.AppDataDir(), "bot_redirect", br.ID+".lists.json")
+	}
+
+	if br.ListFilesInterval == 0 {
+		br.ListFilesInterval = caddy.Duration(30 * time.Second)
+	}
+
+	if br.RateLimitIPv4Prefix == 0 {
+		br.RateLimitIPv4Prefix = 32
+	}
+
+	if br.RateLimitIPv6Prefix == 0 {
+		br.RateLimitIPv6Prefix = 64
+	}
+
+	if br.HoneypotTTL == 0 {
+		br.HoneypotTTL = caddy.Duration(24 * time.Hour)
+	}
+
+	if br.HoneypotIPv4Prefix == 0 {
+		br.HoneypotIPv4Prefix = 32
+	}
+
+	if br.HoneypotIPv6Prefix == 0 {
+		br.HoneypotIPv6Prefix = 64
+	}
+
+	if br.HoneypotAction == "" {
+		br.HoneypotAction = string(PolicyActionBlock)
+	}
+
+	if br.UnverifiedBotAction == "" {
+		br.UnverifiedBotAction = string(PolicyActionLog)
+	}
+
+	if br.ChallengeDifficulty == 0 {
+		br.ChallengeDifficulty = 16
+	}
+
+	if br.ChallengeTTL == 0 {
+		br.ChallengeTTL = caddy.Duration(1 * time.Hour)
+	}
+
+	if br.ChallengeCookieName == "" {
+		br.ChallengeCookieName = "bot_redirect_clearance"
+	}
+
+	if br.ChallengePath == "" {
+		br.ChallengePath = "/.well-known/bot-redirect/challenge"
+	}
+
+	if br.SignatureMaxAge == 0 {
+		br.SignatureMaxAge = caddy.Duration(5 * time.Minute)
+	}
+
+	if br.SignatureRefresh == 0 {
+		br.SignatureRefresh = caddy.Duration(1 * time.Hour)
+	}
+
+	// Секреты могут задаваться через плейсхолдеры, например {env.CHALLENGE_SECRET}
+	repl := caddy.NewReplacer()
+	challengeSecrets := make([]string, 0, len(br.ChallengeSecrets))
+	for _, secret := range br.ChallengeSecrets {
+		challengeSecrets = append(challengeSecrets, repl.ReplaceAll(secret, ""))
+	}
+	redisURL := repl.ReplaceAll(br.RateLimitRedisURL, "")
+
+	// "off" отключает сохранение изменений списков
+	listsOverlayFile := repl.ReplaceAll(br.ListsOverlayFile, "")
+	if listsOverlayFile == "off" {
+		listsOverlayFile = ""
 	}
 
 	// Создание конфигурации
@@ -136,6 +409,10 @@ func (br *BotRedirect) Provision(ctx caddy.Context) error {
 		RateLimitWindow:     time.Duration(br.RateLimitWindow),
 		MaxCacheSize:        br.MaxCacheSize,
 		CleanupInterval:     time.Duration(br.CleanupInterval),
+		UserAgentCacheSize:  br.UserAgentCacheSize,
+		IPRangeCacheSize:    br.IPRangeCacheSize,
+		ReferrerCacheSize:   br.ReferrerCacheSize,
+		ReverseDNSCacheSize: br.ReverseDNSCacheSize,
 		DNSWorkerPoolSize:   br.DNSWorkerPoolSize,
 		DNSQueueSize:        br.DNSQueueSize,
 		LogLevel:            br.LogLevel,
@@ -145,6 +422,83 @@ func (br *BotRedirect) Provision(ctx caddy.Context) error {
 		VerboseMetrics:      br.VerboseMetrics,
 		MetricsPath:         br.MetricsPath,
 		EnablePrometheus:    br.EnablePrometheus,
+		RobotsFile:          br.RobotsFile,
+		RobotsAction:        br.RobotsAction,
+		RateLimitAlgorithm:  br.RateLimitAlgorithm,
+		RateLimitKey:        br.RateLimitKey,
+		RateLimitIPv4Prefix: br.RateLimitIPv4Prefix,
+		RateLimitIPv6Prefix: br.RateLimitIPv6Prefix,
+		ASNDatabase:         br.ASNDatabase,
+		RateLimitTiers:      br.RateLimitTiers,
+		RateLimitTemplate:   br.RateLimitTemplate,
+
+		// Хранилище лимитов
+		RateLimitStorage:      br.RateLimitStorage,
+		RateLimitRedisURL:     redisURL,
+		RateLimitRedisPrefix:  br.RateLimitRedisPrefix,
+		RateLimitRedisTimeout: time.Duration(br.RateLimitRedisTimeout),
+		RateLimitFailMode:     br.RateLimitFailMode,
+		RateLimitMaxBuckets:   br.RateLimitMaxBuckets,
+
+		// Баны
+		BanThreshold:   br.BanThreshold,
+		BanDuration:    time.Duration(br.BanDuration),
+		BanFactor:      br.BanFactor,
+		BanMaxDuration: time.Duration(br.BanMaxDuration),
+		BanDecay:       time.Duration(br.BanDecay),
+		BanIPv4Prefix:  br.BanIPv4Prefix,
+		BanIPv6Prefix:  br.BanIPv6Prefix,
+
+		// Выгрузка правил файрвола
+		FirewallExportDir:      repl.ReplaceAll(br.FirewallExportDir, ""),
+		FirewallFormats:        br.FirewallFormats,
+		FirewallExportInterval: time.Duration(br.FirewallExportInterval),
+		FirewallSetName:        br.FirewallSetName,
+		FirewallNftTable:       br.FirewallNftTable,
+		FirewallCIDRs:          br.FirewallCIDRs,
+
+		// Общий кеш кластера
+		SharedCache:              br.SharedCache,
+		SharedCachePrefix:        br.SharedCachePrefix,
+		SharedCacheLocalTTL:      time.Duration(br.SharedCacheLocalTTL),
+		SharedCacheFlushInterval: time.Duration(br.SharedCacheFlushInterval),
+		SharedCacheSyncInterval:  time.Duration(br.SharedCacheSyncInterval),
+		SharedCacheTimeout:       time.Duration(br.SharedCacheTimeout),
+
+		// Изменения списков через admin API
+		ListsOverlayFile: listsOverlayFile,
+
+		// Файлы списков
+		BotUserAgentsFile:    repl.ReplaceAll(br.BotUserAgentsFile, ""),
+		BotIPRangesFile:      repl.ReplaceAll(br.BotIPRangesFile, ""),
+		AllowedReferrersFile: repl.ReplaceAll(br.AllowedReferrersFile, ""),
+		ListFilesInterval:    time.Duration(br.ListFilesInterval),
+
+		// Ручные переопределения
+		Overrides: br.Overrides,
+
+		HoneypotPaths:       br.HoneypotPaths,
+		HoneypotTTL:         time.Duration(br.HoneypotTTL),
+		HoneypotIPv4Prefix:  br.HoneypotIPv4Prefix,
+		HoneypotIPv6Prefix:  br.HoneypotIPv6Prefix,
+		HoneypotAction:      br.HoneypotAction,
+		UnverifiedBotAction: br.UnverifiedBotAction,
+		ChallengeSecrets:    challengeSecrets,
+		ChallengeDifficulty: br.ChallengeDifficulty,
+		ChallengeTTL:        time.Duration(br.ChallengeTTL),
+		ChallengeCookieName: br.ChallengeCookieName,
+		ChallengePath:       br.ChallengePath,
+
+		// Проверка подписей агентов
+		SignatureDirectories:  br.SignatureDirectories,
+		SignatureMaxAge:       time.Duration(br.SignatureMaxAge),
+		SignatureRefresh:      time.Duration(br.SignatureRefresh),
+		SignatureRequireNonce: br.SignatureRequireNonce,
+	}
+
+	// Общий кеш использует глобальное хранилище Caddy (опция storage)
+	if br.SharedCache {
+		config.Storage = ctx.Storage()
 	}
 
 	// Дополнительная валидация конфигурации
@@ -152,10 +506,39 @@ func (br *BotRedirect) Provision(ctx caddy.Context) error {
 		return fmt.Errorf("bot_redirect: invalid configuration: %w", err)
 	}
 
+	// Экземпляры одной конфигурации различаются по id в admin API и файле изменений списков
+	if err := checkHandlerID(br, ctx.Context); err != nil {
+		return fmt.Errorf("bot_redirect: %w", err)
+	}
+
+	// Общее хранилище лимитов: недоступный URL не заменяется хранилищем в памяти
+	if config.EnableRateLimit {
+		store, err := NewLimiterStore(config)
+		if err != nil {
+			return fmt.Errorf("bot_redirect: rate_limit_storage: %w", err)
+		}
+		config.RateLimitStore = store
+	}
+
 	// Инициализация главного компонента
-	br.botDetector = NewBotDetector(config, br.logger)
+	botDetector, err := NewBotDetector(config, br.logger)
+	if err != nil {
+		return fmt.Errorf("bot_redirect: %w", err)
+	}
+	br.botDetector = botDetector
+
+	// Переопределения, созданные через admin API, и баны переживают перезагрузку конфигурации:
+	// переносятся только от экземпляра с тем же id из предыдущей конфигурации
+	if previous, ok := previousHandler(br.ID, ctx.Context); ok {
+		br.botDetector.GetOverrideTable().Import(previous.botDetector.GetOverrideTable())
+		br.botDetector.GetBanManager().ImportFrom(previous.botDetector.GetBanManager())
+	}
+
+	// Экземпляр доступен в admin API по идентификатору
+	registerHandler(br, ctx.Context)
 
 	br.logger.Info("bot_redirect plugin provisioned",
+		zap.String("id", br.ID),
 		zap.String("redirect_url", br.RedirectURL),
 		zap.Bool("enable_reverse_dns", br.EnableReverseDNS),
 		zap.Bool("enable_referrer_check", br.EnableReferrerCheck),
@@ -179,19 +562,105 @@ func (br *BotRedirect) Validate() error {
 func (br *BotRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	startTime := time.Now()
 
-	// Проверка rate limiting для общих запросов
-	rateLimiter := br.botDetector.GetRateLimiter()
-	if rateLimiter != nil && !rateLimiter.CheckRequest(r.RemoteAddr) {
-		metrics := br.botDetector.GetMetrics()
-		if metrics != nil {
-			metrics.IncrementRateLimitBlocked()
-		}
-		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	// Метрики плагина по metrics_path (если задан)
+	if br.MetricsPath != "" && r.URL.Path == br.MetricsPath {
+		br.botDetector.GetMetrics().ServeHTTP(w, r)
+		return nil
+	}
+
+	// Результат детекции и выполненное действие учитываются в метриках после обработки
+	var detectionResult *DetectionResult
+	action := requestActionPass
+	if metrics := br.botDetector.GetMetrics(); metrics != nil && metrics.PrometheusEnabled() {
+		defer func() {
+			metrics.RecordRequest(detectionResult, action)
+		}()
+	}
+	defer func() {
+		br.botDetector.TraceStep(r, "response", action, nil)
+	}()
+
+	// Ручные переопределения проверяются до банов и любой детекции
+	override := br.botDetector.CheckOverride(r)
+	if override != nil && override.Action != "" {
+		applied, overrideErr := br.applyOverrideAction(w, r, next, override)
+		action = applied
+		return overrideErr
+	}
+
+	// Забаненные клиенты отклоняются до любой детекции
+	if ban := br.botDetector.CheckBan(r); ban != nil {
+		br.botDetector.TraceStep(r, "ban_check", "banned", map[string]interface{}{
+			"key":          ban.Key,
+			"reason":       ban.Reason,
+			"banned_until": ban.BannedUntil,
+		})
+		action = requestActionBanned
+		w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(time.Until(ban.BannedUntil)), 10))
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return nil
 	}
 
+	// Отправленное решение proof-of-work проверки
+	challenger := br.botDetector.GetChallenger()
+	if challenger != nil && challenger.IsSolutionRequest(r) {
+		action = requestActionChallengeSolution
+		return br.handleChallengeSolution(w, r, challenger)
+	}
+
+	// Клиенты с действующей cookie допуска не проходят детекцию ботов
+	cleared := challenger != nil && challenger.HasClearance(r)
+
+	// Ловушки: отмеченные клиенты обрабатываются политикой до детекции
+	if entry := br.botDetector.CheckHoneypot(r); entry != nil {
+		br.botDetector.TraceStep(r, "honeypot_check", "flagged", map[string]interface{}{
+			"key":    entry.Key,
+			"path":   entry.Path,
+			"action": br.botDetector.GetHoneypot().GetAction(),
+		})
+		if !cleared || br.botDetector.GetHoneypot().GetAction() != PolicyActionChallenge {
+			applied, policyErr := br.applyPolicyAction(w, r, br.botDetector.GetHoneypot().GetAction(), "honeypot:"+entry.Key)
+			if applied != "" || policyErr != nil {
+				action = string(applied)
+				return policyErr
+			}
+		}
+	}
+
 	// Определение типа пользователя через BotDetector
-	detectionResult := br.botDetector.DetectBot(r)
+	if override != nil {
+		detectionResult = br.botDetector.DetectOverridden(override)
+	} else if cleared {
+		detectionResult = br.botDetector.DetectCleared(r)
+	} else {
+		detectionResult = br.botDetector.DetectBot(r)
+	}
+	br.botDetector.TraceStep(r, "classification", detectionResult.UserType.String(), map[string]interface{}{
+		"detection_method": detectionResult.DetectionMethod,
+		"confidence":       detectionResult.Confidence,
+		"matched_pattern":  detectionResult.MatchedPattern,
+		"bot_name":         detectionResult.BotName,
+		"verified":         detectionResult.Verified,
+	})
+
+	// Проверка rate limiting: краулеры учитываются по идентичности и своему уровню
+	rateLimiter := br.botDetector.GetRateLimiter()
+	if rateLimiter != nil {
+		decision := rateLimiter.Allow(br.botDetector.RateLimitSubject(r, detectionResult))
+		if rateLimiter.IsEnabled() {
+			br.botDetector.TraceStep(r, "rate_limit", rateLimitOutcome(decision), map[string]interface{}{
+				"key":       decision.Key,
+				"tier":      decision.Tier,
+				"limit":     decision.Limit,
+				"remaining": decision.Remaining,
+			})
+		}
+		if !decision.Allowed {
+			br.botDetector.RecordOffense(r, OffenseRateLimit)
+			action = string(PolicyActionRateLimit)
+			return br.serveRateLimited(w, r, decision)
+		}
+	}
 
 	br.logger.Debug("request processed",
 		zap.String("ip", r.RemoteAddr),
@@ -207,15 +676,64 @@ func (br *BotRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request, next ca
 
 	switch detectionResult.UserType {
 	case UserTypeBot:
+		// Краулеры, нарушающие robots.txt, обрабатываются политикой
+		robotsResult := br.botDetector.CheckRobots(r, detectionResult)
+		if robotsResult != nil {
+			br.botDetector.TraceStep(r, "robots_check", robotsOutcome(robotsResult), map[string]interface{}{
+				"crawler":       robotsResult.Crawler,
+				"matched_group": robotsResult.MatchedGroup,
+				"matched_rule":  robotsResult.MatchedRule,
+			})
+		}
+		if robotsResult != nil && !robotsResult.Allowed {
+			policyKey := "robots:" + robotsResult.Crawler
+			if rateLimiter != nil {
+				policyKey += "|" + rateLimiter.ClientKey(br.botDetector.RateLimitSubject(r, detectionResult))
+			}
+			applied, policyErr := br.applyPolicyAction(w, r, robotsResult.Action, policyKey)
+			if applied != "" || policyErr != nil {
+				action = string(applied)
+				err = policyErr
+				break
+			}
+		}
+
+		// Боты, не подтвержденные по IP диапазону или обратному DNS, обрабатываются политикой
+		if !detectionResult.Verified {
+			br.botDetector.TraceStep(r, "unverified_bot_policy", br.UnverifiedBotAction, map[string]interface{}{
+				"bot_name": detectionResult.BotName,
+				"spoofed":  detectionResult.Spoofed,
+			})
+
+			// Нарушением считается только подделка известного краулера, не прошедшего проверку;
+			// при проверке браузера клиент должен успеть решить задачу до бана
+			unverifiedAction := PolicyAction(br.UnverifiedBotAction)
+			if detectionResult.Spoofed && unverifiedAction != PolicyActionLog && unverifiedAction != PolicyActionChallenge {
+				br.botDetector.RecordOffense(r, OffenseSpoofing)
+			}
+			policyKey := "unverified:" + detectionResult.BotName
+			if rateLimiter != nil {
+				policyKey += "|" + rateLimiter.ClientKey(br.botDetector.RateLimitSubject(r, detectionResult))
+			}
+			applied, policyErr := br.applyPolicyAction(w, r, unverifiedAction, policyKey)
+			if applied != "" || policyErr != nil {
+				action = string(applied)
+				err = policyErr
+				break
+			}
+		}
+
 		// Боты - показываем оригинальный контент
 		err = next.ServeHTTP(w, r)
 
 	case UserTypeFromSearch:
 		// Пользователи с поисковиков - редирект
+		action = requestActionRedirect
 		http.Redirect(w, r, br.RedirectURL, http.StatusFound)
 
 	case UserTypeDirect:
 		// Прямые заходы - пустая страница
+		action = requestActionEmptyPage
 		templates := br.botDetector.GetTemplates()
 		if templates != nil {
 			err = templates.ServeEmptyPage(w, r)
@@ -237,6 +755,122 @@ func (br *BotRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request, next ca
 	return err
 }
 
+// rateLimitOutcome описывает решение rate limiter для трассировки
+func rateLimitOutcome(decision *RateLimitDecision) string {
+	switch {
+	case decision.Bypassed:
+		return "bypassed"
+	case decision.Allowed:
+		return "allowed"
+	default:
+		return "exceeded"
+	}
+}
+
+// robotsOutcome описывает результат проверки robots.txt для трассировки
+func robotsOutcome(result *RobotsResult) string {
+	if result.Allowed {
+		return "allowed"
+	}
+	return "disallowed"
+}
+
+// applyPolicyAction применяет действие политики к запросу.
+// Возвращает фактически выполненное действие, если ответ уже отправлен,
+// или пустую строку, если дальнейшая обработка нужна.
+func (br *BotRedirect) applyPolicyAction(w http.ResponseWriter, r *http.Request, action PolicyAction, key string) (PolicyAction, error) {
+	switch action {
+	case PolicyActionBlock:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return PolicyActionBlock, nil
+
+	case PolicyActionRateLimit:
+		rateLimiter := br.botDetector.GetRateLimiter()
+		if rateLimiter == nil {
+			return "", nil
+		}
+		if decision := rateLimiter.AllowKey(key); !decision.Allowed {
+			br.botDetector.RecordOffense(r, OffenseRateLimit)
+			return PolicyActionRateLimit, br.serveRateLimited(w, r, decision)
+		}
+		return "", nil
+
+	case PolicyActionChallenge:
+		challenger := br.botDetector.GetChallenger()
+		if challenger == nil || !challenger.IsEnabled() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return PolicyActionBlock, nil
+		}
+
+		data, err := challenger.Issue(r)
+		if err != nil {
+			return PolicyActionChallenge, err
+		}
+		return PolicyActionChallenge, br.botDetector.GetTemplates().ServeChallengePage(w, r, data)
+
+	default:
+		// PolicyActionLog - нарушение уже зарегистрировано
+		return "", nil
+	}
+}
+
+// applyOverrideAction выполняет действие ручного переопределения и возвращает метку действия для метрик
+func (br *BotRedirect) applyOverrideAction(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, override *Override) (string, error) {
+	switch override.Action {
+	case OverrideActionBlock:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return string(PolicyActionBlock), nil
+
+	case OverrideActionChallenge:
+		challenger := br.botDetector.GetChallenger()
+		if challenger != nil && challenger.IsSolutionRequest(r) {
+			return requestActionChallengeSolution, br.handleChallengeSolution(w, r, challenger)
+		}
+		if challenger != nil && challenger.HasClearance(r) {
+			return requestActionPass, next.ServeHTTP(w, r)
+		}
+		applied, err := br.applyPolicyAction(w, r, PolicyActionChallenge, "override:"+override.ID)
+		return string(applied), err
+
+	default:
+		// OverrideActionPass - оригинальный контент без банов, детекции и лимитов
+		return requestActionPass, next.ServeHTTP(w, r)
+	}
+}
+
+// serveRateLimited отдает ответ 429 с заголовками состояния лимита
+func (br *BotRedirect) serveRateLimited(w http.ResponseWriter, r *http.Request, decision *RateLimitDecision) error {
+	decision.SetHeaders(w.Header())
+
+	templates := br.botDetector.GetTemplates()
+	if templates == nil {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return nil
+	}
+	return templates.ServeRateLimitPage(w, r, decision)
+}
+
+// handleChallengeSolution проверяет решение proof-of-work и выдает cookie допуска
+func (br *BotRedirect) handleChallengeSolution(w http.ResponseWriter, r *http.Request, challenger *Challenger) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil
+	}
+
+	returnPath := sanitizeReturnPath(r.PostForm.Get("return"))
+
+	if err := challenger.Verify(r, r.PostForm.Get("challenge"), r.PostForm.Get("nonce")); err != nil {
+		// Повторный заход на исходную страницу выдаст новую проверку
+		http.Redirect(w, r, returnPath, http.StatusSeeOther)
+		return nil
+	}
+
+	http.SetCookie(w, challenger.Clearance(r))
+	http.Redirect(w, r, returnPath, http.StatusSeeOther)
+	return nil
+}
+
 // serveDefaultEmptyPage отдает базовую пустую HTML страницу
 func (br *BotRedirect) serveDefaultEmptyPage(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -254,6 +888,14 @@ func (br *BotRedirect) serveDefaultEmptyPage(w http.ResponseWriter, r *http.Requ
 
 // validateConfig проверяет корректность конфигурации
 func (br *BotRedirect) validateConfig(config *Config) error {
+	if !handlerIDPattern.MatchString(br.ID) {
+		return fmt.Errorf("id may contain only letters, digits, '.', '_' and '-' (up to 64 characters): %s", br.ID)
+	}
+
+	if config.MetricsPath != "" && !strings.HasPrefix(config.MetricsPath, "/") {
+		return fmt.Errorf("metrics_path must start with /: %s", config.MetricsPath)
+	}
+
 	if config.CacheTTL < 0 {
 		return fmt.Errorf("cache_ttl must be positive")
 	}
@@ -282,255 +924,407 @@ func (br *BotRedirect) validateConfig(config *Config) error {
 		return fmt.Errorf("max_cache_size must be at least 100")
 	}
 
-	return nil
-}
-
-// getDefaultEmptyPageTemplate возвращает базовый шаблон пустой страницы
-func getDefaultEmptyPageTemplate() string {
-	return `<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="utf-8">
-    <title>Page Not Found</title>
-    <meta name="robots" content="noindex, nofollow">
-    <style>
-        body { font-family: Arial, sans-serif; text-align: center; padding: 50px; }
-        h1 { color: #666; }
-    </style>
-</head>
-<body>
-    <h1>404 - Page Not Found</h1>
-    <p>The requested page could not be found.</p>
-</body>
-</html>`
-}
-
-// parseCaddyfile парсит конфигурацию из Caddyfile
-func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
-	var br BotRedirect
-
-	err := br.UnmarshalCaddyfile(h.Dispenser)
-	if err != nil {
-		return nil, err
+	for name, size := range map[string]int{
+		"user_agent":  config.UserAgentCacheSize,
+		"ip_range":    config.IPRangeCacheSize,
+		"referrer":    config.ReferrerCacheSize,
+		"reverse_dns": config.ReverseDNSCacheSize,
+	} {
+		if size < 1 {
+			return fmt.Errorf("cache_size %s must be at least 1", name)
+		}
 	}
 
-	return &br, nil
-}
+	if _, err := ParsePolicyAction(config.RobotsAction); err != nil {
+		return fmt.Errorf("robots_action: %w", err)
+	}
 
-// UnmarshalCaddyfile реализует парсинг Caddyfile
-func (br *BotRedirect) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
-	for d.Next() {
-		for d.NextBlock(0) {
-			switch d.Val() {
-			case "redirect_url":
-				if !d.Args(&br.RedirectURL) {
-					return d.ArgErr()
-				}
+	if _, err := ParsePolicyAction(config.HoneypotAction); err != nil {
+		return fmt.Errorf("honeypot_action: %w", err)
+	}
 
-			case "bot_ip_ranges":
-				br.BotIPRanges = d.RemainingArgs()
+	if _, err := ParsePolicyAction(config.UnverifiedBotAction); err != nil {
+		return fmt.Errorf("unverified_bot_action: %w", err)
+	}
 
-			case "bot_user_agents":
-				br.BotUserAgents = d.RemainingArgs()
+	// Проверка браузера требует секрета, общего для всех экземпляров
+	challengeUsed := config.RobotsAction == string(PolicyActionChallenge) ||
+		config.HoneypotAction == string(PolicyActionChallenge) ||
+		config.UnverifiedBotAction == string(PolicyActionChallenge)
+	if challengeUsed && len(config.ChallengeSecrets) == 0 {
+		return fmt.Errorf("challenge action requires challenge_secret")
+	}
 
-			case "allowed_referrers":
-				br.AllowedReferrers = d.RemainingArgs()
+	for _, secret := range config.ChallengeSecrets {
+		if len(secret) < 16 {
+			return fmt.Errorf("challenge_secret must be at least 16 characters")
+		}
+	}
 
-			case "enable_reverse_dns":
-				if d.NextArg() {
-					br.EnableReverseDNS = d.Val() == "true"
-				} else {
-					br.EnableReverseDNS = true
-				}
+	if config.ChallengeDifficulty < 1 || config.ChallengeDifficulty > 28 {
+		return fmt.Errorf("challenge_difficulty must be between 1 and 28")
+	}
 
-			case "enable_referrer_check":
-				if d.NextArg() {
-					br.EnableReferrerCheck = d.Val() == "true"
-				} else {
-					br.EnableReferrerCheck = true
-				}
+	if config.ChallengeTTL <= 0 {
+		return fmt.Errorf("challenge_ttl must be positive")
+	}
 
-			case "enable_metrics":
-				if d.NextArg() {
-					br.EnableMetrics = d.Val() == "true"
-				} else {
-					br.EnableMetrics = true
-				}
+	if !strings.HasPrefix(config.ChallengePath, "/") {
+		return fmt.Errorf("challenge_path must start with /")
+	}
 
-			case "enable_rate_limit":
-				if d.NextArg() {
-					br.EnableRateLimit = d.Val() == "true"
-				} else {
-					br.EnableRateLimit = true
-				}
+	for _, dir := range config.SignatureDirectories {
+		if normalizeAgentOrigin(dir.Agent) == "" {
+			return fmt.Errorf("signature_directory: invalid agent %s", dir.Agent)
+		}
+	}
 
-			case "enable_debug":
-				if d.NextArg() {
-					br.EnableDebug = d.Val() == "true"
-				} else {
-					br.EnableDebug = true
-				}
+	if config.SignatureMaxAge <= 0 {
+		return fmt.Errorf("signature_max_age must be positive")
+	}
 
-			case "empty_page_template":
-				if !d.Args(&br.EmptyPageTemplate) {
-					return d.ArgErr()
-				}
+	if config.SignatureRefresh < time.Minute {
+		return fmt.Errorf("signature_refresh must be at least 1m")
+	}
 
-			case "cache_ttl":
-				var ttlStr string
-				if !d.Args(&ttlStr) {
-					return d.ArgErr()
-				}
+	if config.HoneypotTTL < 0 {
+		return fmt.Errorf("honeypot_ttl must be positive")
+	}
 
-				ttl, err := time.ParseDuration(ttlStr)
-				if err != nil {
-					return d.Errf("invalid cache_ttl duration: %v", err)
-				}
-				br.CacheTTL = caddy.Duration(ttl)
+	if config.HoneypotIPv4Prefix < 1 || config.HoneypotIPv4Prefix > 32 {
+		return fmt.Errorf("honeypot_prefix_v4 must be between 1 and 32")
+	}
 
-			case "dns_timeout":
-				var timeoutStr string
-				if !d.Args(&timeoutStr) {
-					return d.ArgErr()
-				}
+	if config.HoneypotIPv6Prefix < 1 || config.HoneypotIPv6Prefix > 128 {
+		return fmt.Errorf("honeypot_prefix_v6 must be between 1 and 128")
+	}
 
-				timeout, err := time.ParseDuration(timeoutStr)
-				if err != nil {
-					return d.Errf("invalid dns_timeout duration: %v", err)
-				}
-				br.DNSTimeout = caddy.Duration(timeout)
+	for _, path := range config.HoneypotPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("honeypot path must start with /: %s", path)
+		}
+	}
 
-			case "max_dns_per_second":
-				var maxDNSStr string
-				if !d.Args(&maxDNSStr) {
-					return d.ArgErr()
-				}
+	if _, err := ParseLimiterAlgorithm(config.RateLimitAlgorithm); err != nil {
+		return fmt.Errorf("rate_limit_algorithm: %w", err)
+	}
 
-				maxDNS, err := strconv.Atoi(maxDNSStr)
-				if err != nil {
-					return d.Errf("invalid max_dns_per_second: %v", err)
-				}
-				br.MaxDNSPerSecond = maxDNS
+	if config.RateLimitTemplate != "" {
+		if _, err := template.New("rate_limit").Parse(config.RateLimitTemplate); err != nil {
+			return fmt.Errorf("rate_limit_template: %w", err)
+		}
+	}
 
-			case "max_requests_per_ip":
-				var maxReqStr string
-				if !d.Args(&maxReqStr) {
-					return d.ArgErr()
-				}
+	switch config.RateLimitStorage {
+	case LimiterStorageMemory:
+	case LimiterStorageRedis:
+		if config.RateLimitRedisURL == "" {
+			return fmt.Errorf("rate_limit_storage redis requires a URL")
+		}
+		if _, err := newRedisClient(config.RateLimitRedisURL, config.RateLimitRedisTimeout); err != nil {
+			return fmt.Errorf("rate_limit_storage: %w", err)
+		}
+		if config.RateLimitRedisTimeout <= 0 {
+			return fmt.Errorf("rate_limit_redis_timeout must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown rate_limit_storage: %s", config.RateLimitStorage)
+	}
 
-				maxReq, err := strconv.Atoi(maxReqStr)
-				if err != nil {
-					return d.Errf("invalid max_requests_per_ip: %v", err)
-				}
-				br.MaxRequestsPerIP = maxReq
+	if config.RateLimitFailMode != LimiterFailOpen && config.RateLimitFailMode != LimiterFailClosed {
+		return fmt.Errorf("rate_limit_fail_mode must be open or closed")
+	}
 
-			case "rate_limit_window":
-				var windowStr string
-				if !d.Args(&windowStr) {
-					return d.ArgErr()
-				}
+	if config.RateLimitMaxBuckets < limiterStoreShards {
+		return fmt.Errorf("rate_limit_max_buckets must be at least %d", limiterStoreShards)
+	}
 
-				window, err := time.ParseDuration(windowStr)
-				if err != nil {
-					return d.Errf("invalid rate_limit_window duration: %v", err)
-				}
-				br.RateLimitWindow = caddy.Duration(window)
+	if config.BanThreshold < 0 {
+		return fmt.Errorf("ban_threshold cannot be negative")
+	}
+	if config.BanThreshold > 0 {
+		if config.BanDuration <= 0 {
+			return fmt.Errorf("ban_duration must be positive")
+		}
+		if config.BanFactor < 1 {
+			return fmt.Errorf("ban_factor must be at least 1")
+		}
+		if config.BanMaxDuration < config.BanDuration {
+			return fmt.Errorf("ban_max_duration must not be less than ban_duration")
+		}
+		if config.BanDecay <= 0 {
+			return fmt.Errorf("ban_decay must be positive")
+		}
+		if config.BanIPv4Prefix < 1 || config.BanIPv4Prefix > 32 {
+			return fmt.Errorf("ban_prefix_v4 must be between 1 and 32")
+		}
+		if config.BanIPv6Prefix < 1 || config.BanIPv6Prefix > 128 {
+			return fmt.Errorf("ban_prefix_v6 must be between 1 and 128")
+		}
+	}
 
-			case "max_cache_size":
-				var cacheStr string
-				if !d.Args(&cacheStr) {
-					return d.ArgErr()
-				}
+	if config.FirewallExportDir != "" {
+		info, err := os.Stat(config.FirewallExportDir)
+		if err != nil {
+			return fmt.Errorf("invalid firewall_export directory: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("firewall_export path is not a directory: %s", config.FirewallExportDir)
+		}
+		for _, format := range config.FirewallFormats {
+			switch format {
+			case FirewallFormatNftables, FirewallFormatIpset, FirewallFormatNginx:
+			default:
+				return fmt.Errorf("invalid firewall export format: %s (must be nftables, ipset or nginx)", format)
+			}
+		}
+		if config.FirewallExportInterval < time.Second {
+			return fmt.Errorf("firewall_export_interval must be at least 1s")
+		}
+		if !firewallSetNamePattern.MatchString(config.FirewallSetName) {
+			return fmt.Errorf("invalid firewall_set_name: %s", config.FirewallSetName)
+		}
+		if len(strings.Fields(config.FirewallNftTable)) != 2 {
+			return fmt.Errorf("firewall_nft_table must be '<family> <table>': %s", config.FirewallNftTable)
+		}
+	}
+	for _, cidr := range config.FirewallCIDRs {
+		if _, err := parseFirewallPrefix(cidr); err != nil {
+			return fmt.Errorf("invalid firewall_cidr %s: %v", cidr, err)
+		}
+	}
 
-				cacheSize, err := strconv.Atoi(cacheStr)
-				if err != nil {
-					return d.Errf("invalid max_cache_size: %v", err)
-				}
-				br.MaxCacheSize = cacheSize
+	if config.SharedCache {
+		if config.SharedCachePrefix == "" || strings.Contains(config.SharedCachePrefix, "..") {
+			return fmt.Errorf("invalid shared_cache prefix: %s", config.SharedCachePrefix)
+		}
+		if config.SharedCacheLocalTTL <= 0 {
+			return fmt.Errorf("shared_cache_local_ttl must be positive")
+		}
+		if config.SharedCacheFlushInterval <= 0 {
+			return fmt.Errorf("shared_cache_flush_interval must be positive")
+		}
+		if config.SharedCacheSyncInterval <= 0 {
+			return fmt.Errorf("shared_cache_sync_interval must be positive")
+		}
+		if config.SharedCacheTimeout <= 0 {
+			return fmt.Errorf("shared_cache_timeout must be positive")
+		}
+	}
 
-			case "cleanup_interval":
-				var intervalStr string
-				if !d.Args(&intervalStr) {
-					return d.ArgErr()
-				}
+	if config.AllowedReferrersFile != "" && !config.EnableReferrerCheck {
+		return fmt.Errorf("allowed_referrers_file requires enable_referrer_check")
+	}
+	if config.BotUserAgentsFile != "" || config.BotIPRangesFile != "" || config.AllowedReferrersFile != "" {
+		if config.ListFilesInterval < time.Second {
+			return fmt.Errorf("list_files_interval must be at least 1s")
+		}
+	}
 
-				interval, err := time.ParseDuration(intervalStr)
-				if err != nil {
-					return d.Errf("invalid cleanup_interval duration: %v", err)
-				}
-				br.CleanupInterval = caddy.Duration(interval)
+	if config.RateLimitIPv4Prefix < 1 || config.RateLimitIPv4Prefix > 32 {
+		return fmt.Errorf("rate_limit_prefix_v4 must be between 1 and 32")
+	}
 
-			case "dns_worker_pool_size":
-				var poolSizeStr string
-				if !d.Args(&poolSizeStr) {
-					return d.ArgErr()
-				}
+	if config.RateLimitIPv6Prefix < 1 || config.RateLimitIPv6Prefix > 128 {
+		return fmt.Errorf("rate_limit_prefix_v6 must be between 1 and 128")
+	}
 
-				poolSize, err := strconv.Atoi(poolSizeStr)
-				if err != nil {
-					return d.Errf("invalid dns_worker_pool_size: %v", err)
-				}
-				br.DNSWorkerPoolSize = poolSize
+	if err := validateRateLimitKey(config.RateLimitKey, config.ASNDatabase); err != nil {
+		return fmt.Errorf("rate_limit_key: %w", err)
+	}
 
-			case "dns_queue_size":
-				var queueSizeStr string
-				if !d.Args(&queueSizeStr) {
-					return d.ArgErr()
-				}
+	for i, rule := range config.Overrides {
+		if err := validateOverrideRule(rule); err != nil {
+			return fmt.Errorf("override %d: %w", i+1, err)
+		}
+	}
 
-				queueSize, err := strconv.Atoi(queueSizeStr)
-				if err != nil {
-					return d.Errf("invalid dns_queue_size: %v", err)
-				}
-				br.DNSQueueSize = queueSize
+	tierNames := make(map[string]bool)
+	for _, tier := range config.RateLimitTiers {
+		if tier.Name == "" {
+			return fmt.Errorf("rate_limit_tier name is required")
+		}
+		if tierNames[tier.Name] {
+			return fmt.Errorf("duplicate rate_limit_tier: %s", tier.Name)
+		}
+		tierNames[tier.Name] = true
 
-			case "log_level":
-				if !d.Args(&br.LogLevel) {
-					return d.ArgErr()
-				}
+		if tier.MaxRequests < 0 {
+			return fmt.Errorf("rate_limit_tier %s: max_requests must be positive", tier.Name)
+		}
+		if tier.Window < 0 {
+			return fmt.Errorf("rate_limit_tier %s: window must be positive", tier.Name)
+		}
+		if tier.Algorithm != "" {
+			if _, err := ParseLimiterAlgorithm(tier.Algorithm); err != nil {
+				return fmt.Errorf("rate_limit_tier %s: %w", tier.Name, err)
+			}
+		}
+		if err := validateRateLimitKey(tier.Key, config.ASNDatabase); err != nil {
+			return fmt.Errorf("rate_limit_tier %s: %w", tier.Name, err)
+		}
+		if tier.IPv4Prefix < 0 || tier.IPv4Prefix > 32 {
+			return fmt.Errorf("rate_limit_tier %s: prefix_v4 must be between 1 and 32", tier.Name)
+		}
+		if tier.IPv6Prefix < 0 || tier.IPv6Prefix > 128 {
+			return fmt.Errorf("rate_limit_tier %s: prefix_v6 must be between 1 and 128", tier.Name)
+		}
+		for _, cidr := range tier.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("rate_limit_tier %s: invalid CIDR %s", tier.Name, cidr)
+			}
+		}
+	}
 
-			case "log_all_requests":
-				if d.NextArg() {
-					br.LogAllRequests = d.Val() == "true"
-				} else {
-					br.LogAllRequests = true
-				}
+	return nil
+}
 
-			case "log_dns_queries":
-				if d.NextArg() {
-					br.LogDNSQueries = d.Val() == "true"
-				} else {
-					br.LogDNSQueries = true
-				}
+// validateRateLimitKey проверяет компоненты ключа rate limiting
+func validateRateLimitKey(parts []string, asnDatabase string) error {
+	for _, part := range parts {
+		if err := ValidateRateLimitKeyPart(part); err != nil {
+			return err
+		}
+		if part == RateLimitKeyASN && asnDatabase == "" {
+			return fmt.Errorf("key component asn requires asn_database")
+		}
+	}
+	return nil
+}
 
-			case "log_cache_ops":
-				if d.NextArg() {
-					br.LogCacheOps = d.Val() == "true"
-				} else {
-					br.LogCacheOps = true
-				}
+// getDefaultEmptyPageTemplate возвращает базовый шаблон пустой страницы
+func getDefaultEmptyPageTemplate() string {
+	return `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Page Not Found</title>
+    <meta name="robots" content="noindex, nofollow">
+    <style>
+        body { font-family: Arial, sans-serif; text-align: center; padding: 50px; }
+        h1 { color: #666; }
+    </style>
+</head>
+<body>
+    <h1>404 - Page Not Found</h1>
+    <p>The requested page could not be found.</p>
+</body>
+</html>`
+}
 
-			case "verbose_metrics":
-				if d.NextArg() {
-					br.VerboseMetrics = d.Val() == "true"
-				} else {
-					br.VerboseMetrics = true
-				}
+// parseCaddyfile парсит конфигурацию из Caddyfile
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var br BotRedirect
 
-			case "metrics_path":
-				if !d.Args(&br.MetricsPath) {
-					return d.ArgErr()
-				}
+	err := br.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
+	}
 
-			case "enable_prometheus":
-				if d.NextArg() {
-					br.EnablePrometheus = d.Val() == "true"
-				} else {
-					br.EnablePrometheus = true
+	return &br, nil
+}
+
+// caddyfileGroups блоки группировки опций Caddyfile: короткое имя в блоке -> опция верхнего уровня.
+// Блоки - только синтаксис Caddyfile, JSON конфигурация не меняется; опции верхнего уровня
+// продолжают работать.
+var caddyfileGroups = map[string]map[string]string{
+	"dns": {
+		"enabled":        "enable_reverse_dns",
+		"timeout":        "dns_timeout",
+		"max_per_second": "max_dns_per_second",
+		"workers":        "dns_worker_pool_size",
+		"queue_size":     "dns_queue_size",
+	},
+	"rate_limit": {
+		"enabled":       "enable_rate_limit",
+		"requests":      "max_requests_per_ip",
+		"window":        "rate_limit_window",
+		"algorithm":     "rate_limit_algorithm",
+		"key":           "rate_limit_key",
+		"prefix_v4":     "rate_limit_prefix_v4",
+		"prefix_v6":     "rate_limit_prefix_v6",
+		"asn_database":  "asn_database",
+		"storage":       "rate_limit_storage",
+		"redis_prefix":  "rate_limit_redis_prefix",
+		"redis_timeout": "rate_limit_redis_timeout",
+		"max_buckets":   "rate_limit_max_buckets",
+		"fail_mode":     "rate_limit_fail_mode",
+		"template":      "rate_limit_template",
+		"tier":          "rate_limit_tier",
+	},
+	"cache": {
+		"ttl":                   "cache_ttl",
+		"max_size":              "max_cache_size",
+		"size":                  "cache_size",
+		"cleanup_interval":      "cleanup_interval",
+		"shared":                "shared_cache",
+		"shared_local_ttl":      "shared_cache_local_ttl",
+		"shared_flush_interval": "shared_cache_flush_interval",
+		"shared_sync_interval":  "shared_cache_sync_interval",
+		"shared_timeout":        "shared_cache_timeout",
+	},
+	"lists": {
+		"user_agents":      "bot_user_agents",
+		"ip_ranges":        "bot_ip_ranges",
+		"referrers":        "allowed_referrers",
+		"referrer_check":   "enable_referrer_check",
+		"user_agents_file": "bot_user_agents_file",
+		"ip_ranges_file":   "bot_ip_ranges_file",
+		"referrers_file":   "allowed_referrers_file",
+		"files_interval":   "list_files_interval",
+		"overlay_file":     "lists_overlay_file",
+	},
+	"policy": {
+		"unverified_bot_action": "unverified_bot_action",
+		"robots_file":           "robots_file",
+		"robots_action":         "robots_action",
+		"honeypot":              "honeypot",
+		"honeypot_ttl":          "honeypot_ttl",
+		"honeypot_prefix_v4":    "honeypot_prefix_v4",
+		"honeypot_prefix_v6":    "honeypot_prefix_v6",
+		"honeypot_action":       "honeypot_action",
+		"challenge_secret":      "challenge_secret",
+		"challenge_difficulty":  "challenge_difficulty",
+		"challenge_ttl":         "challenge_ttl",
+		"challenge_cookie":      "challenge_cookie",
+		"challenge_path":        "challenge_path",
+		"ban_threshold":         "ban_threshold",
+		"ban_duration":          "ban_duration",
+		"ban_factor":            "ban_factor",
+		"ban_max_duration":      "ban_max_duration",
+		"ban_decay":             "ban_decay",
+		"ban_prefix_v4":         "ban_prefix_v4",
+		"ban_prefix_v6":         "ban_prefix_v6",
+		"override":              "override",
+		"empty_page_template":   "empty_page_template",
+	},
+	"logging": {
+		"level":           "log_level",
+		"debug":           "enable_debug",
+		"all_requests":    "log_all_requests",
+		"dns_queries":     "log_dns_queries",
+		"cache_ops":       "log_cache_ops",
+		"metrics":         "enable_metrics",
+		"verbose_metrics": "verbose_metrics",
+		"metrics_path":    "metrics_path",
+		"prometheus":      "enable_prometheus",
+	},
+}
+
+// UnmarshalCaddyfile реализует парсинг Caddyfile
+func (br *BotRedirect) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			option := d.Val()
+			if group, ok := caddyfileGroups[option]; ok {
+				if err := br.unmarshalGroup(d, option, group); err != nil {
+					return err
 				}
+				continue
+			}
 
-			default:
-				return d.Errf("unknown directive: %s", d.Val())
+			if err := br.unmarshalOption(d, option); err != nil {
+				return err
 			}
 		}
 	}
@@ -538,6 +1332,952 @@ func (br *BotRedirect) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	return nil
 }
 
+// unmarshalGroup разбирает блок группы опций (dns, rate_limit, cache, lists, policy, logging).
+// Опции внутри блока - короткие имена опций верхнего уровня.
+func (br *BotRedirect) unmarshalGroup(d *caddyfile.Dispenser, group string, options map[string]string) error {
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		option, ok := options[d.Val()]
+		if !ok {
+			return d.Errf("unknown %s option: %s", group, d.Val())
+		}
+		if err := br.unmarshalOption(d, option); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unmarshalOption разбирает одну опцию верхнего уровня; курсор стоит на имени опции
+func (br *BotRedirect) unmarshalOption(d *caddyfile.Dispenser, option string) error {
+	switch option {
+	case "id":
+		if !d.Args(&br.ID) {
+			return d.ArgErr()
+		}
+
+	case "redirect_url":
+		if !d.Args(&br.RedirectURL) {
+			return d.ArgErr()
+		}
+
+	case "bot_ip_ranges":
+		br.BotIPRanges = d.RemainingArgs()
+
+	case "bot_user_agents":
+		br.BotUserAgents = d.RemainingArgs()
+
+	case "allowed_referrers":
+		br.AllowedReferrers = d.RemainingArgs()
+
+	case "enable_reverse_dns":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.EnableReverseDNS = value
+
+	case "enable_referrer_check":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.EnableReferrerCheck = value
+
+	case "enable_metrics":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.EnableMetrics = value
+
+	case "enable_rate_limit":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.EnableRateLimit = value
+
+	case "enable_debug":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.EnableDebug = value
+
+	case "empty_page_template":
+		if !d.Args(&br.EmptyPageTemplate) {
+			return d.ArgErr()
+		}
+
+	case "rate_limit_template":
+		if !d.Args(&br.RateLimitTemplate) {
+			return d.ArgErr()
+		}
+
+	case "cache_ttl":
+		var ttlStr string
+		if !d.Args(&ttlStr) {
+			return d.ArgErr()
+		}
+
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			return d.Errf("invalid cache_ttl duration: %v", err)
+		}
+		br.CacheTTL = caddy.Duration(ttl)
+
+	case "dns_timeout":
+		var timeoutStr string
+		if !d.Args(&timeoutStr) {
+			return d.ArgErr()
+		}
+
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return d.Errf("invalid dns_timeout duration: %v", err)
+		}
+		br.DNSTimeout = caddy.Duration(timeout)
+
+	case "max_dns_per_second":
+		var maxDNSStr string
+		if !d.Args(&maxDNSStr) {
+			return d.ArgErr()
+		}
+
+		maxDNS, err := strconv.Atoi(maxDNSStr)
+		if err != nil {
+			return d.Errf("invalid max_dns_per_second: %v", err)
+		}
+		br.MaxDNSPerSecond = maxDNS
+
+	case "max_requests_per_ip":
+		var maxReqStr string
+		if !d.Args(&maxReqStr) {
+			return d.ArgErr()
+		}
+
+		maxReq, err := strconv.Atoi(maxReqStr)
+		if err != nil {
+			return d.Errf("invalid max_requests_per_ip: %v", err)
+		}
+		br.MaxRequestsPerIP = maxReq
+
+	case "rate_limit_window":
+		var windowStr string
+		if !d.Args(&windowStr) {
+			return d.ArgErr()
+		}
+
+		window, err := time.ParseDuration(windowStr)
+		if err != nil {
+			return d.Errf("invalid rate_limit_window duration: %v", err)
+		}
+		br.RateLimitWindow = caddy.Duration(window)
+
+	case "max_cache_size":
+		var cacheStr string
+		if !d.Args(&cacheStr) {
+			return d.ArgErr()
+		}
+
+		cacheSize, err := strconv.Atoi(cacheStr)
+		if err != nil {
+			return d.Errf("invalid max_cache_size: %v", err)
+		}
+		br.MaxCacheSize = cacheSize
+
+	case "cache_size":
+		var component, sizeStr string
+		if !d.Args(&component, &sizeStr) {
+			return d.ArgErr()
+		}
+
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			return d.Errf("invalid cache_size: %v", err)
+		}
+
+		switch component {
+		case "detection":
+			br.MaxCacheSize = size
+		case "user_agent":
+			br.UserAgentCacheSize = size
+		case "ip_range":
+			br.IPRangeCacheSize = size
+		case "referrer":
+			br.ReferrerCacheSize = size
+		case "reverse_dns":
+			br.ReverseDNSCacheSize = size
+		default:
+			return d.Errf("unknown cache_size component: %s (must be detection, user_agent, ip_range, referrer or reverse_dns)", component)
+		}
+
+	case "cleanup_interval":
+		var intervalStr string
+		if !d.Args(&intervalStr) {
+			return d.ArgErr()
+		}
+
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			return d.Errf("invalid cleanup_interval duration: %v", err)
+		}
+		br.CleanupInterval = caddy.Duration(interval)
+
+	case "dns_worker_pool_size":
+		var poolSizeStr string
+		if !d.Args(&poolSizeStr) {
+			return d.ArgErr()
+		}
+
+		poolSize, err := strconv.Atoi(poolSizeStr)
+		if err != nil {
+			return d.Errf("invalid dns_worker_pool_size: %v", err)
+		}
+		br.DNSWorkerPoolSize = poolSize
+
+	case "dns_queue_size":
+		var queueSizeStr string
+		if !d.Args(&queueSizeStr) {
+			return d.ArgErr()
+		}
+
+		queueSize, err := strconv.Atoi(queueSizeStr)
+		if err != nil {
+			return d.Errf("invalid dns_queue_size: %v", err)
+		}
+		br.DNSQueueSize = queueSize
+
+	case "log_level":
+		if !d.Args(&br.LogLevel) {
+			return d.ArgErr()
+		}
+
+	case "log_all_requests":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.LogAllRequests = value
+
+	case "log_dns_queries":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.LogDNSQueries = value
+
+	case "log_cache_ops":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.LogCacheOps = value
+
+	case "verbose_metrics":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.VerboseMetrics = value
+
+	case "metrics_path":
+		if !d.Args(&br.MetricsPath) {
+			return d.ArgErr()
+		}
+
+	case "enable_prometheus":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.EnablePrometheus = value
+
+	case "robots_file":
+		if !d.Args(&br.RobotsFile) {
+			return d.ArgErr()
+		}
+
+	case "rate_limit_algorithm":
+		if !d.Args(&br.RateLimitAlgorithm) {
+			return d.ArgErr()
+		}
+		if _, err := ParseLimiterAlgorithm(br.RateLimitAlgorithm); err != nil {
+			return d.Errf("invalid rate_limit_algorithm: %v", err)
+		}
+
+	case "rate_limit_key":
+		parts := d.RemainingArgs()
+		if len(parts) == 0 {
+			return d.ArgErr()
+		}
+		for _, part := range parts {
+			if err := ValidateRateLimitKeyPart(part); err != nil {
+				return d.Errf("invalid rate_limit_key: %v", err)
+			}
+		}
+		br.RateLimitKey = parts
+
+	case "rate_limit_prefix_v4":
+		var prefixStr string
+		if !d.Args(&prefixStr) {
+			return d.ArgErr()
+		}
+
+		prefix, err := strconv.Atoi(prefixStr)
+		if err != nil {
+			return d.Errf("invalid rate_limit_prefix_v4: %v", err)
+		}
+		br.RateLimitIPv4Prefix = prefix
+
+	case "rate_limit_prefix_v6":
+		var prefixStr string
+		if !d.Args(&prefixStr) {
+			return d.ArgErr()
+		}
+
+		prefix, err := strconv.Atoi(prefixStr)
+		if err != nil {
+			return d.Errf("invalid rate_limit_prefix_v6: %v", err)
+		}
+		br.RateLimitIPv6Prefix = prefix
+
+	case "asn_database":
+		if !d.Args(&br.ASNDatabase) {
+			return d.ArgErr()
+		}
+
+	case "rate_limit_storage":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		br.RateLimitStorage = d.Val()
+
+		switch br.RateLimitStorage {
+		case LimiterStorageMemory:
+		case LimiterStorageRedis:
+			if !d.Args(&br.RateLimitRedisURL) {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unknown rate_limit_storage: %s", br.RateLimitStorage)
+		}
+
+	case "rate_limit_redis_prefix":
+		if !d.Args(&br.RateLimitRedisPrefix) {
+			return d.ArgErr()
+		}
+
+	case "rate_limit_redis_timeout":
+		var timeoutStr string
+		if !d.Args(&timeoutStr) {
+			return d.ArgErr()
+		}
+
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return d.Errf("invalid rate_limit_redis_timeout duration: %v", err)
+		}
+		br.RateLimitRedisTimeout = caddy.Duration(timeout)
+
+	case "rate_limit_max_buckets":
+		var maxStr string
+		if !d.Args(&maxStr) {
+			return d.ArgErr()
+		}
+
+		maxBuckets, err := strconv.Atoi(maxStr)
+		if err != nil {
+			return d.Errf("invalid rate_limit_max_buckets: %v", err)
+		}
+		br.RateLimitMaxBuckets = maxBuckets
+
+	case "ban_threshold":
+		var valueStr string
+		if !d.Args(&valueStr) {
+			return d.ArgErr()
+		}
+
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return d.Errf("invalid ban_threshold: %v", err)
+		}
+		br.BanThreshold = value
+
+	case "ban_duration":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid ban_duration duration: %v", err)
+		}
+		br.BanDuration = caddy.Duration(duration)
+
+	case "ban_max_duration":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid ban_max_duration duration: %v", err)
+		}
+		br.BanMaxDuration = caddy.Duration(duration)
+
+	case "ban_decay":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid ban_decay duration: %v", err)
+		}
+		br.BanDecay = caddy.Duration(duration)
+
+	case "ban_prefix_v4":
+		var valueStr string
+		if !d.Args(&valueStr) {
+			return d.ArgErr()
+		}
+
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return d.Errf("invalid ban_prefix_v4: %v", err)
+		}
+		br.BanIPv4Prefix = value
+
+	case "ban_prefix_v6":
+		var valueStr string
+		if !d.Args(&valueStr) {
+			return d.ArgErr()
+		}
+
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return d.Errf("invalid ban_prefix_v6: %v", err)
+		}
+		br.BanIPv6Prefix = value
+
+	case "ban_factor":
+		var factorStr string
+		if !d.Args(&factorStr) {
+			return d.ArgErr()
+		}
+
+		factor, err := strconv.ParseFloat(factorStr, 64)
+		if err != nil {
+			return d.Errf("invalid ban_factor: %v", err)
+		}
+		br.BanFactor = factor
+
+	case "firewall_export":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		br.FirewallExportDir = d.Val()
+		if formats := d.RemainingArgs(); len(formats) > 0 {
+			br.FirewallFormats = formats
+		}
+
+	case "firewall_export_interval":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid firewall_export_interval duration: %v", err)
+		}
+		br.FirewallExportInterval = caddy.Duration(duration)
+
+	case "firewall_set_name":
+		if !d.Args(&br.FirewallSetName) {
+			return d.ArgErr()
+		}
+
+	case "firewall_nft_table":
+		var family, table string
+		if !d.Args(&family, &table) {
+			return d.ArgErr()
+		}
+		br.FirewallNftTable = family + " " + table
+
+	case "firewall_cidr":
+		cidrs := d.RemainingArgs()
+		if len(cidrs) == 0 {
+			return d.ArgErr()
+		}
+		br.FirewallCIDRs = append(br.FirewallCIDRs, cidrs...)
+
+	case "shared_cache":
+		br.SharedCache = true
+		if d.NextArg() {
+			br.SharedCachePrefix = d.Val()
+		}
+
+	case "shared_cache_local_ttl":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid shared_cache_local_ttl duration: %v", err)
+		}
+		br.SharedCacheLocalTTL = caddy.Duration(duration)
+
+	case "shared_cache_flush_interval":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid shared_cache_flush_interval duration: %v", err)
+		}
+		br.SharedCacheFlushInterval = caddy.Duration(duration)
+
+	case "shared_cache_sync_interval":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid shared_cache_sync_interval duration: %v", err)
+		}
+		br.SharedCacheSyncInterval = caddy.Duration(duration)
+
+	case "shared_cache_timeout":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid shared_cache_timeout duration: %v", err)
+		}
+		br.SharedCacheTimeout = caddy.Duration(duration)
+
+	case "override":
+		rule, err := parseOverride(d)
+		if err != nil {
+			return err
+		}
+		br.Overrides = append(br.Overrides, rule)
+
+	case "lists_overlay_file":
+		if !d.Args(&br.ListsOverlayFile) {
+			return d.ArgErr()
+		}
+
+	case "bot_user_agents_file":
+		if !d.Args(&br.BotUserAgentsFile) {
+			return d.ArgErr()
+		}
+
+	case "bot_ip_ranges_file":
+		if !d.Args(&br.BotIPRangesFile) {
+			return d.ArgErr()
+		}
+
+	case "allowed_referrers_file":
+		if !d.Args(&br.AllowedReferrersFile) {
+			return d.ArgErr()
+		}
+
+	case "list_files_interval":
+		var durationStr string
+		if !d.Args(&durationStr) {
+			return d.ArgErr()
+		}
+
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return d.Errf("invalid list_files_interval duration: %v", err)
+		}
+		br.ListFilesInterval = caddy.Duration(duration)
+
+	case "rate_limit_fail_mode":
+		if !d.Args(&br.RateLimitFailMode) {
+			return d.ArgErr()
+		}
+		if br.RateLimitFailMode != LimiterFailOpen && br.RateLimitFailMode != LimiterFailClosed {
+			return d.Errf("rate_limit_fail_mode must be open or closed")
+		}
+
+	case "rate_limit_tier":
+		tier, err := parseRateLimitTier(d)
+		if err != nil {
+			return err
+		}
+		br.RateLimitTiers = append(br.RateLimitTiers, tier)
+
+	case "honeypot":
+		paths := d.RemainingArgs()
+		if len(paths) == 0 {
+			return d.ArgErr()
+		}
+		br.HoneypotPaths = append(br.HoneypotPaths, paths...)
+
+	case "honeypot_ttl":
+		var ttlStr string
+		if !d.Args(&ttlStr) {
+			return d.ArgErr()
+		}
+
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			return d.Errf("invalid honeypot_ttl duration: %v", err)
+		}
+		br.HoneypotTTL = caddy.Duration(ttl)
+
+	case "honeypot_prefix_v4":
+		var prefixStr string
+		if !d.Args(&prefixStr) {
+			return d.ArgErr()
+		}
+
+		prefix, err := strconv.Atoi(prefixStr)
+		if err != nil {
+			return d.Errf("invalid honeypot_prefix_v4: %v", err)
+		}
+		br.HoneypotIPv4Prefix = prefix
+
+	case "honeypot_prefix_v6":
+		var prefixStr string
+		if !d.Args(&prefixStr) {
+			return d.ArgErr()
+		}
+
+		prefix, err := strconv.Atoi(prefixStr)
+		if err != nil {
+			return d.Errf("invalid honeypot_prefix_v6: %v", err)
+		}
+		br.HoneypotIPv6Prefix = prefix
+
+	case "honeypot_action":
+		if !d.Args(&br.HoneypotAction) {
+			return d.ArgErr()
+		}
+		if _, err := ParsePolicyAction(br.HoneypotAction); err != nil {
+			return d.Errf("invalid honeypot_action: %v", err)
+		}
+
+	case "robots_action":
+		if !d.Args(&br.RobotsAction) {
+			return d.ArgErr()
+		}
+		if _, err := ParsePolicyAction(br.RobotsAction); err != nil {
+			return d.Errf("invalid robots_action: %v", err)
+		}
+
+	case "unverified_bot_action":
+		if !d.Args(&br.UnverifiedBotAction) {
+			return d.ArgErr()
+		}
+		if _, err := ParsePolicyAction(br.UnverifiedBotAction); err != nil {
+			return d.Errf("invalid unverified_bot_action: %v", err)
+		}
+
+	case "challenge_secret":
+		secrets := d.RemainingArgs()
+		if len(secrets) == 0 {
+			return d.ArgErr()
+		}
+		br.ChallengeSecrets = append(br.ChallengeSecrets, secrets...)
+
+	case "challenge_difficulty":
+		var difficultyStr string
+		if !d.Args(&difficultyStr) {
+			return d.ArgErr()
+		}
+
+		difficulty, err := strconv.Atoi(difficultyStr)
+		if err != nil {
+			return d.Errf("invalid challenge_difficulty: %v", err)
+		}
+		br.ChallengeDifficulty = difficulty
+
+	case "challenge_ttl":
+		var ttlStr string
+		if !d.Args(&ttlStr) {
+			return d.ArgErr()
+		}
+
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			return d.Errf("invalid challenge_ttl duration: %v", err)
+		}
+		br.ChallengeTTL = caddy.Duration(ttl)
+
+	case "challenge_cookie":
+		if !d.Args(&br.ChallengeCookieName) {
+			return d.ArgErr()
+		}
+
+	case "challenge_path":
+		if !d.Args(&br.ChallengePath) {
+			return d.ArgErr()
+		}
+
+	case "signature_directory":
+		args := d.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return d.ArgErr()
+		}
+
+		dir := SignatureDirectory{Agent: args[0]}
+		if len(args) == 2 {
+			dir.Source = args[1]
+		}
+		br.SignatureDirectories = append(br.SignatureDirectories, dir)
+
+	case "signature_max_age":
+		var ageStr string
+		if !d.Args(&ageStr) {
+			return d.ArgErr()
+		}
+
+		age, err := time.ParseDuration(ageStr)
+		if err != nil {
+			return d.Errf("invalid signature_max_age duration: %v", err)
+		}
+		br.SignatureMaxAge = caddy.Duration(age)
+
+	case "signature_refresh":
+		var refreshStr string
+		if !d.Args(&refreshStr) {
+			return d.ArgErr()
+		}
+
+		refresh, err := time.ParseDuration(refreshStr)
+		if err != nil {
+			return d.Errf("invalid signature_refresh duration: %v", err)
+		}
+		br.SignatureRefresh = caddy.Duration(refresh)
+
+	case "signature_require_nonce":
+		value, err := parseBool(d)
+		if err != nil {
+			return err
+		}
+		br.SignatureRequireNonce = value
+
+	default:
+		return d.Errf("unknown directive: %s", d.Val())
+	}
+
+	return nil
+}
+
+// parseBool разбирает флаг: без аргумента - true, иначе строго true или false
+func parseBool(d *caddyfile.Dispenser) (bool, error) {
+	option := d.Val()
+	if !d.NextArg() {
+		return true, nil
+	}
+
+	value := d.Val()
+	if d.NextArg() {
+		return false, d.ArgErr()
+	}
+
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, d.Errf("invalid value for %s: %s (expected true or false)", option, value)
+	}
+}
+
+// parseOverride разбирает ручное переопределение:
+//
+//	override <ip|user_agent> <value> <pass|block|challenge|bot|from_search|direct> {
+//	    reason <text>
+//	    author <name>
+//	    expires <RFC3339 time>
+//	}
+func parseOverride(d *caddyfile.Dispenser) (OverrideRule, error) {
+	var rule OverrideRule
+	var effect string
+	if !d.Args(&rule.Match, &rule.Value, &effect) {
+		return rule, d.ArgErr()
+	}
+	if _, ok := overrideClassifications[effect]; ok {
+		rule.Classification = effect
+	} else {
+		rule.Action = effect
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "reason":
+			if !d.Args(&rule.Reason) {
+				return rule, d.ArgErr()
+			}
+
+		case "author":
+			if !d.Args(&rule.Author) {
+				return rule, d.ArgErr()
+			}
+
+		case "expires":
+			var expiresStr string
+			if !d.Args(&expiresStr) {
+				return rule, d.ArgErr()
+			}
+
+			expires, err := time.Parse(time.RFC3339, expiresStr)
+			if err != nil {
+				return rule, d.Errf("invalid override expires time (RFC3339 expected): %v", err)
+			}
+			rule.ExpiresAt = expires
+
+		default:
+			return rule, d.Errf("unknown override option: %s", d.Val())
+		}
+	}
+
+	if err := validateOverrideRule(rule); err != nil {
+		return rule, d.Errf("invalid override: %v", err)
+	}
+
+	return rule, nil
+}
+
+// parseRateLimitTier парсит блок rate_limit_tier <name> { ... }
+func parseRateLimitTier(d *caddyfile.Dispenser) (RateLimitTier, error) {
+	var tier RateLimitTier
+	if !d.Args(&tier.Name) {
+		return tier, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "bot_types":
+			tier.BotTypes = append(tier.BotTypes, d.RemainingArgs()...)
+
+		case "bot_names":
+			tier.BotNames = append(tier.BotNames, d.RemainingArgs()...)
+
+		case "cidrs":
+			tier.CIDRs = append(tier.CIDRs, d.RemainingArgs()...)
+
+		case "verified":
+			value, err := parseBool(d)
+			if err != nil {
+				return tier, err
+			}
+			tier.Verified = value
+
+		case "bypass":
+			value, err := parseBool(d)
+			if err != nil {
+				return tier, err
+			}
+			tier.Bypass = value
+
+		case "bad_bot":
+			value, err := parseBool(d)
+			if err != nil {
+				return tier, err
+			}
+			tier.BadBot = value
+
+		case "crawl_delay":
+			value, err := parseBool(d)
+			if err != nil {
+				return tier, err
+			}
+			tier.UseCrawlDelay = value
+
+		case "max_requests":
+			var maxReqStr string
+			if !d.Args(&maxReqStr) {
+				return tier, d.ArgErr()
+			}
+
+			maxReq, err := strconv.Atoi(maxReqStr)
+			if err != nil {
+				return tier, d.Errf("invalid max_requests: %v", err)
+			}
+			tier.MaxRequests = maxReq
+
+		case "window":
+			var windowStr string
+			if !d.Args(&windowStr) {
+				return tier, d.ArgErr()
+			}
+
+			window, err := time.ParseDuration(windowStr)
+			if err != nil {
+				return tier, d.Errf("invalid window duration: %v", err)
+			}
+			tier.Window = caddy.Duration(window)
+
+		case "algorithm":
+			if !d.Args(&tier.Algorithm) {
+				return tier, d.ArgErr()
+			}
+			if _, err := ParseLimiterAlgorithm(tier.Algorithm); err != nil {
+				return tier, d.Errf("invalid algorithm: %v", err)
+			}
+
+		case "key":
+			tier.Key = d.RemainingArgs()
+			if len(tier.Key) == 0 {
+				return tier, d.ArgErr()
+			}
+			for _, part := range tier.Key {
+				if err := ValidateRateLimitKeyPart(part); err != nil {
+					return tier, d.Errf("invalid key: %v", err)
+				}
+			}
+
+		case "prefix_v4", "prefix_v6":
+			option := d.Val()
+			var prefixStr string
+			if !d.Args(&prefixStr) {
+				return tier, d.ArgErr()
+			}
+
+			prefix, err := strconv.Atoi(prefixStr)
+			if err != nil {
+				return tier, d.Errf("invalid %s: %v", option, err)
+			}
+			if option == "prefix_v4" {
+				tier.IPv4Prefix = prefix
+			} else {
+				tier.IPv6Prefix = prefix
+			}
+
+		default:
+			return tier, d.Errf("unknown rate_limit_tier option: %s", d.Val())
+		}
+	}
+
+	return tier, nil
+}
+
 // GetBotDetector возвращает экземпляр BotDetector для доступа к статистике
 func (br *BotRedirect) GetBotDetector() *BotDetector {
 	return br.botDetector
@@ -545,6 +2285,7 @@ func (br *BotRedirect) GetBotDetector() *BotDetector {
 
 // Cleanup очистка ресурсов при завершении работы
 func (br *BotRedirect) Cleanup() error {
+	unregisterHandler(br)
 	if br.botDetector != nil {
 		br.botDetector.Shutdown()
 	}
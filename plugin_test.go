@@ -0,0 +1,515 @@
+package botredirect
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// provisionTestHandler загружает обработчик без метрик, общего кеша и выгрузки правил
+func provisionTestHandler(t *testing.T, br *BotRedirect) error {
+	t.Helper()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+
+	return provisionTestHandlerContext(t, ctx, br)
+}
+
+// provisionTestHandlerContext загружает обработчик в контексте загрузки конфигурации ctx
+func provisionTestHandlerContext(t *testing.T, ctx caddy.Context, br *BotRedirect) error {
+	t.Helper()
+
+	if br.RedirectURL == "" {
+		br.RedirectURL = "https://example.com/"
+	}
+	br.EnableMetrics = false
+	br.EnablePrometheus = false
+	br.logger = zap.NewNop()
+
+	if err := br.provision(ctx); err != nil {
+		return err
+	}
+	t.Cleanup(func() { br.Cleanup() })
+	return nil
+}
+
+func TestProvisionRejectsInvalidRedisURL(t *testing.T) {
+	for _, redisURL := range []string{
+		"",
+		"http://127.0.0.1:6379",
+		"redis://",
+		"redis://127.0.0.1:6379/db",
+		"redis://%zz",
+	} {
+		br := &BotRedirect{
+			EnableRateLimit:   true,
+			RateLimitStorage:  LimiterStorageRedis,
+			RateLimitRedisURL: redisURL,
+		}
+
+		err := provisionTestHandler(t, br)
+		if err == nil || !strings.Contains(err.Error(), "rate_limit_storage") {
+			t.Errorf("provision with rate_limit_redis_url %q: error = %v, want rate_limit_storage error", redisURL, err)
+		}
+	}
+}
+
+func TestProvisionRejectsMissingRobotsFile(t *testing.T) {
+	br := &BotRedirect{RobotsFile: filepath.Join(t.TempDir(), "robots.txt")}
+
+	err := provisionTestHandler(t, br)
+	if err == nil || !strings.Contains(err.Error(), "robots_file") {
+		t.Errorf("provision with a missing robots_file: error = %v, want robots_file error", err)
+	}
+}
+
+func TestProvisionRejectsMissingASNDatabase(t *testing.T) {
+	br := &BotRedirect{
+		EnableRateLimit: true,
+		ASNDatabase:     filepath.Join(t.TempDir(), "asn.txt"),
+		RateLimitKey:    []string{RateLimitKeyASN},
+	}
+
+	err := provisionTestHandler(t, br)
+	if err == nil || !strings.Contains(err.Error(), "asn_database") {
+		t.Errorf("provision with a missing asn_database: error = %v, want asn_database error", err)
+	}
+}
+
+// TestUnverifiedBotRateLimitKey проверяет, что лимит неподтвержденного бота учитывается
+// по адресу клиента, а не по соединению
+func TestUnverifiedBotRateLimitKey(t *testing.T) {
+	br := &BotRedirect{
+		EnableRateLimit:     true,
+		MaxRequestsPerIP:    3,
+		RateLimitWindow:     caddy.Duration(time.Minute),
+		RateLimitTiers:      []RateLimitTier{{Name: "all", Bypass: true}},
+		UnverifiedBotAction: string(PolicyActionRateLimit),
+	}
+	if err := provisionTestHandler(t, br); err != nil {
+		t.Fatal(err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	limited := 0
+	for port := 1001; port <= 1006; port++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "203.0.113.9:" + strconv.Itoa(port)
+		r.Header.Set("User-Agent", "Mozilla/5.0 (compatible; ExampleBot/1.0)")
+
+		w := httptest.NewRecorder()
+		if err := br.ServeHTTP(w, r, next); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+
+	if limited != 3 {
+		t.Errorf("%d of 6 requests from new connections rate limited, want 3", limited)
+	}
+}
+
+// TestSpoofingOffense проверяет, что нарушение spoofing получает только клиент,
+// выдающий себя за известного краулера, и не получает клиент, которому отдана проверка браузера
+func TestSpoofingOffense(t *testing.T) {
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+	tests := []struct {
+		action     PolicyAction
+		userAgent  string
+		wantBanned bool
+	}{
+		{action: PolicyActionBlock, userAgent: googlebot, wantBanned: true},
+		{action: PolicyActionBlock, userAgent: "Mozilla/5.0 (compatible; ExampleBot/1.0)"},
+		{action: PolicyActionBlock, userAgent: "python-requests/2.31 crawler"},
+		{action: PolicyActionChallenge, userAgent: googlebot},
+		{action: PolicyActionLog, userAgent: googlebot},
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	for n, tt := range tests {
+		// Отдельный id: баны экземпляра с тем же id переносятся в следующий
+		br := &BotRedirect{
+			ID:                  "spoofing-" + strconv.Itoa(n),
+			BanThreshold:        2,
+			UnverifiedBotAction: string(tt.action),
+			ChallengeSecrets:    []string{"0123456789abcdef0123456789abcdef"},
+		}
+		if err := provisionTestHandler(t, br); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "203.0.113.10:4321"
+			r.Header.Set("User-Agent", tt.userAgent)
+			if err := br.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		banned := br.botDetector.GetBanManager().Check("203.0.113.10") != nil
+		if banned != tt.wantBanned {
+			t.Errorf("%s with unverified_bot_action %s: banned = %v, want %v", tt.userAgent, tt.action, banned, tt.wantBanned)
+		}
+	}
+}
+
+// TestProvisionRejectsDuplicateID проверяет, что экземпляры одной конфигурации не делят id,
+// а новая конфигурация заменяет экземпляр с тем же id
+func TestProvisionRejectsDuplicateID(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	first := &BotRedirect{ListsOverlayFile: "off"}
+	if err := provisionTestHandlerContext(t, ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := &BotRedirect{ListsOverlayFile: "off"}
+	err := provisionTestHandlerContext(t, ctx, second)
+	if err == nil || !strings.Contains(err.Error(), "duplicate id") {
+		t.Fatalf("second handler without id in the same config: error = %v, want duplicate id error", err)
+	}
+
+	named := &BotRedirect{ID: "named", ListsOverlayFile: "off"}
+	if err := provisionTestHandlerContext(t, ctx, named); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := &BotRedirect{ListsOverlayFile: "off"}
+	if err := provisionTestHandler(t, reloaded); err != nil {
+		t.Fatalf("handler with the same id in a new config: %v", err)
+	}
+	if br, ok := lookupHandler(defaultHandlerID); !ok || br != reloaded {
+		t.Errorf("admin API serves %p for id %s, want the reloaded handler %p", br, defaultHandlerID, reloaded)
+	}
+}
+
+// TestProvisionCarriesOverridesFromPreviousConfig проверяет, что переопределения admin API
+// переносятся только от экземпляра с тем же id из предыдущей конфигурации
+func TestProvisionCarriesOverridesFromPreviousConfig(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	previous := &BotRedirect{ID: "overrides", ListsOverlayFile: "off"}
+	if err := provisionTestHandlerContext(t, ctx, previous); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := previous.botDetector.GetOverrideTable().Add(OverrideRule{
+		Match:  OverrideMatchIP,
+		Value:  "203.0.113.0/24",
+		Action: OverrideActionBlock,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := previousHandler(previous.ID, ctx.Context); ok {
+		t.Error("handler of the same config reported as the previous generation")
+	}
+
+	other := &BotRedirect{ID: "other", ListsOverlayFile: "off"}
+	if err := provisionTestHandler(t, other); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(other.botDetector.GetOverrideTable().List()); n != 0 {
+		t.Errorf("handler with another id imported %d overrides", n)
+	}
+
+	reloaded := &BotRedirect{ID: "overrides", ListsOverlayFile: "off"}
+	if err := provisionTestHandler(t, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reloaded.botDetector.GetOverrideTable().List()); n != 1 {
+		t.Errorf("reloaded handler has %d overrides, want 1 carried over", n)
+	}
+}
+
+// TestRobotsRateLimitKey проверяет, что лимит за нарушение robots.txt ведется для каждого
+// адреса подтвержденного краулера, а бот с User-Agent краулера его не расходует
+func TestRobotsRateLimitKey(t *testing.T) {
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+	robotsFile := filepath.Join(t.TempDir(), "robots.txt")
+	if err := os.WriteFile(robotsFile, []byte("User-agent: Googlebot\nDisallow: /private\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	br := &BotRedirect{
+		EnableRateLimit:  true,
+		MaxRequestsPerIP: 2,
+		RateLimitWindow:  caddy.Duration(time.Minute),
+		RateLimitTiers:   []RateLimitTier{{Name: "all", Bypass: true}},
+		RobotsFile:       robotsFile,
+		RobotsAction:     string(PolicyActionRateLimit),
+	}
+	if err := provisionTestHandler(t, br); err != nil {
+		t.Fatal(err)
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	limited := func(ip string, requests int) int {
+		count := 0
+		for i := 0; i < requests; i++ {
+			r := httptest.NewRequest("GET", "/private/page", nil)
+			r.RemoteAddr = ip + ":4321"
+			r.Header.Set("User-Agent", googlebot)
+
+			w := httptest.NewRecorder()
+			if err := br.ServeHTTP(w, r, next); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code == http.StatusTooManyRequests {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := limited("203.0.113.9", 5); got != 0 {
+		t.Errorf("unverified bot: %d of 5 requests limited by robots.txt, want 0", got)
+	}
+	if got := limited("66.249.66.1", 4); got != 2 {
+		t.Errorf("first crawler address: %d of 4 requests limited, want 2", got)
+	}
+	if got := limited("66.249.66.2", 2); got != 0 {
+		t.Errorf("second crawler address: %d of 2 requests limited, want 0", got)
+	}
+
+	violations := br.botDetector.GetRobotsChecker().GetStats()["total_violations"]
+	if violations != int64(6) {
+		t.Errorf("total_violations = %v, want 6", violations)
+	}
+}
+
+// caddyfileOptionArgs аргументы каждой опции, доступной в блоках группировки Caddyfile
+var caddyfileOptionArgs = map[string]string{
+	"enable_reverse_dns":   "true",
+	"dns_timeout":          "3s",
+	"max_dns_per_second":   "50",
+	"dns_worker_pool_size": "4",
+	"dns_queue_size":       "100",
+
+	"enable_rate_limit":        "true",
+	"max_requests_per_ip":      "100",
+	"rate_limit_window":        "1m",
+	"rate_limit_algorithm":     "gcra",
+	"rate_limit_key":           "ip ua",
+	"rate_limit_prefix_v4":     "24",
+	"rate_limit_prefix_v6":     "64",
+	"asn_database":             "/etc/caddy/asn.txt",
+	"rate_limit_storage":       "memory",
+	"rate_limit_redis_prefix":  "cloak:",
+	"rate_limit_redis_timeout": "100ms",
+	"rate_limit_max_buckets":   "1000",
+	"rate_limit_fail_mode":     "closed",
+	"rate_limit_template":      "/etc/caddy/429.html",
+	"rate_limit_tier":          "crawlers {\n\t\t\tbot_types search\n\t\t\tverified true\n\t\t\tmax_requests 10\n\t\t\twindow 1m\n\t\t}",
+
+	"cache_ttl":                   "1h",
+	"max_cache_size":              "5000",
+	"cache_size":                  "user_agent 500",
+	"cleanup_interval":            "5m",
+	"shared_cache":                "cloak",
+	"shared_cache_local_ttl":      "30s",
+	"shared_cache_flush_interval": "2s",
+	"shared_cache_sync_interval":  "20s",
+	"shared_cache_timeout":        "3s",
+
+	"bot_user_agents":        "Googlebot Bingbot",
+	"bot_ip_ranges":          "66.249.64.0/19",
+	"allowed_referrers":      "google.com",
+	"enable_referrer_check":  "true",
+	"bot_user_agents_file":   "/etc/caddy/agents.txt",
+	"bot_ip_ranges_file":     "/etc/caddy/ranges.txt",
+	"allowed_referrers_file": "/etc/caddy/referrers.txt",
+	"list_files_interval":    "30s",
+	"lists_overlay_file":     "off",
+
+	"unverified_bot_action": "block",
+	"robots_file":           "/etc/caddy/robots.txt",
+	"robots_action":         "rate_limit",
+	"honeypot":              "/trap /wp-admin",
+	"honeypot_ttl":          "12h",
+	"honeypot_prefix_v4":    "24",
+	"honeypot_prefix_v6":    "48",
+	"honeypot_action":       "challenge",
+	"challenge_secret":      "secret-1 secret-2",
+	"challenge_difficulty":  "12",
+	"challenge_ttl":         "2h",
+	"challenge_cookie":      "cloak_ok",
+	"challenge_path":        "/.cloak/solve",
+	"ban_threshold":         "5",
+	"ban_duration":          "10m",
+	"ban_factor":            "3",
+	"ban_max_duration":      "24h",
+	"ban_decay":             "48h",
+	"ban_prefix_v4":         "24",
+	"ban_prefix_v6":         "56",
+	"override":              "ip 192.0.2.0/24 block {\n\t\t\treason incident\n\t\t\tauthor ops\n\t\t}",
+	"empty_page_template":   "/etc/caddy/empty.html",
+
+	"log_level":         "debug",
+	"enable_debug":      "true",
+	"log_all_requests":  "true",
+	"log_dns_queries":   "false",
+	"log_cache_ops":     "true",
+	"enable_metrics":    "false",
+	"verbose_metrics":   "true",
+	"metrics_path":      "/.cloak/metrics",
+	"enable_prometheus": "true",
+}
+
+// parseTestCaddyfile разбирает блок cloak с опциями body
+func parseTestCaddyfile(body string) (*BotRedirect, error) {
+	br := &BotRedirect{}
+	err := br.UnmarshalCaddyfile(caddyfile.NewTestDispenser("cloak {\n" + body + "}\n"))
+	return br, err
+}
+
+// TestCaddyfileGroups проверяет, что каждый блок группировки дает ту же JSON конфигурацию,
+// что и опции верхнего уровня, включая опции с собственным блоком (tier, override)
+func TestCaddyfileGroups(t *testing.T) {
+	for group, options := range caddyfileGroups {
+		t.Run(group, func(t *testing.T) {
+			shortNames := make([]string, 0, len(options))
+			for short := range options {
+				shortNames = append(shortNames, short)
+			}
+			sort.Strings(shortNames)
+
+			var flat, grouped strings.Builder
+			grouped.WriteString("\t" + group + " {\n")
+			for _, short := range shortNames {
+				args, ok := caddyfileOptionArgs[options[short]]
+				if !ok {
+					t.Fatalf("no test arguments for %s", options[short])
+				}
+				flat.WriteString("\t" + options[short] + " " + args + "\n")
+				grouped.WriteString("\t\t" + short + " " + args + "\n")
+			}
+			grouped.WriteString("\t}\n")
+
+			flatHandler, err := parseTestCaddyfile(flat.String())
+			if err != nil {
+				t.Fatalf("flat options: %v", err)
+			}
+			groupedHandler, err := parseTestCaddyfile(grouped.String())
+			if err != nil {
+				t.Fatalf("group block: %v", err)
+			}
+
+			flatJSON, _ := json.Marshal(flatHandler)
+			groupedJSON, _ := json.Marshal(groupedHandler)
+			if string(flatJSON) != string(groupedJSON) {
+				t.Errorf("group block JSON differs:\n flat:    %s\n grouped: %s", flatJSON, groupedJSON)
+			}
+			if string(groupedJSON) == "{}" {
+				t.Error("group block produced an empty configuration")
+			}
+		})
+	}
+}
+
+// TestCaddyfileGroupBlockOptions проверяет, что опции с собственным блоком разбираются внутри
+// группы целиком и следующие опции группы не теряются
+func TestCaddyfileGroupBlockOptions(t *testing.T) {
+	br, err := parseTestCaddyfile(`
+	rate_limit {
+		tier crawlers {
+			bot_types search
+			max_requests 10
+		}
+		requests 50
+	}
+	policy {
+		override ip 192.0.2.0/24 block {
+			reason incident
+		}
+		override user_agent BadBot bot
+		ban_threshold 3
+	}
+	redirect_url https://example.com/
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(br.RateLimitTiers) != 1 || br.RateLimitTiers[0].Name != "crawlers" || br.RateLimitTiers[0].MaxRequests != 10 {
+		t.Errorf("rate_limit_tiers = %+v", br.RateLimitTiers)
+	}
+	if len(br.Overrides) != 2 || br.Overrides[0].Reason != "incident" || br.Overrides[1].Classification != "bot" {
+		t.Errorf("overrides = %+v", br.Overrides)
+	}
+	if br.MaxRequestsPerIP != 50 || br.BanThreshold != 3 || br.RedirectURL != "https://example.com/" {
+		t.Errorf("options after blocks lost: requests %d, ban_threshold %d, redirect_url %q", br.MaxRequestsPerIP, br.BanThreshold, br.RedirectURL)
+	}
+}
+
+// TestCaddyfileInvalidOptions проверяет ошибки разбора: логические значения кроме true/false,
+// неизвестные опции и опции другой группы внутри блока, аргументы у блока группы
+func TestCaddyfileInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "yes as a flat bool", body: "enable_reverse_dns yes\n", wantErr: "expected true or false"},
+		{name: "yes inside a group", body: "dns {\n enabled yes\n}\n", wantErr: "expected true or false"},
+		{name: "extra bool argument", body: "enable_debug true false\n", wantErr: "wrong argument count"},
+		{name: "unknown option inside a group", body: "dns {\n retries 3\n}\n", wantErr: "unknown dns option: retries"},
+		{name: "long name inside a group", body: "dns {\n dns_timeout 3s\n}\n", wantErr: "unknown dns option: dns_timeout"},
+		{name: "option of another group", body: "cache {\n requests 10\n}\n", wantErr: "unknown cache option: requests"},
+		{name: "group with arguments", body: "logging debug {\n level info\n}\n", wantErr: "wrong argument count"},
+		{name: "unknown tier option inside a group", body: "rate_limit {\n tier crawlers {\n burst 5\n }\n}\n", wantErr: "unknown rate_limit_tier option: burst"},
+		{name: "invalid override inside a group", body: "policy {\n override ip 192.0.2.0/33 block\n}\n", wantErr: "invalid override"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseTestCaddyfile(tt.body)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Логические опции без значения и со значением true/false
+	for body, want := range map[string]bool{
+		"enable_reverse_dns\n":       true,
+		"enable_reverse_dns true\n":  true,
+		"enable_reverse_dns false\n": false,
+		"dns {\n enabled\n}\n":       true,
+	} {
+		br, err := parseTestCaddyfile(body)
+		if err != nil {
+			t.Errorf("%q: %v", body, err)
+			continue
+		}
+		if br.EnableReverseDNS != want {
+			t.Errorf("%q: enable_reverse_dns = %v, want %v", body, br.EnableReverseDNS, want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package botredirect
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// promMaxBotNames максимальное количество различных значений метки bot_name,
+// остальные имена учитываются как "other"
+const promMaxBotNames = 100
+
+// promMaxLabelLength максимальная длина значения метки из конфигурации или паттернов
+const promMaxLabelLength = 64
+
+// promOtherLabel значение метки для значений сверх ограничения кардинальности
+const promOtherLabel = "other"
+
+// Действия обработчика в метке action метрики requests_total; действия политик
+// (block, rate_limit, challenge) записываются значениями PolicyAction
+const (
+	requestActionPass              = "pass"
+	requestActionRedirect          = "redirect"
+	requestActionEmptyPage         = "empty_page"
+	requestActionBanned            = "banned"
+	requestActionChallengeSolution = "challenge_solution"
+)
+
+// Допустимые значения меток с фиксированным набором значений
+var (
+	promBotTypes = map[string]bool{
+		string(BotTypeSearch):     true,
+		string(BotTypeSocial):     true,
+		string(BotTypeCrawler):    true,
+		string(BotTypeMonitoring): true,
+		string(BotTypeSEO):        true,
+		string(BotTypeUnknown):    true,
+	}
+	promVerificationMethods = map[string]bool{
+		"ip_range":       true,
+		"reverse_dns":    true,
+		"http_signature": true,
+	}
+	promOffenseReasons = map[string]bool{
+		OffenseRateLimit: true,
+		OffenseHoneypot:  true,
+		OffenseSpoofing:  true,
+		OffenseManual:    true,
+	}
+)
+
+// Время задержки в секундах: детекция обычно укладывается в микросекунды,
+// промах кеша с обратным DNS занимает до dns_timeout
+var (
+	promDetectionBuckets = []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+	promDNSBuckets       = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// PrometheusMetrics коллекторы плагина в реестре Prometheus, который отдает Caddy
+// (admin endpoint /metrics и обработчик metrics). Коллекторы регистрируются один раз
+// на процесс и общие для всех экземпляров обработчика, поэтому переживают перезагрузку конфигурации.
+type PrometheusMetrics struct {
+	requests          *prometheus.CounterVec
+	detectionDuration *prometheus.HistogramVec
+	dnsLookups        *prometheus.CounterVec
+	dnsDuration       *prometheus.HistogramVec
+	cacheRequests     *prometheus.CounterVec
+	rateLimitEvents   *prometheus.CounterVec
+	banEvents         *prometheus.CounterVec
+
+	// Ограничение кардинальности имен ботов
+	botNames      map[string]struct{}
+	botNamesMutex sync.RWMutex
+}
+
+var (
+	prometheusOnce    sync.Once
+	prometheusMetrics *PrometheusMetrics
+)
+
+// registerPrometheusMetrics регистрирует коллекторы при первом вызове и возвращает общий экземпляр
+func registerPrometheusMetrics(registerer prometheus.Registerer, logger *zap.Logger) *PrometheusMetrics {
+	prometheusOnce.Do(func() {
+		const ns, sub = "caddy", "bot_redirect"
+
+		pm := &PrometheusMetrics{
+			requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "requests_total",
+				Help:      "Requests handled by bot_redirect by classification, bot, verification method and action.",
+			}, []string{"classification", "bot_name", "bot_type", "verification", "action"}),
+			detectionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "detection_duration_seconds",
+				Help:      "Time spent classifying a request.",
+				Buckets:   promDetectionBuckets,
+			}, []string{"classification"}),
+			dnsLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "dns_lookups_total",
+				Help:      "Reverse DNS verification lookups by outcome.",
+			}, []string{"outcome"}),
+			dnsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "dns_lookup_duration_seconds",
+				Help:      "Reverse DNS verification lookup latency.",
+				Buckets:   promDNSBuckets,
+			}, []string{"outcome"}),
+			cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "cache_requests_total",
+				Help:      "Cache lookups by cache and result.",
+			}, []string{"cache", "result"}),
+			rateLimitEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "rate_limit_events_total",
+				Help:      "Rate limiter events: blocked requests, limited DNS lookups and store errors.",
+			}, []string{"event", "tier"}),
+			banEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: ns,
+				Subsystem: sub,
+				Name:      "ban_events_total",
+				Help:      "Ban manager events: issued, rejected, imported and lifted bans.",
+			}, []string{"event", "reason"}),
+			botNames: make(map[string]struct{}),
+		}
+
+		pm.requests = registerCollector(registerer, pm.requests, logger)
+		pm.detectionDuration = registerCollector(registerer, pm.detectionDuration, logger)
+		pm.dnsLookups = registerCollector(registerer, pm.dnsLookups, logger)
+		pm.dnsDuration = registerCollector(registerer, pm.dnsDuration, logger)
+		pm.cacheRequests = registerCollector(registerer, pm.cacheRequests, logger)
+		pm.rateLimitEvents = registerCollector(registerer, pm.rateLimitEvents, logger)
+		pm.banEvents = registerCollector(registerer, pm.banEvents, logger)
+
+		prometheusMetrics = pm
+
+		logger.Info("prometheus metrics registered")
+	})
+
+	return prometheusMetrics
+}
+
+// registerCollector регистрирует коллектор; если такой уже зарегистрирован, используется существующий
+func registerCollector[C prometheus.Collector](registerer prometheus.Registerer, collector C, logger *zap.Logger) C {
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector
+	}
+
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+
+	logger.Warn("failed to register prometheus collector", zap.Error(err))
+	return collector
+}
+
+// botNameLabel ограничивает количество различных имен ботов
+func (pm *PrometheusMetrics) botNameLabel(name string) string {
+	if name == "" {
+		return ""
+	}
+	name = truncatedLabel(name)
+
+	pm.botNamesMutex.RLock()
+	_, known := pm.botNames[name]
+	pm.botNamesMutex.RUnlock()
+	if known {
+		return name
+	}
+
+	pm.botNamesMutex.Lock()
+	defer pm.botNamesMutex.Unlock()
+	if _, known := pm.botNames[name]; known {
+		return name
+	}
+	if len(pm.botNames) >= promMaxBotNames {
+		return promOtherLabel
+	}
+	pm.botNames[name] = struct{}{}
+	return name
+}
+
+// boundedLabel возвращает значение из допустимого набора или "other"
+func boundedLabel(value string, allowed map[string]bool) string {
+	if value == "" || allowed[value] {
+		return value
+	}
+	return promOtherLabel
+}
+
+// truncatedLabel обрезает значение метки; Prometheus отклоняет значения с некорректным UTF-8
+func truncatedLabel(value string) string {
+	if len(value) > promMaxLabelLength {
+		value = value[:promMaxLabelLength]
+	}
+	return strings.ToValidUTF8(value, "")
+}
+
+// classificationLabel возвращает метку классификации запроса
+func classificationLabel(result *DetectionResult) string {
+	if result == nil {
+		return "unknown"
+	}
+	return result.UserType.String()
+}
+
+// verificationLabel возвращает способ подтверждения бота
+func verificationLabel(result *DetectionResult) string {
+	if result == nil || !result.IsBot {
+		return "none"
+	}
+	if !result.Verified {
+		return "unverified"
+	}
+	if promVerificationMethods[result.DetectionMethod] {
+		return result.DetectionMethod
+	}
+	if method, ok := result.Details["verified_by"].(string); ok {
+		return boundedLabel(method, promVerificationMethods)
+	}
+	return promOtherLabel
+}
+
+// botTypeLabel возвращает тип бота из деталей результата
+func botTypeLabel(result *DetectionResult) string {
+	if result == nil || !result.IsBot {
+		return ""
+	}
+
+	switch botType := result.Details["bot_type"].(type) {
+	case BotType:
+		return boundedLabel(string(botType), promBotTypes)
+	case string:
+		return boundedLabel(botType, promBotTypes)
+	default:
+		return string(BotTypeUnknown)
+	}
+}
+
+// observeRequest учитывает обработанный запрос и выполненное действие
+func (pm *PrometheusMetrics) observeRequest(result *DetectionResult, action string) {
+	if pm == nil {
+		return
+	}
+
+	botName := ""
+	if result != nil && result.IsBot {
+		botName = pm.botNameLabel(result.BotName)
+	}
+
+	pm.requests.WithLabelValues(
+		classificationLabel(result),
+		botName,
+		botTypeLabel(result),
+		verificationLabel(result),
+		action,
+	).Inc()
+}
+
+// observeDetection учитывает время классификации запроса
+func (pm *PrometheusMetrics) observeDetection(result *DetectionResult) {
+	if pm == nil || result == nil {
+		return
+	}
+	pm.detectionDuration.WithLabelValues(classificationLabel(result)).Observe(result.ProcessingTime.Seconds())
+}
+
+// observeDNSLookup учитывает DNS проверку; время записывается, только если запрос выполнялся
+func (pm *PrometheusMetrics) observeDNSLookup(outcome string, duration time.Duration) {
+	if pm == nil {
+		return
+	}
+	pm.dnsLookups.WithLabelValues(outcome).Inc()
+	if duration > 0 {
+		pm.dnsDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+	}
+}
+
+// cacheCounters возвращает счетчики попаданий и промахов кеша
+func (pm *PrometheusMetrics) cacheCounters(cache string) (prometheus.Counter, prometheus.Counter) {
+	if pm == nil {
+		return nil, nil
+	}
+	return pm.cacheRequests.WithLabelValues(cache, "hit"), pm.cacheRequests.WithLabelValues(cache, "miss")
+}
+
+// rateLimitEvent учитывает событие rate limiter
+func (pm *PrometheusMetrics) rateLimitEvent(event, tier string) {
+	if pm == nil {
+		return
+	}
+	pm.rateLimitEvents.WithLabelValues(event, truncatedLabel(tier)).Inc()
+}
+
+// banEvent учитывает событие менеджера банов
+func (pm *PrometheusMetrics) banEvent(event, reason string) {
+	if pm == nil {
+		return
+	}
+	pm.banEvents.WithLabelValues(event, boundedLabel(reason, promOffenseReasons)).Inc()
+}
@@ -0,0 +1,337 @@
+package botredirect
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// newTestPrometheusMetrics возвращает общий экземпляр коллекторов. Коллекторы
+// регистрируются один раз на процесс, поэтому тесты сравнивают приращения счетчиков.
+func newTestPrometheusMetrics(t *testing.T) *PrometheusMetrics {
+	t.Helper()
+
+	pm := registerPrometheusMetrics(prometheus.NewRegistry(), zap.NewNop())
+	if pm == nil {
+		t.Fatal("registerPrometheusMetrics returned nil")
+	}
+	return pm
+}
+
+// histogramSampleCount возвращает количество наблюдений гистограммы
+func histogramSampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+
+	var metric dto.Metric
+	if err := observer.(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+// TestPrometheusLabels проверяет метки классификации, типа бота и способа подтверждения
+func TestPrometheusLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		result         *DetectionResult
+		classification string
+		botType        string
+		verification   string
+	}{
+		{
+			name:           "nil result",
+			result:         nil,
+			classification: "unknown",
+			botType:        "",
+			verification:   "none",
+		},
+		{
+			name:           "human from search",
+			result:         &DetectionResult{UserType: UserTypeFromSearch},
+			classification: "from_search",
+			botType:        "",
+			verification:   "none",
+		},
+		{
+			name: "unverified bot",
+			result: &DetectionResult{
+				IsBot:    true,
+				UserType: UserTypeBot,
+				Details:  map[string]interface{}{"bot_type": BotTypeCrawler},
+			},
+			classification: "bot",
+			botType:        "crawler",
+			verification:   "unverified",
+		},
+		{
+			name: "verified by detection method",
+			result: &DetectionResult{
+				IsBot:           true,
+				UserType:        UserTypeBot,
+				Verified:        true,
+				DetectionMethod: "reverse_dns",
+				Details:         map[string]interface{}{"bot_type": "search"},
+			},
+			classification: "bot",
+			botType:        "search",
+			verification:   "reverse_dns",
+		},
+		{
+			name: "verified_by from details",
+			result: &DetectionResult{
+				IsBot:           true,
+				UserType:        UserTypeBot,
+				Verified:        true,
+				DetectionMethod: "cache",
+				Details:         map[string]interface{}{"verified_by": "ip_range"},
+			},
+			classification: "bot",
+			botType:        "unknown",
+			verification:   "ip_range",
+		},
+		{
+			name: "unknown verified_by and bot type",
+			result: &DetectionResult{
+				IsBot:           true,
+				UserType:        UserTypeBot,
+				Verified:        true,
+				DetectionMethod: "cache",
+				Details: map[string]interface{}{
+					"verified_by": "carrier_pigeon",
+					"bot_type":    "custom",
+				},
+			},
+			classification: "bot",
+			botType:        "other",
+			verification:   "other",
+		},
+		{
+			name: "verified without method",
+			result: &DetectionResult{
+				IsBot:    true,
+				UserType: UserTypeBot,
+				Verified: true,
+			},
+			classification: "bot",
+			botType:        "unknown",
+			verification:   "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classificationLabel(tt.result); got != tt.classification {
+				t.Errorf("classificationLabel = %q, want %q", got, tt.classification)
+			}
+			if got := botTypeLabel(tt.result); got != tt.botType {
+				t.Errorf("botTypeLabel = %q, want %q", got, tt.botType)
+			}
+			if got := verificationLabel(tt.result); got != tt.verification {
+				t.Errorf("verificationLabel = %q, want %q", got, tt.verification)
+			}
+		})
+	}
+}
+
+// TestTruncatedLabel проверяет обрезку длинных значений и удаление некорректного UTF-8
+func TestTruncatedLabel(t *testing.T) {
+	long := strings.Repeat("a", promMaxLabelLength+10)
+	// Двухбайтовый символ на границе обрезки оставляет неполную последовательность
+	split := strings.Repeat("a", promMaxLabelLength-1) + "я"
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"short", "strict", "strict"},
+		{"empty", "", ""},
+		{"long", long, long[:promMaxLabelLength]},
+		{"split rune", split, strings.Repeat("a", promMaxLabelLength-1)},
+		{"invalid utf-8", "tier\xff", "tier"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncatedLabel(tt.value); got != tt.want {
+				t.Errorf("truncatedLabel = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPrometheusBotNameCardinality проверяет замену имен ботов сверх ограничения на "other"
+func TestPrometheusBotNameCardinality(t *testing.T) {
+	pm := &PrometheusMetrics{botNames: make(map[string]struct{})}
+
+	if got := pm.botNameLabel(""); got != "" {
+		t.Errorf("empty bot name = %q, want empty", got)
+	}
+
+	for i := 0; i < promMaxBotNames; i++ {
+		name := fmt.Sprintf("bot-%d", i)
+		if got := pm.botNameLabel(name); got != name {
+			t.Fatalf("bot name %d = %q, want %q", i, got, name)
+		}
+	}
+
+	if got := pm.botNameLabel("one-too-many"); got != promOtherLabel {
+		t.Errorf("bot name over limit = %q, want %q", got, promOtherLabel)
+	}
+	if got := pm.botNameLabel("bot-0"); got != "bot-0" {
+		t.Errorf("known bot name over limit = %q, want %q", got, "bot-0")
+	}
+	if len(pm.botNames) != promMaxBotNames {
+		t.Errorf("tracked bot names = %d, want %d", len(pm.botNames), promMaxBotNames)
+	}
+}
+
+// TestPrometheusCounters проверяет приращения счетчиков с ожидаемыми метками
+func TestPrometheusCounters(t *testing.T) {
+	pm := newTestPrometheusMetrics(t)
+
+	t.Run("requests", func(t *testing.T) {
+		result := &DetectionResult{
+			IsBot:           true,
+			UserType:        UserTypeBot,
+			BotName:         "Googlebot",
+			Verified:        true,
+			DetectionMethod: "reverse_dns",
+			Details:         map[string]interface{}{"bot_type": BotTypeSearch},
+		}
+		counter := pm.requests.WithLabelValues("bot", "Googlebot", "search", "reverse_dns", requestActionPass)
+		before := testutil.ToFloat64(counter)
+
+		pm.observeRequest(result, requestActionPass)
+		pm.observeRequest(result, requestActionPass)
+
+		if got := testutil.ToFloat64(counter) - before; got != 2 {
+			t.Errorf("requests delta = %v, want 2", got)
+		}
+	})
+
+	t.Run("human request has no bot labels", func(t *testing.T) {
+		result := &DetectionResult{UserType: UserTypeDirect, BotName: "ignored"}
+		counter := pm.requests.WithLabelValues("direct", "", "", "none", requestActionRedirect)
+		before := testutil.ToFloat64(counter)
+
+		pm.observeRequest(result, requestActionRedirect)
+
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Errorf("requests delta = %v, want 1", got)
+		}
+	})
+
+	t.Run("dns lookups", func(t *testing.T) {
+		lookups := pm.dnsLookups.WithLabelValues("test_lookup")
+		cached := pm.dnsLookups.WithLabelValues("test_cached")
+		lookupsBefore, cachedBefore := testutil.ToFloat64(lookups), testutil.ToFloat64(cached)
+		samplesBefore := histogramSampleCount(t, pm.dnsDuration.WithLabelValues("test_lookup"))
+
+		pm.observeDNSLookup("test_lookup", 20*time.Millisecond)
+		// Результат из кеша без запроса не попадает в гистограмму времени
+		pm.observeDNSLookup("test_cached", 0)
+
+		if got := testutil.ToFloat64(lookups) - lookupsBefore; got != 1 {
+			t.Errorf("test_lookup lookups delta = %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(cached) - cachedBefore; got != 1 {
+			t.Errorf("test_cached lookups delta = %v, want 1", got)
+		}
+		if got := histogramSampleCount(t, pm.dnsDuration.WithLabelValues("test_lookup")) - samplesBefore; got != 1 {
+			t.Errorf("test_lookup duration samples delta = %d, want 1", got)
+		}
+		if pm.dnsDuration.DeleteLabelValues("test_cached") {
+			t.Error("cached lookup without a query recorded a duration")
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		hits, misses := pm.cacheCounters("test_cache")
+		hitsBefore, missesBefore := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+		hits.Inc()
+		misses.Inc()
+		misses.Inc()
+
+		if got := testutil.ToFloat64(hits) - hitsBefore; got != 1 {
+			t.Errorf("cache hits delta = %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(misses) - missesBefore; got != 2 {
+			t.Errorf("cache misses delta = %v, want 2", got)
+		}
+	})
+
+	t.Run("rate limit events", func(t *testing.T) {
+		tier := strings.Repeat("t", promMaxLabelLength+5)
+		counter := pm.rateLimitEvents.WithLabelValues("blocked", tier[:promMaxLabelLength])
+		before := testutil.ToFloat64(counter)
+
+		pm.rateLimitEvent("blocked", tier)
+
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Errorf("rate limit delta = %v, want 1", got)
+		}
+	})
+
+	t.Run("ban events", func(t *testing.T) {
+		honeypot := pm.banEvents.WithLabelValues("issued", OffenseHoneypot)
+		other := pm.banEvents.WithLabelValues("issued", promOtherLabel)
+		honeypotBefore, otherBefore := testutil.ToFloat64(honeypot), testutil.ToFloat64(other)
+
+		pm.banEvent("issued", OffenseHoneypot)
+		pm.banEvent("issued", "reason from import")
+
+		if got := testutil.ToFloat64(honeypot) - honeypotBefore; got != 1 {
+			t.Errorf("honeypot ban delta = %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(other) - otherBefore; got != 1 {
+			t.Errorf("unknown reason ban delta = %v, want 1", got)
+		}
+	})
+}
+
+// TestPrometheusNilSafe проверяет, что без подключенного Prometheus методы ничего не делают
+func TestPrometheusNilSafe(t *testing.T) {
+	var pm *PrometheusMetrics
+
+	pm.observeRequest(&DetectionResult{IsBot: true, BotName: "bot"}, requestActionPass)
+	pm.observeDetection(&DetectionResult{})
+	pm.observeDNSLookup("verified", time.Millisecond)
+	pm.rateLimitEvent("blocked", "default")
+	pm.banEvent("issued", OffenseManual)
+
+	if hits, misses := pm.cacheCounters("verdict"); hits != nil || misses != nil {
+		t.Errorf("cacheCounters = %v, %v, want nil", hits, misses)
+	}
+}
+
+// TestRegisterCollectorReusesExisting проверяет повторную регистрацию коллектора после
+// перезагрузки: используется уже зарегистрированный коллектор, а не новый
+func TestRegisterCollectorReusesExisting(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	opts := prometheus.CounterOpts{Name: "test_reload_total", Help: "Test counter."}
+
+	first := registerCollector(registry, prometheus.NewCounterVec(opts, []string{"event"}), zap.NewNop())
+	first.WithLabelValues("a").Inc()
+
+	second := registerCollector(registry, prometheus.NewCounterVec(opts, []string{"event"}), zap.NewNop())
+	if second != first {
+		t.Fatal("registerCollector returned a new collector instead of the registered one")
+	}
+	if got := testutil.ToFloat64(second.WithLabelValues("a")); got != 1 {
+		t.Errorf("counter after re-registration = %v, want 1", got)
+	}
+
+	// Конфликтующее описание не регистрируется, но коллектор остается пригодным
+	conflicting := prometheus.NewCounterVec(opts, []string{"other"})
+	if got := registerCollector(registry, conflicting, zap.NewNop()); got != conflicting {
+		t.Error("conflicting collector should be returned unregistered")
+	}
+}
@@ -0,0 +1,145 @@
+package botredirect
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Компоненты ключа rate limiting
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyASN    = "asn"
+	RateLimitKeyUA     = "ua"
+	RateLimitKeyHeader = "header:"
+)
+
+// rateLimitKeyBuilder строит ключ bucket'а из компонентов запроса
+type rateLimitKeyBuilder struct {
+	parts      []string
+	ipv4Prefix int
+	ipv6Prefix int
+	asnDB      *ASNDatabase
+}
+
+// newRateLimitKeyBuilder проверяет компоненты ключа и создает построитель
+func newRateLimitKeyBuilder(parts []string, ipv4Prefix, ipv6Prefix int, asnDB *ASNDatabase) (*rateLimitKeyBuilder, error) {
+	if len(parts) == 0 {
+		parts = []string{RateLimitKeyIP}
+	}
+	if ipv4Prefix <= 0 || ipv4Prefix > 32 {
+		ipv4Prefix = 32
+	}
+	if ipv6Prefix <= 0 || ipv6Prefix > 128 {
+		ipv6Prefix = 128
+	}
+
+	builder := &rateLimitKeyBuilder{
+		ipv4Prefix: ipv4Prefix,
+		ipv6Prefix: ipv6Prefix,
+		asnDB:      asnDB,
+	}
+
+	for _, part := range parts {
+		if err := ValidateRateLimitKeyPart(part); err != nil {
+			return nil, err
+		}
+		if part == RateLimitKeyASN && asnDB == nil {
+			return nil, fmt.Errorf("key component asn requires asn_database")
+		}
+		if strings.HasPrefix(part, RateLimitKeyHeader) {
+			// Имена заголовков нечувствительны к регистру
+			part = RateLimitKeyHeader + strings.ToLower(strings.TrimPrefix(part, RateLimitKeyHeader))
+		}
+		builder.parts = append(builder.parts, part)
+	}
+
+	return builder, nil
+}
+
+// ValidateRateLimitKeyPart проверяет один компонент ключа
+func ValidateRateLimitKeyPart(part string) error {
+	switch {
+	case part == RateLimitKeyIP, part == RateLimitKeyASN, part == RateLimitKeyUA:
+		return nil
+	case strings.HasPrefix(part, RateLimitKeyHeader) && len(part) > len(RateLimitKeyHeader):
+		return nil
+	default:
+		return fmt.Errorf("unknown rate limit key component: %s", part)
+	}
+}
+
+// build строит ключ клиента
+func (kb *rateLimitKeyBuilder) build(subject *RateLimitSubject, ipStr string) string {
+	addr, err := netip.ParseAddr(ipStr)
+	if err == nil {
+		addr = addr.Unmap()
+	}
+
+	values := make([]string, 0, len(kb.parts))
+	for _, part := range kb.parts {
+		switch {
+		case part == RateLimitKeyIP:
+			values = append(values, "ip:"+kb.networkKey(addr, ipStr))
+
+		case part == RateLimitKeyASN:
+			// Без совпадения в базе клиент учитывается по своей сети, а не в общем bucket'е
+			if asn, ok := kb.asnDB.Lookup(addr); ok {
+				values = append(values, "asn:"+strconv.FormatUint(uint64(asn), 10))
+			} else {
+				values = append(values, "ip:"+kb.networkKey(addr, ipStr))
+			}
+
+		case part == RateLimitKeyUA:
+			values = append(values, "ua:"+hashKeyValue(subject.UserAgent))
+
+		case strings.HasPrefix(part, RateLimitKeyHeader):
+			name := strings.TrimPrefix(part, RateLimitKeyHeader)
+			value := ""
+			if subject.Header != nil {
+				value = subject.Header.Get(name)
+			}
+			values = append(values, name+":"+hashKeyValue(value))
+		}
+	}
+
+	return strings.Join(values, "|")
+}
+
+// networkKey возвращает сеть клиента с учетом длины префикса
+func (kb *rateLimitKeyBuilder) networkKey(addr netip.Addr, fallback string) string {
+	if !addr.IsValid() {
+		return fallback
+	}
+
+	bits := kb.ipv6Prefix
+	if addr.Is4() {
+		bits = kb.ipv4Prefix
+	}
+	if bits == addr.BitLen() {
+		return addr.String()
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
+
+// hashKeyValue хеширует значение, чтобы длина ключа не зависела от клиента
+func hashKeyValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// String возвращает описание ключа для логов и статистики
+func (kb *rateLimitKeyBuilder) String() string {
+	return fmt.Sprintf("%s (v4/%d, v6/%d)", strings.Join(kb.parts, "+"), kb.ipv4Prefix, kb.ipv6Prefix)
+}
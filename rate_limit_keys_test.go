@@ -0,0 +1,248 @@
+package botredirect
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// writeTestASNDatabase записывает базу ASN в обоих поддерживаемых форматах
+func writeTestASNDatabase(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "asn.txt")
+	data := "# CIDR ASN\n198.51.100.0/24 64500\n198.51.100.128/25 64501\n2001:db8::/32 64502\n203.0.113.0\t24\t64503\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRateLimitKeyBuilder проверяет ключи клиентов для каждого компонента ключа
+func TestRateLimitKeyBuilder(t *testing.T) {
+	asnDB, err := LoadASNDatabase(writeTestASNDatabase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := func(value string) http.Header {
+		h := http.Header{}
+		if value != "" {
+			h.Set("X-Api-Key", value)
+		}
+		return h
+	}
+
+	tests := []struct {
+		name       string
+		parts      []string
+		ipv4, ipv6 int
+		a, b       RateLimitSubject
+		wantSame   bool
+		wantKey    string
+	}{
+		{
+			name:    "ip",
+			a:       RateLimitSubject{IP: "192.0.2.1"},
+			b:       RateLimitSubject{IP: "192.0.2.2"},
+			wantKey: "ip:192.0.2.1",
+		},
+		{
+			name:     "ipv4-mapped address is the same client",
+			a:        RateLimitSubject{IP: "192.0.2.1"},
+			b:        RateLimitSubject{IP: "::ffff:192.0.2.1"},
+			wantSame: true,
+		},
+		{
+			name:     "ipv4 prefix",
+			ipv4:     24,
+			a:        RateLimitSubject{IP: "192.0.2.1"},
+			b:        RateLimitSubject{IP: "192.0.2.200"},
+			wantSame: true,
+			wantKey:  "ip:192.0.2.0/24",
+		},
+		{
+			name: "ipv4 prefix boundary",
+			ipv4: 24,
+			a:    RateLimitSubject{IP: "192.0.2.1"},
+			b:    RateLimitSubject{IP: "192.0.3.1"},
+		},
+		{
+			name:     "ipv6 prefix",
+			ipv6:     64,
+			a:        RateLimitSubject{IP: "2001:db8:1:2::1"},
+			b:        RateLimitSubject{IP: "2001:db8:1:2:ffff::9"},
+			wantSame: true,
+			wantKey:  "ip:2001:db8:1:2::/64",
+		},
+		{
+			name:    "unparsable address is kept as is",
+			a:       RateLimitSubject{IP: "unix-socket"},
+			b:       RateLimitSubject{IP: "192.0.2.1"},
+			wantKey: "ip:unix-socket",
+		},
+		{
+			name:     "asn",
+			parts:    []string{RateLimitKeyASN},
+			a:        RateLimitSubject{IP: "198.51.100.1"},
+			b:        RateLimitSubject{IP: "198.51.100.127"},
+			wantSame: true,
+			wantKey:  "asn:64500",
+		},
+		{
+			name:    "asn longest prefix",
+			parts:   []string{RateLimitKeyASN},
+			a:       RateLimitSubject{IP: "198.51.100.200"},
+			b:       RateLimitSubject{IP: "198.51.100.1"},
+			wantKey: "asn:64501",
+		},
+		{
+			name:    "asn pfx2as line",
+			parts:   []string{RateLimitKeyASN},
+			a:       RateLimitSubject{IP: "203.0.113.7"},
+			b:       RateLimitSubject{IP: "198.51.100.1"},
+			wantKey: "asn:64503",
+		},
+		{
+			name:     "asn ipv6",
+			parts:    []string{RateLimitKeyASN},
+			a:        RateLimitSubject{IP: "2001:db8::1"},
+			b:        RateLimitSubject{IP: "2001:db8:ffff::1"},
+			wantSame: true,
+			wantKey:  "asn:64502",
+		},
+		{
+			name:    "address outside asn database falls back to its network",
+			parts:   []string{RateLimitKeyASN},
+			ipv4:    24,
+			a:       RateLimitSubject{IP: "192.0.2.1"},
+			b:       RateLimitSubject{IP: "192.0.3.1"},
+			wantKey: "ip:192.0.2.0/24",
+		},
+		{
+			name:  "ip and ua",
+			parts: []string{RateLimitKeyIP, RateLimitKeyUA},
+			a:     RateLimitSubject{IP: "192.0.2.1", UserAgent: "ExampleBot/1.0"},
+			b:     RateLimitSubject{IP: "192.0.2.1", UserAgent: "ExampleBot/2.0"},
+		},
+		{
+			name:     "ua only",
+			parts:    []string{RateLimitKeyUA},
+			a:        RateLimitSubject{IP: "192.0.2.1", UserAgent: "ExampleBot/1.0"},
+			b:        RateLimitSubject{IP: "198.51.100.1", UserAgent: "ExampleBot/1.0"},
+			wantSame: true,
+		},
+		{
+			name:    "empty ua",
+			parts:   []string{RateLimitKeyUA},
+			a:       RateLimitSubject{IP: "192.0.2.1"},
+			b:       RateLimitSubject{IP: "192.0.2.1", UserAgent: "ExampleBot/1.0"},
+			wantKey: "ua:-",
+		},
+		{
+			name:     "header name is case insensitive",
+			parts:    []string{"header:X-API-KEY"},
+			a:        RateLimitSubject{IP: "192.0.2.1", Header: header("key-1")},
+			b:        RateLimitSubject{IP: "198.51.100.1", Header: header("key-1")},
+			wantSame: true,
+		},
+		{
+			name:  "header value",
+			parts: []string{"header:x-api-key"},
+			a:     RateLimitSubject{IP: "192.0.2.1", Header: header("key-1")},
+			b:     RateLimitSubject{IP: "192.0.2.1", Header: header("key-2")},
+		},
+		{
+			name:    "missing header",
+			parts:   []string{"header:x-api-key"},
+			a:       RateLimitSubject{IP: "192.0.2.1"},
+			b:       RateLimitSubject{IP: "192.0.2.1", Header: header("key-1")},
+			wantKey: "x-api-key:-",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, err := newRateLimitKeyBuilder(tt.parts, tt.ipv4, tt.ipv6, asnDB)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keyA := kb.build(&tt.a, canonicalHost(tt.a.IP))
+			keyB := kb.build(&tt.b, canonicalHost(tt.b.IP))
+			if (keyA == keyB) != tt.wantSame {
+				t.Errorf("keys %q and %q: same = %v, want %v", keyA, keyB, keyA == keyB, tt.wantSame)
+			}
+			if tt.wantKey != "" && keyA != tt.wantKey {
+				t.Errorf("key = %q, want %q", keyA, tt.wantKey)
+			}
+		})
+	}
+}
+
+// TestRateLimitKeyBuilderRejectsInvalidParts проверяет отказ от неизвестных компонентов ключа
+func TestRateLimitKeyBuilderRejectsInvalidParts(t *testing.T) {
+	for _, parts := range [][]string{{"cookie"}, {"header:"}, {RateLimitKeyIP, "IP"}, {RateLimitKeyASN}} {
+		if _, err := newRateLimitKeyBuilder(parts, 0, 0, nil); err == nil {
+			t.Errorf("key %v accepted", parts)
+		}
+	}
+}
+
+// TestNewRateLimiterRejectsInvalidConfig проверяет, что ошибка базы ASN, ключа или уровня
+// останавливает создание rate limiter, а не заменяется ключом по IP или пропуском уровня
+func TestNewRateLimiterRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(config *Config)
+		wantErr string
+	}{
+		{
+			name: "missing asn database",
+			modify: func(config *Config) {
+				config.ASNDatabase = filepath.Join(t.TempDir(), "missing.txt")
+				config.RateLimitKey = []string{RateLimitKeyASN}
+			},
+			wantErr: "asn_database",
+		},
+		{
+			name:    "unknown key component",
+			modify:  func(config *Config) { config.RateLimitKey = []string{"cookie"} },
+			wantErr: "rate_limit_key",
+		},
+		{
+			name: "asn key without database",
+			modify: func(config *Config) {
+				config.RateLimitTiers = []RateLimitTier{{Name: "networks", Key: []string{RateLimitKeyASN}}}
+			},
+			wantErr: "rate_limit_tier networks",
+		},
+		{
+			name: "invalid tier CIDR",
+			modify: func(config *Config) {
+				config.RateLimitTiers = []RateLimitTier{{Name: "office", CIDRs: []string{"192.0.2.0/33"}}}
+			},
+			wantErr: "rate_limit_tier office",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(config)
+
+			rl, err := NewRateLimiter(config, nil, zap.NewNop())
+			if err == nil {
+				rl.Shutdown()
+				t.Fatal("invalid configuration accepted")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want mention of %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,21 +1,94 @@
 package botredirect
 
 import (
+	"fmt"
+	"math"
 	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-// TokenBucket реализует алгоритм token bucket для rate limiting
-type TokenBucket struct {
-	capacity   int
-	tokens     int
-	refillRate int
-	lastRefill time.Time
-	mutex      sync.Mutex
+// rateLimitTier скомпилированный уровень лимитов
+type rateLimitTier struct {
+	name          string
+	botTypes      map[BotType]bool
+	botNames      []string
+	networks      []*net.IPNet
+	verified      bool
+	bypass        bool
+	maxRequests   int
+	window        time.Duration
+	algorithm     LimiterAlgorithm
+	useCrawlDelay bool
+	badBot        bool
+	keyBuilder    *rateLimitKeyBuilder
+
+	// Уровень задан для конкретных краулеров: подтвержденный краулер
+	// учитывается одним bucket'ом на весь пул адресов
+	fleet bool
+}
+
+// RateLimitSubject описывает клиента, для которого проверяется лимит
+type RateLimitSubject struct {
+	IP         string
+	BotName    string
+	BotType    BotType
+	Verified   bool
+	BadBot     bool
+	CrawlDelay time.Duration
+	UserAgent  string
+	Header     http.Header
+}
+
+// RateLimitDecision результат проверки лимита
+type RateLimitDecision struct {
+	Allowed   bool
+	Bypassed  bool
+	Key       string
+	Tier      string
+	Limit     int
+	Window    time.Duration
+	Algorithm LimiterAlgorithm
+
+	// Состояние bucket'а после проверки
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// SetHeaders добавляет заголовки Retry-After и RateLimit-Policy/RateLimit
+// (draft-ietf-httpapi-ratelimit-headers) по состоянию bucket'а
+func (d *RateLimitDecision) SetHeaders(h http.Header) {
+	if d.Bypassed || d.Limit <= 0 {
+		return
+	}
+
+	policy := strconv.Quote(d.Tier)
+	h.Set("RateLimit-Policy", fmt.Sprintf("%s;q=%d;w=%d", policy, d.Limit, ceilSeconds(d.Window)))
+	h.Set("RateLimit", fmt.Sprintf("%s;r=%d;t=%d", policy, d.Remaining, ceilSeconds(d.Reset)))
+
+	if !d.Allowed {
+		retryAfter := ceilSeconds(d.RetryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		h.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	}
+}
+
+// ceilSeconds округляет длительность вверх до целых секунд
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
 }
 
 // RateLimiter управляет rate limiting для различных IP адресов
@@ -25,14 +98,29 @@ type RateLimiter struct {
 	maxRequests    int
 	maxDNSRequests int
 	window         time.Duration
+	algorithm      LimiterAlgorithm
+
+	// Источник времени (подменяется SetClock без блокировки проверок)
+	clock atomic.Pointer[Clock]
+
+	// Построитель ключей по умолчанию и база ASN
+	keyBuilder *rateLimitKeyBuilder
+	asnDB      *ASNDatabase
 
-	// Хранилища для rate limiting
-	requestBuckets map[string]*TokenBucket
-	dnsBuckets     map[string]*TokenBucket
+	// Уровни лимитов (проверяются по порядку)
+	tiers []*rateLimitTier
+
+	// Хранилище лимитов запросов (общее для узлов при redis) и локальное для DNS
+	store      LimiterStore
+	dnsStore   *memoryLimiterStore
+	failClosed bool
+
+	// Ошибки хранилища (логируются не чаще раза в storeErrorLogInterval)
+	storeErrors       int64
+	lastStoreErrorLog int64
 
 	// Мьютексы для безопасного доступа
 	requestMutex sync.RWMutex
-	dnsMutex     sync.RWMutex
 
 	// Очистка старых записей
 	cleanupInterval time.Duration
@@ -48,10 +136,24 @@ type RateLimiter struct {
 	logger  *zap.Logger
 }
 
-// NewRateLimiter создает новый экземпляр rate limiter
-func NewRateLimiter(config *Config, metrics *Metrics, logger *zap.Logger) *RateLimiter {
+// NewRateLimiter создает новый экземпляр rate limiter.
+// Ошибка возвращается, если не загружается база ASN или некорректен ключ или уровень:
+// вместо них limiter считал бы клиентов по IP или пропускал уровень.
+func NewRateLimiter(config *Config, metrics *Metrics, logger *zap.Logger) (*RateLimiter, error) {
 	if !config.EnableRateLimit {
-		return &RateLimiter{enabled: false}
+		return &RateLimiter{enabled: false}, nil
+	}
+
+	algorithm := LimiterAlgorithm(config.RateLimitAlgorithm)
+	if algorithm == "" {
+		algorithm = LimiterTokenBucket
+	}
+
+	// Хранилище создается при Provision: ошибка конфигурации хранилища останавливает
+	// загрузку, а не подменяет общее хранилище локальным
+	store := config.RateLimitStore
+	if store == nil {
+		store = newMemoryLimiterStore(config.RateLimitMaxBuckets)
 	}
 
 	rl := &RateLimiter{
@@ -59,8 +161,10 @@ func NewRateLimiter(config *Config, metrics *Metrics, logger *zap.Logger) *RateL
 		maxRequests:     config.MaxRequestsPerIP,
 		maxDNSRequests:  config.MaxDNSPerSecond,
 		window:          config.RateLimitWindow,
-		requestBuckets:  make(map[string]*TokenBucket),
-		dnsBuckets:      make(map[string]*TokenBucket),
+		algorithm:       algorithm,
+		store:           store,
+		dnsStore:        newMemoryLimiterStore(config.RateLimitMaxBuckets),
+		failClosed:      config.RateLimitFailMode == LimiterFailClosed,
 		cleanupInterval: 5 * time.Minute,
 		lastCleanup:     time.Now(),
 		stopCleanup:     make(chan bool, 1), // буферизованный канал
@@ -68,6 +172,13 @@ func NewRateLimiter(config *Config, metrics *Metrics, logger *zap.Logger) *RateL
 		metrics:         metrics,
 		logger:          logger,
 	}
+	rl.SetClock(systemClock{})
+
+	if err := rl.compileKeys(config); err != nil {
+		// Хранилище передано limiter, поэтому закрывается вместе с ним
+		rl.store.Close()
+		return nil, err
+	}
 
 	// Запускаем горутину для периодической очистки
 	rl.startCleanupRoutine()
@@ -77,31 +188,286 @@ func NewRateLimiter(config *Config, metrics *Metrics, logger *zap.Logger) *RateL
 		zap.Int("max_requests_per_ip", config.MaxRequestsPerIP),
 		zap.Int("max_dns_per_second", config.MaxDNSPerSecond),
 		zap.Duration("window", config.RateLimitWindow),
+		zap.String("algorithm", algorithm.String()),
+		zap.String("key", rl.keyBuilder.String()),
+		zap.Int("tiers", len(rl.tiers)),
+		zap.Any("storage", rl.store.GetStats()["type"]),
 	)
 
-	return rl
+	return rl, nil
+}
+
+// compileKeys загружает базу ASN и компилирует ключ по умолчанию и уровни лимитов
+func (rl *RateLimiter) compileKeys(config *Config) error {
+	// База ASN для ключей по автономной системе
+	if config.ASNDatabase != "" {
+		asnDB, err := LoadASNDatabase(config.ASNDatabase)
+		if err != nil {
+			return fmt.Errorf("asn_database: %w", err)
+		}
+		rl.asnDB = asnDB
+	}
+
+	keyBuilder, err := newRateLimitKeyBuilder(config.RateLimitKey, config.RateLimitIPv4Prefix, config.RateLimitIPv6Prefix, rl.asnDB)
+	if err != nil {
+		return fmt.Errorf("rate_limit_key: %w", err)
+	}
+	rl.keyBuilder = keyBuilder
+
+	// Компилируем уровни лимитов
+	for _, tierConfig := range config.RateLimitTiers {
+		tier, err := rl.compileTier(tierConfig)
+		if err != nil {
+			return fmt.Errorf("rate_limit_tier %s: %w", tierConfig.Name, err)
+		}
+		rl.tiers = append(rl.tiers, tier)
+	}
+
+	return nil
+}
+
+// compileTier проверяет и компилирует конфигурацию уровня
+func (rl *RateLimiter) compileTier(config RateLimitTier) (*rateLimitTier, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("tier name is required")
+	}
+
+	tier := &rateLimitTier{
+		name:          config.Name,
+		botTypes:      make(map[BotType]bool),
+		verified:      config.Verified,
+		bypass:        config.Bypass,
+		maxRequests:   config.MaxRequests,
+		window:        time.Duration(config.Window),
+		algorithm:     LimiterAlgorithm(config.Algorithm),
+		useCrawlDelay: config.UseCrawlDelay,
+		badBot:        config.BadBot,
+		fleet:         len(config.BotNames) > 0,
+	}
+
+	for _, botType := range config.BotTypes {
+		tier.botTypes[BotType(strings.ToLower(botType))] = true
+	}
+
+	for _, name := range config.BotNames {
+		tier.botNames = append(tier.botNames, strings.ToLower(name))
+	}
+
+	for _, cidr := range config.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %w", cidr, err)
+		}
+		tier.networks = append(tier.networks, network)
+	}
+
+	if tier.maxRequests <= 0 {
+		tier.maxRequests = rl.maxRequests
+	}
+	if tier.window <= 0 {
+		tier.window = rl.window
+	}
+	// Ключ уровня: незаданные параметры наследуются от ключа по умолчанию
+	keyParts := config.Key
+	if len(keyParts) == 0 {
+		keyParts = rl.keyBuilder.parts
+	}
+	ipv4Prefix, ipv6Prefix := config.IPv4Prefix, config.IPv6Prefix
+	if ipv4Prefix == 0 {
+		ipv4Prefix = rl.keyBuilder.ipv4Prefix
+	}
+	if ipv6Prefix == 0 {
+		ipv6Prefix = rl.keyBuilder.ipv6Prefix
+	}
+	keyBuilder, err := newRateLimitKeyBuilder(keyParts, ipv4Prefix, ipv6Prefix, rl.asnDB)
+	if err != nil {
+		return nil, err
+	}
+	tier.keyBuilder = keyBuilder
+
+	if tier.algorithm == "" {
+		tier.algorithm = rl.algorithm
+	} else if _, err := ParseLimiterAlgorithm(string(tier.algorithm)); err != nil {
+		return nil, err
+	}
+
+	return tier, nil
+}
+
+// matches проверяет, подходит ли клиент под условия уровня
+func (tier *rateLimitTier) matches(subject *RateLimitSubject, ip net.IP) bool {
+	if tier.verified && !subject.Verified {
+		return false
+	}
+
+	if tier.badBot && !subject.BadBot {
+		return false
+	}
+
+	if len(tier.botTypes) > 0 && !tier.botTypes[subject.BotType] {
+		return false
+	}
+
+	if len(tier.botNames) > 0 {
+		name := strings.ToLower(subject.BotName)
+		found := false
+		for _, botName := range tier.botNames {
+			if name != "" && strings.Contains(name, botName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(tier.networks) > 0 {
+		if ip == nil {
+			return false
+		}
+		found := false
+		for _, network := range tier.networks {
+			if network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
 
 // CheckRequest проверяет, разрешен ли запрос от данного IP
 func (rl *RateLimiter) CheckRequest(clientIP string) bool {
+	return rl.Allow(&RateLimitSubject{IP: clientIP}).Allowed
+}
+
+// Allow проверяет лимит для клиента с учетом уровней.
+// Подтвержденные краулеры, для которых уровень задает лимит по имени, учитываются
+// по идентичности (весь пул адресов в одном bucket), остальные - по идентичности и ключу клиента,
+// чтобы подделка User-Agent не расходовала чужой лимит.
+func (rl *RateLimiter) Allow(subject *RateLimitSubject) *RateLimitDecision {
 	if !rl.enabled {
-		return true
+		return &RateLimitDecision{Allowed: true}
 	}
 
-	// Извлекаем IP из адреса (убираем порт)
-	ip := rl.extractIP(clientIP)
+	ipStr := rl.extractIP(subject.IP)
+	ip := net.ParseIP(ipStr)
+	maxRequests, window := rl.limits()
 
-	allowed := rl.checkTokenBucket(ip, rl.maxRequests, &rl.requestMutex, rl.requestBuckets)
+	decision := &RateLimitDecision{
+		Key:       rl.identity(subject, rl.keyBuilder, ipStr, false),
+		Tier:      "default",
+		Limit:     maxRequests,
+		Window:    window,
+		Algorithm: rl.algorithm,
+	}
 
-	if !allowed && rl.metrics != nil {
-		rl.metrics.IncrementRateLimitBlocked()
+	for _, tier := range rl.tiers {
+		if !tier.matches(subject, ip) {
+			continue
+		}
+
+		decision.Tier = tier.name
+		decision.Key = tier.name + "|" + rl.identity(subject, tier.keyBuilder, ipStr, tier.fleet)
+
+		if tier.bypass {
+			decision.Allowed = true
+			decision.Bypassed = true
+			return decision
+		}
+
+		decision.Limit = tier.maxRequests
+		decision.Window = tier.window
+		decision.Algorithm = tier.algorithm
+
+		// Crawl-delay: один запрос за интервал задержки
+		if tier.useCrawlDelay && subject.CrawlDelay > 0 {
+			decision.Limit = 1
+			decision.Window = subject.CrawlDelay
+		}
+		break
+	}
+
+	decision.apply(rl.take(decision.Key, decision.Algorithm, decision.Limit, decision.Window))
+
+	if !decision.Allowed && rl.metrics != nil {
+		rl.metrics.IncrementRateLimitBlocked(decision.Tier)
 		rl.logger.Warn("request rate limited",
-			zap.String("ip", ip),
-			zap.Int("max_requests", rl.maxRequests),
+			zap.String("ip", ipStr),
+			zap.String("key", decision.Key),
+			zap.String("tier", decision.Tier),
+			zap.Int("limit", decision.Limit),
+			zap.Duration("window", decision.Window),
 		)
 	}
 
-	return allowed
+	return decision
+}
+
+// identity возвращает идентичность клиента для ключа bucket'а.
+// Подтвержденные краулеры учитываются по имени только при лимите на весь пул (fleet),
+// в остальных случаях - по имени и ключу клиента.
+func (rl *RateLimiter) identity(subject *RateLimitSubject, keyBuilder *rateLimitKeyBuilder, ipStr string, fleet bool) string {
+	if subject.BotName != "" && subject.Verified && fleet {
+		return "bot:" + strings.ToLower(subject.BotName)
+	}
+
+	clientKey := keyBuilder.build(subject, ipStr)
+	if subject.BotName != "" {
+		return "bot:" + strings.ToLower(subject.BotName) + "|" + clientKey
+	}
+	return clientKey
+}
+
+// apply переносит состояние bucket'а в решение
+func (d *RateLimitDecision) apply(result LimitResult) {
+	d.Allowed = result.Allowed
+	d.Remaining = result.Remaining
+	d.RetryAfter = result.RetryAfter
+	d.Reset = result.Reset
+}
+
+// ClientKey возвращает ключ клиента по rate_limit_key (сеть, ASN, User-Agent, заголовки)
+// для ключей политик: порт и форма записи адреса на ключ не влияют
+func (rl *RateLimiter) ClientKey(subject *RateLimitSubject) string {
+	host := canonicalHost(subject.IP)
+	if !rl.enabled {
+		return host
+	}
+	return rl.keyBuilder.build(subject, host)
+}
+
+// AllowKey проверяет лимит для произвольного ключа и возвращает состояние bucket'а
+func (rl *RateLimiter) AllowKey(key string) *RateLimitDecision {
+	if !rl.enabled {
+		return &RateLimitDecision{Allowed: true}
+	}
+
+	maxRequests, window := rl.limits()
+
+	decision := &RateLimitDecision{
+		Key:       key,
+		Tier:      "policy",
+		Limit:     maxRequests,
+		Window:    window,
+		Algorithm: rl.algorithm,
+	}
+	decision.apply(rl.take(key, rl.algorithm, maxRequests, window))
+
+	if !decision.Allowed && rl.metrics != nil {
+		rl.metrics.IncrementRateLimitBlocked(decision.Tier)
+		rl.logger.Warn("policy rate limited",
+			zap.String("key", key),
+			zap.Int("max_requests", maxRequests),
+		)
+	}
+
+	return decision
 }
 
 // CheckDNSRequest проверяет, разрешен ли DNS запрос от данного IP
@@ -112,72 +478,72 @@ func (rl *RateLimiter) CheckDNSRequest(clientIP string) bool {
 
 	ip := rl.extractIP(clientIP)
 
-	allowed := rl.checkTokenBucket(ip, rl.maxDNSRequests, &rl.dnsMutex, rl.dnsBuckets)
+	rl.requestMutex.RLock()
+	maxDNSRequests := rl.maxDNSRequests
+	rl.requestMutex.RUnlock()
+
+	result, _ := rl.dnsStore.Take(ip, LimiterTokenBucket, maxDNSRequests, time.Second, rl.now())
+	allowed := result.Allowed
 
 	if !allowed && rl.metrics != nil {
 		rl.metrics.IncrementRateLimited()
 		rl.logger.Warn("DNS request rate limited",
 			zap.String("ip", ip),
-			zap.Int("max_dns_requests", rl.maxDNSRequests),
+			zap.Int("max_dns_requests", maxDNSRequests),
 		)
 	}
 
 	return allowed
 }
 
-// checkTokenBucket проверяет и обновляет token bucket для данного IP
-func (rl *RateLimiter) checkTokenBucket(ip string, maxRate int, mutex *sync.RWMutex, buckets map[string]*TokenBucket) bool {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	bucket, exists := buckets[ip]
-	if !exists {
-		// Создаем новый bucket для IP
-		bucket = &TokenBucket{
-			capacity:   maxRate,
-			tokens:     maxRate,
-			refillRate: maxRate,
-			lastRefill: time.Now(),
-		}
-		buckets[ip] = bucket
-	}
-
-	return bucket.allowRequest()
+// limits возвращает текущий лимит запросов и окно (изменяются UpdateLimits)
+func (rl *RateLimiter) limits() (int, time.Duration) {
+	rl.requestMutex.RLock()
+	defer rl.requestMutex.RUnlock()
+	return rl.maxRequests, rl.window
 }
 
-// allowRequest проверяет, можно ли выполнить запрос (потребляет один токен)
-func (tb *TokenBucket) allowRequest() bool {
-	tb.mutex.Lock()
-	defer tb.mutex.Unlock()
+// storeErrorLogInterval минимальный интервал между записями об ошибках хранилища
+const storeErrorLogInterval = 10 * time.Second
 
-	// Пополняем токены на основе прошедшего времени
-	tb.refill()
+// take проверяет лимит ключа в хранилище.
+// Если хранилище недоступно, запрос пропускается или отклоняется согласно rate_limit_fail_mode.
+func (rl *RateLimiter) take(key string, algorithm LimiterAlgorithm, limit int, window time.Duration) LimitResult {
+	now := rl.now()
 
-	if tb.tokens > 0 {
-		tb.tokens--
-		return true
+	result, err := rl.store.Take(key, algorithm, limit, window, now)
+	if err == nil {
+		return result
 	}
 
-	return false
-}
+	atomic.AddInt64(&rl.storeErrors, 1)
+	if rl.metrics != nil {
+		rl.metrics.IncrementRateLimitStoreErrors()
+	}
 
-// refill пополняет токены в bucket на основе прошедшего времени
-func (tb *TokenBucket) refill() {
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill)
+	// Недоступный backend не должен заполнять лог записью на каждый запрос
+	last := atomic.LoadInt64(&rl.lastStoreErrorLog)
+	if now.UnixNano()-last >= int64(storeErrorLogInterval) && atomic.CompareAndSwapInt64(&rl.lastStoreErrorLog, last, now.UnixNano()) {
+		rl.logger.Warn("rate limit storage unavailable",
+			zap.Bool("fail_closed", rl.failClosed),
+			zap.Error(err),
+		)
+	}
 
-	if elapsed >= time.Second {
-		// Добавляем токены за каждую секунду
-		seconds := int(elapsed.Seconds())
-		tokensToAdd := seconds * tb.refillRate
+	if rl.failClosed {
+		return LimitResult{RetryAfter: time.Second}
+	}
+	return LimitResult{Allowed: true, Remaining: limit}
+}
 
-		tb.tokens += tokensToAdd
-		if tb.tokens > tb.capacity {
-			tb.tokens = tb.capacity
-		}
+// now возвращает текущее время источника времени
+func (rl *RateLimiter) now() time.Time {
+	return (*rl.clock.Load()).Now()
+}
 
-		tb.lastRefill = now
-	}
+// SetClock подменяет источник времени
+func (rl *RateLimiter) SetClock(clock Clock) {
+	rl.clock.Store(&clock)
 }
 
 // extractIP извлекает IP адрес из строки адреса (убирает порт)
@@ -228,36 +594,10 @@ func (rl *RateLimiter) startCleanupRoutine() {
 
 // cleanup удаляет старые неиспользуемые bucket'ы
 func (rl *RateLimiter) cleanup() {
-	now := time.Now()
-	cutoff := now.Add(-rl.window * 2) // Удаляем bucket'ы старше 2 окон
-
-	// Очистка request buckets
-	rl.requestMutex.Lock()
-	for ip, bucket := range rl.requestBuckets {
-		bucket.mutex.Lock()
-		lastRefill := bucket.lastRefill
-		bucket.mutex.Unlock()
-
-		if lastRefill.Before(cutoff) {
-			delete(rl.requestBuckets, ip)
-		}
-	}
-	requestCount := len(rl.requestBuckets)
-	rl.requestMutex.Unlock()
-
-	// Очистка DNS buckets
-	rl.dnsMutex.Lock()
-	for ip, bucket := range rl.dnsBuckets {
-		bucket.mutex.Lock()
-		lastRefill := bucket.lastRefill
-		bucket.mutex.Unlock()
-
-		if lastRefill.Before(cutoff) {
-			delete(rl.dnsBuckets, ip)
-		}
-	}
-	dnsCount := len(rl.dnsBuckets)
-	rl.dnsMutex.Unlock()
+	// Удаляем состояния, которые не отличаются от новых
+	now := rl.now()
+	requestCount := rl.store.Cleanup(now)
+	dnsCount := rl.dnsStore.Cleanup(now)
 
 	rl.logger.Debug("rate limiter cleanup completed",
 		zap.Int("active_request_buckets", requestCount),
@@ -271,21 +611,27 @@ func (rl *RateLimiter) GetStats() map[string]interface{} {
 		return map[string]interface{}{"enabled": false}
 	}
 
+	tiers := make(map[string]string, len(rl.tiers))
+	for _, tier := range rl.tiers {
+		tiers[tier.name] = tier.keyBuilder.String()
+	}
+
 	rl.requestMutex.RLock()
-	requestBuckets := len(rl.requestBuckets)
+	maxRequests, maxDNSRequests, window := rl.maxRequests, rl.maxDNSRequests, rl.window
 	rl.requestMutex.RUnlock()
 
-	rl.dnsMutex.RLock()
-	dnsBuckets := len(rl.dnsBuckets)
-	rl.dnsMutex.RUnlock()
-
 	return map[string]interface{}{
-		"enabled":                true,
-		"max_requests_per_ip":    rl.maxRequests,
-		"max_dns_per_second":     rl.maxDNSRequests,
-		"window_seconds":         rl.window.Seconds(),
-		"active_request_buckets": requestBuckets,
-		"active_dns_buckets":     dnsBuckets,
+		"enabled":             true,
+		"tiers":               tiers,
+		"max_requests_per_ip": maxRequests,
+		"max_dns_per_second":  maxDNSRequests,
+		"window_seconds":      window.Seconds(),
+		"algorithm":           rl.algorithm.String(),
+		"key":                 rl.keyBuilder.String(),
+		"storage":             rl.store.GetStats(),
+		"fail_closed":         rl.failClosed,
+		"storage_errors":      atomic.LoadInt64(&rl.storeErrors),
+		"active_dns_buckets":  rl.dnsStore.Len(),
 	}
 }
 
@@ -302,12 +648,9 @@ func (rl *RateLimiter) UpdateLimits(maxRequests, maxDNS int, window time.Duratio
 
 	rl.requestMutex.Lock()
 	rl.maxRequests = maxRequests
-	rl.requestMutex.Unlock()
-
-	rl.dnsMutex.Lock()
 	rl.maxDNSRequests = maxDNS
 	rl.window = window
-	rl.dnsMutex.Unlock()
+	rl.requestMutex.Unlock()
 
 	rl.logger.Info("rate limiter limits updated",
 		zap.Int("max_requests_per_ip", maxRequests),
@@ -322,17 +665,54 @@ func (rl *RateLimiter) Reset() {
 		return
 	}
 
-	rl.requestMutex.Lock()
-	rl.requestBuckets = make(map[string]*TokenBucket)
-	rl.requestMutex.Unlock()
-
-	rl.dnsMutex.Lock()
-	rl.dnsBuckets = make(map[string]*TokenBucket)
-	rl.dnsMutex.Unlock()
+	if err := rl.store.Reset(); err != nil {
+		rl.logger.Error("failed to reset rate limit storage", zap.Error(err))
+	}
+	rl.dnsStore.Reset()
 
 	rl.logger.Info("rate limiter reset completed")
 }
 
+// ResetClient сбрасывает состояния лимитов клиента: ключи с его сетью в компоненте ip
+// (с учетом длины префикса каждого уровня), ключи политик с его адресом и лимит DNS запросов.
+// Возвращает количество удаленных состояний.
+func (rl *RateLimiter) ResetClient(ip string) (int, error) {
+	if !rl.enabled {
+		return 0, nil
+	}
+
+	host := canonicalHost(ip)
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	networks := map[string]bool{"ip:" + rl.keyBuilder.networkKey(addr, host): true}
+	for _, tier := range rl.tiers {
+		networks["ip:"+tier.keyBuilder.networkKey(addr, host)] = true
+	}
+
+	match := func(key string) bool {
+		for _, part := range strings.Split(key, "|") {
+			if networks[part] || canonicalHost(part) == host {
+				return true
+			}
+		}
+		return false
+	}
+
+	deleted, err := rl.store.DeleteMatching(match)
+	dnsDeleted, _ := rl.dnsStore.DeleteMatching(match)
+	deleted += dnsDeleted
+
+	rl.logger.Info("rate limiter client reset",
+		zap.String("ip", host),
+		zap.Int("deleted", deleted),
+	)
+
+	return deleted, err
+}
+
 // Shutdown останавливает rate limiter
 func (rl *RateLimiter) Shutdown() {
 	if !rl.enabled {
@@ -347,4 +727,6 @@ func (rl *RateLimiter) Shutdown() {
 		}
 	}
 	rl.cleanupMutex.Unlock()
+
+	rl.store.Close()
 }
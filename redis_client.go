@@ -0,0 +1,375 @@
+package botredirect
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisMaxIdleConns количество простаивающих соединений в пуле
+const redisMaxIdleConns = 16
+
+// redisMaxActiveConns максимальное количество открытых соединений (простаивающих и занятых)
+const redisMaxActiveConns = 64
+
+// errRedisPoolExhausted все соединения заняты дольше таймаута
+var errRedisPoolExhausted = errors.New("redis: connection pool exhausted")
+
+// redisError ошибка, возвращенная сервером (ответ RESP "-ERR ...")
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisClient минимальный клиент протокола RESP2 (Redis, Valkey, KeyDB)
+// с пулом соединений. Поддерживаются команды, пайплайны и Lua скрипты.
+type redisClient struct {
+	address   string
+	username  string
+	password  string
+	db        int
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	pool chan *redisConn
+
+	// Слоты открытых соединений: новое соединение открывается только при свободном слоте
+	active chan struct{}
+}
+
+// redisConn одно соединение с сервером
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// newRedisClient создает клиент по URL вида redis://[user:password@]host[:port][/db]
+// (rediss:// - с TLS). Соединения открываются при первом запросе.
+func newRedisClient(rawURL string, timeout time.Duration) (*redisClient, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &redisClient{
+		timeout: timeout,
+		pool:    make(chan *redisConn, redisMaxIdleConns),
+		active:  make(chan struct{}, redisMaxActiveConns),
+	}
+
+	switch parsed.Scheme {
+	case "redis", "valkey":
+	case "rediss", "valkeys":
+		client.tlsConfig = &tls.Config{ServerName: parsed.Hostname(), MinVersion: tls.VersionTLS12}
+	default:
+		return nil, fmt.Errorf("unsupported redis URL scheme: %s", parsed.Scheme)
+	}
+
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("redis URL must contain a host")
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "6379"
+	}
+	client.address = net.JoinHostPort(parsed.Hostname(), port)
+
+	if parsed.User != nil {
+		client.username = parsed.User.Username()
+		client.password, _ = parsed.User.Password()
+		// redis://:password@host - только пароль без имени пользователя
+		if _, hasPassword := parsed.User.Password(); !hasPassword {
+			client.password, client.username = client.username, ""
+		}
+	}
+
+	if dbStr := strings.Trim(parsed.Path, "/"); dbStr != "" {
+		client.db, err = strconv.Atoi(dbStr)
+		if err != nil || client.db < 0 {
+			return nil, fmt.Errorf("invalid redis database: %s", dbStr)
+		}
+	}
+
+	return client, nil
+}
+
+// Do выполняет одну команду
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := replies[0].(redisError); ok {
+		return nil, replyErr
+	}
+	return replies[0], nil
+}
+
+// Pipeline отправляет команды одним пакетом и читает все ответы.
+// Ошибки отдельных команд возвращаются как значения redisError в ответах.
+func (c *redisClient) Pipeline(commands [][]string) ([]interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := conn.roundTrip(commands, c.timeout)
+	if err != nil {
+		// Состояние протокола неизвестно - соединение не возвращаем в пул
+		c.discard(conn)
+		return nil, err
+	}
+
+	c.put(conn)
+	return replies, nil
+}
+
+// EvalScript выполняет Lua скрипт через EVALSHA, загружая его при NOSCRIPT
+func (c *redisClient) EvalScript(script *redisScript, keys []string, args ...string) (interface{}, error) {
+	command := append([]string{"EVALSHA", script.sha, strconv.Itoa(len(keys))}, keys...)
+	reply, err := c.Do(append(command, args...)...)
+	if replyErr, ok := err.(redisError); ok && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		command[0], command[1] = "EVAL", script.source
+		return c.Do(append(command, args...)...)
+	}
+	return reply, err
+}
+
+// get берет соединение из пула или открывает новое, если открыто меньше redisMaxActiveConns.
+// Если все соединения заняты, ждет освобождения не дольше таймаута.
+func (c *redisClient) get() (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	var expired <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	case c.active <- struct{}{}:
+	case <-expired:
+		return nil, errRedisPoolExhausted
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		<-c.active
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dial открывает соединение, выполняет аутентификацию и выбор базы
+func (c *redisClient) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var netConn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{
+		conn:   netConn,
+		reader: bufio.NewReader(netConn),
+		writer: bufio.NewWriter(netConn),
+	}
+
+	// Аутентификация и выбор базы
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) > 0 {
+		replies, err := conn.roundTrip(setup, c.timeout)
+		if err == nil {
+			for _, reply := range replies {
+				if replyErr, ok := reply.(redisError); ok {
+					err = replyErr
+					break
+				}
+			}
+		}
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// put возвращает соединение в пул (или закрывает, если пул заполнен)
+func (c *redisClient) put(conn *redisConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		c.discard(conn)
+	}
+}
+
+// discard закрывает соединение и освобождает его слот
+func (c *redisClient) discard(conn *redisConn) {
+	conn.conn.Close()
+	<-c.active
+}
+
+// Close закрывает простаивающие соединения
+func (c *redisClient) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			c.discard(conn)
+		default:
+			return nil
+		}
+	}
+}
+
+// roundTrip записывает команды и читает столько же ответов
+func (rc *redisConn) roundTrip(commands [][]string, timeout time.Duration) ([]interface{}, error) {
+	if timeout > 0 {
+		if err := rc.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, args := range commands {
+		writeRESPCommand(rc.writer, args)
+	}
+	if err := rc.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		reply, err := readRESP(rc.reader)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// writeRESPCommand кодирует команду массивом bulk строк
+func writeRESPCommand(w *bufio.Writer, args []string) {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+// readRESP читает один ответ: string, redisError, int64, []byte, nil или []interface{}
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+
+	case '-':
+		return redisError(payload), nil
+
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
+
+// redisScript Lua скрипт с предвычисленным SHA1 для EVALSHA
+type redisScript struct {
+	source string
+	sha    string
+}
+
+// newRedisScript подготавливает Lua скрипт
+func newRedisScript(source string) *redisScript {
+	sum := sha1.Sum([]byte(source))
+	return &redisScript{source: source, sha: hex.EncodeToString(sum[:])}
+}
+
+// redisInt преобразует ответ в число
+func redisInt(reply interface{}) (int64, error) {
+	switch value := reply.(type) {
+	case int64:
+		return value, nil
+	case []byte:
+		return strconv.ParseInt(string(value), 10, 64)
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply %T", reply)
+	}
+}
@@ -1,47 +1,54 @@
 package botredirect
 
 import (
+	"fmt"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-// ReferrerChecker отвечает за анализ HTTP Referer заголовков
+// ReferrerChecker отвечает за анализ HTTP Referer заголовков.
+// Скомпилированные домены хранятся в неизменяемом наборе за atomic.Pointer: проверки читают
+// его без блокировок, а изменения собирают новый набор и подменяют указатель.
 type ReferrerChecker struct {
 	// Конфигурация
 	enabled bool
 	
-	// Разрешенные домены поисковых систем
-	allowedDomains    []string
-	compiledPatterns  []*regexp.Regexp
-	exactDomains      map[string]bool
-	wildcardDomains   []string
+	// Действующий набор разрешенных доменов поисковых систем
+	rules atomic.Pointer[referrerRules]
 	
 	// Кеш результатов
-	cache     map[string]*ReferrerResult
-	cacheTTL  time.Duration
-	maxCache  int
+	cache     *Cache[string, *ReferrerResult]
 	
-	// Синхронизация
-	mutex sync.RWMutex
+	// Синхронизация изменений (проверки не блокируются)
+	mutex sync.Mutex
 	
 	// Компоненты
 	metrics *Metrics
 	debug   *DebugConfig
 	logger  *zap.Logger
 	
-	// Статистика
+	// Статистика (используем atomic для thread-safety)
 	totalChecks       int64
 	validReferrers    int64
 	invalidReferrers  int64
 	emptyReferrers    int64
-	cacheHits         int64
 	malformedURLs     int64
-	searchEngineHits  map[string]int64
+	searchEngineHits  sync.Map // map[string]*int64
+}
+
+// referrerRules скомпилированный набор доменов; после создания не изменяется
+type referrerRules struct {
+	domains          []string
+	exactDomains     map[string]bool
+	wildcardDomains  []string
+	compiledPatterns []*regexp.Regexp
 }
 
 // ReferrerResult содержит результат анализа referrer
@@ -87,73 +94,68 @@ func NewReferrerChecker(config *Config, metrics *Metrics, debug *DebugConfig, lo
 	}
 
 	rc := &ReferrerChecker{
-		enabled:          true,
-		allowedDomains:   make([]string, 0),
-		compiledPatterns: make([]*regexp.Regexp, 0),
-		exactDomains:     make(map[string]bool),
-		wildcardDomains:  make([]string, 0),
-		cache:            make(map[string]*ReferrerResult),
-		cacheTTL:         config.CacheTTL,
-		maxCache:         3000, // Кеш для 3000 referrer'ов
-		metrics:          metrics,
-		debug:            debug,
-		logger:           logger,
-		searchEngineHits: make(map[string]int64),
+		enabled: true,
+		metrics: metrics,
+		debug:   debug,
+		logger:  logger,
 	}
 
+	rc.cache = NewCache[string, *ReferrerResult](CacheOptions{
+		Name:            "referrer",
+		MaxSize:         config.ReferrerCacheSize,
+		TTL:             config.CacheTTL,
+		CleanupInterval: config.CleanupInterval,
+	}, hashString, metrics, debug, logger)
+
 	// Используем кастомные домены если заданы, иначе дефолтные
 	domains := config.AllowedReferrers
 	if len(domains) == 0 {
 		domains = getDefaultAllowedReferrers()
 	}
 
-	// Инициализация паттернов
-	if err := rc.initializePatterns(domains); err != nil {
-		logger.Error("failed to initialize referrer patterns", zap.Error(err))
-		return rc
-	}
+	// Компиляция паттернов
+	rules := rc.compileRules(domains)
+	rc.rules.Store(rules)
 
 	logger.Info("referrer checker initialized",
 		zap.Bool("enabled", true),
-		zap.Int("total_domains", len(rc.allowedDomains)),
-		zap.Int("exact_domains", len(rc.exactDomains)),
-		zap.Int("wildcard_domains", len(rc.wildcardDomains)),
-		zap.Int("regex_patterns", len(rc.compiledPatterns)),
+		zap.Int("total_domains", len(rules.domains)),
+		zap.Int("exact_domains", len(rules.exactDomains)),
+		zap.Int("wildcard_domains", len(rules.wildcardDomains)),
+		zap.Int("regex_patterns", len(rules.compiledPatterns)),
 	)
 
 	return rc
 }
 
-// initializePatterns инициализирует и оптимизирует паттерны доменов
-func (rc *ReferrerChecker) initializePatterns(domains []string) error {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-
-	// Очистка предыдущих паттернов
-	rc.allowedDomains = make([]string, 0, len(domains))
-	rc.compiledPatterns = make([]*regexp.Regexp, 0)
-	rc.exactDomains = make(map[string]bool)
-	rc.wildcardDomains = make([]string, 0)
+// compileRules компилирует и оптимизирует паттерны доменов в новый набор
+func (rc *ReferrerChecker) compileRules(domains []string) *referrerRules {
+	rules := &referrerRules{
+		domains:          make([]string, 0, len(domains)),
+		exactDomains:     make(map[string]bool),
+		wildcardDomains:  make([]string, 0),
+		compiledPatterns: make([]*regexp.Regexp, 0),
+	}
 
 	for _, domain := range domains {
 		if domain == "" {
 			continue
 		}
 
-		rc.allowedDomains = append(rc.allowedDomains, domain)
+		rules.domains = append(rules.domains, domain)
 
 		// Классификация паттернов для оптимизации
 		if rc.isExactDomain(domain) {
 			// Точный домен - самый быстрый поиск
-			rc.exactDomains[strings.ToLower(domain)] = true
+			rules.exactDomains[strings.ToLower(domain)] = true
 		} else if rc.isWildcardDomain(domain) {
 			// Wildcard домен - быстрый поиск
-			rc.wildcardDomains = append(rc.wildcardDomains, strings.ToLower(domain))
+			rules.wildcardDomains = append(rules.wildcardDomains, strings.ToLower(domain))
 		} else {
 			// Regex паттерн - медленный но гибкий
 			pattern := rc.convertToRegex(domain)
 			if regex, err := regexp.Compile("(?i)" + pattern); err == nil {
-				rc.compiledPatterns = append(rc.compiledPatterns, regex)
+				rules.compiledPatterns = append(rules.compiledPatterns, regex)
 			} else {
 				rc.logger.Warn("invalid referrer pattern",
 					zap.String("domain", domain),
@@ -164,7 +166,7 @@ func (rc *ReferrerChecker) initializePatterns(domains []string) error {
 		}
 	}
 
-	return nil
+	return rules
 }
 
 // isExactDomain проверяет, является ли паттерн точным доменом
@@ -226,8 +228,7 @@ func (rc *ReferrerChecker) CheckReferrer(referrer string) (*ReferrerResult, erro
 	}
 
 	// Проверка кеша
-	if result := rc.getCachedResult(referrer); result != nil {
-		rc.incrementCacheHits()
+	if result, ok := rc.cache.Get(referrer); ok {
 		if rc.metrics != nil {
 			rc.metrics.IncrementCacheHits()
 		}
@@ -244,10 +245,15 @@ func (rc *ReferrerChecker) CheckReferrer(referrer string) (*ReferrerResult, erro
 	}
 
 	// Выполнение проверки
-	result := rc.performCheck(referrer)
+	rules := rc.rules.Load()
+	result := rc.performCheck(referrer, rules)
 	
-	// Сохранение в кеш
-	rc.setCachedResult(referrer, result)
+	// Сохранение в кеш. Если набор заменили во время проверки, очистка кеша изменением
+	// могла пройти до записи: устаревший результат удаляется
+	rc.cache.Set(referrer, result)
+	if rc.rules.Load() != rules {
+		rc.cache.Delete(referrer)
+	}
 	
 	// Логирование для дебага
 	if rc.debug != nil {
@@ -267,11 +273,8 @@ func (rc *ReferrerChecker) CheckReferrer(referrer string) (*ReferrerResult, erro
 	return result, nil
 }
 
-// performCheck выполняет основную проверку referrer
-func (rc *ReferrerChecker) performCheck(referrer string) *ReferrerResult {
-	rc.mutex.RLock()
-	defer rc.mutex.RUnlock()
-
+// performCheck выполняет основную проверку referrer по набору доменов
+func (rc *ReferrerChecker) performCheck(referrer string, rules *referrerRules) *ReferrerResult {
 	// Парсинг URL
 	parsedURL, err := url.Parse(referrer)
 	if err != nil {
@@ -297,7 +300,7 @@ func (rc *ReferrerChecker) performCheck(referrer string) *ReferrerResult {
 	}
 
 	// 1. Проверка точных доменов (самый быстрый)
-	if rc.exactDomains[hostname] {
+	if rules.exactDomains[hostname] {
 		searchEngine := rc.identifySearchEngine(hostname)
 		queryParams := rc.extractQueryParameters(parsedURL)
 		
@@ -315,7 +318,7 @@ func (rc *ReferrerChecker) performCheck(referrer string) *ReferrerResult {
 	}
 
 	// 2. Проверка wildcard доменов
-	for _, pattern := range rc.wildcardDomains {
+	for _, pattern := range rules.wildcardDomains {
 		if rc.matchWildcard(hostname, pattern) {
 			searchEngine := rc.identifySearchEngine(hostname)
 			queryParams := rc.extractQueryParameters(parsedURL)
@@ -335,7 +338,7 @@ func (rc *ReferrerChecker) performCheck(referrer string) *ReferrerResult {
 	}
 
 	// 3. Проверка regex паттернов (самый медленный)
-	for _, regex := range rc.compiledPatterns {
+	for _, regex := range rules.compiledPatterns {
 		if regex.MatchString(hostname) {
 			searchEngine := rc.identifySearchEngine(hostname)
 			queryParams := rc.extractQueryParameters(parsedURL)
@@ -523,132 +526,161 @@ func (rc *ReferrerChecker) classifyUnknownReferrer(hostname string, parsedURL *u
 	return ReferrerTypeDirectLink
 }
 
-// getCachedResult получает результат из кеша
-func (rc *ReferrerChecker) getCachedResult(referrer string) *ReferrerResult {
-	rc.mutex.RLock()
-	defer rc.mutex.RUnlock()
-	
-	if result, exists := rc.cache[referrer]; exists {
-		// Проверка TTL
-		if time.Since(result.Timestamp) < rc.cacheTTL {
-			return result
-		}
-		// Удаление устаревшей записи
-		delete(rc.cache, referrer)
+// AddDomain добавляет новый разрешенный домен в runtime
+func (rc *ReferrerChecker) AddDomain(domain string) error {
+	if domain == "" {
+		return nil
+	}
+	if err := rc.validateDomain(domain); err != nil {
+		return err
 	}
 	
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	
+	// Собираем новый набор с добавленным доменом
+	domains := append(slices.Clone(rc.rules.Load().domains), domain)
+	rc.rules.Store(rc.compileRules(domains))
+	
+	// Очищаем кеш после добавления нового домена
+	rc.cache.Clear()
+	
+	rc.logger.Info("added new referrer domain",
+		zap.String("domain", domain),
+		zap.Int("total_domains", len(domains)),
+	)
+	
 	return nil
 }
 
-// setCachedResult сохраняет результат в кеш
-func (rc *ReferrerChecker) setCachedResult(referrer string, result *ReferrerResult) {
+// RemoveDomain удаляет домен
+func (rc *ReferrerChecker) RemoveDomain(domain string) {
 	rc.mutex.Lock()
 	defer rc.mutex.Unlock()
 	
-	// Проверка размера кеша
-	if len(rc.cache) >= rc.maxCache {
-		rc.cleanupCache()
+	// Собираем новый набор без домена
+	domains := slices.Clone(rc.rules.Load().domains)
+	if i := slices.Index(domains, domain); i >= 0 {
+		domains = slices.Delete(domains, i, i+1)
 	}
+	rc.rules.Store(rc.compileRules(domains))
+	rc.cache.Clear()
 	
-	rc.cache[referrer] = result
-	
-	if rc.debug != nil {
-		rc.debug.LogCacheOperation(&CacheDebugInfo{
-			Key:       referrer,
-			Operation: "set",
-			Hit:       false,
-			Value:     result,
-			TTL:       rc.cacheTTL,
-			Timestamp: time.Now(),
-		})
-	}
+	rc.logger.Info("removed referrer domain",
+		zap.String("domain", domain),
+		zap.Int("total_domains", len(domains)),
+	)
 }
 
-// cleanupCache очищает старые записи из кеша
-func (rc *ReferrerChecker) cleanupCache() {
-	now := time.Now()
-	
-	for key, result := range rc.cache {
-		if now.Sub(result.Timestamp) > rc.cacheTTL {
-			delete(rc.cache, key)
-		}
+// validateDomain проверяет домен или wildcard паттерн перед добавлением
+func (rc *ReferrerChecker) validateDomain(domain string) error {
+	if domain == "" {
+		return fmt.Errorf("empty referrer domain")
 	}
-	
-	// Если кеш все еще переполнен, удаляем самые старые записи
-	if len(rc.cache) >= rc.maxCache {
-		count := 0
-		target := len(rc.cache) / 2
-		
-		for key := range rc.cache {
-			if count >= target {
-				break
-			}
-			delete(rc.cache, key)
-			count++
-		}
+	if len(domain) > 253 || strings.ContainsAny(domain, " \t/:@?#") {
+		return fmt.Errorf("invalid referrer domain: %s", domain)
 	}
+	if _, err := regexp.Compile("(?i)" + rc.convertToRegex(domain)); err != nil {
+		return fmt.Errorf("invalid referrer domain %s: %w", domain, err)
+	}
+	return nil
 }
 
-// AddDomain добавляет новый разрешенный домен в runtime
-func (rc *ReferrerChecker) AddDomain(domain string) error {
-	if domain == "" {
-		return nil
+// SetDomains заменяет список доменов целиком. Кеш не очищается: вызывающий удаляет
+// затронутые записи через purgeDomains. Домены проверяются до замены: при ошибке
+// действующий список не меняется.
+func (rc *ReferrerChecker) SetDomains(domains []string) error {
+	if !rc.enabled {
+		return fmt.Errorf("referrer check is disabled")
 	}
 	
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	
-	// Добавляем в список доменов
-	rc.allowedDomains = append(rc.allowedDomains, domain)
-	
-	// Классифицируем и добавляем в соответствующую структуру
-	if rc.isExactDomain(domain) {
-		rc.exactDomains[strings.ToLower(domain)] = true
-	} else if rc.isWildcardDomain(domain) {
-		rc.wildcardDomains = append(rc.wildcardDomains, strings.ToLower(domain))
-	} else {
-		pattern := rc.convertToRegex(domain)
-		if regex, err := regexp.Compile("(?i)" + pattern); err == nil {
-			rc.compiledPatterns = append(rc.compiledPatterns, regex)
-		} else {
+	for _, domain := range domains {
+		if err := rc.validateDomain(domain); err != nil {
 			return err
 		}
 	}
 	
-	// Очищаем кеш после добавления нового домена
-	rc.cache = make(map[string]*ReferrerResult)
+	rc.mutex.Lock()
+	rc.rules.Store(rc.compileRules(domains))
+	rc.mutex.Unlock()
 	
-	rc.logger.Info("added new referrer domain",
-		zap.String("domain", domain),
-		zap.Int("total_domains", len(rc.allowedDomains)),
+	rc.logger.Info("referrer domains replaced",
+		zap.Int("total_domains", len(domains)),
 	)
 	
 	return nil
 }
 
-// RemoveDomain удаляет домен
-func (rc *ReferrerChecker) RemoveDomain(domain string) {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
+// domainMatcher возвращает функцию, проверяющую имя хоста на совпадение с доменом
+// по тем же правилам, что и performCheck
+func (rc *ReferrerChecker) domainMatcher(domain string) func(hostname string) bool {
+	domain = strings.ToLower(domain)
 	
-	// Удаляем из основного списка
-	for i, d := range rc.allowedDomains {
-		if d == domain {
-			rc.allowedDomains = append(rc.allowedDomains[:i], rc.allowedDomains[i+1:]...)
-			break
+	switch {
+	case rc.isExactDomain(domain):
+		return func(hostname string) bool {
+			return hostname == domain
+		}
+	case rc.isWildcardDomain(domain):
+		return func(hostname string) bool {
+			return rc.matchWildcard(hostname, domain)
 		}
+	default:
+		regex, err := regexp.Compile("(?i)" + rc.convertToRegex(domain))
+		if err != nil {
+			return func(string) bool { return false }
+		}
+		return regex.MatchString
+	}
+}
+
+// purgeDomains удаляет из кеша результаты для referrer, хост которых совпадает с любым из доменов
+func (rc *ReferrerChecker) purgeDomains(domains []string) int {
+	if len(domains) == 0 {
+		return 0
 	}
 	
-	// Переинициализируем все структуры
-	domains := make([]string, len(rc.allowedDomains))
-	copy(domains, rc.allowedDomains)
-	
-	rc.initializePatterns(domains)
+	matchers := make([]func(hostname string) bool, 0, len(domains))
+	for _, domain := range domains {
+		matchers = append(matchers, rc.domainMatcher(domain))
+	}
 	
-	rc.logger.Info("removed referrer domain",
-		zap.String("domain", domain),
-		zap.Int("total_domains", len(rc.allowedDomains)),
-	)
+	return rc.cache.DeleteFunc(func(referrer string, _ *ReferrerResult) bool {
+		parsedURL, err := url.Parse(referrer)
+		if err != nil {
+			return false
+		}
+		hostname := strings.ToLower(parsedURL.Hostname())
+		for _, match := range matchers {
+			if match(hostname) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// GetDomains возвращает копию списка доменов поисковых систем
+func (rc *ReferrerChecker) GetDomains() []string {
+	if !rc.enabled {
+		return []string{}
+	}
+	return slices.Clone(rc.rules.Load().domains)
+}
+
+// PurgeCache очищает кеш проверок referrer
+func (rc *ReferrerChecker) PurgeCache() int {
+	size := rc.cache.Len()
+	rc.cache.Clear()
+	return size
+}
+
+// Shutdown останавливает фоновую очистку кеша
+func (rc *ReferrerChecker) Shutdown() {
+	if !rc.enabled {
+		return
+	}
+	rc.cache.StopCleanup()
 }
 
 // GetStats возвращает статистику
@@ -657,36 +689,40 @@ func (rc *ReferrerChecker) GetStats() map[string]interface{} {
 		return map[string]interface{}{"enabled": false}
 	}
 
-	rc.mutex.RLock()
-	defer rc.mutex.RUnlock()
-	
-	hitRate := 0.0
-	if rc.totalChecks > 0 {
-		hitRate = float64(rc.cacheHits) / float64(rc.totalChecks)
-	}
+	rules := rc.rules.Load()
+	cacheStats := rc.cache.GetStats()
+	totalChecks := atomic.LoadInt64(&rc.totalChecks)
+	validReferrers := atomic.LoadInt64(&rc.validReferrers)
 	
 	validRate := 0.0
-	if rc.totalChecks > 0 {
-		validRate = float64(rc.validReferrers) / float64(rc.totalChecks)
+	if totalChecks > 0 {
+		validRate = float64(validReferrers) / float64(totalChecks)
 	}
 	
+	searchEngineHits := make(map[string]int64)
+	rc.searchEngineHits.Range(func(key, value interface{}) bool {
+		searchEngineHits[key.(string)] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+	
 	stats := map[string]interface{}{
 		"enabled":             true,
-		"total_domains":       len(rc.allowedDomains),
-		"exact_domains":       len(rc.exactDomains),
-		"wildcard_domains":    len(rc.wildcardDomains),
-		"regex_patterns":      len(rc.compiledPatterns),
-		"cache_size":          len(rc.cache),
-		"cache_max_size":      rc.maxCache,
-		"total_checks":        rc.totalChecks,
-		"valid_referrers":     rc.validReferrers,
-		"invalid_referrers":   rc.invalidReferrers,
-		"empty_referrers":     rc.emptyReferrers,
-		"cache_hits":          rc.cacheHits,
-		"malformed_urls":      rc.malformedURLs,
-		"cache_hit_rate":      hitRate,
+		"total_domains":       len(rules.domains),
+		"exact_domains":       len(rules.exactDomains),
+		"wildcard_domains":    len(rules.wildcardDomains),
+		"regex_patterns":      len(rules.compiledPatterns),
+		"cache_size":          cacheStats.Size,
+		"cache_max_size":      cacheStats.MaxSize,
+		"total_checks":        totalChecks,
+		"valid_referrers":     validReferrers,
+		"invalid_referrers":   atomic.LoadInt64(&rc.invalidReferrers),
+		"empty_referrers":     atomic.LoadInt64(&rc.emptyReferrers),
+		"cache_hits":          cacheStats.Hits,
+		"cache_evictions":     cacheStats.Evictions,
+		"malformed_urls":      atomic.LoadInt64(&rc.malformedURLs),
+		"cache_hit_rate":      cacheStats.HitRate,
 		"valid_rate":          validRate,
-		"search_engine_hits":  rc.searchEngineHits,
+		"search_engine_hits":  searchEngineHits,
 	}
 	
 	return stats
@@ -698,52 +734,35 @@ func (rc *ReferrerChecker) ClearCache() {
 		return
 	}
 	
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	
-	rc.cache = make(map[string]*ReferrerResult)
+	rc.cache.Clear()
 	rc.logger.Info("referrer checker cache cleared")
 }
 
 // Методы для статистики
 func (rc *ReferrerChecker) incrementTotalChecks() {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.totalChecks++
+	atomic.AddInt64(&rc.totalChecks, 1)
 }
 
 func (rc *ReferrerChecker) incrementValidReferrers() {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.validReferrers++
+	atomic.AddInt64(&rc.validReferrers, 1)
 }
 
 func (rc *ReferrerChecker) incrementInvalidReferrers() {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.invalidReferrers++
+	atomic.AddInt64(&rc.invalidReferrers, 1)
 }
 
 func (rc *ReferrerChecker) incrementEmptyReferrers() {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.emptyReferrers++
-}
-
-func (rc *ReferrerChecker) incrementCacheHits() {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.cacheHits++
+	atomic.AddInt64(&rc.emptyReferrers, 1)
 }
 
 func (rc *ReferrerChecker) incrementMalformedURLs() {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.malformedURLs++
+	atomic.AddInt64(&rc.malformedURLs, 1)
 }
 
 func (rc *ReferrerChecker) incrementSearchEngineHit(searchEngine string) {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
-	rc.searchEngineHits[searchEngine]++
-}
\ No newline at end of file
+	counter, ok := rc.searchEngineHits.Load(searchEngine)
+	if !ok {
+		counter, _ = rc.searchEngineHits.LoadOrStore(searchEngine, new(int64))
+	}
+	atomic.AddInt64(counter.(*int64), 1)
+}
@@ -0,0 +1,44 @@
+package botredirect
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// TestReferrerCheckerSetDomainsConcurrent заменяет домены во время проверок
+// (запускать с -race). После последней замены в кеше не должно остаться результата
+// по предыдущему набору.
+func TestReferrerCheckerSetDomainsConcurrent(t *testing.T) {
+	const referrer = "https://search.example/?q=caddy"
+	withDomain := []string{"search.example", "other.example"}
+	withoutDomain := []string{"other.example"}
+
+	config := DefaultConfig()
+	config.AllowedReferrers = withoutDomain
+	rc := NewReferrerChecker(config, nil, nil, zap.NewNop())
+
+	for round := 0; round < 20; round++ {
+		runWithReaders(8, 200, func() {
+			rc.CheckReferrer(referrer)
+			rc.CheckReferrer("https://other.example/")
+		}, func(i int) {
+			domains := withoutDomain
+			if i%2 == 0 {
+				domains = withDomain
+			}
+			if err := rc.SetDomains(domains); err != nil {
+				t.Fatal(err)
+			}
+			rc.purgeDomains([]string{"search.example"})
+		})
+
+		result, err := rc.CheckReferrer(referrer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsFromSearch {
+			t.Fatalf("round %d: stale cached verdict %+v after the domain was removed", round, result)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package botredirect
+
+import (
+	"sort"
+	"time"
+)
+
+// Классы повторной классификации записей журнала
+const (
+	ReplayVerifiedBot   = "verified_bot"
+	ReplayUnverifiedBot = "unverified_bot"
+	ReplayOverride      = "override"
+)
+
+// replayExamples количество примеров клиентов для каждого изменения классификации
+const replayExamples = 5
+
+// ReplayVerdict результат повторной классификации записи журнала доступа
+type ReplayVerdict struct {
+	// verified_bot, unverified_bot, from_search, direct или override:<действие>
+	Class  string           `json:"class"`
+	Result *DetectionResult `json:"-"`
+
+	// User-Agent выдает себя за поискового или социального краулера, которого можно
+	// подтвердить по IP диапазону или обратному DNS
+	CrawlerClaim bool `json:"crawler_claim,omitempty"`
+
+	// Краулер не подтвержден: Spoofed - проверка выполнена и не прошла,
+	// Unverifiable - для адреса нет записанного ответа DNS
+	Spoofed      bool `json:"spoofed,omitempty"`
+	Unverifiable bool `json:"unverifiable,omitempty"`
+
+	// Записанный PTR адреса клиента
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// Replayer прогоняет записи журнала доступа через детектор без сети:
+// обратный DNS отвечает по записанным ответам (RecordedResolver)
+type Replayer struct {
+	detector *BotDetector
+	resolver *RecordedResolver
+}
+
+// NewReplayer создает новый экземпляр Replayer и подключает resolver к проверке
+// обратного DNS детектора. Детектор после этого не должен обслуживать живой трафик.
+func NewReplayer(detector *BotDetector, resolver *RecordedResolver) *Replayer {
+	if checker := detector.GetReverseDNSChecker(); checker != nil {
+		checker.SetResolver(resolver)
+	}
+
+	return &Replayer{
+		detector: detector,
+		resolver: resolver,
+	}
+}
+
+// Classify классифицирует запрос записи так же, как обработчик: переопределения из
+// конфигурации, затем детекция. Баны, ловушки, лимиты и robots.txt зависят от истории
+// запросов во времени и при повторе не применяются.
+func (rp *Replayer) Classify(entry *AccessLogEntry) *ReplayVerdict {
+	r := entry.Request()
+
+	var result *DetectionResult
+	if override := rp.detector.CheckOverride(r); override != nil {
+		if override.Action != "" {
+			return &ReplayVerdict{Class: ReplayOverride + ":" + override.Action}
+		}
+		result = rp.detector.DetectOverridden(override)
+	} else {
+		result = rp.detector.DetectBot(r)
+	}
+
+	verdict := &ReplayVerdict{Class: result.UserType.String(), Result: result}
+	if !result.IsBot {
+		return verdict
+	}
+
+	if result.Verified {
+		verdict.Class = ReplayVerifiedBot
+		return verdict
+	}
+	verdict.Class = ReplayUnverifiedBot
+
+	botType, _ := result.Details["bot_type"].(BotType)
+	verdict.CrawlerClaim = botType == BotTypeSearch || botType == BotTypeSocial
+	if verdict.CrawlerClaim {
+		verdict.Unverifiable = rp.resolver.Missing(entry.ClientIP)
+		verdict.Spoofed = !verdict.Unverifiable
+		verdict.Hostname = rp.resolver.Hostname(entry.ClientIP)
+	}
+
+	return verdict
+}
+
+// ReplayCount количество запросов и клиентов для значения (User-Agent)
+type ReplayCount struct {
+	Value    string `json:"value"`
+	Requests int    `json:"requests"`
+	Clients  int    `json:"clients"`
+
+	clients map[string]bool
+}
+
+// SpoofedCrawler адрес, выдававший себя за краулера и не прошедший проверку
+type SpoofedCrawler struct {
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname,omitempty"`
+	Pattern   string `json:"pattern"`
+	UserAgent string `json:"user_agent"`
+	Requests  int    `json:"requests"`
+}
+
+// ReplayReport отчет о классификации журнала доступа
+type ReplayReport struct {
+	Entries int        `json:"entries"`
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+
+	// Количество запросов по классам и по способам детекции
+	Classifications  map[string]int `json:"classifications"`
+	DetectionMethods map[string]int `json:"detection_methods"`
+
+	// User-Agent неподтвержденных ботов по убыванию количества запросов
+	UnverifiedUserAgents []*ReplayCount `json:"unverified_user_agents"`
+
+	// Адреса, выдававшие себя за краулеров; запросы краулеров без записанного ответа DNS
+	SpoofedCrawlers       []*SpoofedCrawler `json:"spoofed_crawlers"`
+	UnverifiableCrawlers  int               `json:"unverifiable_crawler_requests"`
+	UnverifiableAddresses int               `json:"unverifiable_addresses"`
+
+	top          int
+	unverified   map[string]*ReplayCount
+	spoofed      map[string]*SpoofedCrawler
+	unverifiable map[string]bool
+}
+
+// NewReplayReport создает новый экземпляр ReplayReport.
+// top - длина списков в отчете (0 - без ограничения).
+func NewReplayReport(top int) *ReplayReport {
+	return &ReplayReport{
+		Classifications:  make(map[string]int),
+		DetectionMethods: make(map[string]int),
+		top:              top,
+		unverified:       make(map[string]*ReplayCount),
+		spoofed:          make(map[string]*SpoofedCrawler),
+		unverifiable:     make(map[string]bool),
+	}
+}
+
+// Add учитывает классифицированную запись
+func (report *ReplayReport) Add(entry *AccessLogEntry, verdict *ReplayVerdict) {
+	report.Entries++
+	report.Classifications[verdict.Class]++
+	if verdict.Result != nil {
+		report.DetectionMethods[verdict.Result.DetectionMethod]++
+	}
+
+	if !entry.Time.IsZero() {
+		if report.From == nil || entry.Time.Before(*report.From) {
+			from := entry.Time
+			report.From = &from
+		}
+		if report.To == nil || entry.Time.After(*report.To) {
+			to := entry.Time
+			report.To = &to
+		}
+	}
+
+	if verdict.Class != ReplayUnverifiedBot {
+		return
+	}
+
+	userAgent := entry.UserAgent()
+	count, ok := report.unverified[userAgent]
+	if !ok {
+		count = &ReplayCount{Value: userAgent, clients: make(map[string]bool)}
+		report.unverified[userAgent] = count
+	}
+	count.Requests++
+	count.clients[entry.ClientIP] = true
+
+	if verdict.Unverifiable {
+		report.UnverifiableCrawlers++
+		report.unverifiable[entry.ClientIP] = true
+	}
+	if !verdict.Spoofed {
+		return
+	}
+
+	crawler, ok := report.spoofed[entry.ClientIP]
+	if !ok {
+		crawler = &SpoofedCrawler{
+			IP:        entry.ClientIP,
+			Hostname:  verdict.Hostname,
+			Pattern:   verdict.Result.MatchedPattern,
+			UserAgent: userAgent,
+		}
+		report.spoofed[entry.ClientIP] = crawler
+	}
+	crawler.Requests++
+}
+
+// Finish сортирует списки отчета и ограничивает их длину
+func (report *ReplayReport) Finish() {
+	report.UnverifiedUserAgents = make([]*ReplayCount, 0, len(report.unverified))
+	for _, count := range report.unverified {
+		count.Clients = len(count.clients)
+		report.UnverifiedUserAgents = append(report.UnverifiedUserAgents, count)
+	}
+	sort.Slice(report.UnverifiedUserAgents, func(i, j int) bool {
+		a, b := report.UnverifiedUserAgents[i], report.UnverifiedUserAgents[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Value < b.Value
+	})
+
+	report.SpoofedCrawlers = make([]*SpoofedCrawler, 0, len(report.spoofed))
+	for _, crawler := range report.spoofed {
+		report.SpoofedCrawlers = append(report.SpoofedCrawlers, crawler)
+	}
+	sort.Slice(report.SpoofedCrawlers, func(i, j int) bool {
+		a, b := report.SpoofedCrawlers[i], report.SpoofedCrawlers[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.IP < b.IP
+	})
+
+	if report.top > 0 {
+		if len(report.UnverifiedUserAgents) > report.top {
+			report.UnverifiedUserAgents = report.UnverifiedUserAgents[:report.top]
+		}
+		if len(report.SpoofedCrawlers) > report.top {
+			report.SpoofedCrawlers = report.SpoofedCrawlers[:report.top]
+		}
+	}
+
+	report.UnverifiableAddresses = len(report.unverifiable)
+}
+
+// ReplayTransition изменение класса записей между двумя конфигурациями
+type ReplayTransition struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Requests int      `json:"requests"`
+	Examples []string `json:"examples"`
+
+	seen map[string]bool
+}
+
+// ReplayDiff различия классификации одного журнала двумя конфигурациями
+type ReplayDiff struct {
+	Entries     int                 `json:"entries"`
+	Changed     int                 `json:"changed"`
+	Transitions []*ReplayTransition `json:"transitions"`
+
+	transitions map[[2]string]*ReplayTransition
+}
+
+// NewReplayDiff создает новый экземпляр ReplayDiff
+func NewReplayDiff() *ReplayDiff {
+	return &ReplayDiff{
+		Transitions: make([]*ReplayTransition, 0),
+		transitions: make(map[[2]string]*ReplayTransition),
+	}
+}
+
+// Add сравнивает классификацию записи базовой (before) и новой (after) конфигурацией
+func (diff *ReplayDiff) Add(entry *AccessLogEntry, before, after *ReplayVerdict) {
+	diff.Entries++
+	if before.Class == after.Class {
+		return
+	}
+	diff.Changed++
+
+	key := [2]string{before.Class, after.Class}
+	transition, ok := diff.transitions[key]
+	if !ok {
+		transition = &ReplayTransition{
+			From:     before.Class,
+			To:       after.Class,
+			Examples: make([]string, 0, replayExamples),
+			seen:     make(map[string]bool),
+		}
+		diff.transitions[key] = transition
+		diff.Transitions = append(diff.Transitions, transition)
+	}
+	transition.Requests++
+
+	// Примеры - разные клиенты (адрес и User-Agent)
+	example := entry.ClientIP + " " + entry.UserAgent()
+	if len(transition.Examples) < replayExamples && !transition.seen[example] {
+		transition.seen[example] = true
+		transition.Examples = append(transition.Examples, example)
+	}
+}
+
+// Finish сортирует изменения по количеству запросов
+func (diff *ReplayDiff) Finish() {
+	sort.SliceStable(diff.Transitions, func(i, j int) bool {
+		return diff.Transitions[i].Requests > diff.Transitions[j].Requests
+	})
+}
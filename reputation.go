@@ -0,0 +1,260 @@
+package botredirect
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ReputationStore хранит отметки о вредоносных клиентах с ограниченным временем жизни.
+// Клиенты группируются по префиксу сети, чтобы смена адреса внутри /64 или /24 не снимала отметку.
+type ReputationStore struct {
+	// Конфигурация
+	ttl        time.Duration
+	ipv4Prefix int
+	ipv6Prefix int
+
+	// Отметки по префиксу
+	entries map[string]*ReputationEntry
+	mutex   sync.RWMutex
+	clock   Clock
+
+	// Очистка
+	stopCleanup chan bool
+	cleanupOnce sync.Once
+
+	// Компоненты
+	logger *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	totalMarks   int64
+	totalLookups int64
+	totalMatches int64
+}
+
+// ReputationEntry отметка о клиенте
+type ReputationEntry struct {
+	Key       string
+	Reason    string
+	Path      string
+	LastIP    string
+	Hits      int64
+	MarkedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NewReputationStore создает новое хранилище репутации
+func NewReputationStore(ttl time.Duration, ipv4Prefix, ipv6Prefix int, logger *zap.Logger) *ReputationStore {
+	if ipv4Prefix <= 0 || ipv4Prefix > 32 {
+		ipv4Prefix = 32
+	}
+	if ipv6Prefix <= 0 || ipv6Prefix > 128 {
+		ipv6Prefix = 64
+	}
+
+	rs := &ReputationStore{
+		ttl:         ttl,
+		ipv4Prefix:  ipv4Prefix,
+		ipv6Prefix:  ipv6Prefix,
+		entries:     make(map[string]*ReputationEntry),
+		clock:       systemClock{},
+		stopCleanup: make(chan bool, 1),
+		logger:      logger,
+	}
+
+	rs.startCleanup()
+
+	return rs
+}
+
+// prefixKey возвращает ключ сети для IP адреса
+func (rs *ReputationStore) prefixKey(ipStr string) (string, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address: %s", ipStr)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		network := ip4.Mask(net.CIDRMask(rs.ipv4Prefix, 32))
+		return fmt.Sprintf("%s/%d", network, rs.ipv4Prefix), nil
+	}
+
+	network := ip.Mask(net.CIDRMask(rs.ipv6Prefix, 128))
+	return fmt.Sprintf("%s/%d", network, rs.ipv6Prefix), nil
+}
+
+// Mark отмечает клиента как вредоносного
+func (rs *ReputationStore) Mark(ipStr, reason, path string) (*ReputationEntry, error) {
+	key, err := rs.prefixKey(ipStr)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&rs.totalMarks, 1)
+
+	rs.mutex.Lock()
+	now := rs.clock.Now()
+	entry, exists := rs.entries[key]
+	if !exists || now.After(entry.ExpiresAt) {
+		entry = &ReputationEntry{
+			Key:      key,
+			MarkedAt: now,
+		}
+		rs.entries[key] = entry
+	}
+	entry.Reason = reason
+	entry.Path = path
+	entry.LastIP = ipStr
+	entry.Hits++
+	entry.ExpiresAt = now.Add(rs.ttl)
+	snapshot := *entry
+	rs.mutex.Unlock()
+
+	rs.logger.Warn("client marked as bad bot",
+		zap.String("key", key),
+		zap.String("ip", ipStr),
+		zap.String("reason", reason),
+		zap.String("path", path),
+		zap.Int64("hits", snapshot.Hits),
+		zap.Time("expires_at", snapshot.ExpiresAt),
+	)
+
+	return &snapshot, nil
+}
+
+// Lookup возвращает действующую отметку для IP или nil
+func (rs *ReputationStore) Lookup(ipStr string) *ReputationEntry {
+	atomic.AddInt64(&rs.totalLookups, 1)
+
+	key, err := rs.prefixKey(ipStr)
+	if err != nil {
+		return nil
+	}
+
+	rs.mutex.RLock()
+	entry, exists := rs.entries[key]
+	var snapshot ReputationEntry
+	if exists {
+		snapshot = *entry
+	}
+	now := rs.clock.Now()
+	rs.mutex.RUnlock()
+
+	if !exists || now.After(snapshot.ExpiresAt) {
+		return nil
+	}
+
+	atomic.AddInt64(&rs.totalMatches, 1)
+	return &snapshot
+}
+
+// Remove снимает отметку по ключу сети
+func (rs *ReputationStore) Remove(key string) bool {
+	rs.mutex.Lock()
+	_, exists := rs.entries[key]
+	delete(rs.entries, key)
+	rs.mutex.Unlock()
+
+	if exists {
+		rs.logger.Info("reputation mark removed", zap.String("key", key))
+	}
+
+	return exists
+}
+
+// List возвращает действующие отметки, отсортированные по времени истечения
+func (rs *ReputationStore) List() []ReputationEntry {
+	rs.mutex.RLock()
+	now := rs.clock.Now()
+	entries := make([]ReputationEntry, 0, len(rs.entries))
+	for _, entry := range rs.entries {
+		if now.Before(entry.ExpiresAt) {
+			entries = append(entries, *entry)
+		}
+	}
+	rs.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ExpiresAt.Before(entries[j].ExpiresAt)
+	})
+
+	return entries
+}
+
+// SetClock подменяет источник времени
+func (rs *ReputationStore) SetClock(clock Clock) {
+	rs.mutex.Lock()
+	rs.clock = clock
+	rs.mutex.Unlock()
+}
+
+// cleanup удаляет истекшие отметки
+func (rs *ReputationStore) cleanup() {
+	rs.mutex.Lock()
+	now := rs.clock.Now()
+	for key, entry := range rs.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(rs.entries, key)
+		}
+	}
+	size := len(rs.entries)
+	rs.mutex.Unlock()
+
+	rs.logger.Debug("reputation store cleanup completed",
+		zap.Int("active_entries", size),
+	)
+}
+
+// startCleanup запускает фоновую очистку
+func (rs *ReputationStore) startCleanup() {
+	rs.cleanupOnce.Do(func() {
+		interval := rs.ttl / 4
+		if interval < time.Minute {
+			interval = time.Minute
+		}
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					rs.cleanup()
+				case <-rs.stopCleanup:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Shutdown останавливает фоновую очистку
+func (rs *ReputationStore) Shutdown() {
+	select {
+	case rs.stopCleanup <- true:
+	default:
+	}
+}
+
+// GetStats возвращает статистику
+func (rs *ReputationStore) GetStats() map[string]interface{} {
+	rs.mutex.RLock()
+	size := len(rs.entries)
+	rs.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"entries":       size,
+		"ttl_seconds":   rs.ttl.Seconds(),
+		"ipv4_prefix":   rs.ipv4Prefix,
+		"ipv6_prefix":   rs.ipv6Prefix,
+		"total_marks":   atomic.LoadInt64(&rs.totalMarks),
+		"total_lookups": atomic.LoadInt64(&rs.totalLookups),
+		"total_matches": atomic.LoadInt64(&rs.totalMatches),
+	}
+}
@@ -13,6 +13,16 @@ import (
 	"go.uber.org/zap"
 )
 
+// dnsErrorCacheTTL максимальное время кеширования неудачной проверки
+const dnsErrorCacheTTL = 1 * time.Minute
+
+// DNSResolver выполняет PTR и A/AAAA запросы. Реализуется net.Resolver; команда replay
+// подставляет ответы, записанные в файл, чтобы проверка не зависела от сети.
+type DNSResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
 // ReverseDNSChecker отвечает за асинхронную проверку обратного DNS
 type ReverseDNSChecker struct {
 	// Конфигурация
@@ -22,7 +32,7 @@ type ReverseDNSChecker struct {
 	queueSize  int
 
 	// DNS resolver
-	resolver *net.Resolver
+	resolver DNSResolver
 
 	// Worker pool для асинхронных запросов
 	jobQueue    chan *DNSJob
@@ -30,9 +40,7 @@ type ReverseDNSChecker struct {
 	workers     []*DNSWorker
 
 	// Кеш результатов
-	cache    map[string]*DNSCheckResult
-	cacheTTL time.Duration
-	maxCache int
+	cache *Cache[string, *DNSCheckResult]
 
 	// Паттерны для проверки доменов ботов
 	botDomainPatterns map[BotType][]*regexp.Regexp
@@ -53,7 +61,6 @@ type ReverseDNSChecker struct {
 	successfulLookups int64
 	failedLookups     int64
 	timeouts          int64
-	cacheHits         int64
 	validBots         int64
 	invalidBots       int64
 }
@@ -117,14 +124,17 @@ func NewReverseDNSChecker(config *Config, metrics *Metrics, debug *DebugConfig,
 		resolver:    &net.Resolver{},
 		jobQueue:    make(chan *DNSJob, config.DNSQueueSize),
 		resultQueue: make(chan *DNSResult, config.DNSQueueSize),
-		cache:       make(map[string]*DNSCheckResult),
-		cacheTTL:    config.CacheTTL,
-		maxCache:    2000, // Кеш для 2000 DNS результатов
-		ctx:         ctx,
-		cancel:      cancel,
-		metrics:     metrics,
-		debug:       debug,
-		logger:      logger,
+		cache: NewCache[string, *DNSCheckResult](CacheOptions{
+			Name:            "reverse_dns",
+			MaxSize:         config.ReverseDNSCacheSize,
+			TTL:             config.CacheTTL,
+			CleanupInterval: config.CleanupInterval,
+		}, hashString, metrics, debug, logger),
+		ctx:     ctx,
+		cancel:  cancel,
+		metrics: metrics,
+		debug:   debug,
+		logger:  logger,
 	}
 
 	// Инициализация паттернов доменов ботов
@@ -136,9 +146,6 @@ func NewReverseDNSChecker(config *Config, metrics *Metrics, debug *DebugConfig,
 	// Запуск обработчика результатов
 	go rdns.processResults()
 
-	// Запуск периодической очистки кеша
-	go rdns.startCacheCleanup()
-
 	logger.Info("reverse DNS checker initialized",
 		zap.Bool("enabled", true),
 		zap.Duration("timeout", rdns.timeout),
@@ -271,8 +278,7 @@ func (rdns *ReverseDNSChecker) CheckDNS(ip string) (*DNSCheckResult, error) {
 	}
 
 	// Проверка кеша
-	if result := rdns.getCachedResult(cleanIP); result != nil {
-		atomic.AddInt64(&rdns.cacheHits, 1)
+	if result, ok := rdns.cache.Get(cleanIP); ok {
 		if rdns.metrics != nil {
 			rdns.metrics.IncrementCacheHits()
 		}
@@ -308,7 +314,10 @@ func (rdns *ReverseDNSChecker) CheckDNS(ip string) (*DNSCheckResult, error) {
 			Error:     "DNS queue full",
 			Timestamp: time.Now(),
 		}
-		rdns.setCachedResult(cleanIP, result)
+		if rdns.metrics != nil {
+			rdns.metrics.RecordDNSLookup("queue_full", 0)
+		}
+		rdns.cache.SetWithTTL(cleanIP, result, rdns.errorCacheTTL())
 		return result, nil
 	}
 
@@ -316,7 +325,14 @@ func (rdns *ReverseDNSChecker) CheckDNS(ip string) (*DNSCheckResult, error) {
 	select {
 	case dnsResult := <-job.ResultChan:
 		result := rdns.processDNSResult(dnsResult)
-		rdns.setCachedResult(cleanIP, result)
+		if rdns.metrics != nil {
+			rdns.metrics.RecordDNSLookup(dnsLookupOutcome(result), result.Duration)
+		}
+		if result.Error != "" {
+			rdns.cache.SetWithTTL(cleanIP, result, rdns.errorCacheTTL())
+		} else {
+			rdns.cache.Set(cleanIP, result)
+		}
 
 		if rdns.debug != nil {
 			rdns.debug.LogReverseDNSCheck(cleanIP, result.Hostname, result.IsBot, result.VerifiedIP)
@@ -328,6 +344,7 @@ func (rdns *ReverseDNSChecker) CheckDNS(ip string) (*DNSCheckResult, error) {
 		atomic.AddInt64(&rdns.timeouts, 1)
 		if rdns.metrics != nil {
 			rdns.metrics.IncrementDNSTimeouts()
+			rdns.metrics.RecordDNSLookup("timeout", rdns.timeout)
 		}
 
 		result := &DNSCheckResult{
@@ -336,11 +353,23 @@ func (rdns *ReverseDNSChecker) CheckDNS(ip string) (*DNSCheckResult, error) {
 			Duration:  rdns.timeout,
 			Timestamp: time.Now(),
 		}
-		rdns.setCachedResult(cleanIP, result)
+		rdns.cache.SetWithTTL(cleanIP, result, rdns.errorCacheTTL())
 		return result, nil
 	}
 }
 
+// dnsLookupOutcome возвращает исход DNS проверки для метрик
+func dnsLookupOutcome(result *DNSCheckResult) string {
+	switch {
+	case result.Error != "":
+		return "error"
+	case result.IsBot:
+		return "verified"
+	default:
+		return "not_verified"
+	}
+}
+
 // processDNSResult обрабатывает результат DNS запроса
 func (rdns *ReverseDNSChecker) processDNSResult(dnsResult *DNSResult) *DNSCheckResult {
 	if dnsResult.Error != nil {
@@ -507,7 +536,7 @@ func (rdns *ReverseDNSChecker) lookupHostname(ip string) (string, error) {
 	ctx, cancel := context.WithTimeout(rdns.ctx, rdns.timeout)
 	defer cancel()
 
-	hostnames, err := rdns.resolver.LookupAddr(ctx, ip)
+	hostnames, err := rdns.currentResolver().LookupAddr(ctx, ip)
 	if err != nil {
 		return "", fmt.Errorf("PTR lookup failed: %w", err)
 	}
@@ -526,7 +555,7 @@ func (rdns *ReverseDNSChecker) lookupIP(hostname string) (string, error) {
 	ctx, cancel := context.WithTimeout(rdns.ctx, rdns.timeout)
 	defer cancel()
 
-	ips, err := rdns.resolver.LookupIPAddr(ctx, hostname)
+	ips, err := rdns.currentResolver().LookupIPAddr(ctx, hostname)
 	if err != nil {
 		return "", fmt.Errorf("A/AAAA lookup failed: %w", err)
 	}
@@ -562,6 +591,9 @@ func (rdns *ReverseDNSChecker) verifyIPMatch(originalIP, verifiedIP string) bool
 func (rdns *ReverseDNSChecker) determineBotTypeByHostname(hostname string) BotType {
 	hostname = strings.ToLower(hostname)
 
+	rdns.mutex.RLock()
+	defer rdns.mutex.RUnlock()
+
 	for botType, patterns := range rdns.botDomainPatterns {
 		for _, pattern := range patterns {
 			if pattern.MatchString(hostname) {
@@ -608,74 +640,47 @@ func (rdns *ReverseDNSChecker) extractIP(address string) string {
 	return host
 }
 
-// Методы для работы с кешем
-func (rdns *ReverseDNSChecker) getCachedResult(ip string) *DNSCheckResult {
-	rdns.mutex.RLock()
-	defer rdns.mutex.RUnlock()
-
-	if result, exists := rdns.cache[ip]; exists {
-		// Проверка TTL
-		if time.Since(result.Timestamp) < rdns.cacheTTL {
-			return result
-		}
-		// Удаление устаревшей записи
-		delete(rdns.cache, ip)
+// errorCacheTTL возвращает время жизни неудачного результата (таймаут, ошибка, переполненная очередь):
+// такие результаты кешируются коротко, чтобы временный сбой DNS не закреплялся на весь cache_ttl
+func (rdns *ReverseDNSChecker) errorCacheTTL() time.Duration {
+	ttl := rdns.cache.ttl
+	if ttl <= 0 || ttl > dnsErrorCacheTTL {
+		return dnsErrorCacheTTL
 	}
-
-	return nil
+	return ttl
 }
 
-func (rdns *ReverseDNSChecker) setCachedResult(ip string, result *DNSCheckResult) {
-	rdns.mutex.Lock()
-	defer rdns.mutex.Unlock()
+// GetBotDomainPatterns возвращает паттерны доменов ботов по типам
+func (rdns *ReverseDNSChecker) GetBotDomainPatterns() map[string][]string {
+	rdns.mutex.RLock()
+	defer rdns.mutex.RUnlock()
 
-	// Проверка размера кеша
-	if len(rdns.cache) >= rdns.maxCache {
-		rdns.cleanupCacheUnsafe()
+	patterns := make(map[string][]string, len(rdns.botDomainPatterns))
+	for botType, regexps := range rdns.botDomainPatterns {
+		list := make([]string, 0, len(regexps))
+		for _, regex := range regexps {
+			list = append(list, regex.String())
+		}
+		patterns[string(botType)] = list
 	}
-
-	rdns.cache[ip] = result
+	return patterns
 }
 
-func (rdns *ReverseDNSChecker) cleanupCacheUnsafe() {
-	now := time.Now()
-
-	for key, result := range rdns.cache {
-		if now.Sub(result.Timestamp) > rdns.cacheTTL {
-			delete(rdns.cache, key)
-		}
+// PurgeCache удаляет из кеша результаты для IP адреса (пустая строка - весь кеш)
+func (rdns *ReverseDNSChecker) PurgeCache(ip string) int {
+	if rdns.cache == nil {
+		return 0
 	}
-
-	// Если кеш все еще переполнен, удаляем самые старые записи
-	if len(rdns.cache) >= rdns.maxCache {
-		count := 0
-		target := len(rdns.cache) / 2
-
-		for key := range rdns.cache {
-			if count >= target {
-				break
-			}
-			delete(rdns.cache, key)
-			count++
-		}
+	if ip == "" {
+		size := rdns.cache.Len()
+		rdns.cache.Clear()
+		return size
 	}
-}
-
-// startCacheCleanup запускает периодическую очистку кеша
-func (rdns *ReverseDNSChecker) startCacheCleanup() {
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
 
-	for {
-		select {
-		case <-rdns.ctx.Done():
-			return
-		case <-ticker.C:
-			rdns.mutex.Lock()
-			rdns.cleanupCacheUnsafe()
-			rdns.mutex.Unlock()
-		}
-	}
+	target := canonicalHost(ip)
+	return rdns.cache.DeleteFunc(func(key string, _ *DNSCheckResult) bool {
+		return canonicalHost(key) == target
+	})
 }
 
 // GetStats возвращает статистику
@@ -684,14 +689,15 @@ func (rdns *ReverseDNSChecker) GetStats() map[string]interface{} {
 		return map[string]interface{}{"enabled": false}
 	}
 
-	rdns.mutex.RLock()
-	cacheSize := len(rdns.cache)
 	queueSize := len(rdns.jobQueue)
+	cacheStats := rdns.cache.GetStats()
+
+	rdns.mutex.RLock()
+	botPatterns := len(rdns.botDomainPatterns)
 	rdns.mutex.RUnlock()
 
 	totalRequests := atomic.LoadInt64(&rdns.totalRequests)
 	successfulLookups := atomic.LoadInt64(&rdns.successfulLookups)
-	cacheHits := atomic.LoadInt64(&rdns.cacheHits)
 	validBots := atomic.LoadInt64(&rdns.validBots)
 
 	successRate := 0.0
@@ -699,11 +705,6 @@ func (rdns *ReverseDNSChecker) GetStats() map[string]interface{} {
 		successRate = float64(successfulLookups) / float64(totalRequests)
 	}
 
-	cacheHitRate := 0.0
-	if totalRequests > 0 {
-		cacheHitRate = float64(cacheHits) / float64(totalRequests)
-	}
-
 	validBotRate := 0.0
 	if successfulLookups > 0 {
 		validBotRate = float64(validBots) / float64(successfulLookups)
@@ -715,17 +716,18 @@ func (rdns *ReverseDNSChecker) GetStats() map[string]interface{} {
 		"successful_lookups": successfulLookups,
 		"failed_lookups":     atomic.LoadInt64(&rdns.failedLookups),
 		"timeouts":           atomic.LoadInt64(&rdns.timeouts),
-		"cache_hits":         cacheHits,
+		"cache_hits":         cacheStats.Hits,
+		"cache_evictions":    cacheStats.Evictions,
 		"valid_bots":         validBots,
 		"invalid_bots":       atomic.LoadInt64(&rdns.invalidBots),
 		"success_rate":       successRate,
-		"cache_hit_rate":     cacheHitRate,
+		"cache_hit_rate":     cacheStats.HitRate,
 		"valid_bot_rate":     validBotRate,
-		"cache_size":         cacheSize,
-		"cache_max_size":     rdns.maxCache,
+		"cache_size":         cacheStats.Size,
+		"cache_max_size":     cacheStats.MaxSize,
 		"worker_count":       len(rdns.workers),
 		"queue_size":         queueSize,
-		"bot_patterns":       len(rdns.botDomainPatterns),
+		"bot_patterns":       botPatterns,
 	}
 }
 
@@ -739,6 +741,7 @@ func (rdns *ReverseDNSChecker) Shutdown() {
 
 	// Останавливаем контекст
 	rdns.cancel()
+	rdns.cache.StopCleanup()
 
 	// ИСПРАВЛЕНИЕ: Безопасная остановка worker'ов
 	for _, worker := range rdns.workers {
@@ -773,9 +776,7 @@ func (rdns *ReverseDNSChecker) ClearCache() {
 		return
 	}
 
-	rdns.mutex.Lock()
-	rdns.cache = make(map[string]*DNSCheckResult)
-	rdns.mutex.Unlock()
+	rdns.cache.Clear()
 
 	rdns.logger.Info("reverse DNS checker cache cleared")
 }
@@ -810,11 +811,77 @@ func (rdns *ReverseDNSChecker) AddBotDomainPattern(botType BotType, pattern stri
 	return nil
 }
 
+// validateBotDomainPattern проверяет паттерн домена бота перед добавлением
+func validateBotDomainPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid regex pattern %s: %w", pattern, err)
+	}
+	return nil
+}
+
+// SetBotDomainPatterns заменяет паттерны доменов ботов целиком и очищает кеш.
+// Паттерны проверяются до замены: при ошибке действующие паттерны не меняются.
+func (rdns *ReverseDNSChecker) SetBotDomainPatterns(patterns map[BotType][]string) error {
+	if !rdns.enabled {
+		return fmt.Errorf("reverse DNS is disabled")
+	}
+
+	compiled := make(map[BotType][]*regexp.Regexp, len(patterns))
+	total := 0
+	for botType, list := range patterns {
+		regexps := make([]*regexp.Regexp, 0, len(list))
+		for _, pattern := range list {
+			if err := validateBotDomainPattern(pattern); err != nil {
+				return err
+			}
+			regexps = append(regexps, regexp.MustCompile(pattern))
+		}
+		compiled[botType] = regexps
+		total += len(regexps)
+	}
+
+	rdns.mutex.Lock()
+	rdns.botDomainPatterns = compiled
+	rdns.mutex.Unlock()
+
+	rdns.cache.Clear()
+
+	rdns.logger.Info("bot domain patterns replaced",
+		zap.Int("total_patterns", total),
+	)
+
+	return nil
+}
+
 // IsEnabled возвращает статус включенности reverse DNS checker
 func (rdns *ReverseDNSChecker) IsEnabled() bool {
 	return rdns.enabled
 }
 
+// SetResolver заменяет DNS resolver. Закешированные результаты проверок сбрасываются,
+// чтобы все последующие ответы были получены через новый resolver.
+func (rdns *ReverseDNSChecker) SetResolver(resolver DNSResolver) {
+	if !rdns.enabled {
+		return
+	}
+
+	rdns.mutex.Lock()
+	rdns.resolver = resolver
+	rdns.mutex.Unlock()
+
+	rdns.ClearCache()
+}
+
+// currentResolver возвращает действующий DNS resolver
+func (rdns *ReverseDNSChecker) currentResolver() DNSResolver {
+	rdns.mutex.RLock()
+	defer rdns.mutex.RUnlock()
+	return rdns.resolver
+}
+
 // UpdateTimeout обновляет таймаут DNS запросов
 func (rdns *ReverseDNSChecker) UpdateTimeout(timeout time.Duration) {
 	if !rdns.enabled {
@@ -0,0 +1,427 @@
+package botredirect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RobotsChecker проверяет запросы краулеров на соответствие robots.txt сайта
+type RobotsChecker struct {
+	// Конфигурация
+	enabled    bool
+	action     PolicyAction
+	sourceFile string
+
+	// Разобранные группы robots.txt
+	groups []*RobotsGroup
+
+	// Нарушения по краулерам
+	violations map[string]*RobotsViolationStats
+
+	// Синхронизация
+	mutex sync.RWMutex
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	totalChecks     int64
+	totalViolations int64
+}
+
+// RobotsGroup группа правил robots.txt для набора User-Agent
+type RobotsGroup struct {
+	UserAgents []string
+	Rules      []RobotsRule
+	CrawlDelay time.Duration
+}
+
+// RobotsRule одно правило Allow/Disallow
+type RobotsRule struct {
+	Pattern string
+	Allow   bool
+}
+
+// RobotsResult содержит результат проверки запроса по robots.txt
+type RobotsResult struct {
+	Allowed      bool
+	Crawler      string
+	MatchedGroup string
+	MatchedRule  string
+	CrawlDelay   time.Duration
+	Action       PolicyAction
+	Timestamp    time.Time
+}
+
+// RobotsViolationStats статистика нарушений robots.txt одним краулером
+type RobotsViolationStats struct {
+	Crawler   string
+	Count     int64
+	LastPath  string
+	LastIP    string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// NewRobotsChecker создает новый экземпляр RobotsChecker.
+// Если файл robots.txt не загружается, возвращается ошибка: без групп проверка пропускала бы все запросы.
+func NewRobotsChecker(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) (*RobotsChecker, error) {
+	if config.RobotsFile == "" {
+		return &RobotsChecker{enabled: false}, nil
+	}
+
+	action := PolicyAction(config.RobotsAction)
+	if action == "" {
+		action = PolicyActionLog
+	}
+
+	rc := &RobotsChecker{
+		enabled:    true,
+		action:     action,
+		sourceFile: config.RobotsFile,
+		violations: make(map[string]*RobotsViolationStats),
+		metrics:    metrics,
+		debug:      debug,
+		logger:     logger,
+	}
+
+	if err := rc.Load(config.RobotsFile); err != nil {
+		return nil, fmt.Errorf("robots_file: %w", err)
+	}
+
+	logger.Info("robots checker initialized",
+		zap.String("file", config.RobotsFile),
+		zap.String("action", action.String()),
+		zap.Int("groups", len(rc.groups)),
+	)
+
+	return rc, nil
+}
+
+// Load загружает и разбирает файл robots.txt
+func (rc *RobotsChecker) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	groups, err := ParseRobots(file)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	rc.mutex.Lock()
+	rc.groups = groups
+	rc.mutex.Unlock()
+
+	return nil
+}
+
+// ParseRobots разбирает robots.txt на группы правил
+func ParseRobots(r io.Reader) ([]*RobotsGroup, error) {
+	groups := make([]*RobotsGroup, 0)
+	var current *RobotsGroup
+	lastWasAgent := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		sep := strings.Index(line, ":")
+		if sep == -1 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:sep]))
+		value := strings.TrimSpace(line[sep+1:])
+
+		switch key {
+		case "user-agent":
+			// Подряд идущие User-agent относятся к одной группе
+			if current == nil || !lastWasAgent {
+				current = &RobotsGroup{}
+				groups = append(groups, current)
+			}
+			current.UserAgents = append(current.UserAgents, strings.ToLower(value))
+			lastWasAgent = true
+
+		case "allow", "disallow":
+			lastWasAgent = false
+			if current == nil {
+				continue // правило вне группы игнорируется
+			}
+			if value == "" {
+				// Пустой Disallow разрешает всё и не влияет на сопоставление
+				continue
+			}
+			current.Rules = append(current.Rules, RobotsRule{
+				Pattern: value,
+				Allow:   key == "allow",
+			})
+
+		case "crawl-delay":
+			lastWasAgent = false
+			if current == nil {
+				continue
+			}
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			current.CrawlDelay = time.Duration(seconds * float64(time.Second))
+
+		default:
+			// Sitemap и прочие директивы не относятся к группам
+			lastWasAgent = false
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// Evaluate проверяет путь для данного User-Agent краулера
+func (rc *RobotsChecker) Evaluate(userAgent, path string) *RobotsResult {
+	if !rc.enabled {
+		return &RobotsResult{Allowed: true, Timestamp: time.Now()}
+	}
+
+	atomic.AddInt64(&rc.totalChecks, 1)
+
+	result := &RobotsResult{
+		Allowed:   true,
+		Action:    rc.action,
+		Timestamp: time.Now(),
+	}
+
+	// Сам robots.txt всегда доступен
+	if path == "/robots.txt" {
+		return result
+	}
+
+	rc.mutex.RLock()
+	token, rules, crawlDelay := rc.selectGroupUnsafe(strings.ToLower(userAgent))
+	rc.mutex.RUnlock()
+
+	result.MatchedGroup = token
+	result.CrawlDelay = crawlDelay
+
+	// Самое длинное совпадение побеждает, при равенстве - Allow
+	bestLen := -1
+	for _, rule := range rules {
+		if !robotsPatternMatch(rule.Pattern, path) {
+			continue
+		}
+		if len(rule.Pattern) > bestLen || (len(rule.Pattern) == bestLen && rule.Allow) {
+			bestLen = len(rule.Pattern)
+			result.Allowed = rule.Allow
+			result.MatchedRule = rule.Pattern
+		}
+	}
+
+	if rc.debug != nil {
+		rc.debug.LogRobotsCheck(userAgent, path, result.Allowed, result.MatchedGroup, result.MatchedRule)
+	}
+
+	return result
+}
+
+// CrawlDelay возвращает Crawl-delay группы, подходящей для User-Agent
+func (rc *RobotsChecker) CrawlDelay(userAgent string) time.Duration {
+	if !rc.enabled {
+		return 0
+	}
+
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+
+	_, _, crawlDelay := rc.selectGroupUnsafe(strings.ToLower(userAgent))
+	return crawlDelay
+}
+
+// selectGroupUnsafe выбирает группу с самым специфичным токеном User-Agent (вызывать под мьютексом).
+// Группы с одинаковым токеном объединяются, "*" используется как fallback.
+func (rc *RobotsChecker) selectGroupUnsafe(userAgentLower string) (string, []RobotsRule, time.Duration) {
+	bestToken := ""
+	for _, group := range rc.groups {
+		for _, agent := range group.UserAgents {
+			if agent == "*" || agent == "" {
+				continue
+			}
+			if strings.Contains(userAgentLower, agent) && len(agent) > len(bestToken) {
+				bestToken = agent
+			}
+		}
+	}
+
+	if bestToken == "" {
+		bestToken = "*"
+	}
+
+	var rules []RobotsRule
+	var crawlDelay time.Duration
+	matched := false
+	for _, group := range rc.groups {
+		for _, agent := range group.UserAgents {
+			if agent != bestToken {
+				continue
+			}
+			matched = true
+			rules = append(rules, group.Rules...)
+			if group.CrawlDelay > crawlDelay {
+				crawlDelay = group.CrawlDelay
+			}
+			break
+		}
+	}
+
+	if !matched {
+		return "", nil, 0
+	}
+
+	return bestToken, rules, crawlDelay
+}
+
+// robotsPatternMatch сопоставляет путь с паттерном robots.txt (поддерживает * и $)
+func robotsPatternMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	parts := strings.Split(pattern, "*")
+
+	// Первая часть должна быть префиксом пути
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+
+	// Средние части ищем жадно слева направо
+	for i := 1; i < len(parts)-1; i++ {
+		idx := strings.Index(rest, parts[i])
+		if idx == -1 {
+			return false
+		}
+		rest = rest[idx+len(parts[i]):]
+	}
+
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// RecordViolation регистрирует нарушение robots.txt краулером
+func (rc *RobotsChecker) RecordViolation(crawler, path, ip string) {
+	if !rc.enabled {
+		return
+	}
+
+	atomic.AddInt64(&rc.totalViolations, 1)
+	if rc.metrics != nil {
+		rc.metrics.IncrementRobotsViolations()
+	}
+
+	now := time.Now()
+
+	rc.mutex.Lock()
+	stats, exists := rc.violations[crawler]
+	if !exists {
+		stats = &RobotsViolationStats{
+			Crawler:   crawler,
+			FirstSeen: now,
+		}
+		rc.violations[crawler] = stats
+	}
+	stats.Count++
+	stats.LastPath = path
+	stats.LastIP = ip
+	stats.LastSeen = now
+	count := stats.Count
+	rc.mutex.Unlock()
+
+	rc.logger.Warn("robots.txt violation",
+		zap.String("crawler", crawler),
+		zap.String("path", path),
+		zap.String("ip", ip),
+		zap.Int64("violations", count),
+		zap.String("action", rc.action.String()),
+	)
+}
+
+// GetViolations возвращает копию статистики нарушений, отсортированную по количеству
+func (rc *RobotsChecker) GetViolations() []RobotsViolationStats {
+	rc.mutex.RLock()
+	violations := make([]RobotsViolationStats, 0, len(rc.violations))
+	for _, stats := range rc.violations {
+		violations = append(violations, *stats)
+	}
+	rc.mutex.RUnlock()
+
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Count > violations[j].Count
+	})
+
+	return violations
+}
+
+// IsEnabled возвращает статус включенности robots checker
+func (rc *RobotsChecker) IsEnabled() bool {
+	return rc.enabled
+}
+
+// GetAction возвращает действие для нарушителей
+func (rc *RobotsChecker) GetAction() PolicyAction {
+	return rc.action
+}
+
+// GetStats возвращает статистику
+func (rc *RobotsChecker) GetStats() map[string]interface{} {
+	if !rc.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	rc.mutex.RLock()
+	groups := len(rc.groups)
+	crawlers := len(rc.violations)
+	rc.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"enabled":             true,
+		"file":                rc.sourceFile,
+		"action":              rc.action.String(),
+		"groups":              groups,
+		"total_checks":        atomic.LoadInt64(&rc.totalChecks),
+		"total_violations":    atomic.LoadInt64(&rc.totalViolations),
+		"violating_crawlers":  crawlers,
+		"violations_by_agent": rc.GetViolations(),
+	}
+}
@@ -0,0 +1,213 @@
+package botredirect
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestRobotsChecker создает проверку по тексту robots.txt
+func newTestRobotsChecker(t *testing.T, robots string) *RobotsChecker {
+	t.Helper()
+
+	groups, err := ParseRobots(strings.NewReader(robots))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RobotsChecker{
+		enabled:    true,
+		action:     PolicyActionBlock,
+		groups:     groups,
+		violations: make(map[string]*RobotsViolationStats),
+		logger:     zap.NewNop(),
+	}
+}
+
+// TestParseRobots проверяет разбиение robots.txt на группы
+func TestParseRobots(t *testing.T) {
+	robots := `# комментарий
+Disallow: /outside
+
+User-agent: Googlebot
+User-Agent: Bingbot # две строки подряд - одна группа
+Disallow: /private
+Allow: /private/public
+Disallow:
+Crawl-delay: 1.5
+
+Sitemap: https://example.com/sitemap.xml
+User-agent: *
+Disallow: /tmp
+Crawl-delay: abc
+not a directive
+`
+
+	groups, err := ParseRobots(strings.NewReader(robots))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*RobotsGroup{
+		{
+			UserAgents: []string{"googlebot", "bingbot"},
+			Rules: []RobotsRule{
+				{Pattern: "/private"},
+				{Pattern: "/private/public", Allow: true},
+			},
+			CrawlDelay: 1500 * time.Millisecond,
+		},
+		{
+			UserAgents: []string{"*"},
+			Rules:      []RobotsRule{{Pattern: "/tmp"}},
+		},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		for _, group := range groups {
+			t.Logf("%+v", *group)
+		}
+		t.Error("unexpected groups")
+	}
+}
+
+// TestRobotsPatternMatch проверяет сопоставление путей с паттернами robots.txt
+func TestRobotsPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/private", "/private", true},
+		{"/private", "/private/page", true},
+		{"/private", "/privately", true},
+		{"/private", "/public", false},
+		{"/private", "/Private", false},
+
+		{"/*.pdf", "/docs/file.pdf", true},
+		{"/*.pdf", "/docs/file.pdf?download=1", true},
+		{"/*.pdf", "/docs/file.html", false},
+		{"/*/admin/*", "/site/admin/users", true},
+		{"/*/admin/*", "/admin/users", false},
+		{"*", "/anything", true},
+
+		{"/*.pdf$", "/docs/file.pdf", true},
+		{"/*.pdf$", "/docs/file.pdf?download=1", false},
+		{"/page$", "/page", true},
+		{"/page$", "/page/", false},
+		{"/$", "/", true},
+		{"/$", "/index.html", false},
+		{"/a*b*c$", "/axbxc", true},
+		{"/a*b*c$", "/axbxcx", false},
+	}
+
+	for _, tt := range tests {
+		if got := robotsPatternMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsPatternMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestRobotsEvaluate проверяет выбор группы и правила для запроса
+func TestRobotsEvaluate(t *testing.T) {
+	rc := newTestRobotsChecker(t, `
+User-agent: *
+Disallow: /
+
+User-agent: googlebot
+Disallow: /private
+Allow: /private/public
+Allow: /same
+Disallow: /same
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: googlebot-image
+Disallow: /images
+
+User-agent: Googlebot
+Disallow: /merged
+`)
+
+	const (
+		googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+		images    = "Googlebot-Image/1.0"
+		other     = "Mozilla/5.0 (compatible; ExampleBot/1.0)"
+	)
+
+	tests := []struct {
+		userAgent, path string
+		wantAllowed     bool
+		wantGroup       string
+		wantRule        string
+	}{
+		// Самое длинное совпадение, при равенстве длины - Allow
+		{googlebot, "/private/page", false, "googlebot", "/private"},
+		{googlebot, "/private/public/page", true, "googlebot", "/private/public"},
+		{googlebot, "/same", true, "googlebot", "/same"},
+		{googlebot, "/docs/file.pdf", false, "googlebot", "/*.pdf$"},
+		{googlebot, "/docs/file.pdf?x=1", true, "googlebot", ""},
+
+		// Группы с одним токеном объединяются
+		{googlebot, "/merged/page", false, "googlebot", "/merged"},
+
+		// Более специфичный токен выбирает свою группу без правил общей
+		{images, "/images/a.png", false, "googlebot-image", "/images"},
+		{images, "/private/page", true, "googlebot-image", ""},
+
+		// Остальные получают группу "*"
+		{other, "/page", false, "*", "/"},
+		{other, "/robots.txt", true, "", ""},
+	}
+
+	for _, tt := range tests {
+		result := rc.Evaluate(tt.userAgent, tt.path)
+		if result.Allowed != tt.wantAllowed || result.MatchedGroup != tt.wantGroup || result.MatchedRule != tt.wantRule {
+			t.Errorf("%s %s: allowed = %v, group = %q, rule = %q; want %v, %q, %q",
+				tt.userAgent, tt.path, result.Allowed, result.MatchedGroup, result.MatchedRule,
+				tt.wantAllowed, tt.wantGroup, tt.wantRule)
+		}
+	}
+
+	if delay := rc.CrawlDelay(googlebot); delay != 2*time.Second {
+		t.Errorf("googlebot crawl delay = %v, want 2s", delay)
+	}
+	if delay := rc.CrawlDelay(other); delay != 0 {
+		t.Errorf("default crawl delay = %v, want 0", delay)
+	}
+}
+
+// TestRobotsEvaluateWithoutMatchingGroup проверяет, что без подходящей группы и группы "*"
+// запросы разрешены
+func TestRobotsEvaluateWithoutMatchingGroup(t *testing.T) {
+	rc := newTestRobotsChecker(t, "User-agent: Googlebot\nDisallow: /\n")
+
+	result := rc.Evaluate("Mozilla/5.0 (compatible; ExampleBot/1.0)", "/page")
+	if !result.Allowed || result.MatchedGroup != "" {
+		t.Errorf("allowed = %v, group = %q; want allowed without a group", result.Allowed, result.MatchedGroup)
+	}
+}
+
+// TestRobotsViolationAddress проверяет, что нарушение записывается с адресом клиента без порта
+func TestRobotsViolationAddress(t *testing.T) {
+	config := DefaultConfig()
+	config.EnableMetrics = false
+	config.EnableRateLimit = false
+	bd, err := NewBotDetector(config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bd.Shutdown()
+	bd.robotsChecker = newTestRobotsChecker(t, "User-agent: Googlebot\nDisallow: /private\n")
+
+	r := httptest.NewRequest("GET", "/private/page", nil)
+	r.RemoteAddr = "[::ffff:66.249.66.1]:4321"
+	r.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	bd.CheckRobots(r, &DetectionResult{IsBot: true, Verified: true, BotName: "Googlebot"})
+
+	violations := bd.robotsChecker.GetViolations()
+	if len(violations) != 1 || violations[0].LastIP != "66.249.66.1" {
+		t.Errorf("violations = %+v, want one from 66.249.66.1", violations)
+	}
+}
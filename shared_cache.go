@@ -0,0 +1,504 @@
+package botredirect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// sharedCacheBatchSize количество ожидающих записей, при котором запись начинается досрочно
+const sharedCacheBatchSize = 100
+
+// sharedCacheMaxPending ограничивает очередь записи при недоступном хранилище
+const sharedCacheMaxPending = 10000
+
+// sharedCacheSweepInterval интервал удаления истекших вердиктов из хранилища
+const sharedCacheSweepInterval = 10 * time.Minute
+
+// SharedCache кеш второго уровня, общий для узлов кластера. Хранит подтвержденные
+// вердикты о ботах и баны в хранилище Caddy (certmagic.Storage: file_system, Redis, Consul
+// и другие модули storage). Записи накапливаются и пишутся пачками в фоне (write-behind),
+// баны других узлов периодически загружаются в локальный менеджер банов.
+type SharedCache struct {
+	// Конфигурация
+	enabled       bool
+	storage       certmagic.Storage
+	prefix        string
+	localTTL      time.Duration
+	flushInterval time.Duration
+	syncInterval  time.Duration
+	sweepInterval time.Duration
+	timeout       time.Duration
+
+	// Очередь записи: ключ хранилища -> значение (nil - удаление)
+	pending      map[string][]byte
+	pendingMutex sync.Mutex
+	flushNow     chan struct{}
+
+	// Ключи, которых не оказалось в хранилище: повторный промах не обращается
+	// к хранилищу в течение localTTL
+	misses *Cache[string, struct{}]
+
+	// Баны, которые были в хранилище при последней синхронизации
+	knownBans map[string]time.Time
+
+	// Источники
+	banManager *BanManager
+
+	// Управление фоновой горутиной
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	verdictHits   int64
+	verdictMisses int64
+	writes        int64
+	writeErrors   int64
+	loadErrors    int64
+	droppedWrites int64
+	bansImported  int64
+	bansLifted    int64
+	swept         int64
+}
+
+// sharedVerdict вердикт в общем хранилище
+type sharedVerdict struct {
+	Verdict   *BotVerdict `json:"verdict"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// NewSharedCache создает новый экземпляр SharedCache
+func NewSharedCache(config *Config, banManager *BanManager, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *SharedCache {
+	if !config.SharedCache || config.Storage == nil {
+		return &SharedCache{enabled: false}
+	}
+
+	sc := &SharedCache{
+		enabled:       true,
+		storage:       config.Storage,
+		prefix:        config.SharedCachePrefix,
+		localTTL:      config.SharedCacheLocalTTL,
+		flushInterval: config.SharedCacheFlushInterval,
+		syncInterval:  config.SharedCacheSyncInterval,
+		sweepInterval: sharedCacheSweepInterval,
+		timeout:       config.SharedCacheTimeout,
+		pending:       make(map[string][]byte),
+		flushNow:      make(chan struct{}, 1),
+		knownBans:     make(map[string]time.Time),
+		banManager:    banManager,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		metrics:       metrics,
+		debug:         debug,
+		logger:        logger,
+	}
+
+	if sc.prefix == "" {
+		sc.prefix = "bot_redirect"
+	}
+	if sc.localTTL <= 0 {
+		sc.localTTL = time.Minute
+	}
+	if sc.flushInterval <= 0 {
+		sc.flushInterval = time.Second
+	}
+	if sc.syncInterval <= 0 {
+		sc.syncInterval = 10 * time.Second
+	}
+	if sc.timeout <= 0 {
+		sc.timeout = 200 * time.Millisecond
+	}
+
+	sc.misses = NewCache[string, struct{}](CacheOptions{
+		Name:            "shared_miss",
+		MaxSize:         config.MaxCacheSize,
+		TTL:             sc.localTTL,
+		CleanupInterval: config.CleanupInterval,
+	}, hashString, nil, nil, logger)
+
+	go sc.run()
+
+	logger.Info("shared cache initialized",
+		zap.String("storage", storageName(sc.storage)),
+		zap.String("prefix", sc.prefix),
+		zap.Duration("local_ttl", sc.localTTL),
+		zap.Duration("flush_interval", sc.flushInterval),
+		zap.Duration("sync_interval", sc.syncInterval),
+	)
+
+	return sc
+}
+
+// storageName возвращает описание хранилища для логов
+func storageName(storage certmagic.Storage) string {
+	if stringer, ok := storage.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", storage)
+}
+
+// storageKey возвращает путь записи в хранилище; ключ хешируется,
+// чтобы IP адреса и User-Agent не попадали в имена файлов
+func (sc *SharedCache) storageKey(kind, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return path.Join(sc.prefix, kind, hex.EncodeToString(sum[:]))
+}
+
+// context возвращает контекст с таймаутом операции хранилища
+func (sc *SharedCache) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), sc.timeout)
+}
+
+// LocalTTL возвращает время жизни локальной копии записи
+func (sc *SharedCache) LocalTTL() time.Duration {
+	return sc.localTTL
+}
+
+// GetVerdict ищет вердикт в общем хранилище. Промах запоминается на localTTL,
+// чтобы запросы того же клиента не ждали хранилище каждый раз.
+func (sc *SharedCache) GetVerdict(key string) (*BotVerdict, bool) {
+	if !sc.enabled {
+		return nil, false
+	}
+
+	if _, missed := sc.misses.Get(key); missed {
+		atomic.AddInt64(&sc.verdictMisses, 1)
+		sc.recordLookup(false)
+		return nil, false
+	}
+
+	ctx, cancel := sc.context()
+	defer cancel()
+
+	storageKey := sc.storageKey("verdicts", key)
+	data, err := sc.storage.Load(ctx, storageKey)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			atomic.AddInt64(&sc.loadErrors, 1)
+			sc.logger.Debug("shared cache load failed", zap.Error(err))
+		}
+		sc.misses.Set(key, struct{}{})
+		atomic.AddInt64(&sc.verdictMisses, 1)
+		sc.recordLookup(false)
+		return nil, false
+	}
+
+	entry, ok := parseSharedVerdict(data, time.Now())
+	if !ok {
+		// Истекшая или поврежденная запись удаляется, а не остается в хранилище
+		sc.enqueue(storageKey, nil)
+		sc.misses.Set(key, struct{}{})
+		atomic.AddInt64(&sc.verdictMisses, 1)
+		sc.recordLookup(false)
+		return nil, false
+	}
+
+	// После JSON тип бота приходит строкой
+	if botType, ok := entry.Verdict.Details["bot_type"].(string); ok {
+		entry.Verdict.Details["bot_type"] = BotType(botType)
+	}
+
+	atomic.AddInt64(&sc.verdictHits, 1)
+	sc.recordLookup(true)
+	return entry.Verdict, true
+}
+
+// parseSharedVerdict разбирает запись вердикта; false - запись повреждена или истекла
+func parseSharedVerdict(data []byte, now time.Time) (*sharedVerdict, bool) {
+	var entry sharedVerdict
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Verdict == nil || now.After(entry.ExpiresAt) {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// recordLookup учитывает обращение к общему кешу в метриках
+func (sc *SharedCache) recordLookup(hit bool) {
+	if sc.metrics != nil {
+		sc.metrics.RecordCacheLookup("shared", hit)
+	}
+}
+
+// PutVerdict ставит вердикт о подтвержденном краулере в очередь записи;
+// остальные вердикты в хранилище не попадают
+func (sc *SharedCache) PutVerdict(key string, verdict *BotVerdict, ttl time.Duration) {
+	if !sc.enabled || verdict == nil || !verdict.Verified {
+		return
+	}
+
+	data, err := json.Marshal(sharedVerdict{Verdict: verdict, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return
+	}
+	sc.misses.Delete(key)
+	sc.enqueue(sc.storageKey("verdicts", key), data)
+}
+
+// PutBan ставит бан в очередь записи
+func (sc *SharedCache) PutBan(entry *BanEntry) {
+	if !sc.enabled || entry == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	sc.enqueue(sc.storageKey("bans", entry.Key), data)
+}
+
+// DeleteVerdict ставит удаление вердикта в очередь записи
+func (sc *SharedCache) DeleteVerdict(key string) {
+	if !sc.enabled {
+		return
+	}
+	sc.enqueue(sc.storageKey("verdicts", key), nil)
+}
+
+// DeleteBan ставит удаление бана в очередь записи
+func (sc *SharedCache) DeleteBan(key string) {
+	if !sc.enabled {
+		return
+	}
+	sc.enqueue(sc.storageKey("bans", key), nil)
+}
+
+// enqueue добавляет запись в очередь; повторная запись ключа заменяет предыдущую
+func (sc *SharedCache) enqueue(storageKey string, data []byte) {
+	sc.pendingMutex.Lock()
+	if _, exists := sc.pending[storageKey]; !exists && len(sc.pending) >= sharedCacheMaxPending {
+		sc.pendingMutex.Unlock()
+		atomic.AddInt64(&sc.droppedWrites, 1)
+		return
+	}
+	sc.pending[storageKey] = data
+	size := len(sc.pending)
+	sc.pendingMutex.Unlock()
+
+	if size >= sharedCacheBatchSize {
+		select {
+		case sc.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run выполняет запись очереди, синхронизацию банов и удаление истекших вердиктов
+func (sc *SharedCache) run() {
+	defer close(sc.done)
+
+	flushTicker := time.NewTicker(sc.flushInterval)
+	defer flushTicker.Stop()
+
+	syncTicker := time.NewTicker(sc.syncInterval)
+	defer syncTicker.Stop()
+
+	sweepTicker := time.NewTicker(sc.sweepInterval)
+	defer sweepTicker.Stop()
+
+	sc.syncBans()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			sc.flush()
+		case <-sc.flushNow:
+			sc.flush()
+		case <-syncTicker.C:
+			sc.syncBans()
+		case <-sweepTicker.C:
+			sc.sweepVerdicts()
+		case <-sc.stop:
+			sc.flush()
+			return
+		}
+	}
+}
+
+// flush записывает накопленную очередь в хранилище
+func (sc *SharedCache) flush() {
+	sc.pendingMutex.Lock()
+	batch := sc.pending
+	sc.pending = make(map[string][]byte)
+	sc.pendingMutex.Unlock()
+
+	for storageKey, data := range batch {
+		ctx, cancel := sc.context()
+		var err error
+		if data == nil {
+			err = sc.storage.Delete(ctx, storageKey)
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			err = sc.storage.Store(ctx, storageKey, data)
+		}
+		cancel()
+
+		if err != nil {
+			atomic.AddInt64(&sc.writeErrors, 1)
+			sc.logger.Warn("shared cache write failed",
+				zap.String("key", storageKey),
+				zap.Error(err),
+			)
+			continue
+		}
+		atomic.AddInt64(&sc.writes, 1)
+	}
+}
+
+// syncBans загружает баны других узлов, снимает баны, удаленные из хранилища,
+// и удаляет истекшие записи
+func (sc *SharedCache) syncBans() {
+	if sc.banManager == nil || !sc.banManager.IsEnabled() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sc.syncInterval)
+	defer cancel()
+
+	keys, err := sc.storage.List(ctx, path.Join(sc.prefix, "bans"), false)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		atomic.AddInt64(&sc.loadErrors, 1)
+		sc.logger.Warn("shared cache ban sync failed", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	present := make(map[string]time.Time, len(keys))
+
+	for _, storageKey := range keys {
+		data, err := sc.storage.Load(ctx, storageKey)
+		if err != nil {
+			continue
+		}
+
+		var entry BanEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Key == "" {
+			continue
+		}
+
+		if !now.Before(entry.BannedUntil) {
+			sc.enqueue(storageKey, nil)
+			continue
+		}
+
+		present[entry.Key] = entry.BannedUntil
+		if sc.banManager.Import(entry) {
+			atomic.AddInt64(&sc.bansImported, 1)
+		}
+	}
+
+	// Бан исчез из хранилища до истечения - его сняли на другом узле
+	for key, until := range sc.knownBans {
+		if _, exists := present[key]; exists || !now.Before(until) {
+			continue
+		}
+		if local := sc.banManager.Get(key); local != nil && local.BannedUntil.Equal(until) {
+			sc.banManager.Lift(key)
+			atomic.AddInt64(&sc.bansLifted, 1)
+		}
+	}
+	sc.knownBans = present
+}
+
+// sweepVerdicts удаляет из хранилища истекшие и поврежденные вердикты, которые
+// никто не запрашивал после истечения (например, от клиентов со случайными User-Agent)
+func (sc *SharedCache) sweepVerdicts() {
+	ctx, cancel := context.WithTimeout(context.Background(), sc.sweepInterval)
+	defer cancel()
+
+	keys, err := sc.storage.List(ctx, path.Join(sc.prefix, "verdicts"), false)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			atomic.AddInt64(&sc.loadErrors, 1)
+			sc.logger.Warn("shared cache verdict sweep failed", zap.Error(err))
+		}
+		return
+	}
+
+	now := time.Now()
+	swept := 0
+	for _, storageKey := range keys {
+		data, err := sc.storage.Load(ctx, storageKey)
+		if err != nil {
+			continue
+		}
+		if _, ok := parseSharedVerdict(data, now); !ok {
+			sc.enqueue(storageKey, nil)
+			swept++
+		}
+	}
+
+	if swept > 0 {
+		atomic.AddInt64(&sc.swept, int64(swept))
+		sc.logger.Debug("expired shared verdicts removed",
+			zap.Int("removed", swept),
+			zap.Int("scanned", len(keys)),
+		)
+	}
+}
+
+// Shutdown записывает очередь и останавливает фоновую горутину
+func (sc *SharedCache) Shutdown() {
+	if !sc.enabled {
+		return
+	}
+	sc.stopOnce.Do(func() {
+		close(sc.stop)
+		<-sc.done
+		sc.misses.StopCleanup()
+	})
+}
+
+// IsEnabled возвращает статус включенности общего кеша
+func (sc *SharedCache) IsEnabled() bool {
+	return sc.enabled
+}
+
+// GetStats возвращает статистику
+func (sc *SharedCache) GetStats() map[string]interface{} {
+	if !sc.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	sc.pendingMutex.Lock()
+	pending := len(sc.pending)
+	sc.pendingMutex.Unlock()
+
+	return map[string]interface{}{
+		"enabled":                true,
+		"prefix":                 sc.prefix,
+		"local_ttl_seconds":      sc.localTTL.Seconds(),
+		"flush_interval_seconds": sc.flushInterval.Seconds(),
+		"sync_interval_seconds":  sc.syncInterval.Seconds(),
+		"pending_writes":         pending,
+		"remembered_misses":      sc.misses.Len(),
+		"verdict_hits":           atomic.LoadInt64(&sc.verdictHits),
+		"verdict_misses":         atomic.LoadInt64(&sc.verdictMisses),
+		"writes":                 atomic.LoadInt64(&sc.writes),
+		"write_errors":           atomic.LoadInt64(&sc.writeErrors),
+		"load_errors":            atomic.LoadInt64(&sc.loadErrors),
+		"dropped_writes":         atomic.LoadInt64(&sc.droppedWrites),
+		"bans_imported":          atomic.LoadInt64(&sc.bansImported),
+		"bans_lifted":            atomic.LoadInt64(&sc.bansLifted),
+		"verdicts_swept":         atomic.LoadInt64(&sc.swept),
+	}
+}
@@ -0,0 +1,192 @@
+package botredirect
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// newTestSharedCache создает общий кеш поверх файлового хранилища во временном каталоге
+func newTestSharedCache(t *testing.T, storage certmagic.Storage) *SharedCache {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.SharedCache = true
+	config.Storage = storage
+	config.SharedCacheFlushInterval = time.Hour
+	config.SharedCacheSyncInterval = time.Hour
+
+	sc := NewSharedCache(config, nil, nil, nil, zap.NewNop())
+	t.Cleanup(sc.Shutdown)
+	return sc
+}
+
+// TestSharedCacheStoresOnlyVerifiedVerdicts проверяет, что в хранилище попадают
+// только вердикты о подтвержденных краулерах
+func TestSharedCacheStoresOnlyVerifiedVerdicts(t *testing.T) {
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	sc := newTestSharedCache(t, storage)
+
+	verdicts := map[string]*BotVerdict{
+		"66.249.66.1|Googlebot": {IsBot: true, BotName: "Googlebot", Verified: true},
+		"203.0.113.9|Googlebot": {IsBot: true, BotName: "Googlebot", Spoofed: true},
+		"203.0.113.9|curl/8.0":  {IsBot: true, BotName: "curl"},
+		"203.0.113.9|Mozilla":   {},
+	}
+	for key, verdict := range verdicts {
+		sc.PutVerdict(key, verdict, time.Hour)
+	}
+	sc.flush()
+
+	for key, verdict := range verdicts {
+		stored := storage.Exists(context.Background(), sc.storageKey("verdicts", key))
+		if stored != verdict.Verified {
+			t.Errorf("%s: stored = %v, want %v", key, stored, verdict.Verified)
+		}
+	}
+}
+
+// storeTestVerdict записывает вердикт в хранилище в обход очереди записи
+func storeTestVerdict(t *testing.T, sc *SharedCache, key string, expiresAt time.Time) string {
+	t.Helper()
+
+	data, err := json.Marshal(sharedVerdict{
+		Verdict:   &BotVerdict{IsBot: true, BotName: "Googlebot", Verified: true},
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageKey := sc.storageKey("verdicts", key)
+	if err := sc.storage.Store(context.Background(), storageKey, data); err != nil {
+		t.Fatal(err)
+	}
+	return storageKey
+}
+
+// TestSharedCacheRemovesExpiredVerdicts проверяет удаление истекших вердиктов
+// при чтении и при периодической очистке
+func TestSharedCacheRemovesExpiredVerdicts(t *testing.T) {
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	sc := newTestSharedCache(t, storage)
+	ctx := context.Background()
+
+	expired := storeTestVerdict(t, sc, "66.249.66.1|Googlebot", time.Now().Add(-time.Minute))
+	if _, ok := sc.GetVerdict("66.249.66.1|Googlebot"); ok {
+		t.Fatal("expired verdict returned")
+	}
+	sc.flush()
+	if storage.Exists(ctx, expired) {
+		t.Error("expired verdict left in storage after read")
+	}
+
+	fresh := storeTestVerdict(t, sc, "66.249.66.2|Googlebot", time.Now().Add(time.Hour))
+	stale := storeTestVerdict(t, sc, "66.249.66.3|Googlebot", time.Now().Add(-time.Minute))
+	corrupt := sc.storageKey("verdicts", "66.249.66.4|Googlebot")
+	if err := storage.Store(ctx, corrupt, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+
+	sc.sweepVerdicts()
+	sc.flush()
+
+	if !storage.Exists(ctx, fresh) {
+		t.Error("sweep removed a fresh verdict")
+	}
+	if storage.Exists(ctx, stale) || storage.Exists(ctx, corrupt) {
+		t.Error("sweep left expired or corrupt verdicts in storage")
+	}
+	if swept := sc.GetStats()["verdicts_swept"]; swept != int64(2) {
+		t.Errorf("verdicts_swept = %v, want 2", swept)
+	}
+}
+
+// countingStorage считает чтения из хранилища
+type countingStorage struct {
+	certmagic.Storage
+	loads int64
+}
+
+func (s *countingStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddInt64(&s.loads, 1)
+	return s.Storage.Load(ctx, key)
+}
+
+// TestSharedCacheRemembersMisses проверяет, что повторный промах не обращается к хранилищу
+func TestSharedCacheRemembersMisses(t *testing.T) {
+	storage := &countingStorage{Storage: &certmagic.FileStorage{Path: t.TempDir()}}
+	sc := newTestSharedCache(t, storage)
+
+	for i := 0; i < 5; i++ {
+		if _, ok := sc.GetVerdict("203.0.113.9|Googlebot"); ok {
+			t.Fatal("verdict found in empty storage")
+		}
+	}
+	if loads := atomic.LoadInt64(&storage.loads); loads != 1 {
+		t.Errorf("storage loads = %d, want 1", loads)
+	}
+
+	// Собственная запись узла отменяет запомненный промах
+	sc.PutVerdict("203.0.113.9|Googlebot", &BotVerdict{IsBot: true, BotName: "Googlebot", Verified: true}, time.Hour)
+	sc.flush()
+	if _, ok := sc.GetVerdict("203.0.113.9|Googlebot"); !ok {
+		t.Error("stored verdict hidden by a remembered miss")
+	}
+}
+
+// TestSharedCacheListChange проверяет, что после изменения списка узел не берет из общего
+// кеша вердикт, вынесенный по прежнему списку
+func TestSharedCacheListChange(t *testing.T) {
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+	config := DefaultConfig()
+	config.BotIPRanges = []string{"66.249.64.0/19"}
+	config.EnableMetrics = false
+	config.EnableRateLimit = false
+	config.SharedCache = true
+	config.Storage = &certmagic.FileStorage{Path: t.TempDir()}
+	config.SharedCacheFlushInterval = time.Hour
+	config.SharedCacheSyncInterval = time.Hour
+
+	bd, err := NewBotDetector(config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bd.Shutdown()
+
+	detect := func() *DetectionResult {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "66.249.66.1:4321"
+		r.Header.Set("User-Agent", googlebot)
+		return bd.DetectBot(r)
+	}
+
+	if result := detect(); !result.Verified {
+		t.Fatal("crawler from its own range not verified")
+	}
+	bd.sharedCache.flush()
+
+	if _, err := bd.listEditor.Apply([]ListChange{{Op: ListOpRemove, List: ListIPRanges, Value: "66.249.64.0/19"}}); err != nil {
+		t.Fatal(err)
+	}
+	if result := detect(); result.Verified {
+		t.Error("verdict from the shared cache survived removal of the range")
+	}
+
+	// Возврат диапазона снова делает доступным вердикт, вынесенный по тем же спискам
+	if _, err := bd.listEditor.Apply([]ListChange{{Op: ListOpAdd, List: ListIPRanges, Value: "66.249.64.0/19"}}); err != nil {
+		t.Fatal(err)
+	}
+	if result := detect(); !result.Verified {
+		t.Error("crawler not verified after the range was restored")
+	}
+	if hits := bd.sharedCache.GetStats()["verdict_hits"]; hits != int64(1) {
+		t.Errorf("shared verdict hits = %v, want 1", hits)
+	}
+}
@@ -0,0 +1,717 @@
+package botredirect
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// signatureDirectoryPath путь к каталогу ключей агента (Web Bot Auth)
+	signatureDirectoryPath = "/.well-known/http-message-signatures-directory"
+
+	// signatureClockSkew допустимое расхождение часов агента и сервера
+	signatureClockSkew = 30 * time.Second
+
+	// maxSignatureNonces ограничивает память, занимаемую защитой от повторов
+	maxSignatureNonces = 100000
+
+	// maxDirectorySize ограничивает размер загружаемого каталога ключей
+	maxDirectorySize = 1 << 20
+)
+
+// SignatureVerifier проверяет HTTP Message Signatures (RFC 9421) подписанных агентов (Web Bot Auth).
+// Ключи загружаются из доверенных каталогов, подпись Ed25519 должна покрывать @authority
+// и заголовок Signature-Agent; повторное использование nonce отклоняется.
+type SignatureVerifier struct {
+	// Конфигурация
+	enabled      bool
+	directories  []SignatureDirectory
+	maxAge       time.Duration
+	refresh      time.Duration
+	requireNonce bool
+
+	// Ключи по origin агента и keyid
+	keys map[string]map[string]ed25519.PublicKey
+
+	// Использованные nonce и время их истечения
+	nonces map[string]time.Time
+
+	// HTTP клиент для загрузки каталогов
+	client *http.Client
+
+	// Синхронизация
+	mutex  sync.RWMutex
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Компоненты
+	metrics *Metrics
+	debug   *DebugConfig
+	logger  *zap.Logger
+
+	// Статистика (используем atomic для thread-safety)
+	totalChecks     int64
+	validSignatures int64
+	invalidSigs     int64
+	replays         int64
+	directoryErrors int64
+}
+
+// SignatureResult результат проверки подписи запроса
+type SignatureResult struct {
+	Valid      bool
+	Agent      string
+	KeyID      string
+	Label      string
+	Components []string
+	Created    time.Time
+	Expires    time.Time
+	Error      string
+}
+
+// signatureParams разобранный член Signature-Input
+type signatureParams struct {
+	label      string
+	raw        string
+	components []string
+	params     map[string]string
+}
+
+// NewSignatureVerifier создает новый экземпляр SignatureVerifier
+func NewSignatureVerifier(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *SignatureVerifier {
+	if len(config.SignatureDirectories) == 0 {
+		return &SignatureVerifier{enabled: false}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sv := &SignatureVerifier{
+		enabled:      true,
+		directories:  config.SignatureDirectories,
+		maxAge:       config.SignatureMaxAge,
+		refresh:      config.SignatureRefresh,
+		requireNonce: config.SignatureRequireNonce,
+		keys:         make(map[string]map[string]ed25519.PublicKey),
+		nonces:       make(map[string]time.Time),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		ctx:          ctx,
+		cancel:       cancel,
+		metrics:      metrics,
+		debug:        debug,
+		logger:       logger,
+	}
+
+	// Локальные файлы загружаются сразу, удаленные каталоги - в фоне, чтобы не задерживать запуск
+	for _, dir := range sv.directories {
+		if !isRemoteSource(sv.directorySource(dir)) {
+			sv.loadDirectory(dir)
+		}
+	}
+	go sv.refreshLoop()
+
+	logger.Info("signature verifier initialized",
+		zap.Int("directories", len(sv.directories)),
+		zap.Duration("max_age", sv.maxAge),
+		zap.Bool("require_nonce", sv.requireNonce),
+	)
+
+	return sv
+}
+
+// HasMessageSignature проверяет наличие заголовков HTTP Message Signatures
+func HasMessageSignature(r *http.Request) bool {
+	return r.Header.Get("Signature-Input") != "" && r.Header.Get("Signature") != ""
+}
+
+// Verify проверяет подпись запроса
+func (sv *SignatureVerifier) Verify(r *http.Request) *SignatureResult {
+	result := &SignatureResult{}
+	if !sv.enabled {
+		result.Error = "signature verification disabled"
+		return result
+	}
+
+	atomic.AddInt64(&sv.totalChecks, 1)
+
+	if err := sv.verify(r, result); err != nil {
+		result.Error = err.Error()
+		atomic.AddInt64(&sv.invalidSigs, 1)
+		if sv.metrics != nil {
+			sv.metrics.IncrementSignaturesInvalid()
+		}
+		sv.logger.Debug("message signature rejected",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("agent", result.Agent),
+			zap.String("keyid", result.KeyID),
+			zap.Error(err),
+		)
+		return result
+	}
+
+	result.Valid = true
+	atomic.AddInt64(&sv.validSignatures, 1)
+	if sv.metrics != nil {
+		sv.metrics.IncrementSignaturesVerified()
+	}
+	return result
+}
+
+// verify выполняет проверку и заполняет результат
+func (sv *SignatureVerifier) verify(r *http.Request, result *SignatureResult) error {
+	inputs, err := parseSignatureInput(r.Header.Get("Signature-Input"))
+	if err != nil {
+		return fmt.Errorf("parsing Signature-Input: %w", err)
+	}
+
+	signatures, err := parseSignatureHeader(r.Header.Get("Signature"))
+	if err != nil {
+		return fmt.Errorf("parsing Signature: %w", err)
+	}
+
+	// Предпочитаем подпись с тегом web-bot-auth
+	var input *signatureParams
+	for _, candidate := range inputs {
+		if _, ok := signatures[candidate.label]; !ok {
+			continue
+		}
+		if input == nil || candidate.params["tag"] == "web-bot-auth" {
+			input = candidate
+		}
+	}
+	if input == nil {
+		return fmt.Errorf("no signature matches Signature-Input")
+	}
+
+	result.Label = input.label
+	result.Components = input.components
+	result.KeyID = input.params["keyid"]
+
+	if alg, ok := input.params["alg"]; ok && alg != "ed25519" {
+		return fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+	if result.KeyID == "" {
+		return fmt.Errorf("keyid is required")
+	}
+
+	agentHeader := r.Header.Get("Signature-Agent")
+	if !containsString(input.components, "@authority") {
+		return fmt.Errorf("signature must cover @authority")
+	}
+	if agentHeader != "" && !containsString(input.components, "signature-agent") {
+		return fmt.Errorf("signature must cover signature-agent")
+	}
+
+	// Окно действия подписи
+	now := time.Now()
+	created, err := strconv.ParseInt(input.params["created"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("created is required")
+	}
+	result.Created = time.Unix(created, 0)
+	if result.Created.After(now.Add(signatureClockSkew)) {
+		return fmt.Errorf("signature created in the future")
+	}
+
+	result.Expires = result.Created.Add(sv.maxAge)
+	if value, ok := input.params["expires"]; ok {
+		expires, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("malformed expires")
+		}
+		if time.Unix(expires, 0).Before(result.Expires) {
+			result.Expires = time.Unix(expires, 0)
+		}
+	}
+	if now.Add(-signatureClockSkew).After(result.Expires) {
+		return fmt.Errorf("signature expired")
+	}
+
+	// Поиск ключа
+	agent, key := sv.lookupKey(parseSignatureAgent(agentHeader), result.KeyID)
+	result.Agent = agent
+	if key == nil {
+		return fmt.Errorf("unknown key")
+	}
+
+	// Проверка подписи
+	base, err := signatureBase(r, input)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, []byte(base), signatures[input.label]) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	// Защита от повторов проверяется после подписи, чтобы чужие запросы не занимали nonce
+	nonce := input.params["nonce"]
+	if nonce == "" {
+		if sv.requireNonce {
+			return fmt.Errorf("nonce is required")
+		}
+		return nil
+	}
+	if !sv.rememberNonce(result.KeyID+"|"+nonce, result.Expires.Add(signatureClockSkew)) {
+		atomic.AddInt64(&sv.replays, 1)
+		return fmt.Errorf("nonce already used")
+	}
+
+	return nil
+}
+
+// lookupKey ищет ключ в каталоге агента или, если агент не указан, во всех каталогах
+func (sv *SignatureVerifier) lookupKey(agent, keyID string) (string, ed25519.PublicKey) {
+	sv.mutex.RLock()
+	defer sv.mutex.RUnlock()
+
+	if agent != "" {
+		if keys, ok := sv.keys[agent]; ok {
+			return agent, keys[keyID]
+		}
+		return agent, nil
+	}
+
+	for origin, keys := range sv.keys {
+		if key, ok := keys[keyID]; ok {
+			return origin, key
+		}
+	}
+	return "", nil
+}
+
+// rememberNonce сохраняет nonce; возвращает false, если он уже использовался
+func (sv *SignatureVerifier) rememberNonce(key string, expires time.Time) bool {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	if until, exists := sv.nonces[key]; exists && time.Now().Before(until) {
+		return false
+	}
+	if len(sv.nonces) >= maxSignatureNonces {
+		// Без места для nonce повтор нельзя исключить
+		return false
+	}
+
+	sv.nonces[key] = expires
+	return true
+}
+
+// signatureBase строит строку для проверки подписи (RFC 9421, раздел 2.5)
+func signatureBase(r *http.Request, input *signatureParams) (string, error) {
+	var b strings.Builder
+	for _, component := range input.components {
+		value, err := componentValue(r, component)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%q: %s\n", component, value)
+	}
+	fmt.Fprintf(&b, "%q: %s", "@signature-params", input.raw)
+	return b.String(), nil
+}
+
+// componentValue возвращает значение компонента подписи
+func componentValue(r *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return r.Method, nil
+	case "@authority":
+		return requestAuthority(r), nil
+	case "@scheme":
+		return requestScheme(r), nil
+	case "@path":
+		return r.URL.EscapedPath(), nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@target-uri":
+		return requestScheme(r) + "://" + requestAuthority(r) + r.URL.RequestURI(), nil
+	}
+
+	if strings.HasPrefix(component, "@") {
+		return "", fmt.Errorf("unsupported derived component: %s", component)
+	}
+
+	// Values возвращает срез из карты заголовков запроса, поэтому значения
+	// обрезаются в копии, а не на месте
+	headerValues := r.Header.Values(component)
+	if len(headerValues) == 0 {
+		return "", fmt.Errorf("covered header missing: %s", component)
+	}
+	values := make([]string, len(headerValues))
+	for i, value := range headerValues {
+		values[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// requestAuthority возвращает нормализованный host запроса без порта по умолчанию
+func requestAuthority(r *http.Request) string {
+	host := strings.ToLower(r.Host)
+	if r.TLS != nil {
+		return strings.TrimSuffix(host, ":443")
+	}
+	return strings.TrimSuffix(host, ":80")
+}
+
+// requestScheme возвращает схему запроса
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// parseSignatureInput разбирает словарь Signature-Input (RFC 8941)
+func parseSignatureInput(header string) ([]*signatureParams, error) {
+	var inputs []*signatureParams
+	for _, member := range splitStructured(header, ',') {
+		eq := strings.Index(member, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed member: %s", member)
+		}
+
+		input := &signatureParams{
+			label:  strings.TrimSpace(member[:eq]),
+			raw:    strings.TrimSpace(member[eq+1:]),
+			params: make(map[string]string),
+		}
+
+		if !strings.HasPrefix(input.raw, "(") {
+			return nil, fmt.Errorf("signature %s: inner list expected", input.label)
+		}
+		end := strings.Index(input.raw, ")")
+		if end == -1 {
+			return nil, fmt.Errorf("signature %s: unterminated inner list", input.label)
+		}
+
+		for _, item := range strings.Fields(input.raw[1:end]) {
+			if strings.Contains(item, ";") {
+				return nil, fmt.Errorf("signature %s: component parameters are not supported", input.label)
+			}
+			component, err := strconv.Unquote(item)
+			if err != nil {
+				return nil, fmt.Errorf("signature %s: malformed component %s", input.label, item)
+			}
+			input.components = append(input.components, component)
+		}
+
+		for _, param := range splitStructured(input.raw[end+1:], ';') {
+			if param == "" {
+				continue
+			}
+			key, value := param, "?1"
+			if idx := strings.Index(param, "="); idx != -1 {
+				key, value = param[:idx], param[idx+1:]
+			}
+			if strings.HasPrefix(value, "\"") {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, fmt.Errorf("signature %s: malformed parameter %s", input.label, key)
+				}
+				value = unquoted
+			}
+			input.params[strings.TrimSpace(key)] = value
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("empty header")
+	}
+	return inputs, nil
+}
+
+// parseSignatureHeader разбирает словарь Signature с байтовыми последовательностями
+func parseSignatureHeader(header string) (map[string][]byte, error) {
+	signatures := make(map[string][]byte)
+	for _, member := range splitStructured(header, ',') {
+		eq := strings.Index(member, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed member: %s", member)
+		}
+		label := strings.TrimSpace(member[:eq])
+		value := strings.TrimSpace(member[eq+1:])
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("signature %s: byte sequence expected", label)
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %w", label, err)
+		}
+		signatures[label] = signature
+	}
+	return signatures, nil
+}
+
+// parseSignatureAgent извлекает origin агента из Signature-Agent (строка или словарь)
+func parseSignatureAgent(header string) string {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return ""
+	}
+	if !strings.HasPrefix(header, "\"") {
+		if eq := strings.Index(header, "="); eq != -1 {
+			header = strings.TrimSpace(splitStructured(header[eq+1:], ',')[0])
+		}
+	}
+	if unquoted, err := strconv.Unquote(header); err == nil {
+		header = unquoted
+	}
+	return normalizeAgentOrigin(header)
+}
+
+// normalizeAgentOrigin приводит адрес агента к виду scheme://host
+func normalizeAgentOrigin(agent string) string {
+	if !strings.Contains(agent, "://") {
+		agent = "https://" + agent
+	}
+	u, err := url.Parse(agent)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// splitStructured делит строку по разделителю вне кавычек, скобок и байтовых последовательностей
+func splitStructured(value string, sep byte) []string {
+	var parts []string
+	inQuotes, inBytes, depth, start := false, false, 0, 0
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case inQuotes:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuotes = false
+			}
+		case c == '"':
+			inQuotes = true
+		case c == ':' && depth == 0:
+			inBytes = !inBytes
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0 && !inBytes:
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+// directorySource возвращает источник ключей каталога
+func (sv *SignatureVerifier) directorySource(dir SignatureDirectory) string {
+	if dir.Source != "" {
+		return dir.Source
+	}
+	return normalizeAgentOrigin(dir.Agent) + signatureDirectoryPath
+}
+
+// isRemoteSource проверяет, загружается ли каталог по HTTP
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// loadDirectory загружает ключи одного каталога
+func (sv *SignatureVerifier) loadDirectory(dir SignatureDirectory) {
+	source := sv.directorySource(dir)
+	agent := normalizeAgentOrigin(dir.Agent)
+
+	data, err := sv.readSource(source)
+	if err == nil {
+		var keys map[string]ed25519.PublicKey
+		keys, err = parseKeyDirectory(data)
+		if err == nil {
+			sv.mutex.Lock()
+			sv.keys[agent] = keys
+			sv.mutex.Unlock()
+
+			sv.logger.Debug("signature key directory loaded",
+				zap.String("agent", agent),
+				zap.String("source", source),
+				zap.Int("keys", len(keys)),
+			)
+			return
+		}
+	}
+
+	// Ранее загруженные ключи сохраняются до следующей успешной загрузки
+	atomic.AddInt64(&sv.directoryErrors, 1)
+	sv.logger.Warn("failed to load signature key directory",
+		zap.String("agent", agent),
+		zap.String("source", source),
+		zap.Error(err),
+	)
+}
+
+// readSource читает каталог из файла или по URL
+func (sv *SignatureVerifier) readSource(source string) ([]byte, error) {
+	if !isRemoteSource(source) {
+		return os.ReadFile(source)
+	}
+
+	req, err := http.NewRequestWithContext(sv.ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/http-message-signatures-directory+json, application/json")
+
+	resp, err := sv.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxDirectorySize))
+}
+
+// parseKeyDirectory разбирает JWKS каталога и индексирует Ed25519 ключи по JWK thumbprint (RFC 7638) и kid
+func parseKeyDirectory(data []byte) (map[string]ed25519.PublicKey, error) {
+	var directory struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &directory); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	for _, jwk := range directory.Keys {
+		if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" {
+			continue
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			continue
+		}
+
+		thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + jwk.X + `"}`))
+		keys[base64.RawURLEncoding.EncodeToString(thumbprint[:])] = ed25519.PublicKey(raw)
+		if jwk.Kid != "" {
+			keys[jwk.Kid] = ed25519.PublicKey(raw)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no Ed25519 keys in directory")
+	}
+	return keys, nil
+}
+
+// refreshLoop периодически перезагружает каталоги и очищает истекшие nonce
+func (sv *SignatureVerifier) refreshLoop() {
+	for _, dir := range sv.directories {
+		if isRemoteSource(sv.directorySource(dir)) {
+			sv.loadDirectory(dir)
+		}
+	}
+
+	refreshTicker := time.NewTicker(sv.refresh)
+	defer refreshTicker.Stop()
+	cleanupTicker := time.NewTicker(time.Minute)
+	defer cleanupTicker.Stop()
+
+	for {
+		select {
+		case <-refreshTicker.C:
+			for _, dir := range sv.directories {
+				sv.loadDirectory(dir)
+			}
+		case <-cleanupTicker.C:
+			sv.cleanupNonces()
+		case <-sv.ctx.Done():
+			return
+		}
+	}
+}
+
+// cleanupNonces удаляет истекшие nonce
+func (sv *SignatureVerifier) cleanupNonces() {
+	now := time.Now()
+
+	sv.mutex.Lock()
+	for key, until := range sv.nonces {
+		if now.After(until) {
+			delete(sv.nonces, key)
+		}
+	}
+	sv.mutex.Unlock()
+}
+
+// containsString проверяет наличие строки в слайсе
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEnabled возвращает статус включенности проверки подписей
+func (sv *SignatureVerifier) IsEnabled() bool {
+	return sv.enabled
+}
+
+// GetStats возвращает статистику
+func (sv *SignatureVerifier) GetStats() map[string]interface{} {
+	if !sv.enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	sv.mutex.RLock()
+	agents := make(map[string]int, len(sv.keys))
+	for agent, keys := range sv.keys {
+		agents[agent] = len(keys)
+	}
+	nonces := len(sv.nonces)
+	sv.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"enabled":          true,
+		"agents":           agents,
+		"tracked_nonces":   nonces,
+		"total_checks":     atomic.LoadInt64(&sv.totalChecks),
+		"valid_signatures": atomic.LoadInt64(&sv.validSignatures),
+		"invalid":          atomic.LoadInt64(&sv.invalidSigs),
+		"replays":          atomic.LoadInt64(&sv.replays),
+		"directory_errors": atomic.LoadInt64(&sv.directoryErrors),
+	}
+}
+
+// Shutdown останавливает фоновое обновление каталогов
+func (sv *SignatureVerifier) Shutdown() {
+	if !sv.enabled {
+		return
+	}
+	sv.cancel()
+}
@@ -0,0 +1,202 @@
+package botredirect
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	testSignatureAgent = "https://signer.example"
+	testSignatureKeyID = "test-key"
+)
+
+// newTestSignatureVerifier создает проверку подписей с каталогом агента, содержащим ключ pub
+func newTestSignatureVerifier(t *testing.T, pub ed25519.PublicKey) *SignatureVerifier {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "directory.json")
+	directory := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":%q,"x":%q}]}`,
+		testSignatureKeyID, base64.RawURLEncoding.EncodeToString(pub))
+	if err := os.WriteFile(path, []byte(directory), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.SignatureDirectories = []SignatureDirectory{{Agent: testSignatureAgent, Source: path}}
+	sv := NewSignatureVerifier(config, nil, nil, zap.NewNop())
+	t.Cleanup(sv.Shutdown)
+	return sv
+}
+
+// signTestRequest подписывает компоненты запроса ключом priv с параметрами params
+func signTestRequest(t *testing.T, r *http.Request, priv ed25519.PrivateKey, components []string, params string) {
+	t.Helper()
+
+	quoted := make([]string, len(components))
+	for i, component := range components {
+		quoted[i] = strconv.Quote(component)
+	}
+	r.Header.Set("Signature-Input", "sig1=("+strings.Join(quoted, " ")+")"+params)
+
+	inputs, err := parseSignatureInput(r.Header.Get("Signature-Input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := signatureBase(r, inputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(base)))+":")
+}
+
+// TestSignatureVerify проверяет подпись Ed25519: принятие, чужой ключ, покрытие компонентов,
+// окно действия и повтор nonce
+func TestSignatureVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sv := newTestSignatureVerifier(t, pub)
+
+	now := time.Now().Unix()
+	params := func(created, expires int64, nonce string) string {
+		p := ";created=" + strconv.FormatInt(created, 10)
+		if expires != 0 {
+			p += ";expires=" + strconv.FormatInt(expires, 10)
+		}
+		p += `;keyid="` + testSignatureKeyID + `"`
+		if nonce != "" {
+			p += `;nonce="` + nonce + `"`
+		}
+		return p + `;tag="web-bot-auth"`
+	}
+	covered := []string{"@authority", "signature-agent"}
+
+	tests := []struct {
+		name       string
+		key        ed25519.PrivateKey
+		components []string
+		params     string
+		agent      string
+		host       string
+		wantErr    string
+	}{
+		{name: "valid", key: priv, components: covered, params: params(now, now+60, "n-valid")},
+		{name: "valid without signature-agent", key: priv, components: []string{"@authority"}, params: params(now, 0, "n-no-agent"), agent: "-"},
+		{name: "wrong key", key: otherPriv, components: covered, params: params(now, now+60, "n-wrong"), wantErr: "signature mismatch"},
+		{name: "authority changed after signing", key: priv, components: covered, params: params(now, now+60, "n-host"), host: "other.example", wantErr: "signature mismatch"},
+		{name: "missing @authority", key: priv, components: []string{"signature-agent", "@path"}, params: params(now, now+60, "n-authority"), wantErr: "@authority"},
+		{name: "missing signature-agent", key: priv, components: []string{"@authority"}, params: params(now, now+60, "n-agent"), wantErr: "signature-agent"},
+		{name: "created too long ago", key: priv, components: covered, params: params(now-int64((10*time.Minute).Seconds()), 0, "n-old"), wantErr: "expired"},
+		{name: "expires passed", key: priv, components: covered, params: params(now-120, now-60, "n-expires"), wantErr: "expired"},
+		{name: "created in the future", key: priv, components: covered, params: params(now+600, now+660, "n-future"), wantErr: "future"},
+		{name: "unknown agent", key: priv, components: covered, params: params(now, now+60, "n-unknown"), agent: `"https://unknown.example"`, wantErr: "unknown key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://site.example/page", nil)
+			switch tt.agent {
+			case "":
+				r.Header.Set("Signature-Agent", strconv.Quote(testSignatureAgent))
+			case "-":
+			default:
+				r.Header.Set("Signature-Agent", tt.agent)
+			}
+
+			signTestRequest(t, r, tt.key, tt.components, tt.params)
+			if tt.host != "" {
+				r.Host = tt.host
+			}
+
+			result := sv.Verify(r)
+			switch {
+			case tt.wantErr == "" && !result.Valid:
+				t.Errorf("signature rejected: %s", result.Error)
+			case tt.wantErr == "" && result.Agent != testSignatureAgent:
+				t.Errorf("agent = %q, want %q", result.Agent, testSignatureAgent)
+			case tt.wantErr != "" && (result.Valid || !strings.Contains(result.Error, tt.wantErr)):
+				t.Errorf("valid = %v, error = %q; want %q", result.Valid, result.Error, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSignatureReplay проверяет, что nonce принимается один раз, а запрос
+// с неверной подписью не занимает nonce
+func TestSignatureReplay(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sv := newTestSignatureVerifier(t, pub)
+	params := fmt.Sprintf(`;created=%d;keyid=%q;nonce="replayed";tag="web-bot-auth"`, time.Now().Unix(), testSignatureKeyID)
+
+	request := func(key ed25519.PrivateKey) *http.Request {
+		r := httptest.NewRequest("GET", "https://site.example/page", nil)
+		r.Header.Set("Signature-Agent", strconv.Quote(testSignatureAgent))
+		signTestRequest(t, r, key, []string{"@authority", "signature-agent"}, params)
+		return r
+	}
+
+	if result := sv.Verify(request(otherPriv)); result.Valid {
+		t.Fatal("signature by another key accepted")
+	}
+	if result := sv.Verify(request(priv)); !result.Valid {
+		t.Fatalf("first use rejected: %s", result.Error)
+	}
+	if result := sv.Verify(request(priv)); result.Valid || !strings.Contains(result.Error, "nonce already used") {
+		t.Errorf("replay: valid = %v, error = %q", result.Valid, result.Error)
+	}
+
+	// Без nonce подпись отклоняется, если nonce обязателен
+	sv.requireNonce = true
+	r := httptest.NewRequest("GET", "https://site.example/page", nil)
+	signTestRequest(t, r, priv, []string{"@authority"}, fmt.Sprintf(`;created=%d;keyid=%q`, time.Now().Unix(), testSignatureKeyID))
+	if result := sv.Verify(r); result.Valid || !strings.Contains(result.Error, "nonce is required") {
+		t.Errorf("missing nonce: valid = %v, error = %q", result.Valid, result.Error)
+	}
+}
+
+// TestComponentValueKeepsHeaders проверяет, что вычисление компонента подписи
+// не меняет заголовки, которые уходят на upstream
+func TestComponentValueKeepsHeaders(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Add("X-Custom", "  first ")
+	r.Header.Add("X-Custom", "second  ")
+
+	value, err := componentValue(r, "x-custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "first, second" {
+		t.Errorf("component value = %q, want %q", value, "first, second")
+	}
+
+	headers := r.Header.Values("X-Custom")
+	if len(headers) != 2 || headers[0] != "  first " || headers[1] != "second  " {
+		t.Errorf("request headers changed to %q", headers)
+	}
+}
@@ -2,8 +2,11 @@ package botredirect
 
 import (
 	"bytes"
+	"encoding/json"
 	"html/template"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -13,6 +16,8 @@ import (
 type Templates struct {
 	// Шаблоны
 	emptyPageTemplate *template.Template
+	challengeTemplate *template.Template
+	rateLimitTemplate *template.Template
 	customTemplate    string
 	
 	// Конфигурация
@@ -50,6 +55,21 @@ func NewTemplates(config *Config, logger *zap.Logger) *Templates {
 		t.initializeDefaultTemplate()
 	}
 
+	// Шаблон страницы проверки браузера
+	if err := t.initializeChallengeTemplate(); err != nil {
+		logger.Error("failed to initialize challenge template", zap.Error(err))
+	}
+
+	// Шаблон ответа 429
+	if config.RateLimitTemplate != "" {
+		tmpl, err := template.New("rate_limit_page").Parse(config.RateLimitTemplate)
+		if err != nil {
+			logger.Error("failed to initialize rate limit template", zap.Error(err))
+		} else {
+			t.rateLimitTemplate = tmpl
+		}
+	}
+
 	logger.Info("templates system initialized",
 		zap.Bool("custom_template", t.enableCustom),
 	)
@@ -151,6 +171,112 @@ func (t *Templates) initializeCustomTemplate() error {
 	return nil
 }
 
+// initializeChallengeTemplate инициализирует страницу proof-of-work проверки.
+// Решение ищется на чистом JavaScript, так как crypto.subtle недоступен вне HTTPS.
+func (t *Templates) initializeChallengeTemplate() error {
+	challengeTemplate := `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Checking your browser</title>
+    <meta name="robots" content="noindex, nofollow, noarchive, nosnippet">
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;
+            background-color: #f8f9fa;
+            color: #6c757d;
+            text-align: center;
+            margin: 0;
+            padding: 50px 20px;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            padding: 40px;
+            border-radius: 8px;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+        }
+        h1 { font-weight: 300; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Checking your browser</h1>
+        <p id="status">This takes a few seconds.</p>
+        <noscript><p>Please enable JavaScript to continue.</p></noscript>
+        <form id="challenge" method="POST" action="{{.Action}}">
+            <input type="hidden" name="challenge" value="{{.Token}}">
+            <input type="hidden" name="nonce" value="">
+            <input type="hidden" name="return" value="{{.Return}}">
+        </form>
+    </div>
+    <script>
+        function rr(x, n) { return (x >>> n) | (x << (32 - n)); }
+        function sha256(ascii) {
+            var K = [0x428a2f98,0x71374491,0xb5c0fbcf,0xe9b5dba5,0x3956c25b,0x59f111f1,0x923f82a4,0xab1c5ed5,0xd807aa98,0x12835b01,0x243185be,0x550c7dc3,0x72be5d74,0x80deb1fe,0x9bdc06a7,0xc19bf174,0xe49b69c1,0xefbe4786,0x0fc19dc6,0x240ca1cc,0x2de92c6f,0x4a7484aa,0x5cb0a9dc,0x76f988da,0x983e5152,0xa831c66d,0xb00327c8,0xbf597fc7,0xc6e00bf3,0xd5a79147,0x06ca6351,0x14292967,0x27b70a85,0x2e1b2138,0x4d2c6dfc,0x53380d13,0x650a7354,0x766a0abb,0x81c2c92e,0x92722c85,0xa2bfe8a1,0xa81a664b,0xc24b8b70,0xc76c51a3,0xd192e819,0xd6990624,0xf40e3585,0x106aa070,0x19a4c116,0x1e376c08,0x2748774c,0x34b0bcb5,0x391c0cb3,0x4ed8aa4a,0x5b9cca4f,0x682e6ff3,0x748f82ee,0x78a5636f,0x84c87814,0x8cc70208,0x90befffa,0xa4506ceb,0xbef9a3f7,0xc67178f2];
+            var H = [0x6a09e667,0xbb67ae85,0x3c6ef372,0xa54ff53a,0x510e527f,0x9b05688c,0x1f83d9ab,0x5be0cd19];
+            var words = [], length = ascii.length * 8, i, j;
+            ascii += "\x80";
+            while (ascii.length % 64 !== 56) { ascii += "\x00"; }
+            for (i = 0; i < ascii.length; i++) { words[i >> 2] |= ascii.charCodeAt(i) << ((3 - (i & 3)) * 8); }
+            words.push(0, length);
+            for (j = 0; j < words.length; j += 16) {
+                var w = words.slice(j, j + 16);
+                var a = H[0], b = H[1], c = H[2], d = H[3], e = H[4], f = H[5], g = H[6], h = H[7];
+                for (i = 0; i < 64; i++) {
+                    if (i >= 16) {
+                        var w15 = w[i - 15], w2 = w[i - 2];
+                        w[i] = (w[i - 16] + (rr(w15, 7) ^ rr(w15, 18) ^ (w15 >>> 3)) + w[i - 7] + (rr(w2, 17) ^ rr(w2, 19) ^ (w2 >>> 10))) | 0;
+                    }
+                    var t1 = h + (rr(e, 6) ^ rr(e, 11) ^ rr(e, 25)) + ((e & f) ^ (~e & g)) + K[i] + w[i];
+                    var t2 = (rr(a, 2) ^ rr(a, 13) ^ rr(a, 22)) + ((a & b) ^ (a & c) ^ (b & c));
+                    h = g; g = f; f = e; e = (d + t1) | 0;
+                    d = c; c = b; b = a; a = (t1 + t2) | 0;
+                }
+                H[0] = (H[0] + a) | 0; H[1] = (H[1] + b) | 0; H[2] = (H[2] + c) | 0; H[3] = (H[3] + d) | 0;
+                H[4] = (H[4] + e) | 0; H[5] = (H[5] + f) | 0; H[6] = (H[6] + g) | 0; H[7] = (H[7] + h) | 0;
+            }
+            return H;
+        }
+        function zeroBits(h) {
+            var count = 0;
+            for (var i = 0; i < h.length; i++) {
+                if (h[i] !== 0) { return count + Math.clz32(h[i]); }
+                count += 32;
+            }
+            return count;
+        }
+
+        (function () {
+            var token = {{.Token}}, difficulty = {{.Difficulty}}, nonce = 0;
+            var form = document.getElementById("challenge");
+            function step() {
+                for (var i = 0; i < 5000; i++, nonce++) {
+                    if (zeroBits(sha256(token + ":" + nonce)) >= difficulty) {
+                        form.elements.nonce.value = String(nonce);
+                        form.submit();
+                        return;
+                    }
+                }
+                setTimeout(step, 0);
+            }
+            step();
+        })();
+    </script>
+</body>
+</html>`
+
+	tmpl, err := template.New("challenge_page").Parse(challengeTemplate)
+	if err != nil {
+		return err
+	}
+
+	t.challengeTemplate = tmpl
+	return nil
+}
+
 // ServeEmptyPage отображает пустую страницу
 func (t *Templates) ServeEmptyPage(w http.ResponseWriter, r *http.Request) error {
 	// Подготавливаем данные для шаблона
@@ -203,6 +329,145 @@ func (t *Templates) ServeCustomPage(w http.ResponseWriter, r *http.Request, titl
 	return t.emptyPageTemplate.Execute(w, data)
 }
 
+// ServeChallengePage отображает страницу proof-of-work проверки
+func (t *Templates) ServeChallengePage(w http.ResponseWriter, r *http.Request, data *ChallengeData) error {
+	if t.challengeTemplate == nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive, nosnippet")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+
+	w.WriteHeader(http.StatusForbidden)
+
+	return t.challengeTemplate.Execute(w, data)
+}
+
+// RateLimitPageData данные для шаблона ответа 429
+type RateLimitPageData struct {
+	TemplateData
+	Tier       string
+	Limit      int
+	Window     time.Duration
+	RetryAfter int64 // секунды
+}
+
+// rateLimitBody тело JSON ответа 429
+type rateLimitBody struct {
+	Error         string `json:"error"`
+	Message       string `json:"message"`
+	Policy        string `json:"policy"`
+	Limit         int    `json:"limit"`
+	WindowSeconds int64  `json:"window_seconds"`
+	RetryAfter    int64  `json:"retry_after"`
+}
+
+// ServeRateLimitPage отображает ответ 429: JSON для клиентов, предпочитающих application/json,
+// кастомный шаблон при его наличии, иначе текстовый ответ
+func (t *Templates) ServeRateLimitPage(w http.ResponseWriter, r *http.Request, decision *RateLimitDecision) error {
+	retryAfter := ceilSeconds(decision.RetryAfter)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Add("Vary", "Accept")
+
+	if prefersJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return json.NewEncoder(w).Encode(rateLimitBody{
+			Error:         "rate_limited",
+			Message:       "Rate limit exceeded",
+			Policy:        decision.Tier,
+			Limit:         decision.Limit,
+			WindowSeconds: ceilSeconds(decision.Window),
+			RetryAfter:    retryAfter,
+		})
+	}
+
+	if t.rateLimitTemplate == nil {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return nil
+	}
+
+	data := &RateLimitPageData{
+		TemplateData: TemplateData{
+			Title:      "Too Many Requests",
+			Message:    "Rate limit exceeded",
+			StatusCode: http.StatusTooManyRequests,
+			Timestamp:  time.Now(),
+			UserAgent:  r.UserAgent(),
+			RemoteAddr: r.RemoteAddr,
+			RequestURI: r.RequestURI,
+			ServerName: r.Host,
+		},
+		Tier:       decision.Tier,
+		Limit:      decision.Limit,
+		Window:     decision.Window,
+		RetryAfter: retryAfter,
+	}
+
+	// Рендерим в буфер, чтобы ошибка шаблона не оборвала уже начатый ответ
+	var buf bytes.Buffer
+	if err := t.rateLimitTemplate.Execute(&buf, data); err != nil {
+		t.logger.Error("failed to render rate limit template", zap.Error(err))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive, nosnippet")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// prefersJSON проверяет по заголовку Accept, что клиент предпочитает JSON, а не HTML
+func prefersJSON(accept string) bool {
+	if accept == "" {
+		return false
+	}
+
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, q := parseAcceptItem(item)
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			if q > jsonQ {
+				jsonQ = q
+			}
+		case mediaType == "text/html" || mediaType == "text/*" || mediaType == "*/*":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		}
+	}
+
+	return jsonQ > 0 && jsonQ >= htmlQ
+}
+
+// parseAcceptItem разбирает элемент заголовка Accept на тип и вес q
+func parseAcceptItem(item string) (string, float64) {
+	params := strings.Split(item, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+	q := 1.0
+	for _, param := range params[1:] {
+		name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if found && strings.EqualFold(name, "q") {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return mediaType, q
+}
+
 // RenderToString рендерит шаблон в строку (для тестирования)
 func (t *Templates) RenderToString(data *TemplateData) (string, error) {
 	var buf bytes.Buffer
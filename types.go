@@ -1,5 +1,7 @@
 package botredirect
 
+import "fmt"
+
 // BotType представляет тип бота
 type BotType string
 
@@ -36,4 +38,28 @@ func (ut UserType) String() string {
 	default:
 		return "unknown"
 	}
-}
\ No newline at end of file
+}
+
+// PolicyAction определяет действие, применяемое к нарушителю политики
+type PolicyAction string
+
+const (
+	PolicyActionLog       PolicyAction = "log"
+	PolicyActionBlock     PolicyAction = "block"
+	PolicyActionRateLimit PolicyAction = "rate_limit"
+	PolicyActionChallenge PolicyAction = "challenge"
+)
+
+func (pa PolicyAction) String() string {
+	return string(pa)
+}
+
+// ParsePolicyAction преобразует строку в PolicyAction
+func ParsePolicyAction(value string) (PolicyAction, error) {
+	switch action := PolicyAction(value); action {
+	case PolicyActionLog, PolicyActionBlock, PolicyActionRateLimit, PolicyActionChallenge:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown policy action: %s", value)
+	}
+}
@@ -1,7 +1,9 @@
 package botredirect
 
 import (
+	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -10,25 +12,18 @@ import (
 	"go.uber.org/zap"
 )
 
-// UserAgentMatcher отвечает за анализ User-Agent строк для определения ботов
+// UserAgentMatcher отвечает за анализ User-Agent строк для определения ботов.
+// Скомпилированные паттерны хранятся в неизменяемом наборе за atomic.Pointer: проверки читают
+// его без блокировок, а изменения собирают новый набор и подменяют указатель.
 type UserAgentMatcher struct {
-	// Паттерны для поиска ботов
-	botPatterns     []string
-	compiledRegexps []*regexp.Regexp
-
-	// Exact matches для быстрой проверки
-	exactMatches map[string]bool
-
-	// Contains matches для подстрок
-	containsMatches []string
+	// Действующий набор паттернов
+	rules atomic.Pointer[userAgentRules]
 
-	// Синхронизация
-	mutex sync.RWMutex
+	// Синхронизация изменений (проверки не блокируются)
+	mutex sync.Mutex
 
 	// Кеш результатов
-	cache    map[string]*UserAgentResult
-	cacheTTL time.Duration
-	maxCache int
+	cache *Cache[string, *UserAgentResult]
 
 	// Компоненты
 	metrics *Metrics
@@ -38,7 +33,21 @@ type UserAgentMatcher struct {
 	// Статистика (используем atomic для thread-safety)
 	totalChecks   int64
 	botDetections int64
-	cacheHits     int64
+}
+
+// userAgentRules скомпилированный набор паттернов User-Agent; после создания не изменяется
+type userAgentRules struct {
+	// Паттерны для поиска ботов
+	patterns []string
+
+	// Exact matches для быстрой проверки
+	exactMatches map[string]bool
+
+	// Contains matches для подстрок
+	containsMatches []string
+
+	// Регулярные выражения
+	compiledRegexps []*regexp.Regexp
 }
 
 // UserAgentResult содержит результат анализа User-Agent
@@ -53,70 +62,66 @@ type UserAgentResult struct {
 // NewUserAgentMatcher создает новый экземпляр UserAgentMatcher
 func NewUserAgentMatcher(config *Config, metrics *Metrics, debug *DebugConfig, logger *zap.Logger) *UserAgentMatcher {
 	uam := &UserAgentMatcher{
-		botPatterns:     make([]string, 0),
-		compiledRegexps: make([]*regexp.Regexp, 0),
-		exactMatches:    make(map[string]bool),
-		containsMatches: make([]string, 0),
-		cache:           make(map[string]*UserAgentResult),
-		cacheTTL:        config.CacheTTL,
-		maxCache:        1000, // Максимум 1000 записей в кеше
-		metrics:         metrics,
-		debug:           debug,
-		logger:          logger,
+		metrics: metrics,
+		debug:   debug,
+		logger:  logger,
 	}
 
+	uam.cache = NewCache[string, *UserAgentResult](CacheOptions{
+		Name:            "user_agent",
+		MaxSize:         config.UserAgentCacheSize,
+		TTL:             config.CacheTTL,
+		CleanupInterval: config.CleanupInterval,
+	}, hashString, metrics, debug, logger)
+
 	// Используем кастомные паттерны если заданы, иначе дефолтные
 	patterns := config.BotUserAgents
 	if len(patterns) == 0 {
 		patterns = getDefaultBotUserAgents()
 	}
 
-	// Инициализация паттернов
-	if err := uam.initializePatterns(patterns); err != nil {
-		logger.Error("failed to initialize user agent patterns", zap.Error(err))
-		return uam
-	}
+	// Компиляция паттернов
+	rules := uam.compileRules(patterns)
+	uam.rules.Store(rules)
 
 	logger.Info("user agent matcher initialized",
-		zap.Int("total_patterns", len(uam.botPatterns)),
-		zap.Int("regex_patterns", len(uam.compiledRegexps)),
-		zap.Int("exact_matches", len(uam.exactMatches)),
-		zap.Int("contains_matches", len(uam.containsMatches)),
+		zap.Int("total_patterns", len(rules.patterns)),
+		zap.Int("regex_patterns", len(rules.compiledRegexps)),
+		zap.Int("exact_matches", len(rules.exactMatches)),
+		zap.Int("contains_matches", len(rules.containsMatches)),
 	)
 
 	return uam
 }
 
-// initializePatterns инициализирует и оптимизирует паттерны
-func (uam *UserAgentMatcher) initializePatterns(patterns []string) error {
-	uam.mutex.Lock()
-	defer uam.mutex.Unlock()
-
-	// Очистка предыдущих паттернов
-	uam.botPatterns = make([]string, 0, len(patterns))
-	uam.compiledRegexps = make([]*regexp.Regexp, 0)
-	uam.exactMatches = make(map[string]bool)
-	uam.containsMatches = make([]string, 0)
+// compileRules компилирует и оптимизирует паттерны в новый набор
+func (uam *UserAgentMatcher) compileRules(patterns []string) *userAgentRules {
+	rules := &userAgentRules{
+		patterns:        make([]string, 0, len(patterns)),
+		exactMatches:    make(map[string]bool),
+		containsMatches: make([]string, 0),
+		compiledRegexps: make([]*regexp.Regexp, 0),
+	}
 
 	for _, pattern := range patterns {
 		if pattern == "" {
 			continue
 		}
 
-		uam.botPatterns = append(uam.botPatterns, pattern)
+		rules.patterns = append(rules.patterns, pattern)
 
 		// Оптимизация: разные типы паттернов для разной скорости проверки
 		if uam.isExactMatch(pattern) {
 			// Точное совпадение - самый быстрый
-			uam.exactMatches[strings.ToLower(pattern)] = true
+			rules.exactMatches[strings.ToLower(pattern)] = true
 		} else if uam.isSimpleContains(pattern) {
 			// Простое вхождение - быстрый
 			cleanPattern := strings.Trim(pattern, "*")
-			uam.containsMatches = append(uam.containsMatches, strings.ToLower(cleanPattern))
+			rules.containsMatches = append(rules.containsMatches, strings.ToLower(cleanPattern))
 		} else {
 			// Регулярное выражение - медленный но гибкий
 			if regex, err := regexp.Compile("(?i)" + pattern); err == nil {
-				uam.compiledRegexps = append(uam.compiledRegexps, regex)
+				rules.compiledRegexps = append(rules.compiledRegexps, regex)
 			} else {
 				uam.logger.Warn("invalid regex pattern",
 					zap.String("pattern", pattern),
@@ -126,7 +131,7 @@ func (uam *UserAgentMatcher) initializePatterns(patterns []string) error {
 		}
 	}
 
-	return nil
+	return rules
 }
 
 // isExactMatch проверяет, является ли паттерн точным совпадением
@@ -175,8 +180,7 @@ func (uam *UserAgentMatcher) IsBot(userAgent string) (*UserAgentResult, error) {
 	}
 
 	// Проверка кеша
-	if result := uam.getCachedResult(userAgent); result != nil {
-		atomic.AddInt64(&uam.cacheHits, 1)
+	if result, ok := uam.cache.Get(userAgent); ok {
 		if uam.metrics != nil {
 			uam.metrics.IncrementCacheHits()
 		}
@@ -193,10 +197,15 @@ func (uam *UserAgentMatcher) IsBot(userAgent string) (*UserAgentResult, error) {
 	}
 
 	// Выполнение проверки
-	result := uam.performCheck(userAgent)
+	rules := uam.rules.Load()
+	result := uam.performCheck(userAgent, rules)
 
-	// Сохранение в кеш
-	uam.setCachedResult(userAgent, result)
+	// Сохранение в кеш. Если набор заменили во время проверки, очистка кеша изменением
+	// могла пройти до записи: устаревший результат удаляется
+	uam.cache.Set(userAgent, result)
+	if uam.rules.Load() != rules {
+		uam.cache.Delete(userAgent)
+	}
 
 	// Логирование для дебага
 	if uam.debug != nil {
@@ -211,15 +220,12 @@ func (uam *UserAgentMatcher) IsBot(userAgent string) (*UserAgentResult, error) {
 	return result, nil
 }
 
-// performCheck выполняет основную проверку User-Agent
-func (uam *UserAgentMatcher) performCheck(userAgent string) *UserAgentResult {
-	uam.mutex.RLock()
-	defer uam.mutex.RUnlock()
-
+// performCheck выполняет основную проверку User-Agent по набору паттернов
+func (uam *UserAgentMatcher) performCheck(userAgent string, rules *userAgentRules) *UserAgentResult {
 	userAgentLower := strings.ToLower(userAgent)
 
 	// 1. Проверка точных совпадений (самый быстрый)
-	if uam.exactMatches[userAgentLower] {
+	if rules.exactMatches[userAgentLower] {
 		return &UserAgentResult{
 			IsBot:          true,
 			MatchedPattern: userAgentLower,
@@ -230,7 +236,7 @@ func (uam *UserAgentMatcher) performCheck(userAgent string) *UserAgentResult {
 	}
 
 	// 2. Проверка простых вхождений
-	for _, pattern := range uam.containsMatches {
+	for _, pattern := range rules.containsMatches {
 		if strings.Contains(userAgentLower, pattern) {
 			return &UserAgentResult{
 				IsBot:          true,
@@ -243,7 +249,7 @@ func (uam *UserAgentMatcher) performCheck(userAgent string) *UserAgentResult {
 	}
 
 	// 3. Проверка регулярных выражений (самый медленный)
-	for _, regex := range uam.compiledRegexps {
+	for _, regex := range rules.compiledRegexps {
 		if regex.MatchString(userAgent) {
 			return &UserAgentResult{
 				IsBot:          true,
@@ -324,156 +330,164 @@ func (uam *UserAgentMatcher) determineBotType(userAgent string) BotType {
 	return BotTypeUnknown
 }
 
-// getCachedResult получает результат из кеша
-func (uam *UserAgentMatcher) getCachedResult(userAgent string) *UserAgentResult {
-	uam.mutex.RLock()
-	defer uam.mutex.RUnlock()
-
-	if result, exists := uam.cache[userAgent]; exists {
-		// Проверка TTL
-		if time.Since(result.Timestamp) < uam.cacheTTL {
-			return result
-		}
-		// Удаление устаревшей записи
-		delete(uam.cache, userAgent)
+// AddPattern добавляет новый паттерн в runtime
+func (uam *UserAgentMatcher) AddPattern(pattern string) error {
+	if pattern == "" {
+		return nil
 	}
+	if err := uam.validatePattern(pattern); err != nil {
+		return err
+	}
+
+	uam.mutex.Lock()
+	defer uam.mutex.Unlock()
+
+	// Собираем новый набор с добавленным паттерном
+	patterns := append(slices.Clone(uam.rules.Load().patterns), pattern)
+	uam.rules.Store(uam.compileRules(patterns))
+
+	// Очищаем кеш после добавления нового паттерна
+	uam.cache.Clear()
+
+	uam.logger.Info("added new user agent pattern",
+		zap.String("pattern", pattern),
+		zap.Int("total_patterns", len(patterns)),
+	)
 
 	return nil
 }
 
-// setCachedResult сохраняет результат в кеш
-func (uam *UserAgentMatcher) setCachedResult(userAgent string, result *UserAgentResult) {
+// RemovePattern удаляет паттерн
+func (uam *UserAgentMatcher) RemovePattern(pattern string) {
 	uam.mutex.Lock()
 	defer uam.mutex.Unlock()
 
-	// Проверка размера кеша
-	if len(uam.cache) >= uam.maxCache {
-		uam.cleanupCacheUnsafe()
+	// Собираем новый набор без паттерна
+	patterns := slices.Clone(uam.rules.Load().patterns)
+	if i := slices.Index(patterns, pattern); i >= 0 {
+		patterns = slices.Delete(patterns, i, i+1)
 	}
+	uam.rules.Store(uam.compileRules(patterns))
+	uam.cache.Clear()
 
-	uam.cache[userAgent] = result
+	uam.logger.Info("removed user agent pattern",
+		zap.String("pattern", pattern),
+		zap.Int("total_patterns", len(patterns)),
+	)
+}
 
-	if uam.debug != nil {
-		uam.debug.LogCacheOperation(&CacheDebugInfo{
-			Key:       userAgent,
-			Operation: "set",
-			Hit:       false,
-			Value:     result,
-			TTL:       uam.cacheTTL,
-			Timestamp: time.Now(),
-		})
+// validatePattern проверяет паттерн User-Agent перед добавлением
+func (uam *UserAgentMatcher) validatePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("empty user agent pattern")
+	}
+	if uam.isExactMatch(pattern) || uam.isSimpleContains(pattern) {
+		return nil
+	}
+	if _, err := regexp.Compile("(?i)" + pattern); err != nil {
+		return fmt.Errorf("invalid user agent pattern %s: %w", pattern, err)
 	}
+	return nil
 }
 
-// cleanupCacheUnsafe очищает старые записи из кеша (вызывать под мьютексом)
-func (uam *UserAgentMatcher) cleanupCacheUnsafe() {
-	now := time.Now()
-
-	for key, result := range uam.cache {
-		if now.Sub(result.Timestamp) > uam.cacheTTL {
-			delete(uam.cache, key)
+// patternMatcher возвращает функцию, проверяющую User-Agent на совпадение с паттерном
+// по тем же правилам, что и performCheck
+func (uam *UserAgentMatcher) patternMatcher(pattern string) func(userAgent string) bool {
+	switch {
+	case uam.isExactMatch(pattern):
+		return func(userAgent string) bool {
+			return strings.EqualFold(pattern, userAgent)
 		}
-	}
-
-	// Если кеш все еще переполнен, удаляем самые старые записи
-	if len(uam.cache) >= uam.maxCache {
-		// Простая стратегия: удаляем половину записей
-		count := 0
-		target := len(uam.cache) / 2
-
-		for key := range uam.cache {
-			if count >= target {
-				break
-			}
-			delete(uam.cache, key)
-			count++
+	case uam.isSimpleContains(pattern):
+		core := strings.ToLower(strings.Trim(pattern, "*"))
+		return func(userAgent string) bool {
+			return strings.Contains(strings.ToLower(userAgent), core)
+		}
+	default:
+		regex, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return func(string) bool { return false }
 		}
+		return regex.MatchString
 	}
 }
 
-// AddPattern добавляет новый паттерн в runtime
-func (uam *UserAgentMatcher) AddPattern(pattern string) error {
-	uam.mutex.Lock()
-	defer uam.mutex.Unlock()
-
-	if pattern == "" {
-		return nil
-	}
-
-	// Добавляем в список паттернов
-	uam.botPatterns = append(uam.botPatterns, pattern)
-
-	// Классифицируем и добавляем в соответствующую структуру
-	if uam.isExactMatch(pattern) {
-		uam.exactMatches[strings.ToLower(pattern)] = true
-	} else if uam.isSimpleContains(pattern) {
-		cleanPattern := strings.Trim(pattern, "*")
-		uam.containsMatches = append(uam.containsMatches, strings.ToLower(cleanPattern))
-	} else {
-		if regex, err := regexp.Compile("(?i)" + pattern); err == nil {
-			uam.compiledRegexps = append(uam.compiledRegexps, regex)
-		} else {
+// SetPatterns заменяет список паттернов целиком. Кеш не очищается: вызывающий удаляет
+// затронутые записи через purgePatterns. Паттерны проверяются до замены: при ошибке
+// действующий список не меняется.
+func (uam *UserAgentMatcher) SetPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if err := uam.validatePattern(pattern); err != nil {
 			return err
 		}
 	}
 
-	// Очищаем кеш после добавления нового паттерна
-	uam.cache = make(map[string]*UserAgentResult)
+	uam.mutex.Lock()
+	uam.rules.Store(uam.compileRules(patterns))
+	uam.mutex.Unlock()
 
-	uam.logger.Info("added new user agent pattern",
-		zap.String("pattern", pattern),
-		zap.Int("total_patterns", len(uam.botPatterns)),
+	uam.logger.Info("user agent patterns replaced",
+		zap.Int("total_patterns", len(patterns)),
 	)
 
 	return nil
 }
 
-// RemovePattern удаляет паттерн
-func (uam *UserAgentMatcher) RemovePattern(pattern string) {
-	uam.mutex.Lock()
-	defer uam.mutex.Unlock()
+// purgePatterns удаляет из кеша результаты для User-Agent, совпадающих с любым из паттернов
+func (uam *UserAgentMatcher) purgePatterns(patterns []string) int {
+	if len(patterns) == 0 {
+		return 0
+	}
 
-	// Удаляем из основного списка
-	for i, p := range uam.botPatterns {
-		if p == pattern {
-			uam.botPatterns = append(uam.botPatterns[:i], uam.botPatterns[i+1:]...)
-			break
-		}
+	matchers := make([]func(userAgent string) bool, 0, len(patterns))
+	for _, pattern := range patterns {
+		matchers = append(matchers, uam.patternMatcher(pattern))
 	}
 
-	// Переинициализируем все структуры
-	patterns := make([]string, len(uam.botPatterns))
-	copy(patterns, uam.botPatterns)
+	return uam.cache.DeleteFunc(func(userAgent string, _ *UserAgentResult) bool {
+		for _, match := range matchers {
+			if match(userAgent) {
+				return true
+			}
+		}
+		return false
+	})
+}
 
-	// Освобождаем мьютекс временно для вызова initializePatterns
-	uam.mutex.Unlock()
-	uam.initializePatterns(patterns)
-	uam.mutex.Lock()
+// GetPatterns возвращает копию списка паттернов
+func (uam *UserAgentMatcher) GetPatterns() []string {
+	return slices.Clone(uam.rules.Load().patterns)
+}
 
-	uam.logger.Info("removed user agent pattern",
-		zap.String("pattern", pattern),
-		zap.Int("total_patterns", len(uam.botPatterns)),
-	)
+// PurgeCache удаляет из кеша результат для User-Agent (пустая строка - весь кеш)
+func (uam *UserAgentMatcher) PurgeCache(userAgent string) int {
+	if userAgent == "" {
+		size := uam.cache.Len()
+		uam.cache.Clear()
+		return size
+	}
+	if uam.cache.Delete(userAgent) {
+		return 1
+	}
+	return 0
+}
+
+// Shutdown останавливает фоновую очистку кеша
+func (uam *UserAgentMatcher) Shutdown() {
+	uam.cache.StopCleanup()
 }
 
 // GetStats возвращает статистику
 func (uam *UserAgentMatcher) GetStats() map[string]interface{} {
-	uam.mutex.RLock()
-	cacheSize := len(uam.cache)
-	totalPatterns := len(uam.botPatterns)
-	exactMatches := len(uam.exactMatches)
-	containsMatches := len(uam.containsMatches)
-	regexPatterns := len(uam.compiledRegexps)
-	uam.mutex.RUnlock()
+	rules := uam.rules.Load()
+	totalPatterns := len(rules.patterns)
+	exactMatches := len(rules.exactMatches)
+	containsMatches := len(rules.containsMatches)
+	regexPatterns := len(rules.compiledRegexps)
 
 	totalChecks := atomic.LoadInt64(&uam.totalChecks)
 	botDetections := atomic.LoadInt64(&uam.botDetections)
-	cacheHits := atomic.LoadInt64(&uam.cacheHits)
-
-	hitRate := 0.0
-	if totalChecks > 0 {
-		hitRate = float64(cacheHits) / float64(totalChecks)
-	}
+	cacheStats := uam.cache.GetStats()
 
 	detectionRate := 0.0
 	if totalChecks > 0 {
@@ -485,21 +499,19 @@ func (uam *UserAgentMatcher) GetStats() map[string]interface{} {
 		"exact_matches":    exactMatches,
 		"contains_matches": containsMatches,
 		"regex_patterns":   regexPatterns,
-		"cache_size":       cacheSize,
-		"cache_max_size":   uam.maxCache,
+		"cache_size":       cacheStats.Size,
+		"cache_max_size":   cacheStats.MaxSize,
 		"total_checks":     totalChecks,
 		"bot_detections":   botDetections,
-		"cache_hits":       cacheHits,
-		"cache_hit_rate":   hitRate,
+		"cache_hits":       cacheStats.Hits,
+		"cache_evictions":  cacheStats.Evictions,
+		"cache_hit_rate":   cacheStats.HitRate,
 		"detection_rate":   detectionRate,
 	}
 }
 
 // ClearCache очищает кеш
 func (uam *UserAgentMatcher) ClearCache() {
-	uam.mutex.Lock()
-	defer uam.mutex.Unlock()
-
-	uam.cache = make(map[string]*UserAgentResult)
+	uam.cache.Clear()
 	uam.logger.Info("user agent matcher cache cleared")
 }
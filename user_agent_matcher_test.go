@@ -0,0 +1,73 @@
+package botredirect
+
+import (
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// runWithReaders выполняет write iterations раз, пока readers горутин непрерывно вызывают read
+func runWithReaders(readers, iterations int, read func(), write func(i int)) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					read()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < iterations; i++ {
+		write(i)
+	}
+	close(done)
+	wg.Wait()
+}
+
+// TestUserAgentMatcherSetPatternsConcurrent заменяет паттерны во время проверок
+// (запускать с -race). После последней замены в кеше не должно остаться результата
+// по предыдущему набору.
+func TestUserAgentMatcherSetPatternsConcurrent(t *testing.T) {
+	const userAgent = "ExampleCrawler/1.0"
+	withCrawler := []string{"*examplecrawler*", "*othercrawler*"}
+	withoutCrawler := []string{"*othercrawler*"}
+
+	config := DefaultConfig()
+	config.BotUserAgents = withoutCrawler
+	uam := NewUserAgentMatcher(config, nil, nil, zap.NewNop())
+
+	for round := 0; round < 20; round++ {
+		runWithReaders(8, 200, func() {
+			uam.IsBot(userAgent)
+			uam.IsBot("Mozilla/5.0 OtherCrawler")
+		}, func(i int) {
+			// Как ListEditor: замена набора, затем удаление затронутых записей кеша
+			patterns := withoutCrawler
+			if i%2 == 0 {
+				patterns = withCrawler
+			}
+			if err := uam.SetPatterns(patterns); err != nil {
+				t.Fatal(err)
+			}
+			uam.purgePatterns([]string{"*examplecrawler*"})
+		})
+
+		// Последняя замена убрала паттерн
+		result, err := uam.IsBot(userAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsBot {
+			t.Fatalf("round %d: stale cached verdict %+v after the pattern was removed", round, result)
+		}
+	}
+}